@@ -0,0 +1,430 @@
+//go:build !toxsim
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+	"github.com/calvindc/dpc-tox/librarywrapper/toxmedia"
+)
+
+// Call handles the calls of one ToxAV: it places or answers them, streams the
+// media files while a call is active and records what is received.
+type Call struct {
+	tox *libtox.Tox
+	tav *libtoxav.ToxAV
+	cfg *Config
+
+	// friendKey is the public key of -friend, nil if any caller is answered.
+	friendKey []byte
+
+	mtx      sync.Mutex
+	friend   uint32
+	active   bool
+	stop     chan struct{} // closed to stop the streamers of the active call
+	streams  sync.WaitGroup
+	deadline *time.Timer
+
+	done     chan struct{}
+	doneOnce sync.Once
+
+	recordMtx sync.Mutex
+	wavFile   *os.File
+	wav       *toxmedia.WAVWriter
+	y4mFile   *os.File
+	y4m       *toxmedia.Y4MWriter
+}
+
+// NewCall registers the callbacks on tox and tav. If -friend is a Tox ID, the
+// friend is added.
+func NewCall(tox *libtox.Tox, tav *libtoxav.ToxAV, cfg *Config) (*Call, error) {
+	c := &Call{tox: tox, tav: tav, cfg: cfg, done: make(chan struct{})}
+
+	if cfg.Friend != "" {
+		id, err := hex.DecodeString(cfg.Friend)
+		if err != nil || (len(id) != libtox.TOX_ADDRESS_SIZE && len(id) != libtox.TOX_PUBLIC_KEY_SIZE) {
+			return nil, errors.New("-friend must be a Tox ID or a public key")
+		}
+		c.friendKey = id[:libtox.TOX_PUBLIC_KEY_SIZE]
+
+		if friend, err := tox.FriendByPublicKey(c.friendKey); err == nil {
+			c.friend = friend
+		} else if len(id) == libtox.TOX_ADDRESS_SIZE {
+			if c.friend, err = tox.FriendAdd(id, "toxcall"); err != nil {
+				return nil, fmt.Errorf("adding friend: %v", err)
+			}
+			fmt.Println("[INFO] Sent a friend request")
+		} else if c.friend, err = tox.FriendAddNorequest(c.friendKey); err != nil {
+			return nil, fmt.Errorf("adding friend: %v", err)
+		}
+	}
+
+	if cfg.RecordVideo != "" {
+		f, err := os.Create(cfg.RecordVideo)
+		if err != nil {
+			return nil, err
+		}
+		c.y4mFile = f
+		c.y4m = toxmedia.NewY4MWriter(f, 25)
+	}
+
+	tox.CallbackFriendRequest(c.onFriendRequest)
+	tox.CallbackFriendConnectionStatusChanges(c.onFriendConnectionStatus)
+	tav.CallbackCall(c.onCall)
+	tav.CallbackCallState(c.onCallState)
+	tav.CallbackAudioBitRate(c.onAudioBitRate)
+	tav.CallbackVideoBitRate(c.onVideoBitRate)
+	tav.CallbackAudioReceiveFrame(c.onAudioReceiveFrame)
+	tav.CallbackVideoReceiveFrame(c.onVideoReceiveFrame)
+
+	return c, nil
+}
+
+// Done is closed when the call placed with -friend ended.
+func (c *Call) Done() <-chan struct{} {
+	return c.done
+}
+
+// isFriend reports whether calls of friendNumber are handled.
+func (c *Call) isFriend(friendNumber uint32) bool {
+	if c.friendKey == nil {
+		return true
+	}
+
+	key, err := c.tox.FriendGetPublickey(friendNumber)
+	return err == nil && bytes.Equal(key, c.friendKey)
+}
+
+func (c *Call) onFriendRequest(t *libtox.Tox, publicKey []byte, message []byte, length uint32) {
+	if c.cfg.Answer && (c.friendKey == nil || bytes.Equal(publicKey, c.friendKey)) {
+		fmt.Printf("[INFO] Accepting friend request from %X\n", publicKey)
+		t.FriendAddNorequest(publicKey)
+	}
+}
+
+func (c *Call) onFriendConnectionStatus(t *libtox.Tox, friendNumber uint32, status libtox.ToxConnection) {
+	if c.cfg.Answer || c.friendKey == nil || !c.isFriend(friendNumber) || status == libtox.TOX_CONNECTION_NONE {
+		return
+	}
+
+	c.mtx.Lock()
+	active := c.active
+	c.mtx.Unlock()
+	if active {
+		return
+	}
+
+	// toxav must not be called back into from its own callbacks
+	go func() {
+		fmt.Println("[INFO] Friend is online, calling...")
+		if _, err := c.tav.Call(friendNumber, uint32(c.cfg.AudioBitRate), uint32(c.cfg.VideoBitRate)); err != nil {
+			fmt.Println("[ERROR] Call:", err)
+			return
+		}
+		c.mtx.Lock()
+		c.friend = friendNumber
+		c.active = true
+		c.mtx.Unlock()
+	}()
+}
+
+func (c *Call) onCall(tav *libtoxav.ToxAV, friendNumber uint32, audioEnabled bool, videoEnabled bool) {
+	fmt.Printf("[INFO] Incoming call from friend %d (audio %v, video %v)\n", friendNumber, audioEnabled, videoEnabled)
+	if !c.cfg.Answer || !c.isFriend(friendNumber) {
+		go tav.Cancel(friendNumber)
+		return
+	}
+
+	c.mtx.Lock()
+	busy := c.active
+	c.mtx.Unlock()
+	if busy {
+		go tav.Cancel(friendNumber)
+		return
+	}
+
+	go func() {
+		if _, err := tav.Answer(friendNumber, uint32(c.cfg.AudioBitRate), uint32(c.cfg.VideoBitRate)); err != nil {
+			fmt.Println("[ERROR] Answer:", err)
+			return
+		}
+		c.start(friendNumber)
+	}()
+}
+
+func (c *Call) onCallState(tav *libtoxav.ToxAV, friendNumber uint32, state libtoxav.ToxavFriendCallState) {
+	fmt.Printf("[INFO] Call state of friend %d: %v\n", friendNumber, state)
+
+	if state.Ended() {
+		// the streamers may be waiting for toxav, which is calling us
+		go func() {
+			c.end()
+			if !c.cfg.Answer {
+				c.finish()
+			}
+		}()
+		return
+	}
+
+	// the friend answered our call
+	c.mtx.Lock()
+	started := c.stop != nil
+	c.mtx.Unlock()
+	if !started && !c.cfg.Answer {
+		c.start(friendNumber)
+	}
+}
+
+func (c *Call) onAudioBitRate(tav *libtoxav.ToxAV, friendNumber uint32, audioBitRate uint32) {
+	fmt.Printf("[INFO] Lowering audio bit rate to %d kbit/s\n", audioBitRate)
+	go tav.AudioSetBitRate(friendNumber, audioBitRate)
+}
+
+func (c *Call) onVideoBitRate(tav *libtoxav.ToxAV, friendNumber uint32, videoBitRate uint32) {
+	fmt.Printf("[INFO] Lowering video bit rate to %d kbit/s\n", videoBitRate)
+	go tav.VideoSetBitRate(friendNumber, videoBitRate)
+}
+
+func (c *Call) onAudioReceiveFrame(tav *libtoxav.ToxAV, friendNumber uint32, frame *libtoxav.AudioFrame) {
+	c.recordMtx.Lock()
+	defer c.recordMtx.Unlock()
+
+	if c.cfg.RecordAudio == "" {
+		return
+	}
+	if c.wav == nil {
+		f, err := os.Create(c.cfg.RecordAudio)
+		if err != nil {
+			fmt.Println("[ERROR]", err)
+			c.cfg.RecordAudio = ""
+			return
+		}
+		// the file uses the format of the first frame
+		if c.wav, err = toxmedia.NewWAVWriter(f, frame.SamplingRate, frame.Channels); err != nil {
+			fmt.Println("[ERROR]", err)
+			f.Close()
+			c.cfg.RecordAudio = ""
+			return
+		}
+		c.wavFile = f
+	}
+
+	if err := c.wav.WriteFrame(frame); err != nil {
+		fmt.Println("[ERROR] Recording audio:", err)
+	}
+}
+
+func (c *Call) onVideoReceiveFrame(tav *libtoxav.ToxAV, friendNumber uint32, frame *image.YCbCr) {
+	if c.y4m == nil {
+		return
+	}
+
+	c.recordMtx.Lock()
+	defer c.recordMtx.Unlock()
+
+	if err := c.y4m.WriteFrame(frame); err == toxmedia.ErrY4MFrameSize {
+		// Y4M has a fixed frame size, frames after a resolution change are dropped
+		return
+	} else if err != nil {
+		fmt.Println("[ERROR] Recording video:", err)
+	}
+}
+
+// start starts streaming the media files to friendNumber.
+func (c *Call) start(friendNumber uint32) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.stop != nil {
+		return
+	}
+	c.friend = friendNumber
+	c.active = true
+	c.stop = make(chan struct{})
+	fmt.Printf("[INFO] Call with friend %d started\n", friendNumber)
+
+	if c.cfg.AudioFile != "" && c.cfg.AudioBitRate > 0 {
+		c.streams.Add(1)
+		go c.streamAudio(friendNumber, c.stop)
+	}
+	if c.cfg.VideoFile != "" && c.cfg.VideoBitRate > 0 {
+		c.streams.Add(1)
+		go c.streamVideo(friendNumber, c.stop)
+	}
+
+	if c.cfg.Duration > 0 {
+		c.deadline = time.AfterFunc(c.cfg.Duration, func() {
+			fmt.Println("[INFO] Call duration reached, hanging up")
+			c.Hangup()
+			c.end()
+			if !c.cfg.Answer {
+				c.finish()
+			}
+		})
+	}
+}
+
+// end stops the streamers of the active call.
+func (c *Call) end() {
+	c.mtx.Lock()
+	stop := c.stop
+	c.stop = nil
+	c.active = false
+	if c.deadline != nil {
+		c.deadline.Stop()
+		c.deadline = nil
+	}
+	c.mtx.Unlock()
+
+	if stop != nil {
+		close(stop)
+		c.streams.Wait()
+		fmt.Println("[INFO] Call ended")
+	}
+}
+
+func (c *Call) finish() {
+	c.doneOnce.Do(func() { close(c.done) })
+}
+
+// Hangup cancels the active call.
+func (c *Call) Hangup() {
+	c.mtx.Lock()
+	active, friend := c.active, c.friend
+	c.mtx.Unlock()
+
+	if active {
+		c.tav.Cancel(friend)
+	}
+}
+
+// Close ends the call and finishes the recordings.
+func (c *Call) Close() error {
+	c.end()
+
+	c.recordMtx.Lock()
+	defer c.recordMtx.Unlock()
+
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if c.wav != nil {
+		keep(c.wav.Close())
+		keep(c.wavFile.Close())
+		fmt.Printf("[INFO] Recorded %v of audio to %s\n", c.wav.Duration(), c.cfg.RecordAudio)
+	}
+	if c.y4m != nil {
+		keep(c.y4m.Flush())
+		keep(c.y4mFile.Close())
+		fmt.Printf("[INFO] Recorded %d video frames to %s\n", c.y4m.Frames(), c.cfg.RecordVideo)
+	}
+
+	return firstErr
+}
+
+// streamAudio sends the WAV file in 20 ms frames until stop is closed.
+func (c *Call) streamAudio(friendNumber uint32, stop chan struct{}) {
+	defer c.streams.Done()
+
+	ticker := time.NewTicker(libtoxav.DefaultFrameDuration)
+	defer ticker.Stop()
+
+	for {
+		f, err := os.Open(c.cfg.AudioFile)
+		if err != nil {
+			fmt.Println("[ERROR]", err)
+			return
+		}
+		framer, err := openFramer(f)
+		if err != nil {
+			fmt.Println("[ERROR]", c.cfg.AudioFile+":", err)
+			f.Close()
+			return
+		}
+
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				break
+			}
+			select {
+			case <-stop:
+				f.Close()
+				return
+			case <-ticker.C:
+			}
+			if err := c.tav.AudioSendFrame(friendNumber, frame); err != nil && err != libtoxav.ErrSendFramePayloadTypeDisabled {
+				fmt.Println("[ERROR] Sending audio:", err)
+			}
+		}
+		f.Close()
+
+		if !c.cfg.Loop {
+			return
+		}
+	}
+}
+
+func openFramer(r io.Reader) (*libtoxav.AudioFramer, error) {
+	wav, err := toxmedia.NewWAVReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return wav.Framer(libtoxav.DefaultSamplingRate, wav.Channels)
+}
+
+// streamVideo sends the Y4M file at its frame rate until stop is closed.
+func (c *Call) streamVideo(friendNumber uint32, stop chan struct{}) {
+	defer c.streams.Done()
+
+	for {
+		f, err := os.Open(c.cfg.VideoFile)
+		if err != nil {
+			fmt.Println("[ERROR]", err)
+			return
+		}
+		y4m, err := toxmedia.NewY4MReader(f)
+		if err != nil {
+			fmt.Println("[ERROR]", c.cfg.VideoFile+":", err)
+			f.Close()
+			return
+		}
+
+		pacer := libtoxav.NewFramePacer(y4m.FrameRate)
+		for {
+			frame, err := y4m.ReadFrame()
+			if err != nil {
+				break
+			}
+			select {
+			case <-stop:
+				f.Close()
+				return
+			default:
+			}
+			pacer.Wait()
+			if err := c.tav.VideoSendFrame(friendNumber, frame); err != nil && err != libtoxav.ErrSendFramePayloadTypeDisabled {
+				fmt.Println("[ERROR] Sending video:", err)
+			}
+		}
+		f.Close()
+
+		if !c.cfg.Loop {
+			return
+		}
+	}
+}
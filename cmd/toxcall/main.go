@@ -0,0 +1,172 @@
+//go:build !toxsim
+
+// toxcall places or answers a Tox call, streams WAV audio and Y4M video files
+// as outgoing media and records the incoming media to WAV and Y4M files.
+//
+// Answer every call, play a greeting and record the caller:
+//
+//	toxcall -save answer.tox -answer -audio greeting.wav -record-audio in.wav
+//
+// Call a friend for 30 seconds with audio and video:
+//
+//	toxcall -save call.tox -friend <tox id or public key> -audio music.wav -video clip.y4m -loop -duration 30s
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+type Server struct {
+	Address   string
+	Port      uint16
+	PublicKey []byte
+}
+
+// Config holds the command line flags.
+type Config struct {
+	SavePath     string
+	Friend       string
+	Answer       bool
+	AudioFile    string
+	VideoFile    string
+	RecordAudio  string
+	RecordVideo  string
+	AudioBitRate uint
+	VideoBitRate uint
+	Loop         bool
+	Duration     time.Duration
+	Bootstrap    bool
+}
+
+func main() {
+	var cfg Config
+	flag.StringVar(&cfg.SavePath, "save", "./toxcall.tox", "path to save file")
+	flag.StringVar(&cfg.Friend, "friend", "", "Tox ID or public key of the friend to call; a Tox ID is added as friend")
+	flag.BoolVar(&cfg.Answer, "answer", false, "answer incoming calls (from -friend only, if set)")
+	flag.StringVar(&cfg.AudioFile, "audio", "", "16 bit PCM WAV file to send")
+	flag.StringVar(&cfg.VideoFile, "video", "", "4:2:0 Y4M file to send")
+	flag.StringVar(&cfg.RecordAudio, "record-audio", "", "WAV file to record the received audio to")
+	flag.StringVar(&cfg.RecordVideo, "record-video", "", "Y4M file to record the received video to")
+	flag.UintVar(&cfg.AudioBitRate, "abr", 48, "audio bit rate in kbit/s, 0 disables sending audio")
+	flag.UintVar(&cfg.VideoBitRate, "vbr", 5000, "video bit rate in kbit/s, 0 disables sending video")
+	flag.BoolVar(&cfg.Loop, "loop", false, "restart the media files when they end")
+	flag.DurationVar(&cfg.Duration, "duration", 0, "hang up after this time, 0 for no limit")
+	flag.BoolVar(&cfg.Bootstrap, "bootstrap", true, "bootstrap from a public node; disable to only use LAN discovery")
+	flag.Parse()
+
+	if cfg.Friend == "" && !cfg.Answer {
+		fmt.Println("[ERROR] Either -friend or -answer is required.")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfg.VideoFile == "" {
+		cfg.VideoBitRate = 0
+	}
+
+	var options *libtox.Options
+	savedata, err := loadData(cfg.SavePath)
+	if err == nil {
+		fmt.Println("[INFO] Loading Tox profile from savedata...")
+		options = &libtox.Options{
+			IPv6Enabled:  true,
+			UDPEnabled:   true,
+			ProxyType:    libtox.TOX_PROXY_TYPE_NONE,
+			SaveDataType: libtox.TOX_SAVEDATA_TYPE_TOX_SAVE,
+			SaveData:     savedata}
+	} else {
+		fmt.Println("[INFO] Creating new Tox profile...")
+	}
+
+	tox, err := libtox.New(options)
+	if err != nil {
+		panic(err)
+	}
+	if options == nil {
+		tox.SelfSetName("toxcall")
+		tox.SelfSetStatusMessage("Media player and recorder")
+	}
+
+	addr, _ := tox.SelfGetAddress()
+	fmt.Println("TOX ID:\t\t", strings.ToUpper(hex.EncodeToString(addr)))
+
+	tav, err := libtoxav.NewToxAV(tox)
+	if err != nil {
+		panic(err)
+	}
+
+	call, err := NewCall(tox, tav, &cfg)
+	if err != nil {
+		fmt.Println("[ERROR]", err)
+		os.Exit(1)
+	}
+
+	if cfg.Bootstrap {
+		pubkey, _ := hex.DecodeString("E20ABCF38CDBFFD7D04B29C956B33F7B27A3BB7AF0618101617B036E4AEA402D")
+		server := &Server{"3.0.24.15", 33445, pubkey}
+		if err := tox.Bootstrap(server.Address, server.Port, server.PublicKey); err != nil {
+			fmt.Println("[ERROR] Bootstrap:", err)
+		}
+	}
+
+	runner := libtoxav.NewRunner(tav, nil)
+	if err := runner.Start(); err != nil {
+		panic(err)
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	select {
+	case <-c:
+		fmt.Println("\n[INFO] Interrupted")
+	case <-call.Done():
+	}
+
+	call.Hangup()
+	runner.Stop()
+	if err := call.Close(); err != nil {
+		fmt.Println("[ERROR]", err)
+	}
+	for _, m := range runner.Metrics() {
+		fmt.Printf("[INFO] %s loop: %d iterations, jitter %v (max %v), lag %v (max %v), %d overruns\n",
+			m.Name, m.Iterations, m.Jitter, m.MaxJitter, m.Lag, m.MaxLag, m.Overruns)
+	}
+
+	fmt.Println("[INFO] Saving data...")
+	if err := saveData(tox, cfg.SavePath); err != nil {
+		fmt.Println("[ERROR]", err)
+	}
+	runner.Close()
+}
+
+func loadData(filepath string) ([]byte, error) {
+	if len(filepath) == 0 {
+		return nil, errors.New("Empty path")
+	}
+
+	return ioutil.ReadFile(filepath)
+}
+
+// saveData writes the savedata from toxcore to a file
+func saveData(t *libtox.Tox, filepath string) error {
+	if len(filepath) == 0 {
+		return errors.New("Empty path")
+	}
+
+	data, err := t.GetSavedata()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath, data, 0644)
+}
@@ -19,9 +19,9 @@
 */
 
 (function() {
-  var app = angular.module('webtox', ['fullscreen', 'mozWebApp', 'notifications', 'websocket']);
+  var app = angular.module('webtox', ['calls', 'fullscreen', 'mozWebApp', 'notifications', 'websocket']);
 
-  app.controller('webtoxCtrl', ['$scope', '$http', 'Fullscreen', 'MozWebApp', 'Notifications', 'WS', function($scope, $http, FullscreenService, WebApp, Notifications, WS) {
+  app.controller('webtoxCtrl', ['$scope', '$http', 'CallAudio', 'Fullscreen', 'MozWebApp', 'Notifications', 'WS', function($scope, $http, CallAudio, FullscreenService, WebApp, Notifications, WS) {
     'use strict';
 
     $scope.goFullscreen = FullscreenService.goFullscreen;
@@ -38,6 +38,7 @@
       tox_id: "Loading...",
     };
     $scope.contacts = [];
+    $scope.chats = {}; // the loaded chat histories by public key
     $scope.activecontactindex = -1;
     $scope.messagetosend = '';
     $scope.friendRequests = [];
@@ -46,11 +47,20 @@
       message: '',
     };
     $scope.settings = {};
+    $scope.calls = {};
+    $scope.uploads = {}; // the unfinished uploads by id
+    $scope.downloads = {}; // the unfinished files sent by friends by id
+    $scope.conferences = [];
+    $scope.conferenceChats = {}; // the loaded chat histories by conference ID
+    $scope.conferenceUnread = {}; // the number of unread messages by conference ID
+    $scope.conferenceInvites = [];
+    $scope.activeConferenceID = '';
+    $scope.conferencemessagetosend = '';
     $scope.curDate = Date.now(); // current unix timestap used to work around caching
 
-    var getContactIndexByNum = function(num) {
+    var getContactIndexByPublicKey = function(publicKey) {
       for (var i in $scope.contacts)
-        if ($scope.contacts[i].number === num) return i;
+        if ($scope.contacts[i].publicKey === publicKey) return i;
       return -1;
     };
 
@@ -84,24 +94,36 @@
       });
     };
 
-    $scope.showChat = function(friendnumber) {
-      var i = getContactIndexByNum(friendnumber);
+    $scope.showChat = function(publicKey) {
+      var i = getContactIndexByPublicKey(publicKey);
       if (i != -1) {
         $scope.activecontactindex = i;
         $scope.active_mainview = 'chat';
-        sendMessageRead(friendnumber);
+        sendMessageRead(publicKey);
 
-        window.setTimeout(function() {
-          $("#mainview-chat-body").scrollTop($("#mainview-chat-body").prop("scrollHeight"));
-        }, 10);
+        if ($scope.chats[publicKey] === undefined)
+          loadChat(publicKey);
+        else
+          scrollChatToBottom();
       }
     };
 
+    var isChatShown = function(publicKey) {
+      var contact = $scope.contacts[$scope.activecontactindex];
+      return $scope.active_mainview === 'chat' && contact !== undefined && contact.publicKey === publicKey;
+    };
+
+    var scrollChatToBottom = function() {
+      window.setTimeout(function() {
+        $("#mainview-chat-body").scrollTop($("#mainview-chat-body").prop("scrollHeight"));
+      }, 10);
+    };
+
     $scope.scrollLeft = function() {
       if ($(window).width() < 768) {
         $('#profile-card, #contact-list-wrapper, #button-panel').addClass('translate75left');
         $('#mainview').addClass('translate100left');
-        $('#profile-card-back-button').show();
+        $('#profile-card-back-button, #conference-back-button').show();
       }
     };
 
@@ -115,41 +137,102 @@
       if ($scope.messagetosend.length === 0)
         return;
 
-      if (!$scope.contacts[$scope.activecontactindex].online) {
-        // TODO cache messages server-side until the user gets online again...
-        alert("User is offline. :(");
-        return;
-      }
-
+      var publicKey = $scope.contacts[$scope.activecontactindex].publicKey;
       $http.post('api/post/message', {
-        friend: $scope.contacts[$scope.activecontactindex].number,
+        publicKey: publicKey,
         message: $scope.messagetosend
+      }).success(function(msg) {
+        addMessage(publicKey, msg);
       }).error(function() {
         // TODO
       });
 
-      $scope.contacts[$scope.activecontactindex].chat.unshift({
-        "isIncoming": false,
-        "isAction": false,
-        "message": $scope.messagetosend.replace(/\n/g, "<br>"),
-        "time": Date.now()
-      });
       $scope.contacts[$scope.activecontactindex].last_msg_read = Date.now();
       $scope.messagetosend = '';
-
-      $("#mainview-chat-body").animate({
-        "scrollTop": $("#mainview-chat-body").prop("scrollHeight")
-      }, 1000);
     };
 
-    var sendMessageRead = function(friendnumber) {
+    var sendMessageRead = function(publicKey) {
       $http.post('api/post/message_read_receipt', {
-        friend: friendnumber
+        publicKey: publicKey
       }).success(function() {
-        $scope.contacts[$scope.activecontactindex].last_msg_read = Date.now();
+        var i = getContactIndexByPublicKey(publicKey);
+        if (i != -1) {
+          $scope.contacts[i].last_msg_read = Date.now();
+          $scope.contacts[i].unread = 0;
+        }
+      });
+    };
+
+    // == Chat history ==
+    var loadChat = function(publicKey) {
+      $http.get('api/get/messages', {
+        params: { publicKey: publicKey }
+      }).success(function(page) {
+        $scope.chats[publicKey] = {
+          messages: page.messages,
+          nextBefore: page.next_before
+        };
+        scrollChatToBottom();
+      });
+    };
+
+    $scope.loadOlderMessages = function() {
+      var publicKey = $scope.contacts[$scope.activecontactindex].publicKey;
+      var chat = $scope.chats[publicKey];
+      if (chat === undefined || chat.nextBefore === null)
+        return;
+
+      $http.get('api/get/messages', {
+        params: { publicKey: publicKey, before: chat.nextBefore }
+      }).success(function(page) {
+        // keep the messages in view where they are
+        var body = $("#mainview-chat-body");
+        var fromBottom = body.prop("scrollHeight") - body.scrollTop();
+
+        chat.messages = page.messages.concat(chat.messages);
+        chat.nextBefore = page.next_before;
+
+        window.setTimeout(function() {
+          body.scrollTop(body.prop("scrollHeight") - fromBottom);
+        }, 0);
       });
     };
 
+    // refreshChat adds the messages sent or received by other clients to a
+    // loaded chat
+    var refreshChat = function(publicKey) {
+      if ($scope.chats[publicKey] === undefined)
+        return;
+
+      $http.get('api/get/messages', {
+        params: { publicKey: publicKey }
+      }).success(function(page) {
+        for (var i in page.messages)
+          addMessage(publicKey, page.messages[i]);
+      });
+    };
+
+    var addMessage = function(publicKey, msg) {
+      var i = getContactIndexByPublicKey(publicKey);
+      if (i != -1)
+        $scope.contacts[i].last_message = msg;
+
+      var chat = $scope.chats[publicKey];
+      if (chat === undefined)
+        return;
+
+      var last = chat.messages[chat.messages.length - 1];
+      if (last !== undefined && last.id >= msg.id)
+        return;
+
+      chat.messages.push(msg);
+      if (isChatShown(publicKey)) {
+        $("#mainview-chat-body").animate({
+          "scrollTop": $("#mainview-chat-body").prop("scrollHeight")
+        }, 1000);
+      }
+    };
+
 
     // == Friends ==
     $scope.sendFriendRequest = function(friend_id, message) {
@@ -198,9 +281,9 @@
       });
     };
 
-    $scope.deleteFriend = function(friend) {
+    $scope.deleteFriend = function(publicKey) {
       $http.post('api/post/delete_friend', {
-        friend: friend
+        publicKey: publicKey
       }).success(function() {
         $('#modal-friend-del').modal('hide');
         $http.get('api/get/contactlist').success(function(data) {
@@ -213,11 +296,353 @@
     };
 
 
+    // == Group chats ==
+    $scope.activeConferenceIndex = function() {
+      for (var i in $scope.conferences)
+        if ($scope.conferences[i].id === $scope.activeConferenceID) return i;
+      return -1;
+    };
+
+    $scope.showConference = function(id) {
+      $scope.activeConferenceID = id;
+      $scope.active_mainview = 'conference';
+      $scope.conferenceUnread[id] = 0;
+
+      if ($scope.conferenceChats[id] === undefined)
+        loadConferenceChat(id);
+      else
+        scrollConferenceToBottom();
+    };
+
+    var isConferenceShown = function(id) {
+      return $scope.active_mainview === 'conference' && $scope.activeConferenceID === id;
+    };
+
+    var scrollConferenceToBottom = function() {
+      window.setTimeout(function() {
+        $("#mainview-conference-body").scrollTop($("#mainview-conference-body").prop("scrollHeight"));
+      }, 10);
+    };
+
+    $scope.peerNames = function(conference) {
+      if (conference === undefined)
+        return '';
+
+      var names = [];
+      for (var i in conference.peers)
+        names.push(conference.peers[i].is_ours ? $scope.profile.username : conference.peers[i].name);
+      return names.join(', ');
+    };
+
+    $scope.createConference = function() {
+      var title = prompt("Title of the new group chat:", "");
+      if (title === null)
+        return;
+
+      $http.post('api/v2/conferences', {
+        title: title
+      }).success(function(conference) {
+        fetchConferences();
+        $scope.showConference(conference.id);
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.setConferenceTitle = function(id, title) {
+      $http({ method: 'PATCH', url: 'api/v2/conferences/' + id, data: { title: title } }).error(function(err) {
+        alert(err.message);
+        fetchConferences();
+      });
+    };
+
+    $scope.leaveConference = function(id) {
+      $http.delete('api/v2/conferences/' + id).success(function() {
+        $('#modal-conference-leave').modal('hide');
+        delete $scope.conferenceChats[id];
+        $scope.active_mainview = 'welcome';
+        fetchConferences();
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.inviteToConference = function(id, publicKey) {
+      $http.post('api/v2/conferences/' + id + '/invites', {
+        publicKey: publicKey
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.acceptConferenceInvite = function(id) {
+      $http.post('api/v2/conference_invites/' + id + '/accept').success(function(conference) {
+        $('#modal-conference-invites').modal('hide');
+        fetchConferenceInvites();
+        fetchConferences();
+        $scope.showConference(conference.id);
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.declineConferenceInvite = function(id) {
+      $http.delete('api/v2/conference_invites/' + id).success(function() {
+        fetchConferenceInvites();
+      });
+    };
+
+    $scope.sendConferenceMessage = function() {
+      if ($scope.conferencemessagetosend.length === 0)
+        return;
+
+      var id = $scope.activeConferenceID;
+      $http.post('api/v2/conferences/' + id + '/messages', {
+        message: $scope.conferencemessagetosend
+      }).success(function(msg) {
+        addConferenceMessage(id, msg);
+      }).error(function(err) {
+        alert(err.message);
+      });
+
+      $scope.conferencemessagetosend = '';
+    };
+
+    var loadConferenceChat = function(id) {
+      $http.get('api/v2/conferences/' + id + '/messages').success(function(page) {
+        $scope.conferenceChats[id] = {
+          messages: page.messages,
+          nextBefore: page.next_before
+        };
+        scrollConferenceToBottom();
+      });
+    };
+
+    $scope.loadOlderConferenceMessages = function() {
+      var id = $scope.activeConferenceID;
+      var chat = $scope.conferenceChats[id];
+      if (chat === undefined || chat.nextBefore === null)
+        return;
+
+      $http.get('api/v2/conferences/' + id + '/messages', {
+        params: { before: chat.nextBefore }
+      }).success(function(page) {
+        // keep the messages in view where they are
+        var body = $("#mainview-conference-body");
+        var fromBottom = body.prop("scrollHeight") - body.scrollTop();
+
+        chat.messages = page.messages.concat(chat.messages);
+        chat.nextBefore = page.next_before;
+
+        window.setTimeout(function() {
+          body.scrollTop(body.prop("scrollHeight") - fromBottom);
+        }, 0);
+      });
+    };
+
+    var addConferenceMessage = function(id, msg) {
+      var chat = $scope.conferenceChats[id];
+      if (chat === undefined)
+        return;
+
+      // the events of a conference can arrive out of order
+      for (var i = chat.messages.length - 1; i >= 0 && chat.messages[i].id >= msg.id; i--)
+        if (chat.messages[i].id === msg.id)
+          return;
+      chat.messages.splice(i + 1, 0, msg);
+
+      if (isConferenceShown(id)) {
+        $("#mainview-conference-body").animate({
+          "scrollTop": $("#mainview-conference-body").prop("scrollHeight")
+        }, 1000);
+      }
+    };
+
+
+    // == Calls ==
+    $scope.getContactName = function(publicKey) {
+      var i = getContactIndexByPublicKey(publicKey);
+      return (i != -1) ? $scope.contacts[i].name : "Unknown";
+    };
+
+    $scope.startCall = function(publicKey) {
+      $http.post('api/post/call', {
+        publicKey: publicKey
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.answerCall = function(publicKey) {
+      $http.post('api/post/call_answer', {
+        publicKey: publicKey
+      }).error(function(err) {
+        alert(err.message);
+      });
+    };
+
+    $scope.hangupCall = function(publicKey) {
+      $http.post('api/post/call_hangup', {
+        publicKey: publicKey
+      });
+    };
+
+    $scope.muteCall = function(publicKey, mute) {
+      $http.post('api/post/call_mute', {
+        publicKey: publicKey,
+        mute: mute
+      });
+    };
+
+    var updateCall = function(call) {
+      if (call.state === 'ended') {
+        delete $scope.calls[call.publicKey];
+        CallAudio.stop();
+        return;
+      }
+
+      $scope.calls[call.publicKey] = call;
+      if (call.state === 'active')
+        CallAudio.start(call.publicKey);
+    };
+
+
+    // == File uploads ==
+    var finishedUploads = {}; // events of an upload can arrive after the last one
+
+    $scope.chooseFiles = function() {
+      $('#mainview-chat-footer-file').click();
+    };
+
+    var sendFile = function(publicKey, file) {
+      var data = new FormData();
+      data.append('file', file);
+
+      $http.post('api/v2/friends/' + publicKey + '/files', data, {
+        transformRequest: angular.identity,
+        headers: { 'Content-Type': undefined }
+      }).success(function(upload) {
+        updateUpload(upload);
+      }).error(function(err) {
+        alert(file.name + ": " + err.message);
+      });
+    };
+
+    $scope.cancelUpload = function(id) {
+      $http.delete('api/v2/uploads/' + id);
+    };
+
+    $scope.pauseUpload = function(id, paused) {
+      $http({ method: 'PATCH', url: 'api/v2/uploads/' + id, data: { paused: paused } }).success(function(upload) {
+        updateUpload(upload);
+      });
+    };
+
+    $scope.uploadProgress = function(upload) {
+      return (upload.size > 0) ? 100 * upload.sent / upload.size : 100;
+    };
+
+    var updateUpload = function(upload) {
+      if (finishedUploads[upload.id])
+        return;
+
+      if (upload.state === 'complete' || upload.state === 'cancelled' || upload.state === 'failed') {
+        finishedUploads[upload.id] = true;
+        delete $scope.uploads[upload.id];
+        return;
+      }
+
+      // keep the progress if an older event arrives late
+      var known = $scope.uploads[upload.id];
+      if (known !== undefined && known.state === upload.state && known.sent > upload.sent)
+        return;
+
+      $scope.uploads[upload.id] = upload;
+    };
+
+
+    // == Files sent by friends ==
+    var finishedDownloads = {}; // events of a download can arrive after the last one
+
+    $scope.acceptDownload = function(id) {
+      $http.post('api/v2/downloads/' + id + '/accept').success(function(download) {
+        updateDownload(download);
+      });
+    };
+
+    $scope.pauseDownload = function(id, paused) {
+      $http({ method: 'PATCH', url: 'api/v2/downloads/' + id, data: { paused: paused } }).success(function(download) {
+        updateDownload(download);
+      });
+    };
+
+    $scope.rejectDownload = function(id) {
+      $http.delete('api/v2/downloads/' + id);
+    };
+
+    $scope.dismissDownload = function(id) {
+      delete $scope.downloads[id];
+    };
+
+    $scope.downloadProgress = function(download) {
+      return (download.size > 0) ? 100 * download.received / download.size : 100;
+    };
+
+    var updateDownload = function(download) {
+      if (finishedDownloads[download.id])
+        return;
+
+      if (download.state !== 'pending' && download.state !== 'receiving') {
+        finishedDownloads[download.id] = true;
+        // complete files stay until the user saves or dismisses them
+        if (download.state === 'complete')
+          $scope.downloads[download.id] = download;
+        else
+          delete $scope.downloads[download.id];
+        return;
+      }
+
+      // keep the progress if an older event arrives late
+      var known = $scope.downloads[download.id];
+      if (known !== undefined && known.state === download.state && known.received > download.received)
+        return;
+
+      $scope.downloads[download.id] = download;
+    };
+
+    // the settings of received files, sizes in MiB and timeouts in minutes
+    $scope.saveFileSettings = function() {
+      $http({ method: 'PATCH', url: 'api/v2/settings', data: {
+        file_max_size: Math.round(($scope.fileMaxSizeMiB || 0) * 1024 * 1024),
+        file_reject_timeout: Math.round(($scope.fileRejectTimeoutMinutes || 0) * 60)
+      }}).success(setSettings).error(fetchSettings);
+    };
+
+    $scope.toggleFileAutoAccept = function(publicKey) {
+      var autoAccept = $scope.settings.file_auto_accept.slice();
+      var i = autoAccept.indexOf(publicKey);
+      if (i != -1)
+        autoAccept.splice(i, 1);
+      else
+        autoAccept.push(publicKey);
+
+      $http({ method: 'PATCH', url: 'api/v2/settings', data: { file_auto_accept: autoAccept } })
+        .success(setSettings).error(fetchSettings);
+    };
+
+
     // == Event handlers ==
-    $('#profile-card-back-button').click(function() {
+    $('#profile-card-back-button, #conference-back-button').click(function() {
       $('#profile-card, #contact-list-wrapper, #button-panel').removeClass('translate75left');
       $('#mainview').removeClass('translate100left');
-      $('#profile-card-back-button').hide();
+      $('#profile-card-back-button, #conference-back-button').hide();
+    });
+
+    $('#mainview-chat-footer-file').change(function() {
+      var publicKey = $scope.contacts[$scope.activecontactindex].publicKey;
+      for (var i = 0; i < this.files.length; i++)
+        sendFile(publicKey, this.files[i]);
+      $(this).val('');
     });
 
     $("#mainview-chat-footer-textarea-wrapper textarea").keyup(function(event) {
@@ -226,6 +651,12 @@
       }
     });
 
+    $("#mainview-conference-footer-textarea-wrapper textarea").keyup(function(event) {
+      if (event.which == 13 && event.shiftKey !== true) {
+        $scope.sendConferenceMessage();
+      }
+    });
+
     $('#inputAuthUser').change(function() {
       $(this).parent().next().find('button').show();
     }).keyup(function() {
@@ -283,10 +714,14 @@
     };
 
     // == fetch data from the server ==
+    var setSettings = function(data) {
+      $scope.settings = data;
+      $scope.fileMaxSizeMiB = data.file_max_size / 1024 / 1024;
+      $scope.fileRejectTimeoutMinutes = data.file_reject_timeout / 60;
+    };
+
     var fetchSettings = function() {
-      $http.get('api/get/settings').success(function(data) {
-        $scope.settings = data;
-      });
+      $http.get('api/get/settings').success(setSettings);
     };
 
     var fetchProfile = function() {
@@ -298,6 +733,32 @@
     var fetchContactlist = function() {
       $http.get('api/get/contactlist').success(function(data) {
         $scope.contacts = data;
+        if ($scope.active_mainview === 'chat' && $scope.contacts[$scope.activecontactindex] !== undefined)
+          refreshChat($scope.contacts[$scope.activecontactindex].publicKey);
+      });
+    };
+
+    var fetchCalls = function() {
+      $http.get('api/get/calls').success(function(data) {
+        $scope.calls = {};
+        for (var i in data)
+          updateCall(data[i]);
+      });
+    };
+
+    var fetchUploads = function() {
+      $http.get('api/v2/uploads').success(function(data) {
+        $scope.uploads = {};
+        for (var i in data)
+          updateUpload(data[i]);
+      });
+    };
+
+    var fetchDownloads = function() {
+      $http.get('api/v2/downloads').success(function(data) {
+        $scope.downloads = {};
+        for (var i in data)
+          updateDownload(data[i]);
       });
     };
 
@@ -307,57 +768,137 @@
       });
     };
 
+    var fetchConferences = function() {
+      $http.get('api/v2/conferences').success(function(data) {
+        $scope.conferences = data;
+      });
+    };
+
+    var fetchConferenceInvites = function() {
+      $http.get('api/v2/conference_invites').success(function(data) {
+        $scope.conferenceInvites = data;
+      });
+    };
+
     // == WebSocket connection ==
     WS.registerHandler('friend_message', function(data) {
-      var i = getContactIndexByNum(data.friend);
+      var i = getContactIndexByPublicKey(data.publicKey);
       if (i >= 0 && i < $scope.contacts.length) {
-        $scope.contacts[i].chat.unshift({
+        addMessage(data.publicKey, {
+          "id": data.id,
           "message": data.message,
           "isIncoming": true,
           "isAction": data.isAction,
           "time": data.time
         });
+        if (isChatShown(data.publicKey))
+          sendMessageRead(data.publicKey);
+        else
+          $scope.contacts[i].unread++;
+
         if ($scope.settings.notifications_enabled) {
-          Notifications.show($scope.contacts[i].name, data.message, "friend_message"+$scope.contacts[i].number, function() {
-            $scope.showChat(data.friend);
+          Notifications.show($scope.contacts[i].name, data.message, "friend_message"+$scope.contacts[i].publicKey, function() {
+            $scope.showChat(data.publicKey);
           });
         }
+      }
+    });
+
+    WS.registerHandler('message_status', function(data) {
+      var chat = $scope.chats[data.publicKey];
+      if (chat === undefined)
+        return;
+
+      for (var i = chat.messages.length - 1; i >= 0; i--) {
+        if (chat.messages[i].id == data.id) {
+          chat.messages[i].status = data.status;
+          break;
+        }
+      }
+    });
 
-        $("#mainview-chat-body").animate({
-          "scrollTop": $("#mainview-chat-body").prop("scrollHeight")
-        }, 1000);
+    WS.registerHandler('message_delivered', function(data) {
+      var chat = $scope.chats[data.publicKey];
+      if (chat === undefined)
+        return;
+
+      for (var i = chat.messages.length - 1; i >= 0; i--) {
+        if (chat.messages[i].id == data.id) {
+          chat.messages[i].status = 'delivered';
+          chat.messages[i].delivered = data.delivered;
+          break;
+        }
       }
     });
 
     WS.registerHandler('name_changed', function(data) {
-      var i = getContactIndexByNum(data.friend);
+      var i = getContactIndexByPublicKey(data.publicKey);
       if (i >= 0 && i < $scope.contacts.length)
         $scope.contacts[i].name = data.name;
     });
 
     WS.registerHandler('status_message_changed', function(data) {
-      var i = getContactIndexByNum(data.friend);
+      var i = getContactIndexByPublicKey(data.publicKey);
       if (i >= 0 && i < $scope.contacts.length)
         $scope.contacts[i].status_msg = data.status_msg;
     });
 
     WS.registerHandler('status_changed', function(data) {
-      var i = getContactIndexByNum(data.friend);
+      var i = getContactIndexByPublicKey(data.publicKey);
       if (i >= 0 && i < $scope.contacts.length)
         $scope.contacts[i].status = data.status;
     });
 
     WS.registerHandler('connection_status', function(data) {
-      var i = getContactIndexByNum(data.friend);
+      var i = getContactIndexByPublicKey(data.publicKey);
       $scope.contacts[i].online = data.online;
       if ($scope.settings.notifications_enabled) {
-        Notifications.show($scope.contacts[i].name, "is now " + (data.online ? 'online' : 'offline'), "connection_status"+$scope.contacts[i].number);
+        Notifications.show($scope.contacts[i].name, "is now " + (data.online ? 'online' : 'offline'), "connection_status"+$scope.contacts[i].publicKey);
+      }
+    });
+
+    WS.registerHandler('call', function(data) {
+      updateCall(data);
+      if (data.state === 'incoming' && $scope.settings.notifications_enabled) {
+        Notifications.show($scope.getContactName(data.publicKey), "is calling you", "call"+data.publicKey, function() {
+          $scope.showChat(data.publicKey);
+        });
+      }
+    });
+
+    WS.registerHandler('file_upload', function(data) {
+      updateUpload(data);
+      if (data.state === 'failed' && $scope.settings.notifications_enabled)
+        Notifications.show($scope.getContactName(data.publicKey), data.name + " could not be sent", "file_upload" + data.id);
+    });
+
+    WS.registerHandler('file_download', function(data) {
+      updateDownload(data);
+      if (data.state === 'pending' && $scope.settings.notifications_enabled) {
+        Notifications.show($scope.getContactName(data.publicKey), "wants to send you " + data.name, "file_download" + data.id, function() {
+          $scope.showChat(data.publicKey);
+        });
+      }
+    });
+
+    WS.registerHandler('conference_message', function(data) {
+      addConferenceMessage(data.conference, data);
+      if (!data.isIncoming || isConferenceShown(data.conference))
+        return;
+
+      $scope.conferenceUnread[data.conference] = ($scope.conferenceUnread[data.conference] || 0) + 1;
+      if ($scope.settings.notifications_enabled) {
+        Notifications.show(data.name, data.message, "conference_message" + data.conference, function() {
+          $scope.showConference(data.conference);
+        });
       }
     });
 
     WS.registerHandler('profile_update', fetchProfile);
     WS.registerHandler('friendlist_update', fetchContactlist);
     WS.registerHandler('friend_requests_update', fetchFriendRequests);
+    WS.registerHandler('conferencelist_update', fetchConferences);
+    WS.registerHandler('conference_invites_update', fetchConferenceInvites);
 
     WS.registerHandler('avatar_update', function() {
       $scope.curDate = Date.now(); // reload avatar images
@@ -370,6 +911,11 @@
       fetchContactlist();
       fetchFriendRequests();
       fetchSettings();
+      fetchCalls();
+      fetchUploads();
+      fetchDownloads();
+      fetchConferences();
+      fetchConferenceInvites();
       $scope.$apply();
     };
 
@@ -0,0 +1,98 @@
+(function() {
+  var app = angular.module('calls', []);
+
+  // CallAudio relays the audio of a call over /calls/audio: 16 bit mono PCM at
+  // 48 kHz in both directions.
+  app.service('CallAudio', function() {
+    var SAMPLE_RATE = 48000;
+
+    var ws = null;
+    var context = null;
+    var stream = null;
+    var processor = null;
+    var playTime = 0;
+    var current = null; // the public key of the friend in the call
+
+    var play = function(pcm) {
+      var buffer = context.createBuffer(1, pcm.length, SAMPLE_RATE);
+      var samples = buffer.getChannelData(0);
+      for (var i = 0; i < pcm.length; i++)
+        samples[i] = pcm[i] / 0x8000;
+
+      var source = context.createBufferSource();
+      source.buffer = buffer;
+      source.connect(context.destination);
+
+      // keep a little buffer to even out the network jitter
+      playTime = Math.max(playTime, context.currentTime + 0.06);
+      source.start(playTime);
+      playTime += buffer.duration;
+    };
+
+    var capture = function(s) {
+      if (context === null) {
+        // the call ended while we were waiting for the microphone
+        s.getTracks().forEach(function(track) { track.stop(); });
+        return;
+      }
+
+      stream = s;
+      processor = context.createScriptProcessor(1024, 1, 1);
+      processor.onaudioprocess = function(event) {
+        if (ws === null || ws.readyState !== WebSocket.OPEN)
+          return;
+
+        var input = event.inputBuffer.getChannelData(0);
+        var pcm = new Int16Array(input.length);
+        for (var i = 0; i < input.length; i++) {
+          var sample = Math.max(-1, Math.min(1, input[i]));
+          pcm[i] = sample < 0 ? sample * 0x8000 : sample * 0x7fff;
+        }
+        ws.send(pcm.buffer);
+      };
+      context.createMediaStreamSource(s).connect(processor);
+      processor.connect(context.destination); // the output stays silent
+    };
+
+    this.start = function(publicKey) {
+      if (current === publicKey)
+        return;
+      this.stop();
+
+      current = publicKey;
+      context = new (window.AudioContext || window.webkitAudioContext)({ sampleRate: SAMPLE_RATE });
+      playTime = 0;
+
+      ws = new WebSocket("wss://" + location.host + "/calls/audio?publicKey=" + publicKey);
+      ws.binaryType = 'arraybuffer';
+      ws.onmessage = function(event) {
+        play(new Int16Array(event.data));
+      };
+
+      navigator.mediaDevices.getUserMedia({ audio: true }).then(capture, function(err) {
+        console.log("[Calls] No microphone, only receiving audio.", err);
+      });
+    };
+
+    this.stop = function() {
+      current = null;
+      if (ws !== null) {
+        ws.onclose = null;
+        ws.close();
+        ws = null;
+      }
+      if (processor !== null) {
+        processor.disconnect();
+        processor = null;
+      }
+      if (stream !== null) {
+        stream.getTracks().forEach(function(track) { track.stop(); });
+        stream = null;
+      }
+      if (context !== null) {
+        context.close();
+        context = null;
+      }
+    };
+  });
+})();
@@ -0,0 +1,257 @@
+//go:build !toxsim
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+// the boundary between the JPEG frames of a MJPEG stream
+const mjpegBoundary = "webtoxframe"
+
+// videoFeed is the video received in a call, JPEG encoded at no more than the
+// configured frame rate
+type videoFeed struct {
+	friend uint32
+	frames chan *image.YCbCr // to the encoder; a frame is dropped while it is busy
+	last   time.Time         // when the last frame was passed to the encoder
+
+	mtx         sync.Mutex
+	closed      bool
+	snapshot    []byte // the latest JPEG
+	subscribers map[chan []byte]bool
+}
+
+var videoFeedsMtx sync.Mutex
+
+// Map of the video feeds of the calls
+var videoFeeds = make(map[uint32]*videoFeed)
+
+func onVideoReceiveFrame(tav *libtoxav.ToxAV, friendnumber uint32, frame *image.YCbCr) {
+	feed := getVideoFeed(friendnumber, true)
+	if feed == nil {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(feed.last) < time.Duration(float64(time.Second)/getConfig().Calls.VideoMaxFPS) {
+		return
+	}
+
+	feed.mtx.Lock()
+	defer feed.mtx.Unlock()
+
+	if feed.closed {
+		return
+	}
+	select {
+	case feed.frames <- frame:
+		feed.last = now
+	default:
+	}
+}
+
+// getVideoFeed returns the video feed of the call with a friend
+// friendnumber  the friend in the call
+// create        create the feed if the friend is in a call but has none yet
+func getVideoFeed(friendnumber uint32, create bool) *videoFeed {
+	videoFeedsMtx.Lock()
+	defer videoFeedsMtx.Unlock()
+
+	feed, ok := videoFeeds[friendnumber]
+	if ok || !create || !friendInCall(friendnumber) {
+		return feed
+	}
+
+	feed = &videoFeed{
+		friend:      friendnumber,
+		frames:      make(chan *image.YCbCr, 1),
+		subscribers: make(map[chan []byte]bool),
+	}
+	videoFeeds[friendnumber] = feed
+	go feed.encode()
+	go setCallVideo(friendnumber)
+
+	log.Println("Receiving video from friend", friendnumber)
+	return feed
+}
+
+// closeVideoFeed stops the video feed of a friend whose call ended and ends
+// its MJPEG streams
+// friendnumber  the friend whose call ended
+func closeVideoFeed(friendnumber uint32) {
+	videoFeedsMtx.Lock()
+	feed, ok := videoFeeds[friendnumber]
+	delete(videoFeeds, friendnumber)
+	videoFeedsMtx.Unlock()
+
+	if !ok {
+		return
+	}
+
+	feed.mtx.Lock()
+	defer feed.mtx.Unlock()
+
+	feed.closed = true
+	close(feed.frames)
+	for frames := range feed.subscribers {
+		delete(feed.subscribers, frames)
+		close(frames)
+	}
+}
+
+// encode JPEG encodes the frames of the feed and passes them on to the
+// subscribers until the feed is closed
+func (feed *videoFeed) encode() {
+	var buf bytes.Buffer
+	for frame := range feed.frames {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, frame, &jpeg.Options{Quality: getConfig().Calls.VideoJPEGQuality}); err != nil {
+			log.Println("[ERROR] Could not encode video frame:", err)
+			continue
+		}
+		jpg := append([]byte(nil), buf.Bytes()...)
+
+		feed.mtx.Lock()
+		feed.snapshot = jpg
+		for frames := range feed.subscribers {
+			// a client that is still busy with the previous frame skips this one
+			select {
+			case frames <- jpg:
+			default:
+			}
+		}
+		feed.mtx.Unlock()
+	}
+}
+
+// subscribe returns a channel receiving the JPEG frames of the feed and a
+// function to unsubscribe. The channel is closed when the feed is closed.
+func (feed *videoFeed) subscribe() (chan []byte, func()) {
+	frames := make(chan []byte, 1)
+
+	feed.mtx.Lock()
+	if feed.closed {
+		close(frames)
+	} else {
+		feed.subscribers[frames] = true
+	}
+	feed.mtx.Unlock()
+
+	return frames, func() {
+		feed.mtx.Lock()
+		defer feed.mtx.Unlock()
+
+		if feed.subscribers[frames] {
+			delete(feed.subscribers, frames)
+			close(frames)
+		}
+	}
+}
+
+// getSnapshot returns the latest JPEG frame of the feed, nil if no frame was
+// encoded yet
+func (feed *videoFeed) getSnapshot() []byte {
+	feed.mtx.Lock()
+	defer feed.mtx.Unlock()
+
+	return feed.snapshot
+}
+
+// videoFeedOfRequest returns the video feed of the friend in the query of a
+// request: ?publicKey=<public key of the friend>
+func videoFeedOfRequest(r *http.Request) *videoFeed {
+	friendnumber, err := friendNumberOfPublicKey(r.URL.Query().Get("publicKey"))
+	if err != nil {
+		return nil
+	}
+
+	return getVideoFeed(friendnumber, false)
+}
+
+// handleCallVideo serves the video of a call as a multipart MJPEG stream:
+// /calls/video?publicKey=<public key of the friend>[&fps=<frames per second>]. fps lowers
+// the frame rate below the configured one.
+var handleCallVideo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	feed := videoFeedOfRequest(r)
+	if feed == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var interval time.Duration
+	if fps, err := strconv.ParseFloat(r.URL.Query().Get("fps"), 64); err == nil && fps > 0 {
+		interval = time.Duration(float64(time.Second) / fps)
+	}
+
+	frames, unsubscribe := feed.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	flusher, _ := w.(http.Flusher)
+
+	var last time.Time
+	for {
+		var jpg []byte
+		var ok bool
+		select {
+		case jpg, ok = <-frames:
+			if !ok {
+				// the call ended
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		now := time.Now()
+		if now.Sub(last) < interval {
+			continue
+		}
+		last = now
+
+		if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(jpg)); err != nil {
+			return
+		}
+		if _, err := w.Write(jpg); err != nil {
+			return
+		}
+		if _, err := w.Write([]byte("\r\n")); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+})
+
+// handleCallSnapshot serves the latest frame of the video of a call as JPEG:
+// /calls/snapshot?publicKey=<public key of the friend>
+var handleCallSnapshot = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	feed := videoFeedOfRequest(r)
+	if feed == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	jpg := feed.getSnapshot()
+	if jpg == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(jpg)
+})
@@ -0,0 +1,457 @@
+//go:build !toxsim
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+	"golang.org/x/net/websocket"
+)
+
+// the global toxav instance, nil if calls are not available
+var toxav *libtoxav.ToxAV
+
+// the runner iterating toxav; tox itself is iterated by the main loop
+var avRunner *libtoxav.Runner
+
+// browserCall is a call taken in the GUI. Its audio is relayed as PCM frames
+// over the audio WebSockets of the browsers, see handleCallAudio.
+type browserCall struct {
+	friend    uint32
+	publicKey string // identifies the friend in the events
+	incoming  bool
+	active    bool // answered by us or accepted by the friend
+	muted     bool
+	video     bool // the friend sends video, see handleCallVideo
+
+	ring    *time.Timer                     // hands an unanswered incoming call to the voicemail
+	pending []byte                          // PCM from the browser that does not fill a frame yet
+	sinks   map[*websocket.Conn]chan []byte // PCM frames to the browsers
+}
+
+var callsMtx sync.Mutex
+
+// Map of calls taken in the GUI
+var calls = make(map[uint32]*browserCall)
+
+// callFrameSize is the size in bytes of the PCM frames relayed to and from the
+// browsers: 20 ms of 16 bit mono audio at 48 kHz
+const callFrameSize = 2 * libtoxav.DefaultChannels * int(libtoxav.DefaultSamplingRate) / 50
+
+// startAV creates the ToxAV instance for the global tox instance, registers
+// the call callbacks and starts iterating it
+func startAV() error {
+	var err error
+	toxav, err = libtoxav.NewToxAV(tox)
+	if err != nil {
+		return err
+	}
+
+	toxav.CallbackCall(onCall)
+	toxav.CallbackCallState(onCallState)
+	toxav.CallbackAudioReceiveFrame(onAudioReceiveFrame)
+	toxav.CallbackVideoReceiveFrame(onVideoReceiveFrame)
+
+	avRunner = libtoxav.NewRunner(toxav, &libtoxav.RunnerOptions{NoCoreLoop: true})
+	return avRunner.Start()
+}
+
+// stopAV ends all calls and kills the ToxAV instance. It has to be called
+// before tox is killed.
+func stopAV() {
+	if avRunner == nil {
+		return
+	}
+
+	cancelVoicemails()
+	cancelCalls()
+	if err := avRunner.Close(); err != nil {
+		log.Println("[ERROR] Could not kill ToxAV:", err)
+	}
+}
+
+func onCall(tav *libtoxav.ToxAV, friendnumber uint32, audioenabled bool, videoenabled bool) {
+	log.Printf("Incoming call from friend %d (audio: %t, video: %t)\n", friendnumber, audioenabled, videoenabled)
+
+	if voicemailShouldAnswer() {
+		// toxav must not be called from its own callbacks
+		go startVoicemail(friendnumber)
+		return
+	}
+
+	call := &browserCall{friend: friendnumber, publicKey: publicKeyOfFriend(friendnumber), incoming: true, sinks: make(map[*websocket.Conn]chan []byte)}
+
+	callsMtx.Lock()
+	if _, exists := calls[friendnumber]; exists {
+		callsMtx.Unlock()
+		return
+	}
+	calls[friendnumber] = call
+	if ringTimeout := getConfig().Calls.RingTimeout.Duration; ringTimeout > 0 && voicemailEnabled() {
+		call.ring = time.AfterFunc(ringTimeout, func() { answerWithVoicemail(call) })
+	}
+	callsMtx.Unlock()
+
+	broadcastCallEvent(call, "incoming")
+}
+
+func onCallState(tav *libtoxav.ToxAV, friendnumber uint32, state libtoxav.ToxavFriendCallState) {
+	if state.Ended() {
+		go endVoicemail(friendnumber)
+		endCall(friendnumber)
+		return
+	}
+
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	accepted := ok && !call.incoming && !call.active
+	if accepted {
+		// the friend answered our call
+		call.active = true
+	}
+	callsMtx.Unlock()
+
+	if accepted {
+		broadcastCallEvent(call, "active")
+	}
+}
+
+func onAudioReceiveFrame(tav *libtoxav.ToxAV, friendnumber uint32, frame *libtoxav.AudioFrame) {
+	recordVoicemail(friendnumber, frame)
+	relayCallAudio(friendnumber, frame)
+}
+
+// startCall calls a friend, audio only
+// friendnumber  the friend to be called
+func startCall(friendnumber uint32) error {
+	if toxav == nil {
+		return errCallsUnavailable
+	}
+
+	call := &browserCall{friend: friendnumber, publicKey: publicKeyOfFriend(friendnumber), sinks: make(map[*websocket.Conn]chan []byte)}
+
+	callsMtx.Lock()
+	if _, exists := calls[friendnumber]; exists {
+		callsMtx.Unlock()
+		return libtoxav.ErrCallFriendAlreadyInCall
+	}
+	// register the call first, the friend might answer before Call returns
+	calls[friendnumber] = call
+	callsMtx.Unlock()
+
+	if _, err := toxav.Call(friendnumber, getConfig().Calls.AudioBitRate, 0); err != nil {
+		callsMtx.Lock()
+		delete(calls, friendnumber)
+		callsMtx.Unlock()
+		return err
+	}
+
+	broadcastCallEvent(call, "outgoing")
+	return nil
+}
+
+// answerCall answers the incoming call of a friend
+// friendnumber  the friend who is calling
+func answerCall(friendnumber uint32) error {
+	if toxav == nil {
+		return errCallsUnavailable
+	}
+
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	callsMtx.Unlock()
+	if !ok || !call.incoming {
+		return libtoxav.ErrAnswerFriendNotCalling
+	}
+
+	if _, err := toxav.Answer(friendnumber, getConfig().Calls.AudioBitRate, 0); err != nil {
+		return err
+	}
+
+	callsMtx.Lock()
+	call.active = true
+	if call.ring != nil {
+		call.ring.Stop()
+	}
+	callsMtx.Unlock()
+
+	broadcastCallEvent(call, "active")
+	return nil
+}
+
+// hangupCall hangs up the call with a friend or rejects it if it was not
+// answered yet
+// friendnumber  the friend in the call
+func hangupCall(friendnumber uint32) error {
+	if toxav == nil {
+		return errCallsUnavailable
+	}
+
+	callsMtx.Lock()
+	_, ok := calls[friendnumber]
+	callsMtx.Unlock()
+	if !ok {
+		return libtoxav.ErrCallControlFriendNotInCall
+	}
+
+	err := toxav.Cancel(friendnumber)
+	// hanging up ourselves does not trigger onCallState
+	endCall(friendnumber)
+
+	return err
+}
+
+// muteCall stops (mute true) or restarts sending our audio to a friend
+// friendnumber  the friend in the call
+// mute          whether the audio from the browser is muted
+func muteCall(friendnumber uint32, mute bool) error {
+	if toxav == nil {
+		return errCallsUnavailable
+	}
+
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	var state string
+	if ok {
+		call.muted = mute
+		call.pending = nil
+		state = callState(call)
+	}
+	callsMtx.Unlock()
+	if !ok {
+		return libtoxav.ErrCallControlFriendNotInCall
+	}
+
+	broadcastCallEvent(call, state)
+	return nil
+}
+
+// endCall removes the call with a friend and disconnects its audio sockets
+// friendnumber  the friend whose call ended
+func endCall(friendnumber uint32) {
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	if ok {
+		delete(calls, friendnumber)
+		if call.ring != nil {
+			call.ring.Stop()
+		}
+		for conn, frames := range call.sinks {
+			delete(call.sinks, conn)
+			close(frames)
+		}
+	}
+	callsMtx.Unlock()
+
+	if ok {
+		closeVideoFeed(friendnumber)
+		broadcastCallEvent(call, "ended")
+	}
+}
+
+// setCallVideo marks the call with a friend as a video call
+// friendnumber  the friend who sends video
+func setCallVideo(friendnumber uint32) {
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	var state string
+	if ok {
+		call.video = true
+		state = callState(call)
+	}
+	callsMtx.Unlock()
+
+	if ok {
+		broadcastCallEvent(call, state)
+	}
+}
+
+// friendInCall returns true if the friend is in a call taken in the GUI or
+// by the voicemail
+// friendnumber  the friend
+func friendInCall(friendnumber uint32) bool {
+	callsMtx.Lock()
+	_, inCall := calls[friendnumber]
+	callsMtx.Unlock()
+
+	voicemailMtx.Lock()
+	_, inVoicemail := voicemailSessions[friendnumber]
+	voicemailMtx.Unlock()
+
+	return inCall || inVoicemail
+}
+
+// cancelCalls hangs up all calls taken in the GUI
+func cancelCalls() {
+	callsMtx.Lock()
+	var friends []uint32
+	for friendnumber := range calls {
+		friends = append(friends, friendnumber)
+	}
+	callsMtx.Unlock()
+
+	for _, friendnumber := range friends {
+		hangupCall(friendnumber)
+	}
+}
+
+// answerWithVoicemail hands an incoming call that nobody answered to the
+// voicemail
+// call  the ringing call
+func answerWithVoicemail(call *browserCall) {
+	callsMtx.Lock()
+	ringing := calls[call.friend] == call && !call.active
+	callsMtx.Unlock()
+	if !ringing {
+		return
+	}
+
+	endCall(call.friend)
+	startVoicemail(call.friend)
+}
+
+// callState returns the state of a call as used in the "call" event
+// call  the call
+func callState(call *browserCall) string {
+	switch {
+	case call.active:
+		return "active"
+	case call.incoming:
+		return "incoming"
+	default:
+		return "outgoing"
+	}
+}
+
+// broadcastCallEvent sends the state of a call to all connected clients
+// call   the call
+// state  "incoming", "outgoing", "active" or "ended"
+func broadcastCallEvent(call *browserCall, state string) {
+	type jsonEvent struct {
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		State     string `json:"state"`
+		Muted     bool   `json:"muted"`
+		Video     bool   `json:"video"`
+	}
+
+	callsMtx.Lock()
+	muted, video := call.muted, call.video
+	callsMtx.Unlock()
+
+	e, _ := json.Marshal(jsonEvent{
+		Type:      "call",
+		PublicKey: call.publicKey,
+		Number:    call.friend,
+		State:     state,
+		Muted:     muted,
+		Video:     video,
+	})
+
+	broadcastToClients(string(e))
+}
+
+// getCallsJSON returns the calls taken in the GUI as a JSON string
+func getCallsJSON() string {
+	type jsonCall struct {
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		State     string `json:"state"`
+		Muted     bool   `json:"muted"`
+		Video     bool   `json:"video"`
+	}
+
+	callsMtx.Lock()
+	list := []jsonCall{}
+	for friendnumber, call := range calls {
+		list = append(list, jsonCall{PublicKey: call.publicKey, Number: friendnumber, State: callState(call), Muted: call.muted, Video: call.video})
+	}
+	callsMtx.Unlock()
+
+	jsonCalls, _ := json.Marshal(list)
+	return string(jsonCalls)
+}
+
+// toCallFormat converts an audio frame to 16 bit mono at 48 kHz, the format
+// of the PCM relayed to the browsers and of the voicemail recordings
+// frame  the frame to be converted
+func toCallFormat(frame *libtoxav.AudioFrame) (*libtoxav.AudioFrame, error) {
+	if frame.SamplingRate == libtoxav.DefaultSamplingRate && frame.Channels == libtoxav.DefaultChannels {
+		return frame, nil
+	}
+
+	framer, err := libtoxav.NewAudioFramer(bytes.NewReader(frame.Bytes()), frame.SamplingRate, frame.Channels,
+		libtoxav.DefaultSamplingRate, libtoxav.DefaultChannels, frame.Duration())
+	if err != nil {
+		return nil, err
+	}
+	return framer.ReadFrame()
+}
+
+// relayCallAudio passes a received audio frame on to the audio sockets of the
+// call with the friend. Frames are dropped for browsers that fall behind.
+// friendnumber  the friend who sent the frame
+// frame         the received audio frame
+func relayCallAudio(friendnumber uint32, frame *libtoxav.AudioFrame) {
+	callsMtx.Lock()
+	defer callsMtx.Unlock()
+
+	call, ok := calls[friendnumber]
+	if !ok || len(call.sinks) == 0 {
+		return
+	}
+
+	frame, err := toCallFormat(frame)
+	if err != nil {
+		return
+	}
+	pcm := frame.Bytes()
+
+	for _, frames := range call.sinks {
+		select {
+		case frames <- pcm:
+		default:
+		}
+	}
+}
+
+// sendCallAudio sends PCM received from a browser to the friend, cut into
+// 20 ms frames. PCM is dropped while the call is muted or not answered.
+// friendnumber  the friend in the call
+// pcm           16 bit little endian mono PCM at 48 kHz
+func sendCallAudio(friendnumber uint32, pcm []byte) {
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	if !ok || !call.active || call.muted {
+		callsMtx.Unlock()
+		return
+	}
+
+	call.pending = append(call.pending, pcm...)
+	var frames []*libtoxav.AudioFrame
+	for len(call.pending) >= callFrameSize {
+		frame, _ := libtoxav.NewAudioFrame(libtoxav.DefaultSamplingRate, libtoxav.DefaultChannels, libtoxav.DefaultFrameDuration)
+		for i := range frame.PCM {
+			frame.PCM[i] = int16(binary.LittleEndian.Uint16(call.pending[2*i:]))
+		}
+		frames = append(frames, frame)
+		call.pending = call.pending[callFrameSize:]
+	}
+	// do not keep the backing array of a long upload alive
+	call.pending = append([]byte(nil), call.pending...)
+	callsMtx.Unlock()
+
+	for _, frame := range frames {
+		if err := toxav.AudioSendFrame(friendnumber, frame); err != nil && err != libtoxav.ErrSendFramePayloadTypeDisabled {
+			log.Println("[ERROR] Could not send audio:", err)
+			return
+		}
+	}
+}
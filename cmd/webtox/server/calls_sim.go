@@ -0,0 +1,34 @@
+//go:build toxsim
+
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// startAV is a no-op: the simulated backend has no ToxAV, so calls are not
+// available
+func startAV() error {
+	log.Println("Calls are not available with the simulated Tox backend")
+	return nil
+}
+
+// stopAV is a no-op, see startAV
+func stopAV() {}
+
+func startCall(friendnumber uint32) error { return errCallsUnavailable }
+
+func answerCall(friendnumber uint32) error { return errCallsUnavailable }
+
+func hangupCall(friendnumber uint32) error { return errCallsUnavailable }
+
+func muteCall(friendnumber uint32, mute bool) error { return errCallsUnavailable }
+
+func getCallsJSON() string { return "[]" }
+
+var handleCallAudio = http.NotFoundHandler()
+
+var handleCallVideo = http.NotFoundHandler()
+
+var handleCallSnapshot = http.NotFoundHandler()
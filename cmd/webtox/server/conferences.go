@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+var (
+	errUnknownConference = errors.New("Unknown conference")
+	errConferenceOffline = errors.New("Not connected to the conference")
+	errInvalidTitle      = errors.New("The title is too long")
+	errInvalidMessage    = errors.New("The message is too long")
+	errFriendOffline     = errors.New("The friend is offline")
+	errJoinFailed        = errors.New("The conference could not be joined")
+)
+
+var conferencesMtx sync.Mutex
+
+// The conferences that were joined but are not connected yet, by ID. The
+// conferences the user created and the ones restored from the saveData are
+// considered connected.
+var conferencesJoining = make(map[string]bool)
+
+// apiConferencePeer is a member of a conference. The peers are read whenever a
+// conference is requested; the conferencelist_update event tells the clients
+// when they change.
+type apiConferencePeer struct {
+	Number    uint32 `json:"number"`
+	PublicKey string `json:"publicKey"`
+	Name      string `json:"name"`
+	IsOurs    bool   `json:"is_ours"`
+	IsFriend  bool   `json:"is_friend"`
+}
+
+// apiConference is a text conference the user takes part in. The chat history
+// is not part of it, see getConferenceMessagePage.
+type apiConference struct {
+	ID          string                `json:"id"`
+	Number      uint32                `json:"number"` // informational only, see conferenceOfID
+	Title       string                `json:"title"`
+	Connected   bool                  `json:"connected"`
+	Peers       []apiConferencePeer   `json:"peers"`
+	LastMessage *apiConferenceMessage `json:"last_message"` // nil if there are no messages
+}
+
+type apiConferenceMessage struct {
+	ID         int64  `json:"id"`
+	PublicKey  string `json:"publicKey"` // the public key of the sender
+	Name       string `json:"name"`      // the name of the sender when the message was sent
+	Message    string `json:"message"`
+	IsIncoming bool   `json:"isIncoming"`
+	IsAction   bool   `json:"isAction"`
+	Time       int64  `json:"time"`
+}
+
+// conferenceMessagePage is a page of the chat history of a conference
+type conferenceMessagePage struct {
+	Messages   []apiConferenceMessage `json:"messages"`    // oldest first
+	NextBefore *int64                 `json:"next_before"` // the before of the next (older) page, nil if this is the oldest one
+}
+
+type apiConferenceInvite struct {
+	ID        int64  `json:"id"`
+	PublicKey string `json:"publicKey"` // the friend who sent the invite
+	Time      int64  `json:"time"`
+}
+
+// conferenceID returns the ID of a conference as lowercase hex string. Unlike
+// the conference number it does not change when webtox restarts.
+// number  the conference number
+func conferenceID(number uint32) (string, error) {
+	id, err := tox.ConferenceGetIdentifier(number)
+	if err != nil {
+		return "", errUnknownConference
+	}
+	return strings.ToLower(id), nil
+}
+
+// conferenceOfID returns the conference number and the normalised ID of a
+// conference
+// id  the ID of the conference as hex string
+func conferenceOfID(id string) (uint32, string, error) {
+	idBytes, err := hex.DecodeString(id)
+	if err != nil || len(idBytes) != libtox.TOX_CONFERENCE_ID_SIZE {
+		return 0, "", errUnknownConference
+	}
+	id = hex.EncodeToString(idBytes)
+
+	numbers, _ := tox.ConferenceGetChatlist()
+	for _, number := range numbers {
+		if numberID, err := conferenceID(number); err == nil && numberID == id {
+			return number, id, nil
+		}
+	}
+
+	return 0, "", errUnknownConference
+}
+
+// getAPIConference returns a conference as listed in the conference list
+// number  the conference number
+func getAPIConference(number uint32) (apiConference, error) {
+	id, err := conferenceID(number)
+	if err != nil {
+		return apiConference{}, err
+	}
+
+	// untitled conferences have no title in toxcore
+	title, _ := tox.ConferenceGetTitle(number)
+
+	conferencesMtx.Lock()
+	connected := !conferencesJoining[id]
+	conferencesMtx.Unlock()
+
+	conference := apiConference{ID: id, Number: number, Title: title, Connected: connected, Peers: []apiConferencePeer{}}
+
+	count, _ := tox.ConferencePeerCount(number)
+	for peer := uint32(0); peer < count; peer++ {
+		publicKey, err := tox.ConferencePeerGetPublicKey(number, peer)
+		if err != nil {
+			continue
+		}
+		publicKeyBytes, _ := hex.DecodeString(publicKey)
+		_, err = tox.FriendByPublicKey(publicKeyBytes)
+		name, _ := tox.ConferencePeerGetName(number, peer)
+		isOurs, _ := tox.ConferencePeerNumberIsOurs(number, peer)
+
+		conference.Peers = append(conference.Peers, apiConferencePeer{
+			Number:    peer,
+			PublicKey: strings.ToLower(publicKey),
+			Name:      name,
+			IsOurs:    isOurs,
+			IsFriend:  err == nil,
+		})
+	}
+
+	if messages := storage.GetConferenceMessagesBefore(id, 0, 1); len(messages) > 0 {
+		lastMessage := getAPIConferenceMessage(messages[0])
+		conference.LastMessage = &lastMessage
+	}
+
+	return conference, nil
+}
+
+// getConferences returns the conferences the user takes part in
+func getConferences() []apiConference {
+	conferences := []apiConference{}
+
+	numbers, _ := tox.ConferenceGetChatlist()
+	for _, number := range numbers {
+		if conference, err := getAPIConference(number); err == nil {
+			conferences = append(conferences, conference)
+		}
+	}
+
+	return conferences
+}
+
+// createConference creates a new text conference
+// title  the title of the conference, may be empty
+func createConference(title string) (apiConference, error) {
+	if len(title) > libtox.TOX_MAX_NAME_LENGTH {
+		return apiConference{}, errInvalidTitle
+	}
+
+	number, err := tox.ConferenceNew()
+	if err != nil {
+		return apiConference{}, err
+	}
+	if title != "" {
+		tox.ConferenceSetTitle(number, title)
+	}
+
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+	return getAPIConference(number)
+}
+
+// setConferenceTitle changes the title of a conference for all its peers
+// number  the conference number
+// title   the new title
+func setConferenceTitle(number uint32, title string) error {
+	if len(title) > libtox.TOX_MAX_NAME_LENGTH {
+		return errInvalidTitle
+	}
+
+	if _, err := tox.ConferenceSetTitle(number, title); err != nil {
+		return err
+	}
+
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+	return nil
+}
+
+// leaveConference leaves a conference and deletes its chat history
+// number  the conference number
+// id      the ID of the conference
+func leaveConference(number uint32, id string) error {
+	if _, err := tox.ConferenceDelete(number); err != nil {
+		return err
+	}
+
+	conferencesMtx.Lock()
+	delete(conferencesJoining, id)
+	conferencesMtx.Unlock()
+
+	storage.DeleteConferenceMessages(id)
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+	return nil
+}
+
+// sendConferenceMessage sends a message to the peers of a conference and
+// stores it in the chat history
+// number    the conference number
+// id        the ID of the conference
+// isAction  specifies if the message is an action or not
+// message   the message
+func sendConferenceMessage(number uint32, id string, isAction bool, message string) (apiConferenceMessage, error) {
+	if len(message) > libtox.TOX_MAX_MESSAGE_LENGTH {
+		return apiConferenceMessage{}, errInvalidMessage
+	}
+
+	conferencesMtx.Lock()
+	joining := conferencesJoining[id]
+	conferencesMtx.Unlock()
+	if joining {
+		return apiConferenceMessage{}, errConferenceOffline
+	}
+
+	messageType := libtox.TOX_MESSAGE_TYPE_NORMAL
+	if isAction {
+		messageType = libtox.TOX_MESSAGE_TYPE_ACTION
+	}
+	if _, err := tox.ConferenceSendMessage(number, messageType, []byte(message)); err != nil {
+		// the wrapper does not tell the errors apart, the length was checked
+		return apiConferenceMessage{}, errConferenceOffline
+	}
+
+	publicKey, _ := tox.SelfGetPublicKey()
+	name, _ := tox.SelfGetName()
+	msg := persistence.ConferenceMessage{
+		PeerPublicKey: hex.EncodeToString(publicKey),
+		PeerName:      name,
+		Message:       message,
+		IsAction:      isAction,
+	}
+
+	var err error
+	msg.ID, msg.Time, err = storage.StoreConferenceMessage(id, msg.PeerPublicKey, msg.PeerName, false, isAction, message)
+	if err != nil {
+		return apiConferenceMessage{}, err
+	}
+
+	broadcastConferenceMessageEvent(id, number, msg)
+	return getAPIConferenceMessage(msg), nil
+}
+
+// inviteToConference invites a friend to a conference
+// number        the conference number
+// friendnumber  the friend to invite
+func inviteToConference(number uint32, friendnumber uint32) error {
+	_, err := tox.ConferenceInvite(friendnumber, number)
+	switch err {
+	case nil:
+		return nil
+	case libtox.ErrConferenceInviteFailSend:
+		return errFriendOffline
+	case libtox.ErrConferenceInviteNoConnection:
+		return errConferenceOffline
+	default:
+		return err
+	}
+}
+
+// getAPIConferenceMessage returns a stored conference message as returned by
+// the API
+// msg  the stored message
+func getAPIConferenceMessage(msg persistence.ConferenceMessage) apiConferenceMessage {
+	return apiConferenceMessage{
+		ID:         msg.ID,
+		PublicKey:  msg.PeerPublicKey,
+		Name:       msg.PeerName,
+		Message:    msg.Message,
+		IsIncoming: msg.IsIncoming,
+		IsAction:   msg.IsAction,
+		Time:       msg.Time,
+	}
+}
+
+// getConferenceMessagePage returns a page of the chat history of a conference
+// id      the ID of the conference
+// before  only older messages are returned, 0 for the newest ones
+// limit   the maximum number of messages of the page
+func getConferenceMessagePage(id string, before int64, limit int) conferenceMessagePage {
+	// one more to know if there is an older page
+	dbMessages := storage.GetConferenceMessagesBefore(id, before, limit+1)
+
+	var page conferenceMessagePage
+	if len(dbMessages) > limit {
+		dbMessages = dbMessages[:limit]
+		nextBefore := dbMessages[limit-1].ID
+		page.NextBefore = &nextBefore
+	}
+
+	// the messages are stored newest first
+	page.Messages = make([]apiConferenceMessage, len(dbMessages))
+	for i, msg := range dbMessages {
+		page.Messages[len(dbMessages)-1-i] = getAPIConferenceMessage(msg)
+	}
+
+	return page
+}
+
+// getConferenceInvites returns the invites to conferences that were neither
+// accepted nor rejected
+func getConferenceInvites() []apiConferenceInvite {
+	invites := []apiConferenceInvite{}
+	for _, invite := range storage.GetConferenceInvites() {
+		invites = append(invites, apiConferenceInvite{ID: invite.ID, PublicKey: invite.PublicKey, Time: invite.Time})
+	}
+	return invites
+}
+
+// acceptConferenceInvite joins the conference of an invite and deletes the
+// invite. The conference is not connected until toxcore reports it.
+// inviteID  the id of the invite
+func acceptConferenceInvite(inviteID int64) (apiConference, error) {
+	invite, err := storage.GetConferenceInvite(inviteID)
+	if err != nil {
+		return apiConference{}, err
+	}
+
+	publicKeyBytes, _ := hex.DecodeString(invite.PublicKey)
+	friendnumber, err := tox.FriendByPublicKey(publicKeyBytes)
+	if err != nil {
+		return apiConference{}, errUnknownFriend
+	}
+	if connection, _ := tox.FriendGetConnectionStatus(friendnumber); connection == libtox.TOX_CONNECTION_NONE {
+		return apiConference{}, errFriendOffline
+	}
+
+	// the connected callback must not run before the conference is marked
+	conferencesMtx.Lock()
+	number, err := tox.ConferenceJoin(friendnumber, invite.Cookie)
+	if err == nil {
+		if id, idErr := tox.ConferenceGetIdentifier(number); idErr == nil {
+			conferencesJoining[strings.ToLower(id)] = true
+		}
+	}
+	conferencesMtx.Unlock()
+	if err != nil {
+		log.Print("[acceptConferenceInvite] ", err)
+		return apiConference{}, errJoinFailed
+	}
+
+	storage.DeleteConferenceInvite(inviteID)
+	broadcastToClients(createSimpleJSONEvent("conference_invites_update"))
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+
+	return getAPIConference(number)
+}
+
+// broadcastConferenceMessageEvent sends a message of a conference to the
+// clients
+// id      the ID of the conference
+// number  the conference number
+// msg     the stored message
+func broadcastConferenceMessageEvent(id string, number uint32, msg persistence.ConferenceMessage) {
+	type jsonEvent struct {
+		Type       string `json:"type"`
+		Conference string `json:"conference"`
+		Number     uint32 `json:"number"`
+		apiConferenceMessage
+	}
+
+	data, _ := json.Marshal(jsonEvent{Type: "conference_message", Conference: id, Number: number, apiConferenceMessage: getAPIConferenceMessage(msg)})
+
+	broadcastToClients(string(data))
+}
@@ -0,0 +1,85 @@
+//go:build toxsim
+
+package main
+
+import (
+	"testing"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+func TestConferenceUpdates(t *testing.T) {
+	f := newTestServer(t)
+	events := listenEvents(t)
+
+	tox.CallbackConferenceTitle(onConferenceTitle)
+	tox.CallbackConferencePeerName(onConferencePeerName)
+	tox.CallbackConferencePeerListChanged(onConferencePeerListChanged)
+
+	// the friend joins every conference they are invited to
+	var friendConference uint32
+	f.tox.CallbackConferenceInvite(func(t *libtox.Tox, friendnumber uint32, conferencetype libtox.ToxConferenceType, cookie []byte) {
+		friendConference, _ = t.ConferenceJoin(friendnumber, cookie)
+	})
+
+	conference, err := createConference("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   func(c apiConference) bool
+	}{
+		{
+			name:   "peer joined",
+			change: func() { tox.ConferenceInvite(f.number, conference.Number) },
+			want:   func(c apiConference) bool { return len(c.Peers) == 2 },
+		},
+		{
+			name:   "title",
+			change: func() { f.tox.ConferenceSetTitle(friendConference, "plans") },
+			want:   func(c apiConference) bool { return c.Title == "plans" },
+		},
+		{
+			name:   "peer name",
+			change: func() { f.tox.SelfSetName("alice") },
+			want: func(c apiConference) bool {
+				for _, peer := range c.Peers {
+					if peer.PublicKey == f.publicKey {
+						return peer.Name == "alice" && peer.IsFriend
+					}
+				}
+				return false
+			},
+		},
+		{
+			name:   "peer left",
+			change: func() { f.tox.ConferenceDelete(friendConference) },
+			want:   func(c apiConference) bool { return len(c.Peers) == 1 && c.Peers[0].IsOurs },
+		},
+	}
+
+	for _, step := range steps {
+		receivedEvents(events)
+		step.change()
+		f.net.Settle(10)
+
+		updated := false
+		for _, event := range receivedEvents(events) {
+			updated = updated || event == "conferencelist_update"
+		}
+		if !updated {
+			t.Errorf("%s: no conferencelist_update event", step.name)
+		}
+
+		c, err := getAPIConference(conference.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !step.want(c) {
+			t.Errorf("%s: conference is %+v", step.name, c)
+		}
+	}
+}
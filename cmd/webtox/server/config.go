@@ -1,11 +1,444 @@
 package main
 
-const (
-	CFG_DATA_DIR          string = "../data/"
-	CFG_HTML_DIR          string = "../html/"
-	CFG_IMG_DIR           string = "../html/img/"
-	CFG_CERT_PREFIX       string = "https."
-	CFG_DEFAULT_AUTH_USER string = "user"
-	CFG_TCP_PROXY_PORT    uint16 = 0
-	CFG_MAX_AVATAR_SIZE   uint64 = 65536 // see github.com/Tox/Tox-STS/blob/master/STS.md#avatars
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 )
+
+const CFG_DEFAULT_AUTH_USER string = "user"
+
+// Config is the configuration of webtox. It is read from a JSON file (see
+// webtox.example.json); WEBTOX_* environment variables and command line flags
+// override the file. Relative paths in the file are relative to the file.
+type Config struct {
+	DataDir   string          `json:"data_dir"`
+	HTMLDir   string          `json:"html_dir"`
+	SaveFile  string          `json:"save_file"` // default: <data_dir>/webtox_save
+	Database  string          `json:"database"`  // default: <data_dir>/userdata.db
+	Listen    []string        `json:"listen"`
+	TLS       TLSConfig       `json:"tls"`
+	Tox       ToxConfig       `json:"tox"`
+	Bootstrap []BootstrapNode `json:"bootstrap"`
+	Limits    LimitsConfig    `json:"limits"`
+	Calls     CallsConfig     `json:"calls"`
+	Log       LogConfig       `json:"log"`
+}
+
+// TLSConfig holds the certificate of the GUI. A self-signed certificate is
+// created if neither file exists.
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"` // default: <data_dir>/https.cert.pem
+	KeyFile    string `json:"key_file"`  // default: <data_dir>/https.key.pem
+	CommonName string `json:"common_name"`
+}
+
+type ToxConfig struct {
+	IPv6Enabled bool        `json:"ipv6_enabled"`
+	UDPEnabled  bool        `json:"udp_enabled"`
+	StartPort   uint16      `json:"start_port"`
+	EndPort     uint16      `json:"end_port"`
+	TCPPort     uint16      `json:"tcp_port"` // 0 disables the TCP relay
+	Proxy       ProxyConfig `json:"proxy"`
+}
+
+type ProxyConfig struct {
+	Type string `json:"type"` // "none", "http" or "socks5"
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+type BootstrapNode struct {
+	Address   string `json:"address"`
+	Port      uint16 `json:"port"`
+	PublicKey string `json:"public_key"`
+}
+
+type LimitsConfig struct {
+	MaxAvatarSize  uint64 `json:"max_avatar_size"`  // see github.com/Tox/Tox-STS/blob/master/STS.md#avatars
+	MaxRequestSize int64  `json:"max_request_size"` // of the body of an API request, in bytes
+	MaxUploadSize  int64  `json:"max_upload_size"`  // of a file sent to a friend, in bytes
+}
+
+type CallsConfig struct {
+	AudioBitRate         uint32   `json:"audio_bit_rate"` // kbit/s
+	RingTimeout          Duration `json:"ring_timeout"`   // until the voicemail answers, 0 to wait forever
+	VoicemailGreeting    string   `json:"voicemail_greeting"`
+	VoicemailMaxDuration Duration `json:"voicemail_max_duration"`
+	VideoMaxFPS          float64  `json:"video_max_fps"` // of the MJPEG streams of video calls
+	VideoJPEGQuality     int      `json:"video_jpeg_quality"`
+}
+
+type LogConfig struct {
+	File        string `json:"file"` // empty for stderr
+	APIRequests bool   `json:"api_requests"`
+}
+
+// Duration is a time.Duration written as a string like "30s" in JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("durations are strings like \"30s\"")
+	}
+
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// the current configuration, replaced on SIGHUP
+var currentConfig atomic.Pointer[Config]
+
+// getConfig returns the current configuration
+func getConfig() *Config {
+	return currentConfig.Load()
+}
+
+// defaultBaseDir returns the directory the default data and GUI directories
+// are relative to: the directory of the executable, as in the source tree
+// where webtox is built in cmd/webtox/server, or the working directory if the
+// GUI is not found next to the executable, e.g. with go run
+func defaultBaseDir() string {
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			dir := filepath.Dir(exe)
+			if _, err := os.Stat(filepath.Join(dir, "..", "html", "index.html")); err == nil {
+				return dir
+			}
+		}
+	}
+
+	if dir, err := os.Getwd(); err == nil {
+		return dir
+	}
+	return "."
+}
+
+// defaultConfig returns the configuration used without a config file. The
+// directories are ../data and ../html, see defaultBaseDir.
+func defaultConfig() *Config {
+	base := defaultBaseDir()
+
+	return &Config{
+		DataDir: filepath.Join(base, "..", "data"),
+		HTMLDir: filepath.Join(base, "..", "html"),
+		Listen:  []string{":8080"},
+		TLS:     TLSConfig{CommonName: "localhost"},
+		Tox: ToxConfig{
+			IPv6Enabled: true,
+			UDPEnabled:  true,
+			Proxy:       ProxyConfig{Type: "none"},
+		},
+		Bootstrap: []BootstrapNode{
+			{Address: "3.0.24.15", Port: 33445, PublicKey: "E20ABCF38CDBFFD7D04B29C956B33F7B27A3BB7AF0618101617B036E4AEA402D"},
+		},
+		Limits: LimitsConfig{MaxAvatarSize: 65536, MaxRequestSize: 1 << 20, MaxUploadSize: 1 << 30},
+		Calls: CallsConfig{
+			AudioBitRate:         48,
+			RingTimeout:          Duration{30 * time.Second},
+			VoicemailMaxDuration: Duration{2 * time.Minute},
+			VideoMaxFPS:          10,
+			VideoJPEGQuality:     75,
+		},
+		Log: LogConfig{APIRequests: true},
+	}
+}
+
+// configOverride is a setting that can be overridden by an environment
+// variable and a command line flag
+type configOverride struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string)
+	value *string
+}
+
+var configOverrides = []*configOverride{
+	{env: "WEBTOX_DATA_DIR", flag: "data-dir", usage: "directory of the database, the Tox profile and the certificate",
+		set: func(c *Config, v string) { c.DataDir = v }},
+	{env: "WEBTOX_HTML_DIR", flag: "html-dir", usage: "directory of the GUI",
+		set: func(c *Config, v string) { c.HTMLDir = v }},
+	{env: "WEBTOX_SAVE_FILE", flag: "p", usage: "path to save file",
+		set: func(c *Config, v string) { c.SaveFile = v }},
+	{env: "WEBTOX_DATABASE", flag: "database", usage: "path to the database",
+		set: func(c *Config, v string) { c.Database = v }},
+	{env: "WEBTOX_LISTEN", flag: "listen", usage: "comma separated addresses to serve the GUI on",
+		set: func(c *Config, v string) { c.Listen = strings.Split(v, ",") }},
+	{env: "WEBTOX_TLS_CERT", flag: "tls-cert", usage: "TLS certificate file",
+		set: func(c *Config, v string) { c.TLS.CertFile = v }},
+	{env: "WEBTOX_TLS_KEY", flag: "tls-key", usage: "TLS key file",
+		set: func(c *Config, v string) { c.TLS.KeyFile = v }},
+	{env: "WEBTOX_LOG_FILE", flag: "log-file", usage: "file to log to instead of stderr",
+		set: func(c *Config, v string) { c.Log.File = v }},
+}
+
+// the path of the config file, empty if there is none
+var configPath string
+
+// registerConfigFlags defines the command line flags of the configuration.
+// They have to be parsed with flag.Parse before loadConfig is called.
+func registerConfigFlags() {
+	flag.StringVar(&configPath, "config", os.Getenv("WEBTOX_CONFIG"), "path to the JSON config file (env WEBTOX_CONFIG)")
+	for _, o := range configOverrides {
+		o.value = flag.String(o.flag, "", o.usage+" (env "+o.env+")")
+	}
+}
+
+// loadConfig reads the config file, applies the environment variables and
+// flags, fills in the defaults that depend on other settings and validates
+// the result
+func loadConfig() (*Config, error) {
+	c := defaultConfig()
+
+	if configPath != "" {
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// the default directories are not relative to the file
+		defaults := *c
+		c.DataDir, c.HTMLDir = "", ""
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("%s: %v", configPath, err)
+		}
+
+		base := filepath.Dir(configPath)
+		for _, p := range []*string{&c.DataDir, &c.HTMLDir, &c.SaveFile, &c.Database, &c.TLS.CertFile, &c.TLS.KeyFile, &c.Calls.VoicemailGreeting, &c.Log.File} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(base, *p)
+			}
+		}
+
+		if c.DataDir == "" {
+			c.DataDir = defaults.DataDir
+		}
+		if c.HTMLDir == "" {
+			c.HTMLDir = defaults.HTMLDir
+		}
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	for _, o := range configOverrides {
+		if v := os.Getenv(o.env); v != "" {
+			o.set(c, v)
+		}
+		if o.value != nil && setFlags[o.flag] {
+			o.set(c, *o.value)
+		}
+	}
+
+	inDataDir := func(p *string, name string) {
+		if *p == "" {
+			*p = filepath.Join(c.DataDir, name)
+		}
+	}
+	inDataDir(&c.SaveFile, "webtox_save")
+	inDataDir(&c.Database, "userdata.db")
+	inDataDir(&c.TLS.CertFile, "https.cert.pem")
+	inDataDir(&c.TLS.KeyFile, "https.key.pem")
+	inDataDir(&c.Calls.VoicemailGreeting, "voicemail_greeting.wav")
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate checks the configuration and returns an error listing every
+// invalid setting
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(setting string, format string, args ...interface{}) {
+		problems = append(problems, setting+": "+fmt.Sprintf(format, args...))
+	}
+
+	if c.DataDir == "" {
+		problem("data_dir", "must be set")
+	} else if info, err := os.Stat(c.DataDir); err == nil && !info.IsDir() {
+		problem("data_dir", "%s is not a directory", c.DataDir)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.HTMLDir, "index.html")); err != nil {
+		problem("html_dir", "%s does not contain the GUI (index.html)", c.HTMLDir)
+	}
+
+	if len(c.Listen) == 0 {
+		problem("listen", "at least one address is required")
+	}
+	for _, addr := range c.Listen {
+		_, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			problem("listen", "%q is not an address like \":8080\" or \"127.0.0.1:8080\"", addr)
+		}
+	}
+
+	_, certErr := os.Stat(c.TLS.CertFile)
+	_, keyErr := os.Stat(c.TLS.KeyFile)
+	if (certErr == nil) != (keyErr == nil) {
+		problem("tls", "either both or none of %s and %s must exist", c.TLS.CertFile, c.TLS.KeyFile)
+	}
+
+	if _, err := c.Tox.proxyType(); err != nil {
+		problem("tox.proxy.type", "%v", err)
+	} else if c.Tox.Proxy.Type != "none" && (c.Tox.Proxy.Host == "" || c.Tox.Proxy.Port == 0) {
+		problem("tox.proxy", "host and port are required for a %s proxy", c.Tox.Proxy.Type)
+	}
+	if (c.Tox.StartPort == 0) != (c.Tox.EndPort == 0) || c.Tox.StartPort > c.Tox.EndPort {
+		problem("tox", "start_port and end_port must both be 0 or form a port range")
+	}
+
+	for i, node := range c.Bootstrap {
+		setting := fmt.Sprintf("bootstrap[%d]", i)
+		if node.Address == "" || node.Port == 0 {
+			problem(setting, "address and port are required")
+		}
+		if key, err := hex.DecodeString(node.PublicKey); err != nil || len(key) != libtox.TOX_PUBLIC_KEY_SIZE {
+			problem(setting, "public_key must be %d hex characters", 2*libtox.TOX_PUBLIC_KEY_SIZE)
+		}
+	}
+
+	if c.Limits.MaxAvatarSize == 0 {
+		problem("limits.max_avatar_size", "must be greater than 0")
+	}
+	if c.Limits.MaxRequestSize <= 0 {
+		problem("limits.max_request_size", "must be greater than 0")
+	}
+	if c.Limits.MaxUploadSize <= 0 {
+		problem("limits.max_upload_size", "must be greater than 0")
+	}
+
+	if c.Calls.AudioBitRate == 0 {
+		problem("calls.audio_bit_rate", "must be greater than 0")
+	}
+	if c.Calls.RingTimeout.Duration < 0 {
+		problem("calls.ring_timeout", "must not be negative")
+	}
+	if c.Calls.VoicemailMaxDuration.Duration <= 0 {
+		problem("calls.voicemail_max_duration", "must be greater than 0")
+	}
+	if c.Calls.VideoMaxFPS <= 0 {
+		problem("calls.video_max_fps", "must be greater than 0")
+	}
+	if c.Calls.VideoJPEGQuality < 1 || c.Calls.VideoJPEGQuality > 100 {
+		problem("calls.video_jpeg_quality", "must be between 1 and 100")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// proxyType returns the libtox proxy type of the configured proxy
+func (t *ToxConfig) proxyType() (libtox.ToxProxyType, error) {
+	switch t.Proxy.Type {
+	case "", "none":
+		return libtox.TOX_PROXY_TYPE_NONE, nil
+	case "http":
+		return libtox.TOX_PROXY_TYPE_HTTP, nil
+	case "socks5":
+		return libtox.TOX_PROXY_TYPE_SOCKS5, nil
+	default:
+		return libtox.TOX_PROXY_TYPE_NONE, fmt.Errorf("%q is not one of \"none\", \"http\" or \"socks5\"", t.Proxy.Type)
+	}
+}
+
+// reloadConfig reads the configuration again and applies the settings that
+// do not require a restart: bootstrap nodes, limits, calls and logging.
+// Changes of the other settings are reported and ignored.
+func reloadConfig() {
+	old := getConfig()
+	c, err := loadConfig()
+	if err != nil {
+		log.Println("[config] Reload failed, keeping the current configuration:", err)
+		return
+	}
+
+	restart := map[string]bool{
+		"data_dir":  old.DataDir != c.DataDir,
+		"html_dir":  old.HTMLDir != c.HTMLDir,
+		"save_file": old.SaveFile != c.SaveFile,
+		"database":  old.Database != c.Database,
+		"listen":    !reflect.DeepEqual(old.Listen, c.Listen),
+		"tls":       old.TLS != c.TLS,
+		"tox":       old.Tox != c.Tox,
+	}
+	for setting, changed := range restart {
+		if changed {
+			log.Printf("[config] %s changed, restart webtox to apply it\n", setting)
+		}
+	}
+
+	next := *old
+	next.Bootstrap = c.Bootstrap
+	next.Limits = c.Limits
+	next.Calls = c.Calls
+	next.Log = c.Log
+
+	if err = setupLog(next.Log); err != nil {
+		log.Println("[config] Could not open the log file, keeping the current one:", err)
+		next.Log = old.Log
+	}
+	currentConfig.Store(&next)
+	log.Println("[config] Configuration reloaded")
+
+	bootstrap()
+}
+
+// the log file opened by setupLog, nil for stderr
+var logFile *os.File
+
+// setupLog directs the log to the configured file. The file is opened again
+// on every call, so a SIGHUP after log rotation switches to the new file.
+func setupLog(c LogConfig) error {
+	var f *os.File
+	if c.File != "" {
+		var err error
+		f, err = os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+var (
+	errUnknownDownload = errors.New("Unknown download")
+	errNotPending      = errors.New("The file was already accepted or rejected")
+	errNotReceiving    = errors.New("The file is not being received")
+	errNotPaused       = errors.New("The file was not paused by the user")
+
+	errInvalidPublicKey = errors.New("Invalid public key")
+)
+
+// download is a data file a friend sends us. It waits for the user to accept
+// or reject it unless the file policy decides.
+type download struct {
+	id         string // identifies the download in the API and the events
+	friend     uint32
+	publicKey  string
+	filenumber uint32
+	name       string // the file name sent by the friend
+	size       uint64
+	path       string   // relative to downloadDir, set when the file is accepted
+	file       *os.File // created when the file is accepted
+
+	// the SHA-256 of the file, computed while the chunks arrive in order
+	hash   hash.Hash
+	hashed uint64
+	sum    string // the hash as hex when the file is complete
+
+	state          string // see downloadPending
+	pausedByUs     bool
+	pausedByFriend bool
+	received       uint64
+	offered        time.Time
+	reported       time.Time   // when the progress was last sent in an event
+	timeout        *time.Timer // rejects the file if the user does not answer
+}
+
+// The states of a download:
+//
+//	pending    waiting for the user to accept the file
+//	receiving  accepted, paused if paused by either side
+//	complete   the file was received
+//	rejected   rejected by the user or the file policy
+//	cancelled  cancelled by the user or the friend after it was accepted
+//	failed     the friend went offline or the file could not be written
+const (
+	downloadPending   = "pending"
+	downloadReceiving = "receiving"
+	downloadComplete  = "complete"
+	downloadRejected  = "rejected"
+	downloadCancelled = "cancelled"
+	downloadFailed    = "failed"
+)
+
+var downloadsMtx sync.Mutex
+
+// Map of the downloads that are not finished, by id
+var downloads = make(map[string]*download)
+
+// downloadDir returns the directory of the received files. It is not part of
+// the GUI directory: the files are only served by the API, see
+// handleAPIv2FileContent.
+func downloadDir() string {
+	return filepath.Join(getConfig().DataDir, "downloads")
+}
+
+// downloadFileName returns a file name for the file name sent by a friend
+// that is safe to use on any file system: without a path, control characters,
+// characters Windows does not allow and leading dots
+// name  the file name sent by the friend
+func downloadFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, uploadFileName(name))
+
+	name = strings.TrimRight(strings.TrimLeft(name, ". "), ". ")
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
+
+// the longest file name most file systems allow, in bytes
+const maxFileNameLength = 255
+
+// the most names createDownloadFile tries for a file
+const maxDownloadFileNames = 1000
+
+// cutFileName shortens a file name to at most size bytes without splitting a
+// character
+// name  the file name
+// size  the maximal length in bytes
+func cutFileName(name string, size int) string {
+	for len(name) > size {
+		_, n := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-n]
+	}
+	return name
+}
+
+// createDownloadFile creates the file of a download in the directory of its
+// friend. If a file with the name already exists, a number is added to it.
+// d  the download
+func createDownloadFile(d *download) (*os.File, string, error) {
+	if d.publicKey == "" {
+		// the file would end up in the directory of all friends
+		return nil, "", errUnknownFriend
+	}
+
+	dir := filepath.Join(downloadDir(), d.publicKey)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", err
+	}
+
+	name := cutFileName(downloadFileName(d.name), maxFileNameLength)
+	ext := filepath.Ext(name)
+	if len(ext) > maxFileNameLength/2 {
+		// not an extension worth keeping
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return file, filepath.Join(d.publicKey, name), nil
+		}
+		if !os.IsExist(err) || i >= maxDownloadFileNames {
+			return nil, "", err
+		}
+
+		suffix := fmt.Sprintf(" (%d)%s", i, ext)
+		name = cutFileName(base, maxFileNameLength-len(suffix)) + suffix
+	}
+}
+
+// filePolicy decides which incoming files are accepted or rejected without
+// asking the user. It is part of the settings.
+type filePolicy struct {
+	AutoAccept    map[string]bool // public keys of the friends whose files are accepted
+	MaxSize       uint64          // larger files are rejected, 0 for no limit
+	RejectTimeout time.Duration   // files not accepted in time are rejected, 0 to wait forever
+}
+
+// getFilePolicy returns the stored file policy
+func getFilePolicy() filePolicy {
+	policy := filePolicy{AutoAccept: make(map[string]bool)}
+
+	autoAccept, _ := storage.GetKeyValue("settings_file_auto_accept")
+	for _, publicKey := range strings.Split(autoAccept, ",") {
+		if publicKey != "" {
+			policy.AutoAccept[publicKey] = true
+		}
+	}
+	maxSize, _ := storage.GetKeyValue("settings_file_max_size")
+	policy.MaxSize, _ = strconv.ParseUint(maxSize, 10, 64)
+	rejectTimeout, _ := storage.GetKeyValue("settings_file_reject_timeout")
+	seconds, _ := strconv.ParseInt(rejectTimeout, 10, 64)
+	policy.RejectTimeout = time.Duration(seconds) * time.Second
+
+	return policy
+}
+
+// setFileAutoAccept stores the friends whose files are accepted without asking
+// the user
+// publicKeys  the public keys of the friends as hex strings
+func setFileAutoAccept(publicKeys []string) error {
+	for i, publicKey := range publicKeys {
+		publicKeyBytes, err := hex.DecodeString(publicKey)
+		if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+			return errInvalidPublicKey
+		}
+		publicKeys[i] = hex.EncodeToString(publicKeyBytes)
+	}
+
+	return storage.StoreKeyValue("settings_file_auto_accept", strings.Join(publicKeys, ","))
+}
+
+// newDownload registers a data file offered by a friend and applies the file
+// policy to it
+// friendnumber  the friend who sends the file
+// filenumber    the file transfer
+// filesize      the size of the file
+// filename      the file name sent by the friend
+func newDownload(friendnumber uint32, filenumber uint32, filesize uint64, filename string) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		tox.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+		return
+	}
+
+	publicKey := publicKeyOfFriend(friendnumber)
+	if publicKey == "" {
+		log.Println("[ERROR] Cancelling file", filename, "of unknown friend", friendnumber)
+		tox.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+		return
+	}
+
+	d := &download{
+		id:         hex.EncodeToString(id),
+		friend:     friendnumber,
+		publicKey:  publicKey,
+		filenumber: filenumber,
+		name:       filename,
+		size:       filesize,
+		state:      downloadPending,
+		offered:    time.Now(),
+	}
+	policy := getFilePolicy()
+	downloadsMtx.Lock()
+	downloads[d.id] = d
+	downloadsMtx.Unlock()
+
+	switch {
+	case policy.MaxSize > 0 && filesize > policy.MaxSize:
+		log.Println("Rejecting file", filename, "of friend", friendnumber, "larger than", policy.MaxSize, "bytes")
+		rejectDownload(d.id)
+	case policy.AutoAccept[d.publicKey]:
+		if err := acceptDownload(d.id); err != nil {
+			log.Println("[ERROR] Could not accept file", filename, "of friend", friendnumber, err)
+		}
+	default:
+		if policy.RejectTimeout > 0 {
+			downloadsMtx.Lock()
+			d.timeout = time.AfterFunc(policy.RejectTimeout, func() { rejectPendingDownload(d.id) })
+			downloadsMtx.Unlock()
+		}
+		broadcastDownloadEvent(d)
+	}
+}
+
+// acceptDownload creates the file of a pending download and asks the friend
+// to send it
+// id  the id of the download
+func acceptDownload(id string) error {
+	downloadsMtx.Lock()
+	d, ok := downloads[id]
+	if !ok {
+		downloadsMtx.Unlock()
+		return errUnknownDownload
+	}
+	if d.state != downloadPending {
+		downloadsMtx.Unlock()
+		return errNotPending
+	}
+
+	file, path, err := createDownloadFile(d)
+	if err == nil {
+		d.file = file
+		d.path = path
+		d.hash = sha256.New()
+		d.state = downloadReceiving
+		if d.timeout != nil {
+			d.timeout.Stop()
+		}
+		err = tox.FileControl(d.friend, d.filenumber, libtox.TOX_FILE_CONTROL_RESUME)
+	} else {
+		log.Println("[ERROR] Error creating the file of download", d.id, err)
+	}
+	downloadsMtx.Unlock()
+
+	if err != nil {
+		finishDownload(d, downloadFailed)
+		return err
+	}
+	broadcastDownloadEvent(d)
+	return nil
+}
+
+// rejectDownload rejects a pending download or cancels an accepted one
+// id  the id of the download
+func rejectDownload(id string) error {
+	downloadsMtx.Lock()
+	d, ok := downloads[id]
+	state := downloadRejected
+	if ok {
+		if d.state != downloadPending {
+			state = downloadCancelled
+		}
+		tox.FileControl(d.friend, d.filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+	}
+	downloadsMtx.Unlock()
+	if !ok {
+		return errUnknownDownload
+	}
+
+	finishDownload(d, state)
+	return nil
+}
+
+// rejectPendingDownload rejects a download the user did not answer in time.
+// A download accepted meanwhile is kept.
+// id  the id of the download
+func rejectPendingDownload(id string) {
+	downloadsMtx.Lock()
+	d, ok := downloads[id]
+	pending := ok && d.state == downloadPending
+	if pending {
+		// not accepted anymore
+		d.state = downloadRejected
+		tox.FileControl(d.friend, d.filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+	}
+	downloadsMtx.Unlock()
+
+	if pending {
+		log.Println("Rejecting file", d.name, "of friend", d.friend, "not accepted in time")
+		finishDownload(d, downloadRejected)
+	}
+}
+
+// pauseDownload pauses (pause true) or resumes receiving an accepted file
+// id     the id of the download
+// pause  whether the file is paused
+func pauseDownload(id string, pause bool) error {
+	downloadsMtx.Lock()
+	d, ok := downloads[id]
+	var err error
+	switch {
+	case !ok:
+		err = errUnknownDownload
+	case d.state != downloadReceiving:
+		err = errNotReceiving
+	case pause && !d.pausedByUs:
+		if err = tox.FileControl(d.friend, d.filenumber, libtox.TOX_FILE_CONTROL_PAUSE); err == nil {
+			d.pausedByUs = true
+		}
+	case !pause && d.pausedByUs:
+		if err = tox.FileControl(d.friend, d.filenumber, libtox.TOX_FILE_CONTROL_RESUME); err == nil {
+			d.pausedByUs = false
+		}
+	case !pause:
+		err = errNotPaused
+	}
+	downloadsMtx.Unlock()
+
+	if err == nil {
+		broadcastDownloadEvent(d)
+	}
+	return err
+}
+
+// friendDownload returns the download of a Tox file transfer. downloadsMtx
+// must be held.
+// friendnumber  the friend who sends the file
+// filenumber    the file transfer
+func friendDownload(friendnumber uint32, filenumber uint32) *download {
+	for _, d := range downloads {
+		if d.friend == friendnumber && d.filenumber == filenumber {
+			return d
+		}
+	}
+	return nil
+}
+
+// onDownloadChunk writes a chunk of a download to its file and returns false
+// if the file transfer is not a download
+// friendnumber  the friend who sends the file
+// filenumber    the file transfer
+// position      the position of the chunk in the file
+// data          the chunk
+func onDownloadChunk(friendnumber uint32, filenumber uint32, position uint64, data []byte) bool {
+	downloadsMtx.Lock()
+	d := friendDownload(friendnumber, filenumber)
+	if d == nil || d.file == nil {
+		downloadsMtx.Unlock()
+		return d != nil
+	}
+
+	_, err := d.file.WriteAt(data, int64(position))
+	if position == d.hashed {
+		d.hash.Write(data)
+		d.hashed += uint64(len(data))
+	}
+	if position+uint64(len(data)) > d.received {
+		d.received = position + uint64(len(data))
+	}
+	// Some clients send another zero-length chunk after the last one and some
+	// do not, the file is complete when all bytes were received. Streams of
+	// unknown size (UINT64_MAX) only end with the zero-length chunk.
+	complete := d.received >= d.size || len(data) == 0
+	report := time.Since(d.reported) >= uploadProgressInterval
+	if report {
+		d.reported = time.Now()
+	}
+	downloadsMtx.Unlock()
+
+	switch {
+	case err != nil:
+		log.Println("[ERROR] Error writing the file of download", d.id, err)
+		tox.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+		finishDownload(d, downloadFailed)
+	case complete:
+		log.Println("File transfer completed (receiving)", filenumber)
+		finishDownload(d, downloadComplete)
+	case report:
+		broadcastDownloadEvent(d)
+	}
+	return true
+}
+
+// onDownloadControl handles a file control of a friend for a download and
+// returns false if the file transfer is not a download
+// friendnumber  the friend who sends the file
+// filenumber    the file transfer
+// fileControl   the control sent by the friend
+func onDownloadControl(friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) bool {
+	downloadsMtx.Lock()
+	d := friendDownload(friendnumber, filenumber)
+	if d == nil {
+		downloadsMtx.Unlock()
+		return false
+	}
+
+	switch fileControl {
+	case libtox.TOX_FILE_CONTROL_RESUME:
+		d.pausedByFriend = false
+	case libtox.TOX_FILE_CONTROL_PAUSE:
+		d.pausedByFriend = true
+	}
+	state := downloadCancelled
+	if d.state == downloadPending {
+		// withdrawn by the friend before the user answered
+		state = downloadRejected
+	}
+	downloadsMtx.Unlock()
+
+	if fileControl == libtox.TOX_FILE_CONTROL_CANCEL {
+		finishDownload(d, state)
+	} else {
+		broadcastDownloadEvent(d)
+	}
+	return true
+}
+
+// onDownloadFriendConnection fails the downloads of a friend who went
+// offline, toxcore drops their transfers
+// friendnumber  the friend
+// online        whether the friend is online now
+func onDownloadFriendConnection(friendnumber uint32, online bool) {
+	if online {
+		return
+	}
+
+	downloadsMtx.Lock()
+	var dropped []*download
+	for _, d := range downloads {
+		if d.friend == friendnumber {
+			dropped = append(dropped, d)
+		}
+	}
+	downloadsMtx.Unlock()
+
+	for _, d := range dropped {
+		finishDownload(d, downloadFailed)
+	}
+}
+
+// cancelFriendDownloads rejects or cancels all downloads of a friend, e.g.
+// before the friend is deleted
+// friendnumber  the friend
+func cancelFriendDownloads(friendnumber uint32) {
+	downloadsMtx.Lock()
+	var ids []string
+	for id, d := range downloads {
+		if d.friend == friendnumber {
+			ids = append(ids, id)
+		}
+	}
+	downloadsMtx.Unlock()
+
+	for _, id := range ids {
+		rejectDownload(id)
+	}
+}
+
+// finishDownload removes a download and adds it to the history of the
+// received files. The file is kept if it is complete.
+// d      the download
+// state  complete, rejected, cancelled or failed
+func finishDownload(d *download, state string) {
+	downloadsMtx.Lock()
+	_, ok := downloads[d.id]
+	if ok {
+		delete(downloads, d.id)
+		d.state = state
+		if d.timeout != nil {
+			d.timeout.Stop()
+		}
+	}
+	downloadsMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	receivedFile := persistence.ReceivedFile{ID: d.id, PublicKey: d.publicKey, Name: d.name, Size: d.size, Status: state, Time: d.offered.Unix() * 1000}
+	if d.size == math.MaxUint64 {
+		// a stream, its size is known when it ends
+		receivedFile.Size = d.received
+	}
+	if d.file != nil {
+		if state == downloadComplete {
+			// the chunks after a seek were not hashed
+			d.file.Seek(int64(d.hashed), io.SeekStart)
+			io.Copy(d.hash, d.file)
+			receivedFile.Path = d.path
+			receivedFile.Hash = hex.EncodeToString(d.hash.Sum(nil))
+		}
+
+		d.file.Sync()
+		d.file.Close()
+		if state != downloadComplete {
+			os.Remove(filepath.Join(downloadDir(), d.path))
+		}
+	}
+	storage.StoreReceivedFile(receivedFile)
+
+	downloadsMtx.Lock()
+	d.sum = receivedFile.Hash
+	downloadsMtx.Unlock()
+
+	broadcastDownloadEvent(d)
+}
+
+// apiDownload is a download as returned by the API and sent in the
+// "file_download" event
+type apiDownload struct {
+	ID             string `json:"id"`
+	PublicKey      string `json:"publicKey"`
+	Number         uint32 `json:"number"`
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	Received       uint64 `json:"received"`
+	State          string `json:"state"`
+	PausedByUs     bool   `json:"paused_by_us"`
+	PausedByFriend bool   `json:"paused_by_friend"`
+	Time           int64  `json:"time"`           // when the friend offered the file
+	Hash           string `json:"hash,omitempty"` // the SHA-256 of a complete file
+}
+
+// getAPIDownload returns a download as returned by the API
+// d  the download
+func getAPIDownload(d *download) apiDownload {
+	downloadsMtx.Lock()
+	defer downloadsMtx.Unlock()
+
+	return apiDownload{
+		ID:             d.id,
+		PublicKey:      d.publicKey,
+		Number:         d.friend,
+		Name:           d.name,
+		Size:           d.size,
+		Received:       d.received,
+		State:          d.state,
+		PausedByUs:     d.pausedByUs,
+		PausedByFriend: d.pausedByFriend,
+		Time:           d.offered.Unix() * 1000,
+		Hash:           d.sum,
+	}
+}
+
+// getDownloads returns the downloads that are not finished
+func getDownloads() []apiDownload {
+	downloadsMtx.Lock()
+	var list []*download
+	for _, d := range downloads {
+		list = append(list, d)
+	}
+	downloadsMtx.Unlock()
+
+	result := make([]apiDownload, len(list))
+	for i, d := range list {
+		result[i] = getAPIDownload(d)
+	}
+	return result
+}
+
+// getDownload returns a download that is not finished
+// id  the id of the download
+func getDownload(id string) (apiDownload, error) {
+	downloadsMtx.Lock()
+	d, ok := downloads[id]
+	downloadsMtx.Unlock()
+	if !ok {
+		return apiDownload{}, errUnknownDownload
+	}
+
+	return getAPIDownload(d), nil
+}
+
+// broadcastDownloadEvent sends the "file_download" event with the state and
+// the progress of a download to all clients
+// d  the download
+func broadcastDownloadEvent(d *download) {
+	type jsonEvent struct {
+		Type string `json:"type"`
+		apiDownload
+	}
+
+	data, _ := json.Marshal(jsonEvent{Type: "file_download", apiDownload: getAPIDownload(d)})
+
+	broadcastToClients(string(data))
+}
+
+// apiReceivedFile is an entry of the history of the received files as
+// returned by the API
+type apiReceivedFile struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"publicKey"`
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Hash      string `json:"hash"`
+	State     string `json:"state"`
+	Time      int64  `json:"time"`
+	Available bool   `json:"available"` // whether the file can be downloaded
+}
+
+// getAPIReceivedFile returns an entry of the history of the received files as
+// returned by the API
+// file  the entry
+func getAPIReceivedFile(file persistence.ReceivedFile) apiReceivedFile {
+	available := false
+	if file.Path != "" {
+		_, err := os.Stat(filepath.Join(downloadDir(), file.Path))
+		available = err == nil
+	}
+
+	return apiReceivedFile{ID: file.ID, PublicKey: file.PublicKey, Name: file.Name, Size: file.Size, Hash: file.Hash, State: file.Status, Time: file.Time, Available: available}
+}
+
+// getReceivedFiles returns the history of the received files, the newest
+// first
+// publicKey  only return the files of this friend, if set
+func getReceivedFiles(publicKey string) []apiReceivedFile {
+	files := []apiReceivedFile{}
+	for _, file := range storage.GetReceivedFiles(publicKey, -1) {
+		files = append(files, getAPIReceivedFile(file))
+	}
+	return files
+}
+
+// deleteReceivedFile deletes a received file and its entry of the history
+// id  the id of the transfer
+func deleteReceivedFile(id string) error {
+	file, err := storage.GetReceivedFile(id)
+	if err != nil {
+		return err
+	}
+
+	if file.Path != "" {
+		if err := os.Remove(filepath.Join(downloadDir(), file.Path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return storage.DeleteReceivedFile(id)
+}
@@ -0,0 +1,281 @@
+//go:build toxsim
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+// useTestDataDir makes a temporary directory the data directory
+func useTestDataDir(t *testing.T) {
+	config := defaultConfig()
+	config.DataDir = t.TempDir()
+	currentConfig.Store(config)
+}
+
+func TestDownloadFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\notes.txt`, "notes.txt"},
+		{"a<b>c:d\"e|f?g*.txt", "a_b_c_d_e_f_g_.txt"},
+		{"tab\there\x7f", "tab_here_"},
+		{"..hidden", "hidden"},
+		{"name. . ", "name"},
+		{"", "file"},
+		{"/", "file"},
+		{"...", "file"},
+	}
+
+	for _, tt := range tests {
+		if got := downloadFileName(tt.name); got != tt.want {
+			t.Errorf("downloadFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCreateDownloadFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		sent  string
+		want  []string // the names of the files created one after another
+		check func(t *testing.T, name string)
+	}{
+		{
+			name: "collisions",
+			sent: "report.pdf",
+			want: []string{"report.pdf", "report (1).pdf", "report (2).pdf"},
+		},
+		{
+			name: "without extension",
+			sent: "README",
+			want: []string{"README", "README (1)"},
+		},
+		{
+			name: "long name",
+			sent: strings.Repeat("x", 251) + ".txt",
+			want: []string{strings.Repeat("x", 251) + ".txt", strings.Repeat("x", 247) + " (1).txt"},
+		},
+		{
+			name: "long extension",
+			sent: "a." + strings.Repeat("x", 253),
+			want: []string{"a." + strings.Repeat("x", 253), "a." + strings.Repeat("x", 249) + " (1)"},
+		},
+		{
+			name: "multibyte characters",
+			sent: strings.Repeat("é", 127) + ".txt",
+			check: func(t *testing.T, name string) {
+				if !utf8.ValidString(name) {
+					t.Errorf("name %q is not valid UTF-8", name)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDataDir(t)
+			d := &download{publicKey: "friend", name: tt.sent}
+
+			for i := 0; i < 3; i++ {
+				file, path, err := createDownloadFile(d)
+				if err != nil {
+					t.Fatalf("createDownloadFile() error = %v", err)
+				}
+				file.Close()
+
+				name := filepath.Base(path)
+				if len(name) > maxFileNameLength {
+					t.Errorf("name %q has %d bytes, want at most %d", name, len(name), maxFileNameLength)
+				}
+				if i < len(tt.want) && name != tt.want[i] {
+					t.Errorf("file %d is %q, want %q", i, name, tt.want[i])
+				}
+				if tt.check != nil {
+					tt.check(t, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateDownloadFileError(t *testing.T) {
+	useTestDataDir(t)
+
+	// a file in place of the directory of the friend
+	if err := os.MkdirAll(downloadDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(downloadDir(), "friend"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := createDownloadFile(&download{publicKey: "friend", name: "file"}); err == nil {
+		t.Fatal("createDownloadFile() succeeded without a directory")
+	}
+	if _, _, err := createDownloadFile(&download{name: "file"}); err != errUnknownFriend {
+		t.Fatalf("createDownloadFile() without a friend = %v, want %v", err, errUnknownFriend)
+	}
+}
+
+func TestDownloadUnknownFriend(t *testing.T) {
+	newTestServer(t)
+
+	newDownload(1000, 0, 10, "file.txt")
+
+	if list := getDownloads(); len(list) != 0 {
+		t.Fatalf("file of an unknown friend is a download: %+v", list)
+	}
+}
+
+// testUpload is a file the friend sends to webtox
+type testUpload struct {
+	data     []byte
+	controls []libtox.ToxFileControl // the file controls webtox sent
+}
+
+// sendFile offers a file to webtox and sends its data when it is accepted
+// data  the content of the file
+// size  the size sent with the offer, math.MaxUint64 for a stream
+func (f *testFriend) sendFile(t *testing.T, data []byte, size uint64) *testUpload {
+	t.Helper()
+	u := &testUpload{data: data}
+
+	f.tox.CallbackFileRecvControl(func(_ *libtox.Tox, friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) {
+		u.controls = append(u.controls, fileControl)
+	})
+	f.tox.CallbackFileChunkRequest(func(tox *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+		if length == 0 || position > uint64(len(data)) {
+			return
+		}
+		end := min(position+length, uint64(len(data)))
+		tox.FileSendChunk(friendnumber, filenumber, position, data[position:end])
+	})
+
+	if _, err := f.tox.FileSend(0, libtox.TOX_FILE_KIND_DATA, size, nil, "file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	f.net.Settle(10)
+	return u
+}
+
+// downloadState returns the state of the only download, from the history if
+// it is finished
+func (f *testFriend) downloadState(t *testing.T) (string, persistence.ReceivedFile) {
+	t.Helper()
+
+	if list := getDownloads(); len(list) == 1 {
+		return list[0].State, persistence.ReceivedFile{}
+	}
+	files := storage.GetReceivedFiles(f.publicKey, -1)
+	if len(files) != 1 {
+		t.Fatalf("%d downloads and %d received files, want one", len(getDownloads()), len(files))
+	}
+	return files[0].Status, files[0]
+}
+
+func TestFilePolicy(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+
+	tests := []struct {
+		name       string
+		maxSize    string
+		autoAccept bool
+		size       uint64
+		want       string
+	}{
+		{"ask", "", false, uint64(len(data)), downloadPending},
+		{"ask larger than the limit", "1000", false, uint64(len(data)), downloadRejected},
+		{"auto accept", "", true, uint64(len(data)), downloadComplete},
+		{"auto accept within the limit", "3000", true, uint64(len(data)), downloadComplete},
+		{"auto accept larger than the limit", "1000", true, uint64(len(data)), downloadRejected},
+		{"auto accept stream", "", true, math.MaxUint64, downloadComplete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestServer(t)
+			storage.StoreKeyValue("settings_file_max_size", tt.maxSize)
+			if tt.autoAccept {
+				storage.StoreKeyValue("settings_file_auto_accept", f.publicKey)
+			}
+
+			u := f.sendFile(t, data, tt.size)
+			f.net.Settle(100)
+
+			state, file := f.downloadState(t)
+			if state != tt.want {
+				t.Fatalf("download is %s, want %s", state, tt.want)
+			}
+			switch tt.want {
+			case downloadRejected:
+				if len(u.controls) != 1 || u.controls[0] != libtox.TOX_FILE_CONTROL_CANCEL {
+					t.Errorf("friend got the file controls %v, want a cancel", u.controls)
+				}
+			case downloadComplete:
+				content, err := os.ReadFile(filepath.Join(downloadDir(), file.Path))
+				if err != nil || !bytes.Equal(content, data) {
+					t.Errorf("file has %d bytes (%v), want %d", len(content), err, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadRejectTimeout(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+
+	tests := []struct {
+		name   string
+		accept bool
+		want   string
+	}{
+		{"not answered", false, downloadRejected},
+		{"accepted", true, downloadReceiving},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestServer(t)
+			storage.StoreKeyValue("settings_file_reject_timeout", "1")
+
+			u := f.sendFile(t, data, uint64(len(data)))
+			if state, _ := f.downloadState(t); state != downloadPending {
+				t.Fatalf("download is %s, want %s", state, downloadPending)
+			}
+			if tt.accept {
+				if err := acceptDownload(getDownloads()[0].ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// the chunks are not received before the timeout
+			time.Sleep(1200 * time.Millisecond)
+			if state, _ := f.downloadState(t); state != tt.want {
+				t.Fatalf("download is %s after the timeout, want %s", state, tt.want)
+			}
+
+			f.net.Settle(100)
+			if tt.want == downloadRejected {
+				if len(u.controls) != 1 || u.controls[0] != libtox.TOX_FILE_CONTROL_CANCEL {
+					t.Errorf("friend got the file controls %v, want a cancel", u.controls)
+				}
+			} else if state, _ := f.downloadState(t); state != downloadComplete {
+				t.Errorf("download is %s, want %s", state, downloadComplete)
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/calvindc/dpc-tox/cmd/webtox/httpserve"
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 	"log"
 	"net/http"
@@ -28,7 +28,9 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	request := r.URL.Path[len("/api"):]
-	log.Println("[handleAPI]", request)
+	if getConfig().Log.APIRequests {
+		log.Println("[handleAPI]", request)
+	}
 
 	switch {
 	// GET REQUESTS
@@ -42,6 +44,37 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			}
 			fmt.Fprintf(w, friendlist)
 
+		case "/get/messages":
+			friendnumber, err := friendNumberOfPublicKey(r.URL.Query().Get("publicKey"))
+			if err != nil {
+				rejectWithErrorJSON(w, "invalid_friend", "The friend does not exist.")
+				return
+			}
+
+			before, limit, err := parseHistoryQuery(r)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+			jsonPage, _ := json.Marshal(getMessagePage(publicKeyOfFriend(friendnumber), before, limit))
+			w.Write(jsonPage)
+
+		case "/get/search":
+			query, opts, err := parseSearchQuery(r)
+			if err != nil {
+				rejectWithErrorJSON(w, "invalid_query", "The query is invalid: "+err.Error())
+				return
+			}
+
+			results, err := searchMessages(query, opts)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+			jsonResults, _ := json.Marshal(results)
+			w.Write(jsonResults)
+
 		case "/get/friend_requests":
 			type friendRequest struct {
 				PublicKey string `json:"publicKey"`
@@ -65,48 +98,39 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, string(jsonFriendRequests))
 
 		case "/get/profile":
-			type profile struct {
-				Username      string `json:"username"`
-				StatusMessage string `json:"status_msg"`
-				ToxID         string `json:"tox_id"`
-				Status        string `json:"status"`
-			}
-
-			username, _ := tox.SelfGetName()
-			statusMessage, _ := tox.SelfGetStatusMessage()
-			toxid, _ := tox.SelfGetAddress()
-			status, _ := tox.SelfGetStatus()
-			p := profile{
-				Username:      username,
-				StatusMessage: string(statusMessage),
-				ToxID:         strings.ToUpper(hex.EncodeToString(toxid)),
-				Status:        getUserStatusAsString(status),
-			}
-
-			pJSON, _ := json.Marshal(p)
+			pJSON, _ := json.Marshal(getProfile())
 			fmt.Fprintf(w, string(pJSON))
 
 		case "/get/settings":
-			type settings struct {
-				AuthUser             string `json:"auth_user"`
-				AwayOnDisconnect     bool   `json:"away_on_disconnect"`
-				NotificationsEnabled bool   `json:"notifications_enabled"`
-			}
+			sJSON, _ := json.Marshal(getSettings())
+			fmt.Fprintf(w, string(sJSON))
+
+		case "/get/calls":
+			w.Write([]byte(getCallsJSON()))
 
-			username, _ := storage.GetKeyValue("settings_auth_user")
-			notificationsEnabledString, _ := storage.GetKeyValue("settings_notifications_enabled")
-			notificationsEnabled, _ := strconv.ParseBool(notificationsEnabledString)
-			awayOnDisconnectString, _ := storage.GetKeyValue("settings_away_on_disconnect")
-			awayOnDisconnect, _ := strconv.ParseBool(awayOnDisconnectString)
+		case "/get/voicemails":
+			jsonVoicemails, _ := json.Marshal(getVoicemails())
+			w.Write(jsonVoicemails)
 
-			s := settings{
-				AuthUser:             username,
-				AwayOnDisconnect:     awayOnDisconnect,
-				NotificationsEnabled: notificationsEnabled,
+		case "/get/voicemail":
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
 			}
 
-			sJSON, _ := json.Marshal(s)
-			fmt.Fprintf(w, string(sJSON))
+			audio, err := storage.GetVoicemailAudio(id)
+			if err == persistence.VoicemailNotFound {
+				rejectWithErrorJSON(w, "not_found", "The voicemail does not exist.")
+				return
+			} else if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"voicemail-%d.wav\"", id))
+			w.Write(audio)
 
 		default:
 			// unknown GET request
@@ -116,9 +140,8 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 	// POST REQUESTS
 	case strings.HasPrefix(request, "/post/"):
-		data := make([]byte, r.ContentLength)
-		bytesRead, err := r.Body.Read(data)
-		if err != nil && bytesRead == 0 {
+		data, err := readRequestBody(w, r)
+		if err != nil {
 			rejectWithDefaultErrorJSON(w)
 			return
 		}
@@ -126,8 +149,8 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch request {
 		case "/post/message":
 			type message struct {
-				Friend  uint32
-				Message string
+				PublicKey string `json:"publicKey"`
+				Message   string `json:"message"`
 			}
 
 			var incomingData message
@@ -137,22 +160,24 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			_, err = tox.FriendSendMessage(incomingData.Friend, libtox.TOX_MESSAGE_TYPE_NORMAL, []byte(incomingData.Message))
+			friendnumber, err := friendNumberOfPublicKey(incomingData.PublicKey)
 			if err != nil {
-				rejectWithDefaultErrorJSON(w)
+				rejectWithErrorJSON(w, "invalid_friend", "The friend does not exist.")
 				return
 			}
 
-			publicKey, _ := tox.FriendGetPublickey(incomingData.Friend)
-			storage.StoreMessage(hex.EncodeToString(publicKey), false, false, incomingData.Message)
-			storage.SetLastMessageRead(hex.EncodeToString(publicKey))
+			msg, err := queueMessage(friendnumber, publicKeyOfFriend(friendnumber), false, incomingData.Message)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
 
-			// broadcast message to all connected clients
-			broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+			jsonMessage, _ := json.Marshal(getAPIMessage(msg))
+			w.Write(jsonMessage)
 
 		case "/post/message_read_receipt":
 			type friend struct {
-				Friend uint32 `json:"friend"`
+				PublicKey string `json:"publicKey"`
 			}
 
 			var incomingData friend
@@ -162,8 +187,13 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			publicKey, _ := tox.FriendGetPublickey(incomingData.Friend)
-			storage.SetLastMessageRead(hex.EncodeToString(publicKey))
+			friendnumber, err := friendNumberOfPublicKey(incomingData.PublicKey)
+			if err != nil {
+				rejectWithErrorJSON(w, "invalid_friend", "The friend does not exist.")
+				return
+			}
+
+			storage.SetLastMessageRead(publicKeyOfFriend(friendnumber))
 
 			// broadcast status to all connected clients
 			broadcastToClients(createSimpleJSONEvent("friendlist_update"))
@@ -248,7 +278,7 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				rejectWithFriendErrorJSON(w, err)
 				return
 			}
-			fmt.Fprintf(w, string(friendID))
+			fmt.Fprint(w, friendID)
 
 		case "/post/friend_request_is_ignored":
 			type friendRequest struct {
@@ -296,7 +326,7 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		case "/post/delete_friend":
 			type friend struct {
-				Number uint32 `json:"friend"`
+				PublicKey string `json:"publicKey"`
 			}
 
 			var incomingData friend
@@ -306,12 +336,95 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			err = tox.FriendDelete(incomingData.Number)
+			friendnumber, err := friendNumberOfPublicKey(incomingData.PublicKey)
+			if err != nil {
+				rejectWithErrorJSON(w, "invalid_friend", "The friend does not exist.")
+				return
+			}
+
+			cancelFriendUploads(friendnumber)
+			cancelFriendDownloads(friendnumber)
+			failFriendOutbox(publicKeyOfFriend(friendnumber))
+			err = tox.FriendDelete(friendnumber)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+		case "/post/call", "/post/call_answer", "/post/call_hangup":
+			type call struct {
+				PublicKey string `json:"publicKey"`
+			}
+
+			var incomingData call
+			err = json.Unmarshal(data, &incomingData)
 			if err != nil {
 				rejectWithDefaultErrorJSON(w)
 				return
 			}
 
+			friendnumber, err := friendNumberOfPublicKey(incomingData.PublicKey)
+			if err == nil {
+				switch request {
+				case "/post/call":
+					err = startCall(friendnumber)
+				case "/post/call_answer":
+					err = answerCall(friendnumber)
+				case "/post/call_hangup":
+					err = hangupCall(friendnumber)
+				}
+			}
+			if err != nil {
+				rejectWithCallErrorJSON(w, err)
+				return
+			}
+
+		case "/post/call_mute":
+			type call struct {
+				PublicKey string `json:"publicKey"`
+				Mute      bool   `json:"mute"`
+			}
+
+			var incomingData call
+			err = json.Unmarshal(data, &incomingData)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+			friendnumber, err := friendNumberOfPublicKey(incomingData.PublicKey)
+			if err == nil {
+				err = muteCall(friendnumber, incomingData.Mute)
+			}
+			if err != nil {
+				rejectWithCallErrorJSON(w, err)
+				return
+			}
+
+		case "/post/delete_voicemail":
+			type voicemail struct {
+				ID int64 `json:"id"`
+			}
+
+			var incomingData voicemail
+			err = json.Unmarshal(data, &incomingData)
+			if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+			err = storage.DeleteVoicemail(incomingData.ID)
+			if err == persistence.VoicemailNotFound {
+				rejectWithErrorJSON(w, "not_found", "The voicemail does not exist.")
+				return
+			} else if err != nil {
+				rejectWithDefaultErrorJSON(w)
+				return
+			}
+
+			// broadcast status to all connected clients
+			broadcastToClients(createSimpleJSONEvent("voicemails_update"))
+
 		case "/post/settings_auth_user":
 			type user struct {
 				Username string `json:"username"`
@@ -322,14 +435,11 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				rejectWithDefaultErrorJSON(w)
 				return
 			}
-			err = storage.StoreKeyValue("settings_auth_user", incomingData.Username)
-			if err != nil {
+			if err = setAuthUser(incomingData.Username); err != nil {
 				rejectWithDefaultErrorJSON(w)
 				return
 			}
 
-			httpserve.ChangeAuthOptionsUser(authOptions, incomingData.Username)
-
 		case "/post/settings_auth_pass":
 			type user struct {
 				Password string `json:"password"`
@@ -341,23 +451,11 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			salt, err := httpserve.RandomString(32)
-			if err != nil {
-				panic("could not generate salt")
-			}
-
-			pass := httpserve.Sha512Sum(incomingData.Password + salt)
-
-			err = storage.StoreKeyValue("settings_auth_pass", pass)
-			err2 := storage.StoreKeyValue("settings_auth_salt", salt)
-
-			if err != nil || err2 != nil {
+			if err = setAuthPassword(incomingData.Password); err != nil {
 				rejectWithDefaultErrorJSON(w)
 				return
 			}
 
-			httpserve.ChangeAuthOptionsPass(authOptions, pass, salt)
-
 		case "/post/keyValue":
 			type keyValue struct {
 				Key   string `json:"key"`
@@ -374,6 +472,7 @@ var handleAPI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			allowedKeys := map[string]bool{
 				"settings_notifications_enabled": true,
 				"settings_away_on_disconnect":    true,
+				"settings_voicemail_enabled":     true,
 			}
 
 			if !allowedKeys[incomingData.Key] {
@@ -0,0 +1,1285 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+// the OpenAPI description of the v2 API, served at /api/v2/openapi.json
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type apiFriendRequest struct {
+	PublicKey string `json:"publicKey"`
+	Message   string `json:"message"`
+	IsIgnored bool   `json:"is_ignored"`
+}
+
+// handleAPIv2 serves the resources of the v2 API below /api/v2. Unlike the
+// legacy API it answers with the HTTP status codes of the errors, see
+// openapi.json.
+var handleAPIv2 = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if tox == nil || storage == nil {
+		log.Print("[handleAPIv2] ERROR: tox or storage is nil.")
+		writeErrorJSON(w, http.StatusServiceUnavailable, "unavailable", "The server is not ready yet.")
+		return
+	}
+
+	request := strings.Trim(r.URL.Path[len("/api/v2"):], "/")
+	if getConfig().Log.APIRequests {
+		log.Println("[handleAPIv2]", r.Method, request)
+	}
+
+	path := strings.Split(request, "/")
+	switch {
+	case request == "openapi.json":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+
+	case path[0] == "friends" && len(path) == 1:
+		handleAPIv2Friends(w, r)
+
+	case path[0] == "friends" && len(path) == 2:
+		handleAPIv2Friend(w, r, path[1])
+
+	case path[0] == "friends" && len(path) == 3 && path[2] == "messages":
+		handleAPIv2Messages(w, r, path[1])
+
+	case path[0] == "friends" && len(path) == 3 && path[2] == "files":
+		handleAPIv2Files(w, r, path[1])
+
+	case path[0] == "conferences" && len(path) == 1:
+		handleAPIv2Conferences(w, r)
+
+	case path[0] == "conferences" && len(path) == 2:
+		handleAPIv2Conference(w, r, path[1])
+
+	case path[0] == "conferences" && len(path) == 3 && path[2] == "messages":
+		handleAPIv2ConferenceMessages(w, r, path[1])
+
+	case path[0] == "conferences" && len(path) == 3 && path[2] == "invites":
+		handleAPIv2ConferenceInvites(w, r, path[1])
+
+	case request == "conference_invites":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, getConferenceInvites())
+
+	case path[0] == "conference_invites" && len(path) == 2:
+		if !allowMethods(w, r, http.MethodDelete) {
+			return
+		}
+		id, ok := inviteIDOfPath(w, path[1])
+		if !ok {
+			return
+		}
+		if err := storage.DeleteConferenceInvite(id); err != nil {
+			writeErrorJSON(w, http.StatusNotFound, "unknown_invite", "The conference invite does not exist.")
+			return
+		}
+		broadcastToClients(createSimpleJSONEvent("conference_invites_update"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case path[0] == "conference_invites" && len(path) == 3 && path[2] == "accept":
+		handleAPIv2ConferenceInviteAccept(w, r, path[1])
+
+	case request == "uploads":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, getUploads())
+
+	case path[0] == "uploads" && len(path) == 2:
+		handleAPIv2Upload(w, r, path[1])
+
+	case request == "downloads":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, getDownloads())
+
+	case path[0] == "downloads" && len(path) == 2:
+		handleAPIv2Download(w, r, path[1])
+
+	case path[0] == "downloads" && len(path) == 3 && path[2] == "accept":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		if !writeDownloadErrorJSON(w, acceptDownload(path[1])) {
+			return
+		}
+		d, _ := getDownload(path[1])
+		writeJSON(w, http.StatusOK, d)
+
+	case request == "received_files":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		// the files of deleted friends stay in the history
+		publicKey := r.URL.Query().Get("publicKey")
+		if publicKey != "" {
+			publicKeyBytes, err := hex.DecodeString(publicKey)
+			if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+				writeErrorJSON(w, http.StatusBadRequest, "invalid_public_key", "The public key is invalid.")
+				return
+			}
+			publicKey = hex.EncodeToString(publicKeyBytes)
+		}
+		writeJSON(w, http.StatusOK, getReceivedFiles(publicKey))
+
+	case path[0] == "received_files" && len(path) == 2:
+		handleAPIv2ReceivedFile(w, r, path[1])
+
+	case path[0] == "received_files" && len(path) == 3 && path[2] == "content":
+		handleAPIv2ReceivedFileContent(w, r, path[1])
+
+	case path[0] == "requests" && len(path) == 1:
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		requests := []apiFriendRequest{}
+		for _, dbFriendRequest := range storage.GetFriendRequests(-1) {
+			requests = append(requests, apiFriendRequest{PublicKey: dbFriendRequest.PublicKey, Message: dbFriendRequest.Message, IsIgnored: dbFriendRequest.IsIgnored})
+		}
+		writeJSON(w, http.StatusOK, requests)
+
+	case path[0] == "requests" && len(path) == 2:
+		handleAPIv2Request(w, r, path[1])
+
+	case path[0] == "requests" && len(path) == 3 && path[2] == "accept":
+		handleAPIv2RequestAccept(w, r, path[1])
+
+	case request == "search":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		query, opts, err := parseSearchQuery(r)
+		if err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, "invalid_query", "The query is invalid: "+err.Error())
+			return
+		}
+		results, err := searchMessages(query, opts)
+		if err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		writeJSON(w, http.StatusOK, results)
+
+	case request == "calls":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(getCallsJSON()))
+
+	case path[0] == "calls" && len(path) == 2:
+		handleAPIv2Call(w, r, path[1])
+
+	case path[0] == "calls" && len(path) == 3 && path[2] == "answer":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		friendnumber, _, ok := friendOfPublicKey(w, path[1])
+		if ok && writeCallErrorJSON(w, answerCall(friendnumber)) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case request == "voicemails":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, getVoicemails())
+
+	case path[0] == "voicemails" && len(path) == 2:
+		handleAPIv2Voicemail(w, r, path[1])
+
+	case path[0] == "voicemails" && len(path) == 3 && path[2] == "audio":
+		handleAPIv2VoicemailAudio(w, r, path[1])
+
+	case request == "profile":
+		handleAPIv2Profile(w, r)
+
+	case request == "settings":
+		handleAPIv2Settings(w, r)
+
+	default:
+		writeErrorJSON(w, http.StatusNotFound, "not_found", "The resource does not exist.")
+	}
+})
+
+// handleAPIv2Friends serves /friends: GET lists the friends, POST sends a
+// friend request
+func handleAPIv2Friends(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		roster, err := tox.Roster()
+		if err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+
+		friends := []apiFriend{}
+		for _, info := range roster {
+			friends = append(friends, getAPIFriend(info))
+		}
+		writeJSON(w, http.StatusOK, friends)
+		return
+	}
+
+	var incomingData struct {
+		ToxID   string `json:"tox_id"`
+		Message string `json:"message"`
+	}
+	if !decodeRequestJSON(w, r, &incomingData) {
+		return
+	}
+
+	friendAddressBytes, err := hex.DecodeString(incomingData.ToxID)
+	if err != nil || len(friendAddressBytes) != libtox.TOX_ADDRESS_SIZE {
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_toxid", "The Tox ID you entered is invalid.")
+		return
+	}
+
+	if len(incomingData.Message) == 0 {
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "no_message", "An invitation message is required.")
+		return
+	}
+
+	friendnumber, err := tox.FriendAdd(friendAddressBytes, incomingData.Message)
+	if err != nil {
+		writeFriendAddErrorJSON(w, err)
+		return
+	}
+
+	writeCreatedFriend(w, friendnumber)
+}
+
+// handleAPIv2Friend serves /friends/{publicKey}: GET returns the friend, PATCH
+// marks the messages of the friend as read, DELETE removes the friend
+// publicKey  the public key of the friend in the path
+func handleAPIv2Friend(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	friendnumber, publicKey, ok := friendOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var incomingData struct {
+			Read *bool `json:"read"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.Read != nil && *incomingData.Read {
+			if err := storage.SetLastMessageRead(publicKey); err != nil {
+				writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+				return
+			}
+			broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+		}
+
+	case http.MethodDelete:
+		cancelFriendUploads(friendnumber)
+		cancelFriendDownloads(friendnumber)
+		failFriendOutbox(publicKey)
+		if err := tox.FriendDelete(friendnumber); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	info, err := tox.RosterFriend(friendnumber)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_friend", "The friend does not exist.")
+		return
+	}
+	writeJSON(w, http.StatusOK, getAPIFriend(info))
+}
+
+// handleAPIv2Messages serves /friends/{publicKey}/messages: GET returns a page
+// of the chat history (?before=<message id>&limit=<n>), POST sends a message,
+// DELETE deletes the chat history
+// publicKey  the public key of the friend in the path
+func handleAPIv2Messages(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+
+	friendnumber, publicKey, ok := friendOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		before, limit, err := parseHistoryQuery(r)
+		if err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, "invalid_query", "The query is invalid: "+err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, getMessagePage(publicKey, before, limit))
+
+	case http.MethodPost:
+		var incomingData struct {
+			Message string `json:"message"`
+			Action  bool   `json:"action"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if len(incomingData.Message) == 0 {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "no_message", "The message is empty.")
+			return
+		}
+
+		msg, err := queueMessage(friendnumber, publicKey, incomingData.Action, incomingData.Message)
+		switch err {
+		case nil:
+		case errInvalidMessage:
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_message", "The message you entered is too long.")
+			return
+		default:
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		writeJSON(w, http.StatusCreated, getAPIMessage(msg))
+
+	case http.MethodDelete:
+		if err := storage.DeleteMessages(publicKey); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAPIv2Conferences serves /conferences: GET lists the conferences, POST
+// creates a new text conference
+func handleAPIv2Conferences(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, getConferences())
+		return
+	}
+
+	var incomingData struct {
+		Title string `json:"title"`
+	}
+	if !decodeRequestJSON(w, r, &incomingData) {
+		return
+	}
+
+	conference, err := createConference(incomingData.Title)
+	switch err {
+	case nil:
+	case errInvalidTitle:
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_title", "The title you entered is too long.")
+		return
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/conferences/"+conference.ID)
+	writeJSON(w, http.StatusCreated, conference)
+}
+
+// handleAPIv2Conference serves /conferences/{id}: GET returns the conference,
+// PATCH changes its title, DELETE leaves it and deletes the chat history
+// id  the ID of the conference in the path
+func handleAPIv2Conference(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	number, id, ok := conferenceOfIDPath(w, id)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var incomingData struct {
+			Title *string `json:"title"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.Title != nil {
+			switch err := setConferenceTitle(number, *incomingData.Title); err {
+			case nil:
+			case errInvalidTitle:
+				writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_title", "The title you entered is too long.")
+				return
+			default:
+				writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+				return
+			}
+		}
+
+	case http.MethodDelete:
+		if err := leaveConference(number, id); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	conference, err := getAPIConference(number)
+	if err != nil {
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+	writeJSON(w, http.StatusOK, conference)
+}
+
+// handleAPIv2ConferenceMessages serves /conferences/{id}/messages: GET returns
+// a page of the chat history, POST sends a message to the conference
+// id  the ID of the conference in the path
+func handleAPIv2ConferenceMessages(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	number, id, ok := conferenceOfIDPath(w, id)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		before, limit, err := parseHistoryQuery(r)
+		if err != nil {
+			writeErrorJSON(w, http.StatusBadRequest, "invalid_query", "The query is invalid: "+err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, getConferenceMessagePage(id, before, limit))
+		return
+	}
+
+	var incomingData struct {
+		Message string `json:"message"`
+		Action  bool   `json:"action"`
+	}
+	if !decodeRequestJSON(w, r, &incomingData) {
+		return
+	}
+
+	if len(incomingData.Message) == 0 {
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "no_message", "The message is empty.")
+		return
+	}
+
+	msg, err := sendConferenceMessage(number, id, incomingData.Action, incomingData.Message)
+	switch err {
+	case nil:
+	case errInvalidMessage:
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_message", "The message you entered is too long.")
+		return
+	case errConferenceOffline:
+		writeErrorJSON(w, http.StatusConflict, "conference_offline", "You are not connected to the conference.")
+		return
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+// handleAPIv2ConferenceInvites serves /conferences/{id}/invites: POST invites
+// a friend to the conference
+// id  the ID of the conference in the path
+func handleAPIv2ConferenceInvites(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	number, _, ok := conferenceOfIDPath(w, id)
+	if !ok {
+		return
+	}
+
+	var incomingData struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if !decodeRequestJSON(w, r, &incomingData) {
+		return
+	}
+
+	friendnumber, _, ok := friendOfPublicKey(w, incomingData.PublicKey)
+	if !ok {
+		return
+	}
+
+	switch err := inviteToConference(number, friendnumber); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errFriendOffline:
+		writeErrorJSON(w, http.StatusConflict, "friend_offline", "Your friend is offline.")
+	case errConferenceOffline:
+		writeErrorJSON(w, http.StatusConflict, "conference_offline", "You are not connected to the conference.")
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+	}
+}
+
+// handleAPIv2ConferenceInviteAccept serves /conference_invites/{id}/accept:
+// POST joins the conference of the invite
+// id  the id of the invite in the path
+func handleAPIv2ConferenceInviteAccept(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	inviteID, ok := inviteIDOfPath(w, id)
+	if !ok {
+		return
+	}
+	conference, err := acceptConferenceInvite(inviteID)
+	switch err {
+	case nil:
+	case persistence.ConferenceInviteNotFound:
+		writeErrorJSON(w, http.StatusNotFound, "unknown_invite", "The conference invite does not exist.")
+		return
+	case errUnknownFriend:
+		writeErrorJSON(w, http.StatusNotFound, "unknown_friend", "The friend does not exist.")
+		return
+	case errFriendOffline:
+		writeErrorJSON(w, http.StatusConflict, "friend_offline", "Your friend is offline.")
+		return
+	case errJoinFailed:
+		writeErrorJSON(w, http.StatusConflict, "join_failed", "The conference could not be joined.")
+		return
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/conferences/"+conference.ID)
+	writeJSON(w, http.StatusCreated, conference)
+}
+
+// handleAPIv2Files serves /friends/{publicKey}/files: POST sends the file
+// field of a multipart/form-data request to the friend. The file is streamed
+// to the upload directory and sent when the friend is online.
+// publicKey  the public key of the friend in the path
+func handleAPIv2Files(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	friendnumber, _, ok := friendOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, getConfig().Limits.MaxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "The request is not multipart/form-data.")
+		return
+	}
+
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err != nil || part.FormName() == "file" {
+			break
+		}
+	}
+	if err == io.EOF {
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "no_file", "The request has no file field.")
+		return
+	}
+
+	var u *upload
+	if err == nil {
+		u, err = newUpload(friendnumber, part.FileName(), part)
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeErrorJSON(w, http.StatusRequestEntityTooLarge, "request_too_large", "The file is too large.")
+		return
+	} else if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "The file could not be read.")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/uploads/"+u.id)
+	writeJSON(w, http.StatusCreated, getAPIUpload(u))
+}
+
+// handleAPIv2Upload serves /uploads/{id}: GET returns the state of the
+// upload, PATCH pauses or resumes it, DELETE cancels it
+// id  the id of the upload in the path
+func handleAPIv2Upload(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var incomingData struct {
+			Paused *bool `json:"paused"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.Paused != nil {
+			switch err := pauseUpload(id, *incomingData.Paused); err {
+			case nil:
+			case errUnknownUpload:
+				writeErrorJSON(w, http.StatusNotFound, "unknown_upload", "The upload does not exist or is finished.")
+				return
+			case errNotSending:
+				writeErrorJSON(w, http.StatusConflict, "not_sending", "The file is not being sent.")
+				return
+			default:
+				writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+				return
+			}
+		}
+
+	case http.MethodDelete:
+		if err := cancelUpload(id); err != nil {
+			writeErrorJSON(w, http.StatusNotFound, "unknown_upload", "The upload does not exist or is finished.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	u, err := getUpload(id)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_upload", "The upload does not exist or is finished.")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// handleAPIv2Download serves /downloads/{id}: GET returns a file a friend
+// sends, PATCH pauses or resumes it, DELETE rejects or cancels it
+// id  the id of the download in the path
+func handleAPIv2Download(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var incomingData struct {
+			Paused *bool `json:"paused"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.Paused != nil && !writeDownloadErrorJSON(w, pauseDownload(id, *incomingData.Paused)) {
+			return
+		}
+
+	case http.MethodDelete:
+		if writeDownloadErrorJSON(w, rejectDownload(id)) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	d, err := getDownload(id)
+	if writeDownloadErrorJSON(w, err) {
+		writeJSON(w, http.StatusOK, d)
+	}
+}
+
+// writeDownloadErrorJSON writes the error of a download action to w. It returns
+// true if there was no error.
+// w    the http.ResponseWriter of the request
+// err  the error of the action
+func writeDownloadErrorJSON(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case errUnknownDownload:
+		writeErrorJSON(w, http.StatusNotFound, "unknown_download", "The download does not exist or is finished.")
+	case errNotPending:
+		writeErrorJSON(w, http.StatusConflict, "not_pending", "The file was already accepted or rejected.")
+	case errNotReceiving:
+		writeErrorJSON(w, http.StatusConflict, "not_receiving", "The file is not being received.")
+	case errNotPaused:
+		writeErrorJSON(w, http.StatusConflict, "not_paused", "The file was not paused.")
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+	}
+	return false
+}
+
+// handleAPIv2ReceivedFile serves /received_files/{id}: GET returns an entry
+// of the history of the received files, DELETE deletes it and the file
+// id  the id of the transfer in the path
+func handleAPIv2ReceivedFile(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+
+	file, err := storage.GetReceivedFile(id)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_file", "The file is not in the history of the received files.")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := deleteReceivedFile(id); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, getAPIReceivedFile(file))
+}
+
+// handleAPIv2ReceivedFileContent serves /received_files/{id}/content: GET
+// returns a received file as an attachment. The type is guessed from the
+// extension only, browsers must not render a file of a friend as a page of
+// the GUI.
+// id  the id of the transfer in the path
+func handleAPIv2ReceivedFileContent(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	file, err := storage.GetReceivedFile(id)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_file", "The file is not in the history of the received files.")
+		return
+	}
+	if file.Path == "" {
+		writeErrorJSON(w, http.StatusNotFound, "not_received", "The file was not received.")
+		return
+	}
+
+	f, err := os.Open(filepath.Join(downloadDir(), file.Path))
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "not_available", "The file was deleted.")
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(file.Path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": downloadFileName(file.Name)})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+// handleAPIv2Call serves /calls/{publicKey}: POST calls the friend, audio
+// only, PATCH mutes or unmutes the call, DELETE hangs up or rejects it
+// publicKey  the public key of the friend in the path
+func handleAPIv2Call(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodPost, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	friendnumber, _, ok := friendOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = startCall(friendnumber)
+
+	case http.MethodPatch:
+		var incomingData struct {
+			Muted *bool `json:"muted"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+		if incomingData.Muted == nil {
+			writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "The request has no muted field.")
+			return
+		}
+		err = muteCall(friendnumber, *incomingData.Muted)
+
+	case http.MethodDelete:
+		err = hangupCall(friendnumber)
+	}
+
+	if writeCallErrorJSON(w, err) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeCallErrorJSON writes the error of a call action to w. It returns true
+// if there was no error.
+// w    the http.ResponseWriter of the request
+// err  the error of the action
+func writeCallErrorJSON(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case errCallsUnavailable:
+		writeErrorJSON(w, http.StatusServiceUnavailable, "calls_unavailable", "Calls are not available on this server.")
+	case errUnknownFriend, libtoxav.ErrCallFriendNotFound, libtoxav.ErrAnswerFriendNotFound, libtoxav.ErrCallControlFriendNotFound:
+		writeErrorJSON(w, http.StatusNotFound, "unknown_friend", "The friend does not exist.")
+	case libtoxav.ErrCallFriendNotConnected:
+		writeErrorJSON(w, http.StatusConflict, "friend_offline", "Your friend is offline.")
+	case libtoxav.ErrCallFriendAlreadyInCall:
+		writeErrorJSON(w, http.StatusConflict, "already_in_call", "You are already in a call with this friend.")
+	case libtoxav.ErrAnswerFriendNotCalling, libtoxav.ErrCallControlFriendNotInCall:
+		writeErrorJSON(w, http.StatusNotFound, "no_call", "There is no call with this friend.")
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+	}
+	return false
+}
+
+// handleAPIv2Voicemail serves /voicemails/{id}: GET returns a recording of
+// the voicemail, DELETE deletes it
+// id  the id of the recording in the path
+func handleAPIv2Voicemail(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+
+	voicemail, ok := voicemailOfIDPath(w, id)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := storage.DeleteVoicemail(voicemail.ID); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		broadcastToClients(createSimpleJSONEvent("voicemails_update"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, voicemail)
+}
+
+// handleAPIv2VoicemailAudio serves /voicemails/{id}/audio: GET returns the
+// audio of a recording as a WAV attachment
+// id  the id of the recording in the path
+func handleAPIv2VoicemailAudio(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	voicemail, ok := voicemailOfIDPath(w, id)
+	if !ok {
+		return
+	}
+
+	audio, err := storage.GetVoicemailAudio(voicemail.ID)
+	if err == persistence.VoicemailNotFound {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_voicemail", "The voicemail does not exist.")
+		return
+	} else if err != nil {
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "voicemail-" + strconv.FormatInt(voicemail.ID, 10) + ".wav"}))
+	w.Write(audio)
+}
+
+// handleAPIv2Request serves /requests/{publicKey}: GET returns the friend
+// request, PATCH sets whether it is ignored, DELETE rejects it
+// publicKey  the public key of the sender of the request in the path
+func handleAPIv2Request(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete) {
+		return
+	}
+
+	request, ok := friendRequestOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		var incomingData struct {
+			IsIgnored *bool `json:"is_ignored"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.IsIgnored != nil {
+			if err := storage.StoreFriendRequestIgnoreStatus(request.PublicKey, *incomingData.IsIgnored); err != nil {
+				writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+				return
+			}
+			request.IsIgnored = *incomingData.IsIgnored
+		}
+
+	case http.MethodDelete:
+		if err := storage.DeleteFriendRequest(request.PublicKey); err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, request)
+}
+
+// handleAPIv2RequestAccept serves /requests/{publicKey}/accept: POST adds the
+// sender of the friend request as a friend
+// publicKey  the public key of the sender of the request in the path
+func handleAPIv2RequestAccept(w http.ResponseWriter, r *http.Request, publicKey string) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	request, ok := friendRequestOfPublicKey(w, publicKey)
+	if !ok {
+		return
+	}
+
+	publicKeyBytes, _ := hex.DecodeString(request.PublicKey)
+	friendnumber, err := tox.FriendAddNorequest(publicKeyBytes)
+	if err != nil {
+		writeFriendAddErrorJSON(w, err)
+		return
+	}
+
+	storage.DeleteFriendRequest(request.PublicKey)
+	broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+
+	writeCreatedFriend(w, friendnumber)
+}
+
+// handleAPIv2Profile serves /profile: GET returns the profile, PATCH changes
+// the username, the status message or the status
+func handleAPIv2Profile(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var incomingData struct {
+			Username      *string `json:"username"`
+			StatusMessage *string `json:"status_msg"`
+			Status        *string `json:"status"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.Status != nil && getUserStatusAsString(getUserStatusFromString(*incomingData.Status)) != *incomingData.Status {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_status", "The status must be NONE, AWAY or BUSY.")
+			return
+		}
+		if incomingData.Username != nil && len(*incomingData.Username) == 0 {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_username", "The username must not be empty.")
+			return
+		}
+
+		if incomingData.Username != nil {
+			if err := tox.SelfSetName(*incomingData.Username); err != nil {
+				writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_username", "The username you entered is too long.")
+				return
+			}
+		}
+		if incomingData.StatusMessage != nil {
+			if err := tox.SelfSetStatusMessage(*incomingData.StatusMessage); err != nil {
+				writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_status_msg", "The status message you entered is too long.")
+				return
+			}
+		}
+		if incomingData.Status != nil {
+			if err := tox.SelfSetStatus(getUserStatusFromString(*incomingData.Status)); err != nil {
+				writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+				return
+			}
+		}
+
+		broadcastToClients(createSimpleJSONEvent("profile_update"))
+	}
+
+	writeJSON(w, http.StatusOK, getProfile())
+}
+
+// handleAPIv2Settings serves /settings: GET returns the settings, PATCH
+// changes them. The password can be set but is never returned.
+func handleAPIv2Settings(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var incomingData struct {
+			AuthUser             *string  `json:"auth_user"`
+			AuthPassword         *string  `json:"auth_password"`
+			AwayOnDisconnect     *bool    `json:"away_on_disconnect"`
+			NotificationsEnabled *bool    `json:"notifications_enabled"`
+			VoicemailEnabled     *bool    `json:"voicemail_enabled"`
+			FileAutoAccept       []string `json:"file_auto_accept"`
+			FileMaxSize          *uint64  `json:"file_max_size"`
+			FileRejectTimeout    *int64   `json:"file_reject_timeout"`
+		}
+		if !decodeRequestJSON(w, r, &incomingData) {
+			return
+		}
+
+		if incomingData.AuthUser != nil && len(*incomingData.AuthUser) == 0 {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_auth_user", "The username must not be empty.")
+			return
+		}
+		if incomingData.AuthPassword != nil && len(*incomingData.AuthPassword) == 0 {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_auth_password", "The password must not be empty.")
+			return
+		}
+		if incomingData.FileRejectTimeout != nil && *incomingData.FileRejectTimeout < 0 {
+			writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_file_reject_timeout", "The timeout must not be negative.")
+			return
+		}
+
+		var err error
+		if incomingData.AuthUser != nil {
+			err = setAuthUser(*incomingData.AuthUser)
+		}
+		if err == nil && incomingData.AuthPassword != nil {
+			err = setAuthPassword(*incomingData.AuthPassword)
+		}
+		if err == nil && incomingData.AwayOnDisconnect != nil {
+			err = storage.StoreKeyValue("settings_away_on_disconnect", strconv.FormatBool(*incomingData.AwayOnDisconnect))
+		}
+		if err == nil && incomingData.NotificationsEnabled != nil {
+			err = storage.StoreKeyValue("settings_notifications_enabled", strconv.FormatBool(*incomingData.NotificationsEnabled))
+		}
+		if err == nil && incomingData.VoicemailEnabled != nil {
+			err = storage.StoreKeyValue("settings_voicemail_enabled", strconv.FormatBool(*incomingData.VoicemailEnabled))
+		}
+		if err == nil && incomingData.FileAutoAccept != nil {
+			err = setFileAutoAccept(incomingData.FileAutoAccept)
+			if err == errInvalidPublicKey {
+				writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_public_key", "The public key is invalid.")
+				return
+			}
+		}
+		if err == nil && incomingData.FileMaxSize != nil {
+			err = storage.StoreKeyValue("settings_file_max_size", strconv.FormatUint(*incomingData.FileMaxSize, 10))
+		}
+		if err == nil && incomingData.FileRejectTimeout != nil {
+			err = storage.StoreKeyValue("settings_file_reject_timeout", strconv.FormatInt(*incomingData.FileRejectTimeout, 10))
+		}
+		if err != nil {
+			writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, getSettings())
+}
+
+// allowMethods returns true if the method of a request is one of the given
+// methods. Otherwise a 405 error is written to w and false is returned.
+// w        the http.ResponseWriter of the request
+// r        the request
+// methods  the allowed methods
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeErrorJSON(w, http.StatusMethodNotAllowed, "method_not_allowed", "The method "+r.Method+" is not allowed here.")
+	return false
+}
+
+// friendOfPublicKey returns the friend number and the normalised public key of
+// the friend with the public key in the path of a request. If there is no
+// such friend, an error is written to w and false is returned.
+// w          the http.ResponseWriter of the request
+// publicKey  the public key from the path
+func friendOfPublicKey(w http.ResponseWriter, publicKey string) (uint32, string, bool) {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_public_key", "The public key is invalid.")
+		return 0, "", false
+	}
+
+	friendnumber, err := tox.FriendByPublicKey(publicKeyBytes)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_friend", "The friend does not exist.")
+		return 0, "", false
+	}
+
+	return friendnumber, hex.EncodeToString(publicKeyBytes), true
+}
+
+// conferenceOfIDPath returns the conference number and the normalised ID of
+// the conference with the ID in the path of a request. If there is no such
+// conference, an error is written to w and false is returned.
+// w   the http.ResponseWriter of the request
+// id  the ID from the path
+func conferenceOfIDPath(w http.ResponseWriter, id string) (uint32, string, bool) {
+	number, id, err := conferenceOfID(id)
+	if err != nil {
+		writeErrorJSON(w, http.StatusNotFound, "unknown_conference", "The conference does not exist.")
+		return 0, "", false
+	}
+
+	return number, id, true
+}
+
+// inviteIDOfPath returns the id of a conference invite in the path of a
+// request. If it is not a number, an error is written to w and false is
+// returned.
+// w   the http.ResponseWriter of the request
+// id  the id from the path
+func inviteIDOfPath(w http.ResponseWriter, id string) (int64, bool) {
+	inviteID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_id", "The id is invalid.")
+		return 0, false
+	}
+
+	return inviteID, true
+}
+
+// voicemailOfIDPath returns the recording of the voicemail with the id in the
+// path of a request. If there is no such recording, an error is written to w
+// and false is returned.
+// w   the http.ResponseWriter of the request
+// id  the id from the path
+func voicemailOfIDPath(w http.ResponseWriter, id string) (apiVoicemail, bool) {
+	voicemailID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_id", "The id is invalid.")
+		return apiVoicemail{}, false
+	}
+
+	for _, voicemail := range getVoicemails() {
+		if voicemail.ID == voicemailID {
+			return voicemail, true
+		}
+	}
+
+	writeErrorJSON(w, http.StatusNotFound, "unknown_voicemail", "The voicemail does not exist.")
+	return apiVoicemail{}, false
+}
+
+// friendRequestOfPublicKey returns the stored friend request of the public key
+// in the path of a request. If there is no such request, an error is written
+// to w and false is returned.
+// w          the http.ResponseWriter of the request
+// publicKey  the public key from the path
+func friendRequestOfPublicKey(w http.ResponseWriter, publicKey string) (apiFriendRequest, bool) {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_public_key", "The public key is invalid.")
+		return apiFriendRequest{}, false
+	}
+
+	for _, dbFriendRequest := range storage.GetFriendRequests(-1) {
+		if strings.EqualFold(dbFriendRequest.PublicKey, publicKey) {
+			return apiFriendRequest{PublicKey: dbFriendRequest.PublicKey, Message: dbFriendRequest.Message, IsIgnored: dbFriendRequest.IsIgnored}, true
+		}
+	}
+
+	writeErrorJSON(w, http.StatusNotFound, "unknown_request", "The friend request does not exist.")
+	return apiFriendRequest{}, false
+}
+
+// writeCreatedFriend writes a 201 response with a friend that was just added
+// w             the http.ResponseWriter
+// friendnumber  the friend that was added
+func writeCreatedFriend(w http.ResponseWriter, friendnumber uint32) {
+	info, err := tox.RosterFriend(friendnumber)
+	if err != nil {
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	friend := getAPIFriend(info)
+	w.Header().Set("Location", "/api/v2/friends/"+friend.PublicKey)
+	writeJSON(w, http.StatusCreated, friend)
+}
+
+// writeFriendAddErrorJSON writes a libtox.ToxErrFriendAdd error encoded as
+// JSON with a matching HTTP status code to a http.ResponseWriter
+// w    the http.ResponseWriter
+// err  the libtox.ToxErrFriendAdd error to be encoded
+func writeFriendAddErrorJSON(w http.ResponseWriter, err error) {
+	switch err {
+	case libtox.ErrFriendAddNoMessage:
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "no_message", "An invitation message is required.")
+	case libtox.ErrFriendAddTooLong:
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_message", "The message you entered is too long.")
+	case libtox.ErrFriendAddOwnKey, libtox.ErrFriendAddBadChecksum:
+		writeErrorJSON(w, http.StatusUnprocessableEntity, "invalid_toxid", "The Tox ID you entered is invalid.")
+	case libtox.ErrFriendAddAlreadySent, libtox.ErrFriendAddSetNewNospam:
+		writeErrorJSON(w, http.StatusConflict, "already_send", "A friend request to this person has already send.")
+	default:
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !toxsim
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/websocket"
+)
+
+// handleCallAudio relays the audio of a call between the browser and the
+// friend: /calls/audio?publicKey=<public key of the friend>. Both directions carry binary
+// messages of 16 bit little endian mono PCM at 48 kHz; the server sends 20 ms
+// frames, the browser may send any length. The socket is closed when the call
+// ends.
+var handleCallAudio = websocket.Handler(func(conn *websocket.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			fmt.Println("[handleCallAudio] Websocket could not be closed", err.Error())
+		}
+	}()
+
+	friendnumber, err := friendNumberOfPublicKey(conn.Request().URL.Query().Get("publicKey"))
+	if err != nil {
+		return
+	}
+
+	// about a second of audio
+	frames := make(chan []byte, 50)
+
+	callsMtx.Lock()
+	call, ok := calls[friendnumber]
+	if ok {
+		call.sinks[conn] = frames
+	}
+	callsMtx.Unlock()
+	if !ok {
+		fmt.Println("[handleCallAudio] No call with friend", friendnumber)
+		return
+	}
+
+	go func() {
+		for pcm := range frames {
+			if err := websocket.Message.Send(conn, pcm); err != nil {
+				break
+			}
+		}
+		// the call ended or the browser is gone, stop the receiving side
+		conn.Close()
+	}()
+
+	for {
+		var pcm []byte
+		if err := websocket.Message.Receive(conn, &pcm); err != nil {
+			break
+		}
+		sendCallAudio(friendnumber, pcm)
+	}
+
+	callsMtx.Lock()
+	if _, ok := call.sinks[conn]; ok {
+		delete(call.sinks, conn)
+		close(frames)
+	}
+	callsMtx.Unlock()
+})
@@ -5,18 +5,42 @@ import (
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 	"golang.org/x/net/websocket"
 	"strconv"
+	"sync"
 )
 
-var activeConnections = make(map[*websocket.Conn]bool)
+// the number of events waiting for a client before it is disconnected
+const clientQueueSize = 256
+
+var activeConnectionsMtx sync.Mutex
+
+// Map of the connected clients to the queue of the events for them. Every
+// client has a goroutine writing its queue, so the events arrive in the order
+// they are broadcast. Whoever removes a client from the map closes its queue.
+var activeConnections = make(map[*websocket.Conn]chan string)
+
+// clientCount returns the number of connected clients
+func clientCount() int {
+	activeConnectionsMtx.Lock()
+	defer activeConnectionsMtx.Unlock()
+
+	return len(activeConnections)
+}
 
 func broadcastToClients(msg string) {
-	go func() {
-		for conn, _ := range activeConnections {
-			if err := websocket.Message.Send(conn, msg); err != nil {
-				fmt.Println("[handleWS] Could not send message to ", conn.RemoteAddr, err.Error())
-			}
+	activeConnectionsMtx.Lock()
+	defer activeConnectionsMtx.Unlock()
+
+	for conn, events := range activeConnections {
+		select {
+		case events <- msg:
+		default:
+			// the client reconnects and loads everything again
+			fmt.Println("[handleWS] Client does not keep up, disconnecting:", conn.Request().RemoteAddr)
+			delete(activeConnections, conn)
+			close(events)
+			conn.Close()
 		}
-	}()
+	}
 }
 
 var handleWS = websocket.Handler(func(conn *websocket.Conn) {
@@ -30,14 +54,26 @@ var handleWS = websocket.Handler(func(conn *websocket.Conn) {
 		}
 	}()
 
-	activeConnections[conn] = true
+	events := make(chan string, clientQueueSize)
+	go func() {
+		for msg := range events {
+			if err := websocket.Message.Send(conn, msg); err != nil {
+				fmt.Println("[handleWS] Could not send message to", conn.Request().RemoteAddr, err.Error())
+			}
+		}
+	}()
+
+	activeConnectionsMtx.Lock()
+	activeConnections[conn] = events
+	connected := len(activeConnections)
+	activeConnectionsMtx.Unlock()
 	fmt.Println("[handleWS] Client connected:", conn.Request().RemoteAddr)
-	fmt.Println("[handleWS] Number of clients connected:", len(activeConnections))
+	fmt.Println("[handleWS] Number of clients connected:", connected)
 
 	awayOnDisconnectString, _ := storage.GetKeyValue("settings_away_on_disconnect")
 	awayOnDisconnect, _ := strconv.ParseBool(awayOnDisconnectString)
 
-	if awayOnDisconnect && len(activeConnections) == 1 {
+	if awayOnDisconnect && connected == 1 {
 		tox.SelfSetStatus(libtox.TOX_USERSTATUS_NONE)
 		broadcastToClients(createSimpleJSONEvent("profile_update"))
 	}
@@ -46,10 +82,17 @@ var handleWS = websocket.Handler(func(conn *websocket.Conn) {
 		if err = websocket.Message.Receive(conn, &clientMessage); err != nil {
 			// the connection is closed
 			fmt.Println("[handleWS] Read error. Removing client.", err.Error())
-			delete(activeConnections, conn)
-			fmt.Println("[handleWS] Number of clients still connected:", len(activeConnections))
+			activeConnectionsMtx.Lock()
+			if _, ok := activeConnections[conn]; ok {
+				// not disconnected by broadcastToClients
+				delete(activeConnections, conn)
+				close(events)
+			}
+			connected = len(activeConnections)
+			activeConnectionsMtx.Unlock()
+			fmt.Println("[handleWS] Number of clients still connected:", connected)
 
-			if len(activeConnections) == 0 {
+			if connected == 0 {
 				awayOnDisconnectString, _ := storage.GetKeyValue("settings_away_on_disconnect")
 				awayOnDisconnect, _ := strconv.ParseBool(awayOnDisconnectString)
 
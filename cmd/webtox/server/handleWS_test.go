@@ -0,0 +1,54 @@
+//go:build toxsim
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestSlowClient(t *testing.T) {
+	newTestServer(t)
+
+	done := make(chan struct{})
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		// net/http would recover the panic of a double close
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("handleWS panicked: %v", r)
+			}
+			close(done)
+		}()
+		handleWS(conn)
+	}))
+	defer server.Close()
+
+	client, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for clientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the client reads nothing, so its socket and then its queue fill up
+	event := strings.Repeat("x", 1<<20)
+	for i := 0; i < 2*clientQueueSize; i++ {
+		broadcastToClients(event)
+	}
+	if n := clientCount(); n != 0 {
+		t.Fatalf("%d clients connected, want the slow client disconnected", n)
+	}
+
+	// the read loop ends without closing the queue again
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection of the slow client is still open")
+	}
+}
@@ -1,14 +1,48 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
 	"github.com/calvindc/dpc-tox/cmd/webtox/httpserve"
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 )
 
+// errUnknownFriend is returned by friendNumberOfPublicKey if the public key does
+// not belong to a friend
+var errUnknownFriend = errors.New("Unknown friend")
+
+// friendNumberOfPublicKey returns the current friend number of a friend. The
+// API and the events identify friends by public key since friend numbers are
+// reused after a friend is deleted.
+// publicKey  the public key of the friend as a hex string
+func friendNumberOfPublicKey(publicKey string) (uint32, error) {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+		return 0, errUnknownFriend
+	}
+
+	friendnumber, err := tox.FriendByPublicKey(publicKeyBytes)
+	if err != nil {
+		return 0, errUnknownFriend
+	}
+	return friendnumber, nil
+}
+
+// publicKeyOfFriend returns the public key of a friend as a hex string, or an
+// empty string if the friend does not exist
+// friendnumber  the friend
+func publicKeyOfFriend(friendnumber uint32) string {
+	publicKey, err := tox.FriendGetPublickey(friendnumber)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(publicKey)
+}
+
 // getUserStatusAsString returns a string representing the given Tox user status
 // status  the Tox user status to be converted
 func getUserStatusAsString(status libtox.ToxUserStatus) string {
@@ -110,3 +144,49 @@ func storeDefaultHTTPAuth() (string, string, string) {
 
 	return user, pass, salt
 }
+
+// voicemailEnabled returns true unless the voicemail has been disabled in the
+// settings
+func voicemailEnabled() bool {
+	voicemailEnabledString, err := storage.GetKeyValue("settings_voicemail_enabled")
+	if err != nil {
+		return true
+	}
+
+	voicemailEnabled, err := strconv.ParseBool(voicemailEnabledString)
+	return err != nil || voicemailEnabled
+}
+
+// setAuthUser stores a new username for the GUI and applies it
+// username  the new username
+func setAuthUser(username string) error {
+	if err := storage.StoreKeyValue("settings_auth_user", username); err != nil {
+		return err
+	}
+
+	httpserve.ChangeAuthOptionsUser(authOptions, username)
+	return nil
+}
+
+// setAuthPassword stores a new salted password for the GUI and applies it
+// password  the new password in plain text
+func setAuthPassword(password string) error {
+	salt, err := httpserve.RandomString(32)
+	if err != nil {
+		panic("could not generate salt")
+	}
+
+	pass := httpserve.Sha512Sum(password + salt)
+
+	err = storage.StoreKeyValue("settings_auth_pass", pass)
+	err2 := storage.StoreKeyValue("settings_auth_salt", salt)
+	if err != nil {
+		return err
+	}
+	if err2 != nil {
+		return err2
+	}
+
+	httpserve.ChangeAuthOptionsPass(authOptions, pass, salt)
+	return nil
+}
@@ -3,68 +3,344 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// profile is the Tox profile of the user
+type profile struct {
+	Username      string `json:"username"`
+	StatusMessage string `json:"status_msg"`
+	ToxID         string `json:"tox_id"`
+	Status        string `json:"status"`
+}
+
+// getProfile returns the Tox profile of the user
+func getProfile() profile {
+	username, _ := tox.SelfGetName()
+	statusMessage, _ := tox.SelfGetStatusMessage()
+	toxid, _ := tox.SelfGetAddress()
+	status, _ := tox.SelfGetStatus()
+
+	return profile{
+		Username:      username,
+		StatusMessage: string(statusMessage),
+		ToxID:         strings.ToUpper(hex.EncodeToString(toxid)),
+		Status:        getUserStatusAsString(status),
+	}
+}
+
+// settings are the settings of the GUI
+type settings struct {
+	AuthUser             string `json:"auth_user"`
+	AwayOnDisconnect     bool   `json:"away_on_disconnect"`
+	NotificationsEnabled bool   `json:"notifications_enabled"`
+	VoicemailEnabled     bool   `json:"voicemail_enabled"`
+
+	// the file policy, see filePolicy
+	FileAutoAccept    []string `json:"file_auto_accept"`
+	FileMaxSize       uint64   `json:"file_max_size"`
+	FileRejectTimeout int64    `json:"file_reject_timeout"` // seconds
+}
+
+// getSettings returns the stored settings of the GUI
+func getSettings() settings {
+	username, _ := storage.GetKeyValue("settings_auth_user")
+	notificationsEnabledString, _ := storage.GetKeyValue("settings_notifications_enabled")
+	notificationsEnabled, _ := strconv.ParseBool(notificationsEnabledString)
+	awayOnDisconnectString, _ := storage.GetKeyValue("settings_away_on_disconnect")
+	awayOnDisconnect, _ := strconv.ParseBool(awayOnDisconnectString)
+
+	policy := getFilePolicy()
+	autoAccept := make([]string, 0, len(policy.AutoAccept))
+	for publicKey := range policy.AutoAccept {
+		autoAccept = append(autoAccept, publicKey)
+	}
+	sort.Strings(autoAccept)
+
+	return settings{
+		AuthUser:             username,
+		AwayOnDisconnect:     awayOnDisconnect,
+		NotificationsEnabled: notificationsEnabled,
+		VoicemailEnabled:     voicemailEnabled(),
+		FileAutoAccept:       autoAccept,
+		FileMaxSize:          policy.MaxSize,
+		FileRejectTimeout:    int64(policy.RejectTimeout / time.Second),
+	}
+}
+
+// the number of messages of a history page if the request has no limit
+const historyDefaultLimit = 50
+
+// the maximum number of messages of a history page
+const historyMaxLimit = 200
+
+// apiFriend is a friend in the contact list. The chat history is not part of
+// it, see getMessagePage.
+type apiFriend struct {
+	PublicKey       string      `json:"publicKey"`
+	Number          uint32      `json:"number"` // informational only, see friendNumberOfPublicKey
+	Name            string      `json:"name"`
+	Status          string      `json:"status"`
+	StatusMsg       string      `json:"status_msg"`
+	Online          bool        `json:"online"`
+	LastMessageRead int64       `json:"last_msg_read"`
+	LastMessage     *apiMessage `json:"last_message"` // nil if there are no messages
+	Unread          int64       `json:"unread"`
+}
+
+type apiMessage struct {
+	ID         int64  `json:"id"`
+	Message    string `json:"message"`
+	IsIncoming bool   `json:"isIncoming"`
+	IsAction   bool   `json:"isAction"`
+	Time       int64  `json:"time"`
+	Status     string `json:"status,omitempty"`    // only for sent messages
+	Delivered  int64  `json:"delivered,omitempty"` // only for delivered messages
+}
+
+// messagePage is a page of the chat history with a friend
+type messagePage struct {
+	Messages   []apiMessage `json:"messages"`    // oldest first
+	NextBefore *int64       `json:"next_before"` // the before of the next (older) page, nil if this is the oldest one
+}
+
 // getFriendListJSON returns the users Tox friendlist as a JSON string
 func getFriendListJSON() (string, error) {
-	type Message struct {
-		Message    string `json:"message"`
-		IsIncoming bool   `json:"isIncoming"`
-		IsAction   bool   `json:"isAction"`
-		Time       int64  `json:"time"`
+	roster, err := tox.Roster()
+	if err != nil {
+		return "", err
+	}
+
+	friends := make([]apiFriend, len(roster))
+	for i, info := range roster {
+		friends[i] = getAPIFriend(info)
+	}
+	jsonFriends, _ := json.Marshal(friends)
+	return string(jsonFriends), nil
+}
+
+// getAPIFriend returns a friend as listed in the contact list
+// info  the state of the friend
+func getAPIFriend(info libtox.FriendInfo) apiFriend {
+	publicKey := hex.EncodeToString(info.PublicKey)
+	lastMessageRead, _ := storage.GetLastMessageRead(publicKey)
+	unread, _ := storage.CountUnreadMessages(publicKey)
+
+	friend := apiFriend{
+		PublicKey:       publicKey,
+		Number:          info.Number,
+		Name:            info.Name,
+		Status:          getUserStatusAsString(info.Status),
+		StatusMsg:       info.StatusMessage,
+		Online:          info.Connection != libtox.TOX_CONNECTION_NONE,
+		LastMessageRead: lastMessageRead,
+		Unread:          unread,
 	}
 
-	type friend struct {
-		Number          uint32    `json:"number"`
-		PublicKey       string    `json:"publicKey"`
-		Chat            []Message `json:"chat"`
-		LastMessageRead int64     `json:"last_msg_read"`
-		Name            string    `json:"name"`
-		Status          string    `json:"status"`
-		StatusMsg       string    `json:"status_msg"`
-		Online          bool      `json:"online"`
+	if messages := storage.GetMessagesBefore(publicKey, 0, 1); len(messages) > 0 {
+		lastMessage := getAPIMessage(messages[0])
+		friend.LastMessage = &lastMessage
+	}
+
+	return friend
+}
+
+// getAPIMessage returns a stored message as returned by the API
+// msg  the stored message
+func getAPIMessage(msg persistence.Message) apiMessage {
+	return apiMessage{ID: msg.ID, Message: msg.Message, IsIncoming: msg.IsIncoming, IsAction: msg.IsAction, Time: msg.Time, Status: msg.Status, Delivered: msg.Delivered}
+}
+
+// getMessagePage returns a page of the chat history with a friend
+// publicKey  the public key of the friend
+// before     only older messages are returned, 0 for the newest ones
+// limit      the maximum number of messages of the page
+func getMessagePage(publicKey string, before int64, limit int) messagePage {
+	// one more to know if there is an older page
+	dbMessages := storage.GetMessagesBefore(publicKey, before, limit+1)
+
+	var page messagePage
+	if len(dbMessages) > limit {
+		dbMessages = dbMessages[:limit]
+		nextBefore := dbMessages[limit-1].ID
+		page.NextBefore = &nextBefore
 	}
 
-	friend_ids, err := tox.SelfGetFriendlist()
+	// the messages are stored newest first
+	page.Messages = make([]apiMessage, len(dbMessages))
+	for i, msg := range dbMessages {
+		page.Messages[len(dbMessages)-1-i] = getAPIMessage(msg)
+	}
+
+	return page
+}
+
+// parseHistoryQuery returns the before and limit parameters of a request for a
+// page of the chat history: ?before=<message id>&limit=<n>. Both are optional.
+// r  the request
+func parseHistoryQuery(r *http.Request) (int64, int, error) {
+	var before int64
+	limit := historyDefaultLimit
+
+	query := r.URL.Query()
+	if beforeString := query.Get("before"); beforeString != "" {
+		var err error
+		before, err = strconv.ParseInt(beforeString, 10, 64)
+		if err != nil || before <= 0 {
+			return 0, 0, errors.New("before must be a message id")
+		}
+	}
+	if limitString := query.Get("limit"); limitString != "" {
+		var err error
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit must be a positive number")
+		}
+		if limit > historyMaxLimit {
+			limit = historyMaxLimit
+		}
+	}
+
+	return before, limit, nil
+}
+
+// the number of search results if the request has no limit
+const searchDefaultLimit = 20
+
+// the maximum number of search results of a request
+const searchMaxLimit = 100
+
+// searchResult is a message matching a search. The snippet is HTML, the
+// matched words are enclosed in <mark> elements.
+type searchResult struct {
+	ID         int64  `json:"id"`
+	PublicKey  string `json:"publicKey"`
+	Time       int64  `json:"time"`
+	IsIncoming bool   `json:"isIncoming"`
+	IsAction   bool   `json:"isAction"`
+	Snippet    string `json:"snippet"`
+}
+
+type searchResults struct {
+	Results  []searchResult `json:"results"`
+	FullText bool           `json:"full_text"` // false if the results were found without the FTS5 index
+}
+
+// escapes a snippet for HTML and marks the matches
+var snippetReplacer = strings.NewReplacer(persistence.SnippetMatchStart, "<mark>", persistence.SnippetMatchEnd, "</mark>")
+
+// searchMessages returns the messages matching a search of the chat history
+// query  the words to search for
+// opts   the filters and the page of the results
+func searchMessages(query string, opts persistence.SearchOptions) (searchResults, error) {
+	dbResults, err := storage.SearchMessages(query, opts)
 	if err != nil {
-		return "", err
+		return searchResults{}, err
 	}
 
-	friends := make([]friend, len(friend_ids))
-	for i, friend_num := range friend_ids {
-		// TODO: handle errors
-		publicKey, _ := tox.FriendGetPublickey(friend_num)
-		name, _ := tox.FriendGetName(friend_num)
-		connected, _ := tox.FriendGetConnectionStatus(friend_num)
-		userstatus, _ := tox.FriendGetStatus(friend_num)
-		status_msg, _ := tox.FriendGetStatusMessage(friend_num)
-		dbMessages := storage.GetMessages(hex.EncodeToString(publicKey), -1) // TOOD set a limit
-		dbLastMessageRead, _ := storage.GetLastMessageRead(hex.EncodeToString(publicKey))
+	results := searchResults{Results: make([]searchResult, len(dbResults)), FullText: storage.FullTextSearchEnabled()}
+	for i, result := range dbResults {
+		results.Results[i] = searchResult{
+			ID:         result.ID,
+			PublicKey:  result.PublicKey,
+			Time:       result.Time,
+			IsIncoming: result.IsIncoming,
+			IsAction:   result.IsAction,
+			Snippet:    snippetReplacer.Replace(html.EscapeString(result.Snippet)),
+		}
+	}
+
+	return results, nil
+}
+
+// parseSearchQuery returns the parameters of a search of the chat history:
+// ?q=<words>&publicKey=<friend>&from=<ms>&to=<ms>&direction=incoming|outgoing&limit=<n>&offset=<n>.
+// Only q is required.
+// r  the request
+func parseSearchQuery(r *http.Request) (string, persistence.SearchOptions, error) {
+	query := r.URL.Query()
+	opts := persistence.SearchOptions{Limit: searchDefaultLimit}
 
-		var messages []Message
+	words := strings.TrimSpace(query.Get("q"))
+	if words == "" {
+		return "", opts, errors.New("q must not be empty")
+	}
 
-		for _, msg := range dbMessages {
-			messages = append(messages, Message{Message: msg.Message, IsIncoming: msg.IsIncoming, IsAction: msg.IsAction, Time: msg.Time})
+	if publicKey := query.Get("publicKey"); publicKey != "" {
+		publicKeyBytes, err := hex.DecodeString(publicKey)
+		if err != nil || len(publicKeyBytes) != libtox.TOX_PUBLIC_KEY_SIZE {
+			return "", opts, errors.New("publicKey must be a public key")
 		}
+		opts.FriendPublicKey = hex.EncodeToString(publicKeyBytes)
+	}
 
-		if messages == nil {
-			messages = []Message{}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{{"from", &opts.From}, {"to", &opts.To}} {
+		if valueString := query.Get(param.name); valueString != "" {
+			value, err := strconv.ParseInt(valueString, 10, 64)
+			if err != nil || value <= 0 {
+				return "", opts, errors.New(param.name + " must be a time in milliseconds")
+			}
+			*param.value = value
 		}
+	}
+
+	switch query.Get("direction") {
+	case "":
+	case "incoming":
+		isIncoming := true
+		opts.IsIncoming = &isIncoming
+	case "outgoing":
+		isIncoming := false
+		opts.IsIncoming = &isIncoming
+	default:
+		return "", opts, errors.New("direction must be incoming or outgoing")
+	}
 
-		newfriend := friend{
-			Number:          friend_num,
-			PublicKey:       hex.EncodeToString(publicKey),
-			Chat:            messages,
-			LastMessageRead: dbLastMessageRead,
-			Name:            name,
-			Status:          getUserStatusAsString(userstatus),
-			StatusMsg:       string(status_msg),
-			Online:          connected != libtox.TOX_CONNECTION_NONE,
+	if limitString := query.Get("limit"); limitString != "" {
+		limit, err := strconv.Atoi(limitString)
+		if err != nil || limit <= 0 {
+			return "", opts, errors.New("limit must be a positive number")
 		}
+		if limit > searchMaxLimit {
+			limit = searchMaxLimit
+		}
+		opts.Limit = limit
+	}
+	if offsetString := query.Get("offset"); offsetString != "" {
+		offset, err := strconv.Atoi(offsetString)
+		if err != nil || offset < 0 {
+			return "", opts, errors.New("offset must not be negative")
+		}
+		opts.Offset = offset
+	}
+
+	return words, opts, nil
+}
+
+// apiVoicemail is a recording of the voicemail, without its audio
+type apiVoicemail struct {
+	ID        int64  `json:"id"`
+	PublicKey string `json:"publicKey"`
+	Time      int64  `json:"time"`
+	Duration  int64  `json:"duration"`
+}
 
-		friends[i] = newfriend
+// getVoicemails returns the recordings of the voicemail, newest first
+func getVoicemails() []apiVoicemail {
+	voicemails := []apiVoicemail{}
+	for _, dbVoicemail := range storage.GetVoicemails(-1) {
+		voicemails = append(voicemails, apiVoicemail{ID: dbVoicemail.ID, PublicKey: dbVoicemail.PublicKey, Time: dbVoicemail.Time, Duration: dbVoicemail.Duration})
 	}
-	jsonFriends, _ := json.Marshal(friends)
-	return string(jsonFriends), nil
+	return voicemails
 }
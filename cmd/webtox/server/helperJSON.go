@@ -2,10 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+	"io/ioutil"
 	"net/http"
 )
 
+// errCallsUnavailable is returned by the call functions if there is no ToxAV
+// instance
+var errCallsUnavailable = errors.New("Calls are not available")
+
 // rejectWithErrorJSON writes an error encoded as JSON to a http.ResponseWriter
 // w        the http.ResponseWriter
 // code     an error code that identifies the error
@@ -64,6 +71,98 @@ func rejectWithFriendErrorJSON(w http.ResponseWriter, err error) {
 	}
 }
 
+// rejectWithCallErrorJSON writes an error of the call functions encoded as
+// JSON to a http.ResponseWriter
+// w    the http.ResponseWriter
+// err  the error to be encoded
+func rejectWithCallErrorJSON(w http.ResponseWriter, err error) {
+	switch err {
+	case errCallsUnavailable:
+		rejectWithErrorJSON(w, "calls_unavailable", "Calls are not available on this server.")
+	case errUnknownFriend, libtoxav.ErrCallFriendNotFound, libtoxav.ErrAnswerFriendNotFound, libtoxav.ErrCallControlFriendNotFound:
+		rejectWithErrorJSON(w, "invalid_friend", "The friend does not exist.")
+	case libtoxav.ErrCallFriendNotConnected:
+		rejectWithErrorJSON(w, "friend_offline", "Your friend is offline.")
+	case libtoxav.ErrCallFriendAlreadyInCall:
+		rejectWithErrorJSON(w, "already_in_call", "You are already in a call with this friend.")
+	case libtoxav.ErrAnswerFriendNotCalling, libtoxav.ErrCallControlFriendNotInCall:
+		rejectWithErrorJSON(w, "no_call", "There is no call with this friend.")
+	default:
+		rejectWithDefaultErrorJSON(w)
+	}
+}
+
+// writeJSON writes a value encoded as JSON to a http.ResponseWriter
+// w       the http.ResponseWriter
+// status  the HTTP status code of the response
+// v       the value to be encoded
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonV, err := json.Marshal(v)
+	if err != nil {
+		writeErrorJSON(w, http.StatusInternalServerError, "unknown", "An unknown error occoured.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonV)
+}
+
+// writeErrorJSON writes an error encoded as JSON to a http.ResponseWriter. The
+// error has the same schema as the errors of rejectWithErrorJSON.
+// w        the http.ResponseWriter
+// status   the HTTP status code of the response
+// code     an error code that identifies the error
+// message  a message explaining what went wrong (should be human readable)
+func writeErrorJSON(w http.ResponseWriter, status int, code string, message string) {
+	type Err struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	jsonErr, _ := json.Marshal(Err{Code: code, Message: message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(jsonErr)
+}
+
+// readRequestBody reads the body of a request, at most the configured
+// limits.max_request_size bytes. A larger body fails with a
+// *http.MaxBytesError.
+// w  the http.ResponseWriter of the request
+// r  the request
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, getConfig().Limits.MaxRequestSize)
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// decodeRequestJSON decodes the JSON body of a request into v. If the body
+// can not be read or decoded, an error is written to w and false is returned.
+// w  the http.ResponseWriter of the request
+// r  the request
+// v  the value the body is decoded into
+func decodeRequestJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := readRequestBody(w, r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeErrorJSON(w, http.StatusRequestEntityTooLarge, "request_too_large", "The request is too large.")
+		return false
+	} else if err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_request", "The request could not be read.")
+		return false
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		writeErrorJSON(w, http.StatusBadRequest, "invalid_json", "The request is not valid JSON: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
 // createSimpleJSONEvent creates a simple JSON event used in a WS connection
 // name  the name of the type of the event
 func createSimpleJSONEvent(name string) string {
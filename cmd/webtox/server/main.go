@@ -13,6 +13,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -36,46 +37,55 @@ var transfers = make(map[uint32]FileTransfer)
 
 func main() {
 	var newToxInstance bool = false
-	var options *libtox.Options
-	var databasePath string = filepath.Join(CFG_DATA_DIR, "userdata.db")
 
-	var err error
-	storage, err = persistence.Open(databasePath)
+	registerConfigFlags()
+	flag.Parse()
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	currentConfig.Store(config)
+
+	if err = setupLog(config.Log); err != nil {
+		log.Fatal("Could not open the log file: ", err)
+	}
+	if err = os.MkdirAll(config.DataDir, 0700); err != nil {
+		log.Fatal("Could not create the data directory: ", err)
+	}
+	if err = clearUploadDir(); err != nil {
+		log.Fatal("Could not create the upload directory: ", err)
+	}
+
+	storage, err = persistence.Open(config.Database)
 	if err != nil {
 		log.Panic("DB initialisation failed.")
 	}
 	defer storage.Close()
+	if !storage.FullTextSearchEnabled() {
+		log.Println("[WARNING] Built without the sqlite_fts5 tag: searching the chat history scans every message and ranks nothing (\"full_text\": false)")
+	}
 
-	var toxSaveFilepath string
-	flag.StringVar(&toxSaveFilepath, "p", filepath.Join(CFG_DATA_DIR, "webtox_save"), "path to save file")
-	flag.Parse()
-	fmt.Println("ToxData will be saved to", toxSaveFilepath)
+	fmt.Println("ToxData will be saved to", config.SaveFile)
+
+	proxyType, _ := config.Tox.proxyType()
+	options := &libtox.Options{
+		IPv6Enabled:  config.Tox.IPv6Enabled,
+		UDPEnabled:   config.Tox.UDPEnabled,
+		ProxyType:    proxyType,
+		ProxyHost:    config.Tox.Proxy.Host,
+		ProxyPort:    config.Tox.Proxy.Port,
+		StartPort:    config.Tox.StartPort,
+		EndPort:      config.Tox.EndPort,
+		TcpPort:      config.Tox.TCPPort,
+		SaveDataType: libtox.TOX_SAVEDATA_TYPE_NONE,
+		SaveData:     nil}
 
-	savedata, err := loadData(toxSaveFilepath)
+	savedata, err := loadData(config.SaveFile)
 	if err == nil {
-		options = &libtox.Options{
-			IPv6Enabled:  true,
-			UDPEnabled:   true,
-			ProxyType:    libtox.TOX_PROXY_TYPE_NONE,
-			ProxyHost:    "127.0.0.1",
-			ProxyPort:    5555,
-			StartPort:    0,
-			EndPort:      0,
-			TcpPort:      CFG_TCP_PROXY_PORT,
-			SaveDataType: libtox.TOX_SAVEDATA_TYPE_TOX_SAVE,
-			SaveData:     savedata}
+		options.SaveDataType = libtox.TOX_SAVEDATA_TYPE_TOX_SAVE
+		options.SaveData = savedata
 	} else {
-		options = &libtox.Options{
-			IPv6Enabled:  true,
-			UDPEnabled:   true,
-			ProxyType:    libtox.TOX_PROXY_TYPE_NONE,
-			ProxyHost:    "127.0.0.1",
-			ProxyPort:    5555,
-			StartPort:    0,
-			EndPort:      0,
-			TcpPort:      CFG_TCP_PROXY_PORT,
-			SaveDataType: libtox.TOX_SAVEDATA_TYPE_NONE,
-			SaveData:     nil}
 		newToxInstance = true
 	}
 
@@ -122,6 +132,7 @@ func main() {
 	// Register our callbacks
 	tox.CallbackFriendRequest(onFriendRequest)
 	tox.CallbackFriendMessage(onFriendMessage)
+	tox.CallbackFriendReadReceipt(onFriendReadReceipt)
 	tox.CallbackFriendConnectionStatusChanges(onFriendConnectionStatusChanges)
 	tox.CallbackFriendNameChanges(onFriendNameChanges)
 	tox.CallbackFriendStatusMessageChanges(onFriendStatusMessageChanges)
@@ -129,32 +140,51 @@ func main() {
 	tox.CallbackFileRecv(onFileRecv)
 	tox.CallbackFileRecvControl(onFileRecvControl)
 	tox.CallbackFileRecvChunk(onFileRecvChunk)
+	tox.CallbackFileChunkRequest(onFileChunkRequest)
+	tox.CallbackConferenceInvite(onConferenceInvite)
+	tox.CallbackConferenceMessage(onConferenceMessage)
+	tox.CallbackConferenceConnected(onConferenceConnected)
+	tox.CallbackConferenceTitle(onConferenceTitle)
+	tox.CallbackConferencePeerName(onConferencePeerName)
+	tox.CallbackConferencePeerListChanged(onConferencePeerListChanged)
 
-	// Connect to the network
-	// TODO add more servers (as fallback)
-	pubkey, _ := hex.DecodeString("E20ABCF38CDBFFD7D04B29C956B33F7B27A3BB7AF0618101617B036E4AEA402D")
-	err = tox.Bootstrap("3.0.24.15", 33445, pubkey)
-	if err != nil {
+	// Keep the state of all friends in memory, so the contact list can be
+	// served without querying toxcore for every friend
+	if err = tox.EnableRosterCache(); err != nil {
 		panic(err)
 	}
 
+	// Answer calls with the voicemail
+	if err = startAV(); err != nil {
+		log.Println("[ERROR] Calls are not available:", err)
+	}
+
+	// Connect to the network
+	bootstrap()
+
 	// Start the server
 	go serveGUI()
 
 	// Main loop
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	ticker := time.NewTicker(25 * time.Millisecond)
 
 	for {
 		select {
+		case <-hup:
+			reloadConfig()
+
 		case <-c:
 			fmt.Printf("\nSaving...\n")
-			if err := saveData(tox, toxSaveFilepath); err != nil {
+			if err := saveData(tox, getConfig().SaveFile); err != nil {
 				fmt.Println(err)
 			}
 
 			fmt.Println("Killing")
+			stopAV()
 			tox.Kill()
 			return
 
@@ -189,17 +219,39 @@ func serveGUI() {
 		panic("GUI authentication salt could not be determined.")
 	}
 
+	config := getConfig()
 	authOptions = httpserve.NewAuthOptions(user, pass, salt)
 	mux := http.NewServeMux()
 
 	// paths that require authentication
 	mux.Handle("/events", httpserve.BasicAuthHandler(handleWS, authOptions))
+	mux.Handle("/calls/audio", httpserve.BasicAuthHandler(handleCallAudio, authOptions))
+	mux.Handle("/calls/video", httpserve.BasicAuthHandler(handleCallVideo, authOptions))
+	mux.Handle("/calls/snapshot", httpserve.BasicAuthHandler(handleCallSnapshot, authOptions))
+	mux.Handle("/api/v2/", httpserve.BasicAuthHandler(handleAPIv2, authOptions))
 	mux.Handle("/api/", httpserve.BasicAuthHandler(handleAPI, authOptions))
-	mux.Handle("/", httpserve.BasicAuthHandler(http.FileServer(http.Dir(CFG_HTML_DIR)), authOptions))
+	mux.Handle("/", httpserve.BasicAuthHandler(http.FileServer(http.Dir(config.HTMLDir)), authOptions))
 
 	// paths that *do not* require authentication
-	mux.Handle("/img/", http.StripPrefix("/img/", http.FileServer(http.Dir(CFG_IMG_DIR))))
+	mux.Handle("/img/", http.StripPrefix("/img/", http.FileServer(http.Dir(filepath.Join(config.HTMLDir, "img")))))
 
-	httpserve.CreateCertificateIfNotExist(CFG_DATA_DIR+CFG_CERT_PREFIX+"cert.pem", CFG_DATA_DIR+CFG_CERT_PREFIX+"key.pem", "localhost", 3072)
-	err = httpserve.ListenAndUpgradeTLS(":8080", CFG_DATA_DIR+CFG_CERT_PREFIX+"cert.pem", CFG_DATA_DIR+CFG_CERT_PREFIX+"key.pem", mux)
+	httpserve.CreateCertificateIfNotExist(config.TLS.CertFile, config.TLS.KeyFile, config.TLS.CommonName, 3072)
+	for _, addr := range config.Listen {
+		go func(addr string) {
+			log.Println("Serving the GUI on", addr)
+			if err := httpserve.ListenAndUpgradeTLS(addr, config.TLS.CertFile, config.TLS.KeyFile, mux); err != nil {
+				log.Println("[ERROR] Could not serve the GUI on", addr+":", err)
+			}
+		}(addr)
+	}
+}
+
+// bootstrap connects to the configured bootstrap nodes
+func bootstrap() {
+	for _, node := range getConfig().Bootstrap {
+		pubkey, _ := hex.DecodeString(node.PublicKey)
+		if err := tox.Bootstrap(node.Address, node.Port, pubkey); err != nil {
+			log.Println("[ERROR] Bootstrap from", node.Address, "failed:", err)
+		}
+	}
 }
@@ -0,0 +1,165 @@
+//go:build toxsim
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"golang.org/x/net/websocket"
+)
+
+// testFriend is the only friend of the webtox instance under test, both on
+// their own simulated network
+type testFriend struct {
+	net       *libtox.Network
+	tox       *libtox.Tox
+	publicKey string // the public key of the friend as hex string
+	number    uint32 // the friend number of the friend in webtox
+	timers    *simTimers
+}
+
+// simTimer is an outbox retry waiting for the simulated clock
+type simTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (s *simTimer) Stop() bool {
+	stopped := s.stopped
+	s.stopped = true
+	return !stopped
+}
+
+// simTimers runs the outbox retries on the clock of a simulated network
+type simTimers struct {
+	net     *libtox.Network
+	pending []*simTimer
+}
+
+func (s *simTimers) afterFunc(d time.Duration, f func()) outboxTimer {
+	timer := &simTimer{at: s.net.Now().Add(d), f: f}
+	s.pending = append(s.pending, timer)
+	return timer
+}
+
+// advance moves the simulated clock forward by d and runs the retries that
+// became due, in the order they are due
+func (f *testFriend) advance(d time.Duration) {
+	f.net.Advance(d)
+	now := f.net.Now()
+
+	for {
+		sort.SliceStable(f.timers.pending, func(i, j int) bool { return f.timers.pending[i].at.Before(f.timers.pending[j].at) })
+		if len(f.timers.pending) == 0 || f.timers.pending[0].at.After(now) {
+			return
+		}
+
+		timer := f.timers.pending[0]
+		f.timers.pending = f.timers.pending[1:]
+		if timer.Stop() {
+			timer.f()
+			f.net.Settle(10)
+		}
+	}
+}
+
+// newTestServer sets up webtox with a temporary data directory, a new
+// database and a Tox instance with one friend who is online
+func newTestServer(t *testing.T) *testFriend {
+	t.Helper()
+	useTestDataDir(t)
+
+	var err error
+	storage, err = persistence.Open(filepath.Join(getConfig().DataDir, "userdata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Close)
+
+	downloads = make(map[string]*download)
+	outboxes = make(map[string]*outbox)
+	transfers = make(map[uint32]FileTransfer)
+
+	n := libtox.NewNetwork(1)
+	timers := &simTimers{net: n}
+	outboxAfterFunc = timers.afterFunc
+	if tox, err = n.New(nil); err != nil {
+		t.Fatal(err)
+	}
+	friend, err := n.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		tox.Kill()
+		friend.Kill()
+	})
+
+	for _, instance := range []*libtox.Tox{tox, friend} {
+		if err := instance.Bootstrap("127.0.0.1", 33445, make([]byte, libtox.TOX_PUBLIC_KEY_SIZE)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publicKey, _ := tox.SelfGetPublicKey()
+	friendPublicKey, _ := friend.SelfGetPublicKey()
+	number, err := tox.FriendAddNorequest(friendPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := friend.FriendAddNorequest(publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tox.CallbackFriendReadReceipt(onFriendReadReceipt)
+	tox.CallbackFriendConnectionStatusChanges(onFriendConnectionStatusChanges)
+	tox.CallbackFileRecv(onFileRecv)
+	tox.CallbackFileRecvControl(onFileRecvControl)
+	tox.CallbackFileRecvChunk(onFileRecvChunk)
+	n.Settle(10)
+
+	return &testFriend{net: n, tox: friend, publicKey: hex.EncodeToString(friendPublicKey), number: number, timers: timers}
+}
+
+// listenEvents registers a client that receives the broadcast events without
+// a websocket connection
+func listenEvents(t *testing.T) chan string {
+	t.Helper()
+
+	conn := new(websocket.Conn)
+	events := make(chan string, clientQueueSize)
+	activeConnectionsMtx.Lock()
+	activeConnections[conn] = events
+	activeConnectionsMtx.Unlock()
+
+	t.Cleanup(func() {
+		activeConnectionsMtx.Lock()
+		delete(activeConnections, conn)
+		activeConnectionsMtx.Unlock()
+	})
+	return events
+}
+
+// receivedEvents returns the types of the events that are waiting in events
+func receivedEvents(events chan string) []string {
+	var types []string
+	for {
+		select {
+		case msg := <-events:
+			var e struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(msg), &e)
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
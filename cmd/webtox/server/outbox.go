@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+// Messages to friends are stored before they are sent, with the status
+// persistence.MessageQueued. The outbox of a friend hands them to toxcore in
+// order when the friend is online. If toxcore refuses a message for a reason
+// that may pass, e.g. a full send queue, the outbox tries again later, waiting
+// twice as long after every attempt.
+//
+// toxcore drops the messages it has not delivered when the friend goes
+// offline. The sent messages without a read receipt are therefore queued
+// again at that moment and sent once more, in their order, when the friend
+// comes back.
+//
+// The read receipts of toxcore carry the Tox message ID, which toxcore counts
+// per friend from the start and reuses after a restart. Receipts are therefore
+// only matched with the messages sent since webtox started; messages sent
+// before stay MessageSent.
+
+// the delay before the first retry of a refused message
+const outboxRetryDelay = time.Second
+
+// the number of attempts to send a message before it fails
+const outboxMaxAttempts = 6
+
+// outboxTimer is a pending retry of an outbox
+type outboxTimer interface {
+	Stop() bool
+}
+
+// outboxAfterFunc calls f once the duration d has passed. The tests replace it
+// to run the retries on the clock of the simulated network.
+var outboxAfterFunc = func(d time.Duration, f func()) outboxTimer {
+	return time.AfterFunc(d, f)
+}
+
+// outbox is the state of sending the queued messages to a friend. mtx is held
+// while messages are handed to toxcore, so a message is never sent twice.
+type outbox struct {
+	mtx      sync.Mutex
+	attempts int         // the failed attempts to send the oldest queued message
+	retry    outboxTimer // set while waiting for a retry
+
+	// the ids of the sent messages waiting for a read receipt, by Tox message
+	// ID
+	sent map[uint32]int64
+}
+
+var outboxesMtx sync.Mutex
+
+// Map of the outboxes, by the public key of the friend
+var outboxes = make(map[string]*outbox)
+
+// outboxOf returns the outbox of a friend
+// publicKey  the public key of the friend
+func outboxOf(publicKey string) *outbox {
+	outboxesMtx.Lock()
+	defer outboxesMtx.Unlock()
+
+	o, ok := outboxes[publicKey]
+	if !ok {
+		o = &outbox{sent: make(map[uint32]int64)}
+		outboxes[publicKey] = o
+	}
+	return o
+}
+
+// queueMessage stores a message to a friend and sends it if the friend is
+// online. It returns the stored message with its current status.
+// friendnumber  the friend
+// publicKey     the public key of the friend
+// isAction      specifies if the message is an action or not
+// message       the message
+func queueMessage(friendnumber uint32, publicKey string, isAction bool, message string) (persistence.Message, error) {
+	if len(message) > libtox.TOX_MAX_MESSAGE_LENGTH {
+		return persistence.Message{}, errInvalidMessage
+	}
+
+	msg, err := storage.QueueMessage(publicKey, isAction, message)
+	if err != nil {
+		return persistence.Message{}, err
+	}
+	storage.SetLastMessageRead(publicKey)
+	broadcastToClients(createSimpleJSONEvent("friendlist_update"))
+
+	flushOutbox(friendnumber, publicKey)
+
+	if sent, err := storage.GetMessage(publicKey, msg.ID); err == nil {
+		msg = sent
+	}
+	return msg, nil
+}
+
+// flushOutbox sends the queued messages to a friend, oldest first, until the
+// friend is offline or toxcore refuses a message
+// friendnumber  the friend
+// publicKey     the public key of the friend
+func flushOutbox(friendnumber uint32, publicKey string) {
+	o := outboxOf(publicKey)
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if o.retry != nil {
+		// the messages wait for the retry to keep their order
+		return
+	}
+
+	for _, msg := range storage.GetQueuedMessages(publicKey) {
+		messageType := libtox.TOX_MESSAGE_TYPE_NORMAL
+		if msg.IsAction {
+			messageType = libtox.TOX_MESSAGE_TYPE_ACTION
+		}
+
+		messageID, err := tox.FriendSendMessage(friendnumber, messageType, []byte(msg.Message))
+		switch err {
+		case nil:
+			o.attempts = 0
+			if sent, err := storage.MarkMessageSent(msg.ID, messageID); err == nil && sent {
+				o.sent[messageID] = msg.ID
+				broadcastMessageStatusEvent(publicKey, friendnumber, msg.ID, persistence.MessageSent)
+			}
+			continue
+
+		case libtox.ErrFriendSendMessageFriendNotConnected:
+			// sent when the friend comes online
+			return
+
+		case libtox.ErrFriendSendMessageFriendNotFound, libtox.ErrFriendSendMessageTooLong, libtox.ErrArgs:
+			o.attempts = 0
+			setMessageStatus(publicKey, friendnumber, msg.ID, persistence.MessageQueued, persistence.MessageFailed)
+			continue
+		}
+
+		o.attempts++
+		if o.attempts >= outboxMaxAttempts {
+			log.Printf("[flushOutbox] Sending message %d failed: %v\n", msg.ID, err)
+			o.attempts = 0
+			setMessageStatus(publicKey, friendnumber, msg.ID, persistence.MessageQueued, persistence.MessageFailed)
+			continue
+		}
+
+		delay := outboxRetryDelay << (o.attempts - 1)
+		o.retry = outboxAfterFunc(delay, func() {
+			o.mtx.Lock()
+			o.retry = nil
+			o.mtx.Unlock()
+
+			if friendnumber, err := friendNumberOfPublicKey(publicKey); err == nil {
+				flushOutbox(friendnumber, publicKey)
+			}
+		})
+		return
+	}
+}
+
+// onOutboxFriendConnection sends the queued messages to a friend who came
+// online. Messages refused before are tried again at once. When the friend
+// goes offline, the sent messages without a read receipt are queued again.
+// friendnumber  the friend
+// online        whether the friend is online
+func onOutboxFriendConnection(friendnumber uint32, online bool) {
+	publicKey := publicKeyOfFriend(friendnumber)
+	if publicKey == "" {
+		return
+	}
+
+	o := outboxOf(publicKey)
+	o.mtx.Lock()
+	if o.retry != nil {
+		o.retry.Stop()
+		o.retry = nil
+	}
+	o.attempts = 0
+
+	if !online {
+		for _, id := range o.sent {
+			setMessageStatus(publicKey, friendnumber, id, persistence.MessageSent, persistence.MessageQueued)
+		}
+		o.sent = make(map[uint32]int64)
+		o.mtx.Unlock()
+		return
+	}
+	o.mtx.Unlock()
+
+	flushOutbox(friendnumber, publicKey)
+}
+
+// failFriendOutbox marks the queued messages to a deleted friend as failed
+// publicKey  the public key of the friend
+func failFriendOutbox(publicKey string) {
+	o := outboxOf(publicKey)
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if o.retry != nil {
+		o.retry.Stop()
+		o.retry = nil
+	}
+	o.attempts = 0
+	o.sent = make(map[uint32]int64)
+
+	for _, msg := range storage.GetQueuedMessages(publicKey) {
+		storage.UpdateMessageStatus(msg.ID, persistence.MessageQueued, persistence.MessageFailed)
+	}
+}
+
+// onOutboxReadReceipt marks a sent message as delivered
+// friendnumber  the friend
+// messageID     the Tox message ID of the message
+func onOutboxReadReceipt(friendnumber uint32, messageID uint32) {
+	publicKey := publicKeyOfFriend(friendnumber)
+	if publicKey == "" {
+		return
+	}
+
+	o := outboxOf(publicKey)
+	o.mtx.Lock()
+	id, ok := o.sent[messageID]
+	delete(o.sent, messageID)
+	o.mtx.Unlock()
+
+	if !ok {
+		// sent before webtox started, or a repeated receipt
+		log.Printf("[onOutboxReadReceipt] Ignoring receipt for unknown message %d of friend %d\n", messageID, friendnumber)
+		return
+	}
+
+	delivered, err := storage.MarkMessageDelivered(id)
+	if err != nil || delivered == 0 {
+		return
+	}
+
+	broadcastMessageDeliveredEvent(publicKey, friendnumber, id, delivered)
+}
+
+// setMessageStatus changes the status of a sent message and tells the clients
+// publicKey     the public key of the friend
+// friendnumber  the friend
+// id            the id of the message
+// from          the expected status of the message
+// to            the new status
+func setMessageStatus(publicKey string, friendnumber uint32, id int64, from string, to string) {
+	if changed, err := storage.UpdateMessageStatus(id, from, to); err != nil || !changed {
+		return
+	}
+
+	broadcastMessageStatusEvent(publicKey, friendnumber, id, to)
+}
+
+// broadcastMessageStatusEvent sends the status of a sent message to the
+// clients
+// publicKey     the public key of the friend
+// friendnumber  the friend
+// id            the id of the message
+// status        the new status
+func broadcastMessageStatusEvent(publicKey string, friendnumber uint32, id int64, status string) {
+	type jsonEvent struct {
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		ID        int64  `json:"id"`
+		Status    string `json:"status"`
+	}
+
+	e, _ := json.Marshal(jsonEvent{
+		Type:      "message_status",
+		PublicKey: publicKey,
+		Number:    friendnumber,
+		ID:        id,
+		Status:    status,
+	})
+
+	broadcastToClients(string(e))
+}
+
+// broadcastMessageDeliveredEvent tells the clients that a friend confirmed a
+// sent message
+// publicKey     the public key of the friend
+// friendnumber  the friend
+// id            the id of the message
+// delivered     the time the message was delivered
+func broadcastMessageDeliveredEvent(publicKey string, friendnumber uint32, id int64, delivered int64) {
+	type jsonEvent struct {
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		ID        int64  `json:"id"`
+		Delivered int64  `json:"delivered"`
+	}
+
+	e, _ := json.Marshal(jsonEvent{
+		Type:      "message_delivered",
+		PublicKey: publicKey,
+		Number:    friendnumber,
+		ID:        id,
+		Delivered: delivered,
+	})
+
+	broadcastToClients(string(e))
+}
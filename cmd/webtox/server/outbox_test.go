@@ -0,0 +1,148 @@
+//go:build toxsim
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+func TestOutbox(t *testing.T) {
+	messages := []string{"one", "two", "three"}
+
+	tests := []struct {
+		name       string
+		fault      func(f *testFriend) // before the messages are queued
+		recover    func(f *testFriend) // after the messages are queued
+		wantQueued string              // the status of the messages after queueing
+	}{
+		{
+			name:       "online",
+			wantQueued: persistence.MessageSent,
+		},
+		{
+			name:       "offline",
+			fault:      func(f *testFriend) { f.net.SetOnline(f.tox, false) },
+			recover:    func(f *testFriend) { f.net.SetOnline(f.tox, true) },
+			wantQueued: persistence.MessageQueued,
+		},
+		{
+			name:       "send queue full",
+			fault:      func(f *testFriend) { f.net.FailSendq(tox, 1) },
+			recover:    func(f *testFriend) { f.advance(outboxRetryDelay) },
+			wantQueued: persistence.MessageQueued,
+		},
+		{
+			name:  "lost before the receipt",
+			fault: func(f *testFriend) { f.net.SetDropRate(1) },
+			recover: func(f *testFriend) {
+				f.net.SetOnline(f.tox, false)
+				f.net.Settle(10)
+				f.net.SetDropRate(0)
+				f.net.SetOnline(f.tox, true)
+			},
+			wantQueued: persistence.MessageSent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestServer(t)
+
+			var received []string
+			f.tox.CallbackFriendMessage(func(_ *libtox.Tox, friendnumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
+				received = append(received, string(message))
+			})
+
+			if tt.fault != nil {
+				tt.fault(f)
+				f.net.Settle(10)
+			}
+
+			var ids []int64
+			for _, message := range messages {
+				msg, err := queueMessage(f.number, f.publicKey, false, message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.Status != tt.wantQueued {
+					t.Fatalf("message %q is %s, want %s", message, msg.Status, tt.wantQueued)
+				}
+				ids = append(ids, msg.ID)
+			}
+			f.net.Settle(10)
+
+			if tt.recover != nil {
+				tt.recover(f)
+				f.net.Settle(10)
+			}
+
+			if len(received) != len(messages) {
+				t.Fatalf("friend received %q, want %q", received, messages)
+			}
+			for i := range messages {
+				if received[i] != messages[i] {
+					t.Fatalf("friend received %q, want %q", received, messages)
+				}
+
+				msg, err := storage.GetMessage(f.publicKey, ids[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.Status != persistence.MessageDelivered || msg.Delivered == 0 {
+					t.Errorf("message %q is %s, delivered at %d, want %s", msg.Message, msg.Status, msg.Delivered, persistence.MessageDelivered)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	f := newTestServer(t)
+	f.net.FailSendq(tox, 3)
+
+	msg, err := queueMessage(f.number, f.publicKey, false, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the delay doubles after every refused attempt
+	steps := []struct {
+		advance time.Duration
+		want    string
+	}{
+		{outboxRetryDelay - time.Millisecond, persistence.MessageQueued},
+		{time.Millisecond, persistence.MessageQueued},
+		{2*outboxRetryDelay - time.Millisecond, persistence.MessageQueued},
+		{time.Millisecond, persistence.MessageQueued},
+		{4*outboxRetryDelay - time.Millisecond, persistence.MessageQueued},
+		{time.Millisecond, persistence.MessageDelivered},
+	}
+
+	for i, step := range steps {
+		f.advance(step.advance)
+
+		if msg, _ = storage.GetMessage(f.publicKey, msg.ID); msg.Status != step.want {
+			t.Fatalf("step %d: message is %s, want %s", i, msg.Status, step.want)
+		}
+	}
+}
+
+func TestOutboxUnknownReceipt(t *testing.T) {
+	f := newTestServer(t)
+
+	msg, err := queueMessage(f.number, f.publicKey, false, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a receipt of a message sent before webtox started
+	onOutboxReadReceipt(f.number, 1000)
+
+	if msg, _ = storage.GetMessage(f.publicKey, msg.ID); msg.Status != persistence.MessageSent {
+		t.Fatalf("message is %s, want %s", msg.Status, persistence.MessageSent)
+	}
+}
@@ -0,0 +1,178 @@
+package persistence
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var ConferenceInviteNotFound = errors.New("Conference invite does not exist")
+
+// ConferenceMessage is a message of a conference. Conferences are identified
+// by their conference ID, their number changes when webtox restarts.
+type ConferenceMessage struct {
+	ID            int64
+	PeerPublicKey string // the public key of the sender
+	PeerName      string // the name of the sender when the message was sent
+	Message       string
+	IsIncoming    bool
+	IsAction      bool
+	Time          int64
+}
+
+// ConferenceInvite is an invite of a friend to a conference that was neither
+// accepted nor rejected
+type ConferenceInvite struct {
+	ID        int64
+	PublicKey string // the public key of the friend
+	Cookie    []byte // the data needed to join the conference
+	Time      int64
+}
+
+// StoreConferenceMessage stores a message of a conference and returns its id
+// and time
+// conferenceID   the ID of the conference as hex string
+// peerPublicKey  the public key of the sender
+// peerName       the name of the sender
+// isIncoming     specifies if the message is received (true) or sent (false)
+// isAction       specifies if the message is an action or not
+// message        the message
+func (s *StorageConn) StoreConferenceMessage(conferenceID string, peerPublicKey string, peerName string, isIncoming bool, isAction bool, message string) (int64, int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now().Unix() * 1000
+	result, err := s.db.Exec(`INSERT INTO conference_messages(conference, peerPublicKey, peerName, isIncoming, isAction, time, message) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		conferenceID, peerPublicKey, peerName, isIncoming, isAction, now, message)
+	if err != nil {
+		log.Print("[persistence StoreConferenceMessage] INSERT statement failed")
+		return 0, 0, err
+	}
+	id, err := result.LastInsertId()
+	return id, now, err
+}
+
+// GetConferenceMessagesBefore returns a page of the stored messages of a
+// conference, newest first
+// conferenceID  the ID of the conference as hex string
+// beforeID      only older messages are returned, 0 for the newest ones
+// limit         the maximum number of messages that should be returned
+func (s *StorageConn) GetConferenceMessagesBefore(conferenceID string, beforeID int64, limit int) []ConferenceMessage {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var rows *sql.Rows
+	var err error
+	if beforeID > 0 {
+		rows, err = s.db.Query("SELECT id, peerPublicKey, peerName, isAction, isIncoming, time, message FROM conference_messages WHERE conference = ? AND id < ? ORDER BY id DESC LIMIT ?", conferenceID, beforeID, limit)
+	} else {
+		rows, err = s.db.Query("SELECT id, peerPublicKey, peerName, isAction, isIncoming, time, message FROM conference_messages WHERE conference = ? ORDER BY id DESC LIMIT ?", conferenceID, limit)
+	}
+	if err != nil {
+		log.Print("[persistence GetConferenceMessagesBefore] SELECT statement failed")
+		return nil
+	}
+	defer rows.Close()
+
+	var messages []ConferenceMessage
+
+	for rows.Next() {
+		var msg ConferenceMessage
+		rows.Scan(&msg.ID, &msg.PeerPublicKey, &msg.PeerName, &msg.IsAction, &msg.IsIncoming, &msg.Time, &msg.Message)
+		messages = append(messages, msg)
+	}
+
+	return messages
+}
+
+// DeleteConferenceMessages deletes all stored messages of a conference
+// conferenceID  the ID of the conference as hex string
+func (s *StorageConn) DeleteConferenceMessages(conferenceID string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM conference_messages WHERE conference = ?`, conferenceID)
+	if err != nil {
+		log.Print("[persistence DeleteConferenceMessages] DELETE statement failed")
+		return err
+	}
+	return nil
+}
+
+// StoreConferenceInvite stores an invite to a conference and returns its id. A
+// repeated invite replaces the stored one.
+// friendPublicKey  the publicKey of the friend who sent the invite
+// cookie           the data needed to join the conference
+func (s *StorageConn) StoreConferenceInvite(friendPublicKey string, cookie []byte) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendID, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.db.Exec(`INSERT OR REPLACE INTO conference_invites(friend, cookie, time) VALUES(?, ?, ?)`, friendID, cookie, time.Now().Unix()*1000)
+	if err != nil {
+		log.Print("[persistence StoreConferenceInvite] INSERT statement failed")
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetConferenceInvites returns the stored invites to conferences, the newest
+// first
+func (s *StorageConn) GetConferenceInvites() []ConferenceInvite {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.Query("SELECT i.id, f.publicKey, i.cookie, i.time FROM conference_invites i JOIN friends f ON i.friend = f.id ORDER BY i.id DESC")
+	if err != nil {
+		log.Print("[persistence GetConferenceInvites] SELECT statement failed")
+		return nil
+	}
+	defer rows.Close()
+
+	var invites []ConferenceInvite
+
+	for rows.Next() {
+		var invite ConferenceInvite
+		rows.Scan(&invite.ID, &invite.PublicKey, &invite.Cookie, &invite.Time)
+		invites = append(invites, invite)
+	}
+
+	return invites
+}
+
+// GetConferenceInvite returns a stored invite to a conference
+// id  the id of the invite
+func (s *StorageConn) GetConferenceInvite(id int64) (ConferenceInvite, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var invite ConferenceInvite
+	err := s.db.QueryRow("SELECT i.id, f.publicKey, i.cookie, i.time FROM conference_invites i JOIN friends f ON i.friend = f.id WHERE i.id = ?", id).
+		Scan(&invite.ID, &invite.PublicKey, &invite.Cookie, &invite.Time)
+	if err != nil {
+		return ConferenceInvite{}, ConferenceInviteNotFound
+	}
+	return invite, nil
+}
+
+// DeleteConferenceInvite deletes a stored invite to a conference
+// id  the id of the invite
+func (s *StorageConn) DeleteConferenceInvite(id int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result, err := s.db.Exec(`DELETE FROM conference_invites WHERE id = ?`, id)
+	if err != nil {
+		log.Print("[persistence DeleteConferenceInvite] DELETE statement failed")
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ConferenceInviteNotFound
+	}
+	return nil
+}
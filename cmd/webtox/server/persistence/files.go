@@ -0,0 +1,104 @@
+package persistence
+
+import (
+	"errors"
+	"log"
+)
+
+var ReceivedFileNotFound = errors.New("Received file does not exist")
+
+// ReceivedFile is an entry of the history of the files sent by friends
+type ReceivedFile struct {
+	ID        string // the id of the transfer in the API and the events
+	PublicKey string
+	Name      string // the file name sent by the friend
+	Path      string // where the file is stored, relative to the download directory; empty if it was not received
+	Size      uint64
+	Hash      string // the SHA-256 of the file as hex, empty if it was not received
+	Status    string // complete, rejected, cancelled or failed
+	Time      int64  // when the friend offered the file, in ms
+}
+
+// StoreReceivedFile adds a finished file transfer to the history
+// file  the file, identified by its ID
+func (s *StorageConn) StoreReceivedFile(file ReceivedFile) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendID, err := s.getFriendDbId(file.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO received_files(id, friend, name, path, size, hash, status, time) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		file.ID, friendID, file.Name, file.Path, file.Size, file.Hash, file.Status, file.Time)
+	if err != nil {
+		log.Print("[persistence StoreReceivedFile] INSERT statement failed")
+		return err
+	}
+	return nil
+}
+
+// GetReceivedFiles returns the history of the received files, the newest
+// first
+// friendPublicKey  only return the files of this friend, if set
+// limit            the number of files that should be returned. Set limit to
+//
+//	-1 to get all files
+func (s *StorageConn) GetReceivedFiles(friendPublicKey string, limit int) []ReceivedFile {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.Query(`SELECT r.id, f.publicKey, r.name, r.path, r.size, r.hash, r.status, r.time
+		FROM received_files r JOIN friends f ON r.friend = f.id
+		WHERE ? = '' OR f.publicKey LIKE ? ORDER BY r.time DESC, r.rowid DESC LIMIT ?`, friendPublicKey, friendPublicKey, limit)
+	if err != nil {
+		log.Print("[persistence GetReceivedFiles] SELECT statement failed")
+		return nil
+	}
+	defer rows.Close()
+
+	var files []ReceivedFile
+
+	for rows.Next() {
+		var file ReceivedFile
+		rows.Scan(&file.ID, &file.PublicKey, &file.Name, &file.Path, &file.Size, &file.Hash, &file.Status, &file.Time)
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// GetReceivedFile returns an entry of the history of the received files
+// id  the id of the transfer
+func (s *StorageConn) GetReceivedFile(id string) (ReceivedFile, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var file ReceivedFile
+	err := s.db.QueryRow(`SELECT r.id, f.publicKey, r.name, r.path, r.size, r.hash, r.status, r.time
+		FROM received_files r JOIN friends f ON r.friend = f.id WHERE r.id = ?`, id).
+		Scan(&file.ID, &file.PublicKey, &file.Name, &file.Path, &file.Size, &file.Hash, &file.Status, &file.Time)
+	if err != nil {
+		return ReceivedFile{}, ReceivedFileNotFound
+	}
+	return file, nil
+}
+
+// DeleteReceivedFile deletes an entry of the history of the received files.
+// The file itself is not deleted.
+// id  the id of the transfer
+func (s *StorageConn) DeleteReceivedFile(id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result, err := s.db.Exec(`DELETE FROM received_files WHERE id = ?`, id)
+	if err != nil {
+		log.Print("[persistence DeleteReceivedFile] DELETE statement failed")
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ReceivedFileNotFound
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package persistence
+
+import (
+	"log"
+	"time"
+)
+
+// The status of a sent message:
+//
+//	queued     stored, waiting until it can be handed to toxcore
+//	sent       handed to toxcore
+//	delivered  the friend confirmed the message
+//	failed     toxcore refused the message
+const (
+	MessageQueued    = "queued"
+	MessageSent      = "sent"
+	MessageDelivered = "delivered"
+	MessageFailed    = "failed"
+)
+
+// upgradeMessages adds the columns of sent messages to the messages table of
+// databases created before they existed. The messages sent until then are
+// considered sent.
+func (s *StorageConn) upgradeMessages() error {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info('messages')")
+	if err != nil {
+		log.Print("[persistence upgradeMessages] SELECT statement failed")
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		columns[name] = true
+	}
+	rows.Close()
+
+	if !columns["status"] {
+		if _, err := s.db.Exec(`ALTER TABLE messages ADD COLUMN status TEXT NOT NULL DEFAULT ''`); err != nil {
+			log.Print("[persistence upgradeMessages] ALTER TABLE statement failed")
+			return err
+		}
+		if _, err := s.db.Exec(`UPDATE messages SET status = ? WHERE isIncoming = 0`, MessageSent); err != nil {
+			log.Print("[persistence upgradeMessages] UPDATE statement failed")
+			return err
+		}
+	}
+	if !columns["messageID"] {
+		if _, err := s.db.Exec(`ALTER TABLE messages ADD COLUMN messageID INTEGER`); err != nil {
+			log.Print("[persistence upgradeMessages] ALTER TABLE statement failed")
+			return err
+		}
+	}
+	if !columns["delivered"] {
+		if _, err := s.db.Exec(`ALTER TABLE messages ADD COLUMN delivered INTEGER NOT NULL DEFAULT 0`); err != nil {
+			log.Print("[persistence upgradeMessages] ALTER TABLE statement failed")
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueMessage stores a message to a friend with the status MessageQueued
+// and returns it
+// friendPublicKey  the publicKey of the friend
+// isAction         specifies if the message is an action or not
+// message          the message
+func (s *StorageConn) QueueMessage(friendPublicKey string, isAction bool, message string) (Message, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendID, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Message: message, IsAction: isAction, Time: time.Now().Unix() * 1000, Status: MessageQueued}
+	result, err := s.db.Exec(`INSERT INTO messages(friend, isIncoming, isAction, time, message, status) VALUES(?, 0, ?, ?, ?, ?)`, friendID, isAction, msg.Time, message, msg.Status)
+	if err != nil {
+		log.Print("[persistence QueueMessage] INSERT statement failed")
+		return Message{}, err
+	}
+	msg.ID, err = result.LastInsertId()
+	return msg, err
+}
+
+// GetQueuedMessages returns the messages to a friend with the status
+// MessageQueued, oldest first
+// friendPublicKey  the publicKey of the friend
+func (s *StorageConn) GetQueuedMessages(friendPublicKey string) []Message {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendId, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		log.Print("[persistence GetQueuedMessages] getFriendDbId failed")
+		return nil
+	}
+
+	rows, err := s.db.Query("SELECT id, isAction, isIncoming, time, message, status, delivered FROM messages WHERE friend = ? AND status = ? ORDER BY id", friendId, MessageQueued)
+	if err != nil {
+		log.Print("[persistence GetQueuedMessages] SELECT statement failed")
+		return nil
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// UpdateMessageStatus changes the status of a sent message if it has the
+// expected status. It returns false if the message has another status, e.g.
+// because it was already sent.
+// id    the id of the message
+// from  the expected status
+// to    the new status
+func (s *StorageConn) UpdateMessageStatus(id int64, from string, to string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result, err := s.db.Exec(`UPDATE messages SET status = ? WHERE id = ? AND status = ?`, to, id, from)
+	if err != nil {
+		log.Print("[persistence UpdateMessageStatus] UPDATE statement failed")
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// MarkMessageSent changes the status of a queued message to MessageSent and
+// stores the message ID toxcore returned for it. It returns false if the
+// message is not queued.
+// id         the id of the message
+// messageID  the Tox message ID
+func (s *StorageConn) MarkMessageSent(id int64, messageID uint32) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result, err := s.db.Exec(`UPDATE messages SET status = ?, messageID = ? WHERE id = ? AND status = ?`, MessageSent, messageID, id, MessageQueued)
+	if err != nil {
+		log.Print("[persistence MarkMessageSent] UPDATE statement failed")
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// MarkMessageDelivered changes the status of a sent message to
+// MessageDelivered and returns the time it was delivered. It returns 0 if the
+// message is not sent, e.g. because it was already delivered.
+// id  the id of the message
+func (s *StorageConn) MarkMessageDelivered(id int64) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now().Unix() * 1000
+	result, err := s.db.Exec(`UPDATE messages SET status = ?, delivered = ? WHERE id = ? AND status = ?`, MessageDelivered, now, id, MessageSent)
+	if err != nil {
+		log.Print("[persistence MarkMessageDelivered] UPDATE statement failed")
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+	return now, nil
+}
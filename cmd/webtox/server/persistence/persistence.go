@@ -10,19 +10,25 @@ import (
 )
 
 var (
-	KeyNotFound = errors.New("Key does not exist")
+	KeyNotFound       = errors.New("Key does not exist")
+	MessageNotFound   = errors.New("Message does not exist")
+	VoicemailNotFound = errors.New("Voicemail does not exist")
 )
 
 type StorageConn struct {
 	db  *sql.DB
 	mtx sync.Mutex
+	fts bool // messages_fts is available and up to date
 }
 
 type Message struct {
+	ID         int64
 	Message    string
 	IsIncoming bool
 	IsAction   bool
 	Time       int64
+	Status     string // the status of a sent message, see QueueMessage; empty for received messages
+	Delivered  int64  // the time the friend confirmed a sent message, 0 until then
 }
 
 type FriendRequest struct {
@@ -31,6 +37,13 @@ type FriendRequest struct {
 	IsIgnored bool
 }
 
+type Voicemail struct {
+	ID        int64
+	PublicKey string
+	Time      int64
+	Duration  int64 // in milliseconds
+}
+
 // Open creates a connection to the database
 // always close the connection with `defer storageConn.Close()`
 func Open(filename string) (*StorageConn, error) {
@@ -48,8 +61,12 @@ func Open(filename string) (*StorageConn, error) {
 		isIncoming INTEGER,
 		isAction INTEGER,
 		time INTEGER,
-		message TEXT NOT NULL
+		message TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT '',
+		messageID INTEGER,
+		delivered INTEGER NOT NULL DEFAULT 0
 	);
+	CREATE INDEX IF NOT EXISTS messages_friend_id ON messages(friend, id);
 	CREATE TABLE IF NOT EXISTS friends (
 		id INTEGER PRIMARY KEY,
 		publicKey TEXT
@@ -67,6 +84,41 @@ func Open(filename string) (*StorageConn, error) {
 	CREATE TABLE IF NOT EXISTS keyValueStorage (
 		key TEXT PRIMARY KEY,
 		value TEXT
+	);
+	CREATE TABLE IF NOT EXISTS voicemails (
+		id INTEGER PRIMARY KEY,
+		friend INTEGER,
+		time INTEGER,
+		duration INTEGER,
+		audio BLOB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS received_files (
+		id TEXT PRIMARY KEY,
+		friend INTEGER,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER,
+		hash TEXT NOT NULL,
+		status TEXT NOT NULL,
+		time INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS conference_messages (
+		id INTEGER PRIMARY KEY,
+		conference TEXT NOT NULL,
+		peerPublicKey TEXT NOT NULL,
+		peerName TEXT NOT NULL,
+		isIncoming INTEGER,
+		isAction INTEGER,
+		time INTEGER,
+		message TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS conference_messages_conference_id ON conference_messages(conference, id);
+	CREATE TABLE IF NOT EXISTS conference_invites (
+		id INTEGER PRIMARY KEY,
+		friend INTEGER,
+		cookie BLOB NOT NULL,
+		time INTEGER,
+		UNIQUE(friend, cookie)
 	);`
 
 	_, err = db.Exec(sqlStmt)
@@ -76,6 +128,10 @@ func Open(filename string) (*StorageConn, error) {
 	}
 
 	s := &StorageConn{db: db}
+	if err := s.upgradeMessages(); err != nil {
+		return &StorageConn{}, err
+	}
+	s.initFullTextSearch()
 	return s, nil
 }
 
@@ -120,26 +176,32 @@ func (s *StorageConn) GetKeyValue(key string) (string, error) {
 	return "", KeyNotFound
 }
 
-// StoreMessage stores a message
+// StoreMessage stores a message and returns its id. Sent messages are stored
+// with the status MessageSent, see QueueMessage for messages not sent yet.
 // friendPublicKey  the publicKey of the friend
 // isIncoming       specifies if the message is received (true) or sent (false)
 // isAction         specifies if the message is an action or not
 // message          the message
-func (s *StorageConn) StoreMessage(friendPublicKey string, isIncoming bool, isAction bool, message string) error {
+func (s *StorageConn) StoreMessage(friendPublicKey string, isIncoming bool, isAction bool, message string) (int64, error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
 	friendID, err := s.getFriendDbId(friendPublicKey)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	status := MessageSent
+	if isIncoming {
+		status = ""
 	}
 
-	_, err = s.db.Exec(`INSERT INTO messages(friend, isIncoming, isAction, time, message) VALUES(?, ?, ?, ?, ?)`, friendID, isIncoming, isAction, time.Now().Unix()*1000, message)
+	result, err := s.db.Exec(`INSERT INTO messages(friend, isIncoming, isAction, time, message, status) VALUES(?, ?, ?, ?, ?, ?)`, friendID, isIncoming, isAction, time.Now().Unix()*1000, message, status)
 	if err != nil {
 		log.Print("[persistence StoreMessage] INSERT statement failed")
-		return err
+		return 0, err
 	}
-	return nil
+	return result.LastInsertId()
 }
 
 // GetMessages returns previously stored messages of a friend.
@@ -157,31 +219,130 @@ func (s *StorageConn) GetMessages(friendPublicKey string, limit int) []Message {
 		return nil
 	}
 
-	rows, err := s.db.Query("SELECT isAction, isIncoming, time, message FROM messages WHERE friend = ? ORDER BY id DESC LIMIT ?", friendId, limit)
+	rows, err := s.db.Query("SELECT id, isAction, isIncoming, time, message, status, delivered FROM messages WHERE friend = ? ORDER BY id DESC LIMIT ?", friendId, limit)
 	if err != nil {
 		log.Print("[persistence GetMessages] SELECT statement failed")
 		return nil
 	}
 	defer rows.Close()
 
-	var messages []Message
+	return scanMessages(rows)
+}
 
-	for rows.Next() {
-		var isIncoming bool
-		var isAction bool
-		var time int64
-		var message string
-		rows.Scan(&isAction, &isIncoming, &time, &message)
-		messages = append(messages, Message{Message: message, IsIncoming: isIncoming, IsAction: isAction, Time: time})
+// GetMessagesBefore returns a page of the stored messages of a friend, newest
+// first
+// friendPublicKey  the publicKey of the friend
+// beforeID         only older messages are returned, 0 for the newest ones
+// limit            the maximum number of messages that should be returned
+func (s *StorageConn) GetMessagesBefore(friendPublicKey string, beforeID int64, limit int) []Message {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendId, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		log.Print("[persistence GetMessagesBefore] getFriendDbId failed")
+		return nil
 	}
 
-	if messages == nil {
+	var rows *sql.Rows
+	if beforeID > 0 {
+		rows, err = s.db.Query("SELECT id, isAction, isIncoming, time, message, status, delivered FROM messages WHERE friend = ? AND id < ? ORDER BY id DESC LIMIT ?", friendId, beforeID, limit)
+	} else {
+		rows, err = s.db.Query("SELECT id, isAction, isIncoming, time, message, status, delivered FROM messages WHERE friend = ? ORDER BY id DESC LIMIT ?", friendId, limit)
+	}
+	if err != nil {
+		log.Print("[persistence GetMessagesBefore] SELECT statement failed")
 		return nil
 	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// GetMessage returns a stored message
+// friendPublicKey  the publicKey of the friend the message belongs to
+// id               the id of the message
+func (s *StorageConn) GetMessage(friendPublicKey string, id int64) (Message, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendId, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		log.Print("[persistence GetMessage] getFriendDbId failed")
+		return Message{}, err
+	}
+
+	rows, err := s.db.Query("SELECT id, isAction, isIncoming, time, message, status, delivered FROM messages WHERE friend = ? AND id = ?", friendId, id)
+	if err != nil {
+		log.Print("[persistence GetMessage] SELECT statement failed")
+		return Message{}, err
+	}
+	defer rows.Close()
+
+	messages := scanMessages(rows)
+	if len(messages) == 0 {
+		return Message{}, MessageNotFound
+	}
+	return messages[0], nil
+}
+
+// CountUnreadMessages returns the number of messages received from a friend
+// since the last message read time of the friend
+// friendPublicKey  the publicKey of the friend
+func (s *StorageConn) CountUnreadMessages(friendPublicKey string) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendId, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		log.Print("[persistence CountUnreadMessages] getFriendDbId failed")
+		return 0, err
+	}
+
+	var count int64
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE friend = ? AND isIncoming = 1
+		AND time > COALESCE((SELECT time FROM friendLastMessageRead WHERE friend = ?), 0)`, friendId, friendId).Scan(&count)
+	if err != nil {
+		log.Print("[persistence CountUnreadMessages] SELECT statement failed")
+		return 0, err
+	}
+	return count, nil
+}
+
+// scanMessages reads the rows of a SELECT id, isAction, isIncoming, time,
+// message, status, delivered query
+func scanMessages(rows *sql.Rows) []Message {
+	var messages []Message
+
+	for rows.Next() {
+		var msg Message
+		rows.Scan(&msg.ID, &msg.IsAction, &msg.IsIncoming, &msg.Time, &msg.Message, &msg.Status, &msg.Delivered)
+		messages = append(messages, msg)
+	}
 
 	return messages
 }
 
+// DeleteMessages deletes all stored messages of a friend
+// friendPublicKey  the publicKey of the friend
+func (s *StorageConn) DeleteMessages(friendPublicKey string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendId, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		log.Print("[persistence DeleteMessages] getFriendDbId failed")
+		return err
+	}
+
+	_, err = s.db.Exec(`DELETE FROM messages WHERE friend = ?`, friendId)
+	if err != nil {
+		log.Print("[persistence DeleteMessages] DELETE statement failed")
+		return err
+	}
+	return nil
+}
+
 // StoreFriendRequest stores a friend request
 // friendPublicKey  the publicKey of the friend request
 // message          the message send with the friend request
@@ -301,6 +462,93 @@ func (s *StorageConn) GetLastMessageRead(friendPublicKey string) (int64, error)
 	return 0, nil
 }
 
+// StoreVoicemail stores a recorded voicemail and returns its id
+// friendPublicKey  the publicKey of the caller
+// duration         the length of the recording in milliseconds
+// audio            the recording as a WAV file
+func (s *StorageConn) StoreVoicemail(friendPublicKey string, duration int64, audio []byte) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	friendID, err := s.getFriendDbId(friendPublicKey)
+	if err != nil {
+		return -1, err
+	}
+
+	result, err := s.db.Exec(`INSERT INTO voicemails(friend, time, duration, audio) VALUES(?, ?, ?, ?)`, friendID, time.Now().Unix()*1000, duration, audio)
+	if err != nil {
+		log.Print("[persistence StoreVoicemail] INSERT statement failed")
+		return -1, err
+	}
+	return result.LastInsertId()
+}
+
+// GetVoicemails returns the stored voicemails without their audio, the newest
+// first.
+// limit  the number of voicemails that should be returned. Set limit to -1 to
+//
+//	get all voicemails
+func (s *StorageConn) GetVoicemails(limit int) []Voicemail {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.Query("SELECT voicemails.id, friends.publicKey, voicemails.time, voicemails.duration FROM voicemails JOIN friends ON voicemails.friend = friends.id ORDER BY voicemails.id DESC LIMIT ?", limit)
+	if err != nil {
+		log.Print("[persistence GetVoicemails] SELECT statement failed")
+		return nil
+	}
+	defer rows.Close()
+
+	var voicemails []Voicemail
+
+	for rows.Next() {
+		var v Voicemail
+		rows.Scan(&v.ID, &v.PublicKey, &v.Time, &v.Duration)
+		voicemails = append(voicemails, v)
+	}
+
+	return voicemails
+}
+
+// GetVoicemailAudio returns the WAV file of a stored voicemail
+// id  the id of the voicemail
+func (s *StorageConn) GetVoicemailAudio(id int64) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.Query("SELECT audio FROM voicemails WHERE id = ?", id)
+	if err != nil {
+		log.Print("[persistence GetVoicemailAudio] SELECT statement failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var audio []byte
+		rows.Scan(&audio)
+		return audio, nil
+	}
+
+	return nil, VoicemailNotFound
+}
+
+// DeleteVoicemail deletes a stored voicemail
+// id  the id of the voicemail
+func (s *StorageConn) DeleteVoicemail(id int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result, err := s.db.Exec(`DELETE FROM voicemails WHERE id = ?`, id)
+	if err != nil {
+		log.Print("[persistence DeleteVoicemail] DELETE statement failed")
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return VoicemailNotFound
+	}
+	return nil
+}
+
 // getFriendDbId returns the friendId that is used internally in the database
 // for the friend with the given publicKey
 // friendPublicKey  the publicKey of the friend
@@ -0,0 +1,244 @@
+package persistence
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// Markers around the matches in the snippets of search results
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// the number of runes of a snippet if FTS5 is not available
+const likeSnippetLength = 100
+
+type SearchOptions struct {
+	FriendPublicKey string // only search the messages of this friend, if set
+	From            int64  // only messages sent at or after this time (ms), if set
+	To              int64  // only messages sent at or before this time (ms), if set
+	IsIncoming      *bool  // only received (true) or sent (false) messages, if set
+	Limit           int
+	Offset          int
+}
+
+type SearchResult struct {
+	Message
+	PublicKey string
+	Snippet   string // part of the message, the matches are enclosed in SnippetMatchStart and SnippetMatchEnd
+}
+
+// the statements keeping messages_fts in sync with messages. The index is an
+// external content table: it stores no copy of the messages.
+var fullTextSearchTriggers = map[string]string{
+	"messages_fts_insert": `CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, message) VALUES (new.id, new.message);
+	END`,
+	"messages_fts_delete": `CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+	END`,
+	"messages_fts_update": `CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF message ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		INSERT INTO messages_fts(rowid, message) VALUES (new.id, new.message);
+	END`,
+}
+
+// initFullTextSearch creates the FTS5 index of the messages if SQLite was
+// built with FTS5 (the sqlite_fts5 build tag of go-sqlite3). The index is
+// (re)built from the messages table if its triggers are missing: for
+// databases created before the index existed or last opened without FTS5.
+// Without FTS5 the triggers are dropped, they would make every INSERT fail,
+// and SearchMessages falls back to LIKE.
+func (s *StorageConn) initFullTextSearch() {
+	var enabled bool
+	if err := s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil || !enabled {
+		for name := range fullTextSearchTriggers {
+			if _, err := s.db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				log.Print("[persistence initFullTextSearch] DROP TRIGGER statement failed")
+			}
+		}
+		log.Print("[persistence] SQLite has no FTS5, searching messages with LIKE")
+		return
+	}
+
+	_, err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(message, content='messages', content_rowid='id', tokenize='unicode61 remove_diacritics 2')`)
+	if err != nil {
+		log.Print("[persistence initFullTextSearch] CREATE VIRTUAL TABLE statement failed: ", err)
+		return
+	}
+
+	var triggers int
+	s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%'").Scan(&triggers)
+	if triggers != len(fullTextSearchTriggers) {
+		for _, stmt := range fullTextSearchTriggers {
+			if _, err := s.db.Exec(stmt); err != nil {
+				log.Print("[persistence initFullTextSearch] CREATE TRIGGER statement failed: ", err)
+				return
+			}
+		}
+
+		log.Print("[persistence] Building the search index of the messages")
+		if _, err := s.db.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')`); err != nil {
+			log.Print("[persistence initFullTextSearch] rebuilding the index failed: ", err)
+			return
+		}
+	}
+
+	s.fts = true
+}
+
+// FullTextSearchEnabled returns true if SearchMessages uses the FTS5 index
+// and false if it falls back to LIKE
+func (s *StorageConn) FullTextSearchEnabled() bool {
+	return s.fts
+}
+
+// SearchMessages returns the messages containing all words of a query. With
+// FTS5 the words match prefixes and the results are ordered by relevance,
+// otherwise they match anywhere in the message and the newest results come
+// first.
+// query  the words to search for
+// opts   the filters and the page of the results
+func (s *StorageConn) SearchMessages(query string, opts SearchOptions) ([]SearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var stmt strings.Builder
+	var args []interface{}
+
+	if s.fts {
+		stmt.WriteString(`SELECT m.id, f.publicKey, m.isAction, m.isIncoming, m.time, m.message,
+			snippet(messages_fts, 0, ?, ?, '…', 16)
+			FROM messages_fts JOIN messages m ON m.id = messages_fts.rowid JOIN friends f ON f.id = m.friend
+			WHERE messages_fts MATCH ?`)
+		args = append(args, SnippetMatchStart, SnippetMatchEnd, fullTextQuery(terms))
+	} else {
+		stmt.WriteString(`SELECT m.id, f.publicKey, m.isAction, m.isIncoming, m.time, m.message, ''
+			FROM messages m JOIN friends f ON f.id = m.friend WHERE 1`)
+		for _, term := range terms {
+			stmt.WriteString(` AND m.message LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscaper.Replace(term)+"%")
+		}
+	}
+
+	if opts.FriendPublicKey != "" {
+		stmt.WriteString(" AND f.publicKey LIKE ?")
+		args = append(args, opts.FriendPublicKey)
+	}
+	if opts.From > 0 {
+		stmt.WriteString(" AND m.time >= ?")
+		args = append(args, opts.From)
+	}
+	if opts.To > 0 {
+		stmt.WriteString(" AND m.time <= ?")
+		args = append(args, opts.To)
+	}
+	if opts.IsIncoming != nil {
+		stmt.WriteString(" AND m.isIncoming = ?")
+		args = append(args, *opts.IsIncoming)
+	}
+
+	if s.fts {
+		stmt.WriteString(" ORDER BY rank")
+	} else {
+		stmt.WriteString(" ORDER BY m.id DESC")
+	}
+	stmt.WriteString(" LIMIT ? OFFSET ?")
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := s.db.Query(stmt.String(), args...)
+	if err != nil {
+		log.Print("[persistence SearchMessages] SELECT statement failed: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		rows.Scan(&result.ID, &result.PublicKey, &result.IsAction, &result.IsIncoming, &result.Time, &result.Message.Message, &result.Snippet)
+		if !s.fts {
+			result.Snippet = likeSnippet(result.Message.Message, terms)
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// fullTextQuery returns a FTS5 query matching messages with words starting
+// with each of the terms. The terms are quoted, FTS5 operators in them have no
+// effect.
+// terms  the words to search for
+func fullTextQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// likeSnippet returns the part of a message around the first match of the
+// terms with all matches marked, like the snippet function of FTS5
+// message  the message
+// terms    the words that were searched for
+func likeSnippet(message string, terms []string) string {
+	text := []rune(message)
+	lower := []rune(strings.Map(unicode.ToLower, message))
+
+	// the matched runes
+	matched := make([]bool, len(text))
+	first := -1
+	for _, term := range terms {
+		needle := []rune(strings.Map(unicode.ToLower, term))
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) != string(needle) {
+				continue
+			}
+			for j := i; j < i+len(needle); j++ {
+				matched[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > likeSnippetLength/3 {
+		start = first - likeSnippetLength/3
+	}
+	end := start + likeSnippetLength
+	if end > len(text) {
+		end = len(text)
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if matched[i] && (i == start || !matched[i-1]) {
+			snippet.WriteString(SnippetMatchStart)
+		}
+		snippet.WriteRune(text[i])
+		if matched[i] && (i == end-1 || !matched[i+1]) {
+			snippet.WriteString(SnippetMatchEnd)
+		}
+	}
+	if end < len(text) {
+		snippet.WriteString("…")
+	}
+
+	return snippet.String()
+}
@@ -0,0 +1,132 @@
+package persistence
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testFriendA = strings.Repeat("a", 64)
+	testFriendB = strings.Repeat("b", 64)
+)
+
+// openTestStorage opens a new database in a temporary directory and returns
+// it with its file name
+func openTestStorage(t *testing.T) (*StorageConn, string) {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "userdata.db")
+	s, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, filename
+}
+
+// searchIDs returns the ids of the messages found for a query
+func searchIDs(t *testing.T, s *StorageConn, query string) map[int64]bool {
+	t.Helper()
+
+	results, err := s.SearchMessages(query, SearchOptions{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[int64]bool)
+	for _, result := range results {
+		ids[result.ID] = true
+	}
+	return ids
+}
+
+func TestSearchIndexBackfill(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string // statements turning the database into an older one
+	}{
+		{
+			name: "created before the index",
+			setup: []string{
+				"DROP TRIGGER messages_fts_insert",
+				"DROP TRIGGER messages_fts_delete",
+				"DROP TRIGGER messages_fts_update",
+				"DROP TABLE messages_fts",
+			},
+		},
+		{
+			// initFullTextSearch drops the triggers without FTS5
+			name: "opened without FTS5",
+			setup: []string{
+				"DROP TRIGGER messages_fts_insert",
+				"DROP TRIGGER messages_fts_delete",
+				"DROP TRIGGER messages_fts_update",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, filename := openTestStorage(t)
+			if !s.FullTextSearchEnabled() {
+				s.Close()
+				t.Skip("SQLite has no FTS5, build with the sqlite_fts5 tag")
+			}
+
+			indexed, _ := s.StoreMessage(testFriendA, true, false, "alpha indexed")
+			for _, stmt := range tt.setup {
+				if _, err := s.db.Exec(stmt); err != nil {
+					t.Fatalf("%s: %v", stmt, err)
+				}
+			}
+			missing, _ := s.StoreMessage(testFriendA, true, false, "alpha missing")
+			s.Close()
+
+			s, err := Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ids := searchIDs(t, s, "alpha")
+			if len(ids) != 2 || !ids[indexed] || !ids[missing] {
+				t.Fatalf("found messages %v, want %d and %d", ids, indexed, missing)
+			}
+
+			// the triggers are back
+			added, _ := s.StoreMessage(testFriendA, true, false, "alpha added")
+			if ids := searchIDs(t, s, "alpha"); len(ids) != 3 || !ids[added] {
+				t.Fatalf("found messages %v, want 3 with %d", ids, added)
+			}
+		})
+	}
+}
+
+func TestSearchDeleteSync(t *testing.T) {
+	s, _ := openTestStorage(t)
+	defer s.Close()
+
+	s.StoreMessage(testFriendA, true, false, "hello world")
+	s.StoreMessage(testFriendA, false, false, "hello again")
+	kept, _ := s.StoreMessage(testFriendB, true, false, "hello there")
+
+	if err := s.DeleteMessages(testFriendA); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := searchIDs(t, s, "hello"); len(ids) != 1 || !ids[kept] {
+		t.Fatalf("found messages %v, want only %d", ids, kept)
+	}
+
+	if !s.FullTextSearchEnabled() {
+		return
+	}
+	// SearchMessages joins the messages, the index itself must not keep the
+	// deleted ones either
+	var indexed int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'hello'").Scan(&indexed); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 1 {
+		t.Fatalf("%d messages in the index, want 1", indexed)
+	}
+}
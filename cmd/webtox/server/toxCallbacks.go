@@ -3,9 +3,12 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"github.com/calvindc/dpc-tox/cmd/webtox/server/persistence"
 	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -18,54 +21,69 @@ func onFriendRequest(t *libtox.Tox, publicKey []byte, message []byte, length uin
 
 func onFriendMessage(t *libtox.Tox, friendnumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
 	type jsonEvent struct {
-		Type     string `json:"type"`
-		Friend   uint32 `json:"friend"`
-		Time     int64  `json:"time"`
-		Message  string `json:"message"`
-		IsAction bool   `json:"isAction"`
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		ID        int64  `json:"id"`
+		Time      int64  `json:"time"`
+		Message   string `json:"message"`
+		IsAction  bool   `json:"isAction"`
 	}
 
+	publicKey := publicKeyOfFriend(friendnumber)
+	id, _ := storage.StoreMessage(publicKey, true, messagetype == libtox.TOX_MESSAGE_TYPE_ACTION, string(message))
+
 	e, _ := json.Marshal(jsonEvent{
-		Type:     "friend_message",
-		Friend:   friendnumber,
-		Time:     time.Now().Unix() * 1000,
-		Message:  string(message),
-		IsAction: messagetype == libtox.TOX_MESSAGE_TYPE_ACTION,
+		Type:      "friend_message",
+		PublicKey: publicKey,
+		Number:    friendnumber,
+		ID:        id,
+		Time:      time.Now().Unix() * 1000,
+		Message:   string(message),
+		IsAction:  messagetype == libtox.TOX_MESSAGE_TYPE_ACTION,
 	})
 
-	publicKey, _ := tox.FriendGetPublickey(friendnumber)
-	storage.StoreMessage(hex.EncodeToString(publicKey), true, messagetype == libtox.TOX_MESSAGE_TYPE_ACTION, string(message))
-
 	broadcastToClients(string(e))
 }
 
+func onFriendReadReceipt(t *libtox.Tox, friendnumber uint32, messageid uint32) {
+	onOutboxReadReceipt(friendnumber, messageid)
+}
+
 func onFriendConnectionStatusChanges(t *libtox.Tox, friendnumber uint32, connectionStatus libtox.ToxConnection) {
 	type jsonEvent struct {
-		Type   string `json:"type"`
-		Friend uint32 `json:"friend"`
-		Online bool   `json:"online"`
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		Online    bool   `json:"online"`
 	}
 
 	e, _ := json.Marshal(jsonEvent{
-		Type:   "connection_status",
-		Friend: friendnumber,
-		Online: connectionStatus != libtox.TOX_CONNECTION_NONE,
+		Type:      "connection_status",
+		PublicKey: publicKeyOfFriend(friendnumber),
+		Number:    friendnumber,
+		Online:    connectionStatus != libtox.TOX_CONNECTION_NONE,
 	})
 
 	broadcastToClients(string(e))
+	onUploadFriendConnection(friendnumber, connectionStatus != libtox.TOX_CONNECTION_NONE)
+	onDownloadFriendConnection(friendnumber, connectionStatus != libtox.TOX_CONNECTION_NONE)
+	onOutboxFriendConnection(friendnumber, connectionStatus != libtox.TOX_CONNECTION_NONE)
 }
 
 func onFriendNameChanges(t *libtox.Tox, friendnumber uint32, newname []byte, length uint32) {
 	type jsonEvent struct {
-		Type   string `json:"type"`
-		Friend uint32 `json:"friend"`
-		Name   string `json:"name"`
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		Name      string `json:"name"`
 	}
 
 	e, _ := json.Marshal(jsonEvent{
-		Type:   "name_changed",
-		Friend: friendnumber,
-		Name:   string(newname),
+		Type:      "name_changed",
+		PublicKey: publicKeyOfFriend(friendnumber),
+		Number:    friendnumber,
+		Name:      string(newname),
 	})
 
 	broadcastToClients(string(e))
@@ -74,13 +92,15 @@ func onFriendNameChanges(t *libtox.Tox, friendnumber uint32, newname []byte, len
 func onFriendStatusMessageChanges(t *libtox.Tox, friendnumber uint32, status []byte, length uint32) {
 	type jsonEvent struct {
 		Type      string `json:"type"`
-		Friend    uint32 `json:"friend"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
 		StatusMsg string `json:"status_msg"`
 	}
 
 	e, _ := json.Marshal(jsonEvent{
 		Type:      "status_message_changed",
-		Friend:    friendnumber,
+		PublicKey: publicKeyOfFriend(friendnumber),
+		Number:    friendnumber,
 		StatusMsg: string(status),
 	})
 
@@ -89,15 +109,17 @@ func onFriendStatusMessageChanges(t *libtox.Tox, friendnumber uint32, status []b
 
 func onFriendStatusChanges(t *libtox.Tox, friendnumber uint32, userstatus libtox.ToxUserStatus) {
 	type jsonEvent struct {
-		Type   string `json:"type"`
-		Friend uint32 `json:"friend"`
-		Status string `json:"status"`
+		Type      string `json:"type"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		Status    string `json:"status"`
 	}
 
 	e, _ := json.Marshal(jsonEvent{
-		Type:   "status_changed",
-		Friend: friendnumber,
-		Status: getUserStatusAsString(userstatus),
+		Type:      "status_changed",
+		PublicKey: publicKeyOfFriend(friendnumber),
+		Number:    friendnumber,
+		Status:    getUserStatusAsString(userstatus),
 	})
 
 	broadcastToClients(string(e))
@@ -105,33 +127,36 @@ func onFriendStatusChanges(t *libtox.Tox, friendnumber uint32, userstatus libtox
 
 func onFileRecv(t *libtox.Tox, friendnumber uint32, filenumber uint32, kind libtox.ToxFileKind, filesize uint64, filename string, length uint32) {
 	if kind == libtox.TOX_FILE_KIND_AVATAR {
-		publicKey, _ := tox.FriendGetPublickey(friendnumber)
-		file, err := os.Create("../html/avatars/" + hex.EncodeToString(publicKey) + ".png")
-		if err != nil {
-			log.Println("[ERROR] Error creating file", "../html/avatars/"+hex.EncodeToString(publicKey)+".png")
+		// only accept avatars with a file size <= the configured limit
+		if filesize > getConfig().Limits.MaxAvatarSize {
+			t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+			return
 		}
 
-		// only accept avatars with a file size <= CFG_MAX_AVATAR_SIZE
-		if filesize <= CFG_MAX_AVATAR_SIZE {
-			// append the file to the map of active file transfers
-			transfers[filenumber] = FileTransfer{fileHandle: file, fileSize: filesize, fileKind: kind}
-
-			t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_RESUME)
-		} else {
+		publicKey, err := tox.FriendGetPublickey(friendnumber)
+		if err != nil {
 			t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+			return
 		}
-
-	} else if kind == libtox.TOX_FILE_KIND_DATA {
-		file, err := os.Create("../html/download/" + string(filename))
+		avatarPath := filepath.Join(getConfig().HTMLDir, "avatars", hex.EncodeToString(publicKey)+".png")
+		file, err := os.Create(avatarPath)
 		if err != nil {
-			log.Println("[ERROR] Error creating file", "../html/download/"+string(filename))
+			log.Println("[ERROR] Error creating file", avatarPath, err)
+			t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+			return
 		}
 
 		// append the file to the map of active file transfers
 		transfers[filenumber] = FileTransfer{fileHandle: file, fileSize: filesize, fileKind: kind}
 
-		// TODO do not accept any file send request without asking the user
-		t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_RESUME)
+		if err = t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_RESUME); err != nil {
+			delete(transfers, filenumber)
+			file.Close()
+			os.Remove(avatarPath)
+		}
+
+	} else if kind == libtox.TOX_FILE_KIND_DATA {
+		newDownload(friendnumber, filenumber, filesize, filename)
 
 	} else {
 		log.Print("onFileRecv: unknown TOX_FILE_KIND: ", kind)
@@ -139,6 +164,10 @@ func onFileRecv(t *libtox.Tox, friendnumber uint32, filenumber uint32, kind libt
 }
 
 func onFileRecvControl(t *libtox.Tox, friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) {
+	if onUploadControl(friendnumber, filenumber, fileControl) || onDownloadControl(friendnumber, filenumber, fileControl) {
+		return
+	}
+
 	transfer, ok := transfers[filenumber]
 	if !ok {
 		log.Println("Error: File handle does not exist")
@@ -154,6 +183,10 @@ func onFileRecvControl(t *libtox.Tox, friendnumber uint32, filenumber uint32, fi
 }
 
 func onFileRecvChunk(t *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, data []byte, length uint32) {
+	if onDownloadChunk(friendnumber, filenumber, position, data) {
+		return
+	}
+
 	transfer, ok := transfers[filenumber]
 	if !ok {
 		if len(data) == 0 {
@@ -190,3 +223,74 @@ func onFileRecvChunk(t *libtox.Tox, friendnumber uint32, filenumber uint32, posi
 		}
 	}
 }
+
+// onConferenceInvite stores an invite of a friend to a text conference
+func onConferenceInvite(t *libtox.Tox, friendnumber uint32, conferencetype libtox.ToxConferenceType, cookie []byte) {
+	publicKey := publicKeyOfFriend(friendnumber)
+	if conferencetype != libtox.TOX_CONFERENCE_TYPE_TEXT {
+		log.Printf("Ignoring invite to an audio conference from %s\n", publicKey)
+		return
+	}
+	log.Printf("New conference invite from %s\n", publicKey)
+
+	storage.StoreConferenceInvite(publicKey, cookie)
+	broadcastToClients(createSimpleJSONEvent("conference_invites_update"))
+}
+
+// onConferenceMessage stores a message of a peer of a conference
+func onConferenceMessage(t *libtox.Tox, conferencenumber uint32, peernumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
+	// toxcore reports the messages of the user, too; they are stored when sent
+	if isOurs, err := t.ConferencePeerNumberIsOurs(conferencenumber, peernumber); err != nil || isOurs {
+		return
+	}
+
+	id, err := conferenceID(conferencenumber)
+	if err != nil {
+		return
+	}
+
+	publicKey, _ := t.ConferencePeerGetPublicKey(conferencenumber, peernumber)
+	name, _ := t.ConferencePeerGetName(conferencenumber, peernumber)
+	msg := persistence.ConferenceMessage{
+		PeerPublicKey: strings.ToLower(publicKey),
+		PeerName:      name,
+		Message:       string(message),
+		IsIncoming:    true,
+		IsAction:      messagetype == libtox.TOX_MESSAGE_TYPE_ACTION,
+	}
+	msg.ID, msg.Time, _ = storage.StoreConferenceMessage(id, msg.PeerPublicKey, msg.PeerName, true, msg.IsAction, msg.Message)
+
+	broadcastConferenceMessageEvent(id, conferencenumber, msg)
+}
+
+// onConferenceConnected marks a joined conference as connected
+func onConferenceConnected(t *libtox.Tox, conferencenumber uint32) {
+	id, err := conferenceID(conferencenumber)
+	if err != nil {
+		return
+	}
+
+	conferencesMtx.Lock()
+	delete(conferencesJoining, id)
+	conferencesMtx.Unlock()
+
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+}
+
+// onConferenceTitle tells the clients that a peer changed the title of a
+// conference
+func onConferenceTitle(t *libtox.Tox, conferencenumber uint32, peernumber uint32, title []byte, length uint32) {
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+}
+
+// onConferencePeerName tells the clients that a peer of a conference changed
+// their name
+func onConferencePeerName(t *libtox.Tox, conferencenumber uint32, peernumber uint32, name []byte, length uint32) {
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+}
+
+// onConferencePeerListChanged tells the clients that a peer joined or left a
+// conference
+func onConferencePeerListChanged(t *libtox.Tox, conferencenumber uint32) {
+	broadcastToClients(createSimpleJSONEvent("conferencelist_update"))
+}
@@ -0,0 +1,76 @@
+//go:build toxsim
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+func TestAvatar(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		noAvatarDir bool
+		wantControl libtox.ToxFileControl
+	}{
+		{"accepted", 1000, false, libtox.TOX_FILE_CONTROL_RESUME},
+		{"larger than the limit", 2000, false, libtox.TOX_FILE_CONTROL_CANCEL},
+		{"no avatar directory", 1000, true, libtox.TOX_FILE_CONTROL_CANCEL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestServer(t)
+			config := *getConfig()
+			config.HTMLDir = t.TempDir()
+			config.Limits.MaxAvatarSize = 1500
+			currentConfig.Store(&config)
+			if !tt.noAvatarDir {
+				if err := os.Mkdir(filepath.Join(config.HTMLDir, "avatars"), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			avatar := bytes.Repeat([]byte{0x89}, tt.size)
+			var controls []libtox.ToxFileControl
+			f.tox.CallbackFileRecvControl(func(_ *libtox.Tox, friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) {
+				controls = append(controls, fileControl)
+			})
+			f.tox.CallbackFileChunkRequest(func(tox *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+				if length > 0 && position < uint64(len(avatar)) {
+					tox.FileSendChunk(friendnumber, filenumber, position, avatar[position:min(position+length, uint64(len(avatar)))])
+				}
+			})
+			if _, err := f.tox.FileSend(0, libtox.TOX_FILE_KIND_AVATAR, uint64(len(avatar)), nil, "avatar.png"); err != nil {
+				t.Fatal(err)
+			}
+			f.net.Settle(100)
+
+			if len(controls) != 1 || controls[0] != tt.wantControl {
+				t.Fatalf("friend got the file controls %v, want %v", controls, tt.wantControl)
+			}
+			if len(transfers) != 0 {
+				t.Errorf("%d transfers left open", len(transfers))
+			}
+
+			files, _ := os.ReadDir(filepath.Join(config.HTMLDir, "avatars"))
+			if tt.wantControl == libtox.TOX_FILE_CONTROL_CANCEL {
+				if len(files) != 0 {
+					t.Errorf("rejected avatar left the files %v", files)
+				}
+				return
+			}
+			if len(files) != 1 || files[0].Name() != f.publicKey+".png" {
+				t.Fatalf("avatar directory has %v, want %s.png", files, f.publicKey)
+			}
+			if content, _ := os.ReadFile(filepath.Join(config.HTMLDir, "avatars", files[0].Name())); !bytes.Equal(content, avatar) {
+				t.Errorf("avatar has %d bytes, want %d", len(content), len(avatar))
+			}
+		})
+	}
+}
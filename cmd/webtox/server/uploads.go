@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+var (
+	errUnknownUpload = errors.New("Unknown upload")
+	errNotSending    = errors.New("The file is not being sent")
+)
+
+// upload is a file uploaded in the GUI to be sent to a friend. The file stays
+// in the upload directory until the friend received it, so the transfer can
+// start over when the friend reconnects.
+type upload struct {
+	id        string // identifies the upload in the API and the events
+	friend    uint32
+	publicKey string
+	name      string // the file name sent to the friend
+	size      uint64
+	path      string
+	file      *os.File
+
+	// the file id is the same for every attempt, so the friend can resume
+	// the file instead of receiving it again
+	fileID     []byte
+	filenumber uint32
+	state      string // see uploadQueued
+	pausedByUs bool
+	sent       uint64
+	reported   time.Time // when the progress was last sent in an event
+}
+
+// The states of an upload:
+//
+//	queued     waiting for the friend to come online
+//	offered    waiting for the friend to accept the file
+//	sending    the friend requests chunks
+//	paused     paused by the friend, see also pausedByUs
+//	complete   the friend received the file
+//	cancelled  cancelled by the user or rejected by the friend
+//	failed     the file could not be sent
+const (
+	uploadQueued    = "queued"
+	uploadOffered   = "offered"
+	uploadSending   = "sending"
+	uploadPaused    = "paused"
+	uploadComplete  = "complete"
+	uploadCancelled = "cancelled"
+	uploadFailed    = "failed"
+)
+
+// the minimum time between two events reporting the progress of an upload
+const uploadProgressInterval = 500 * time.Millisecond
+
+var uploadsMtx sync.Mutex
+
+// Map of the uploads that are not finished, by id
+var uploads = make(map[string]*upload)
+
+// uploadDir returns the directory of the uploaded files
+func uploadDir() string {
+	return filepath.Join(getConfig().DataDir, "uploads")
+}
+
+// clearUploadDir removes the files of uploads that were interrupted by a
+// restart: they can not be resumed without their transfer state
+func clearUploadDir() error {
+	if err := os.RemoveAll(uploadDir()); err != nil {
+		return err
+	}
+	return os.MkdirAll(uploadDir(), 0700)
+}
+
+// newUpload stores a file uploaded in the GUI and starts sending it to a
+// friend, or queues it until the friend comes online
+// friendnumber  the friend who receives the file
+// name          the name of the file in the browser
+// r             the content of the file
+func newUpload(friendnumber uint32, name string, r io.Reader) (*upload, error) {
+	id := make([]byte, 16)
+	fileID := make([]byte, libtox.TOX_FILE_ID_LENGTH)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(fileID); err != nil {
+		return nil, err
+	}
+
+	u := &upload{
+		id:        hex.EncodeToString(id),
+		friend:    friendnumber,
+		publicKey: publicKeyOfFriend(friendnumber),
+		name:      uploadFileName(name),
+		fileID:    fileID,
+	}
+	u.path = filepath.Join(uploadDir(), u.id)
+
+	file, err := os.OpenFile(u.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		os.Remove(u.path)
+		return nil, err
+	}
+	u.file = file
+	u.size = uint64(n)
+
+	uploadsMtx.Lock()
+	uploads[u.id] = u
+	err = offerUpload(u)
+	uploadsMtx.Unlock()
+
+	if err != nil {
+		finishUpload(u, uploadFailed)
+	} else {
+		broadcastUploadEvent(u)
+	}
+	return u, nil
+}
+
+// offerUpload offers an upload to its friend if the friend is online, else it
+// is queued. uploadsMtx must be held, the friend might accept the file before
+// FileSend returns.
+// u  the upload
+func offerUpload(u *upload) error {
+	u.sent = 0
+	u.state = uploadQueued
+	u.pausedByUs = false
+	if status, err := tox.FriendGetConnectionStatus(u.friend); err != nil || status == libtox.TOX_CONNECTION_NONE {
+		return nil
+	}
+
+	filenumber, err := tox.FileSend(u.friend, libtox.TOX_FILE_KIND_DATA, u.size, u.fileID, u.name)
+	if err != nil {
+		log.Println("[ERROR] Could not send the file", u.name, "to friend", u.friend, err)
+		return err
+	}
+
+	u.filenumber = filenumber
+	u.state = uploadOffered
+	return nil
+}
+
+// uploadFileName returns the file name sent to a friend for the name of a
+// file in the browser: without a path and at most TOX_MAX_FILENAME_LENGTH
+// bytes long
+// name  the name of the file in the browser
+func uploadFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "file"
+	}
+
+	return cutFileName(name, libtox.TOX_MAX_FILENAME_LENGTH)
+}
+
+// activeUpload returns the upload of a Tox file transfer. uploadsMtx must be
+// held.
+// friendnumber  the friend who receives the file
+// filenumber    the file transfer
+func activeUpload(friendnumber uint32, filenumber uint32) *upload {
+	for _, u := range uploads {
+		if u.friend == friendnumber && u.filenumber == filenumber && u.state != uploadQueued {
+			return u
+		}
+	}
+	return nil
+}
+
+func onFileChunkRequest(t *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+	uploadsMtx.Lock()
+	u := activeUpload(friendnumber, filenumber)
+	if u == nil {
+		uploadsMtx.Unlock()
+		log.Println("Error: Upload does not exist")
+		return
+	}
+
+	// a zero-length chunk request confirms that the file was successfully
+	// transferred
+	if length == 0 {
+		uploadsMtx.Unlock()
+		log.Println("File transfer completed (sending)", filenumber)
+		finishUpload(u, uploadComplete)
+		return
+	}
+
+	data := make([]byte, length)
+	if _, err := u.file.ReadAt(data, int64(position)); err != nil {
+		uploadsMtx.Unlock()
+		log.Println("[ERROR] Error reading the upload", u.path, err)
+		t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+		finishUpload(u, uploadFailed)
+		return
+	}
+
+	if err := t.FileSendChunk(friendnumber, filenumber, position, data); err != nil {
+		// toxcore requests the chunk again
+		uploadsMtx.Unlock()
+		return
+	}
+
+	u.state = uploadSending
+	u.sent = position + length
+	report := time.Since(u.reported) >= uploadProgressInterval
+	if report {
+		u.reported = time.Now()
+	}
+	uploadsMtx.Unlock()
+
+	if report {
+		broadcastUploadEvent(u)
+	}
+}
+
+// onUploadControl handles a file control of a friend for an upload and
+// returns false if the file transfer is not an upload
+// friendnumber  the friend who receives the file
+// filenumber    the file transfer
+// fileControl   the control sent by the friend
+func onUploadControl(friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) bool {
+	uploadsMtx.Lock()
+	u := activeUpload(friendnumber, filenumber)
+	if u == nil {
+		uploadsMtx.Unlock()
+		return false
+	}
+
+	switch fileControl {
+	case libtox.TOX_FILE_CONTROL_RESUME:
+		u.state = uploadSending
+	case libtox.TOX_FILE_CONTROL_PAUSE:
+		u.state = uploadPaused
+	}
+	uploadsMtx.Unlock()
+
+	if fileControl == libtox.TOX_FILE_CONTROL_CANCEL {
+		finishUpload(u, uploadCancelled)
+	} else {
+		broadcastUploadEvent(u)
+	}
+	return true
+}
+
+// onUploadFriendConnection queues the uploads to a friend who went offline,
+// toxcore drops their transfers, and offers them again when the friend comes
+// back online
+// friendnumber  the friend
+// online        whether the friend is online now
+func onUploadFriendConnection(friendnumber uint32, online bool) {
+	uploadsMtx.Lock()
+	var changed, failed []*upload
+	for _, u := range uploads {
+		if u.friend != friendnumber || (u.state == uploadQueued) != online {
+			continue
+		}
+		if !online {
+			u.state = uploadQueued
+			changed = append(changed, u)
+		} else if err := offerUpload(u); err != nil {
+			failed = append(failed, u)
+		} else {
+			changed = append(changed, u)
+		}
+	}
+	uploadsMtx.Unlock()
+
+	for _, u := range changed {
+		broadcastUploadEvent(u)
+	}
+	for _, u := range failed {
+		finishUpload(u, uploadFailed)
+	}
+}
+
+// pauseUpload pauses (pause true) or resumes sending a file the friend
+// accepted
+// id     the id of the upload
+// pause  whether the file is paused
+func pauseUpload(id string, pause bool) error {
+	uploadsMtx.Lock()
+	u, ok := uploads[id]
+	var err error
+	switch {
+	case !ok:
+		err = errUnknownUpload
+	case u.state != uploadSending && u.state != uploadPaused:
+		err = errNotSending
+	case pause != u.pausedByUs:
+		control := libtox.TOX_FILE_CONTROL_RESUME
+		if pause {
+			control = libtox.TOX_FILE_CONTROL_PAUSE
+		}
+		if err = tox.FileControl(u.friend, u.filenumber, control); err == nil {
+			u.pausedByUs = pause
+		}
+	}
+	uploadsMtx.Unlock()
+
+	if err == nil {
+		broadcastUploadEvent(u)
+	}
+	return err
+}
+
+// cancelUpload cancels an upload
+// id  the id of the upload
+func cancelUpload(id string) error {
+	uploadsMtx.Lock()
+	u, ok := uploads[id]
+	if ok && u.state != uploadQueued {
+		tox.FileControl(u.friend, u.filenumber, libtox.TOX_FILE_CONTROL_CANCEL)
+	}
+	uploadsMtx.Unlock()
+	if !ok {
+		return errUnknownUpload
+	}
+
+	finishUpload(u, uploadCancelled)
+	return nil
+}
+
+// cancelFriendUploads cancels all uploads to a friend, e.g. before the friend
+// is deleted
+// friendnumber  the friend
+func cancelFriendUploads(friendnumber uint32) {
+	uploadsMtx.Lock()
+	var ids []string
+	for id, u := range uploads {
+		if u.friend == friendnumber {
+			ids = append(ids, id)
+		}
+	}
+	uploadsMtx.Unlock()
+
+	for _, id := range ids {
+		cancelUpload(id)
+	}
+}
+
+// finishUpload removes an upload and its file
+// u      the upload
+// state  complete, cancelled or failed
+func finishUpload(u *upload, state string) {
+	uploadsMtx.Lock()
+	_, ok := uploads[u.id]
+	if ok {
+		delete(uploads, u.id)
+		u.state = state
+		if state == uploadComplete {
+			u.sent = u.size
+		}
+	}
+	uploadsMtx.Unlock()
+	if !ok {
+		return
+	}
+
+	u.file.Close()
+	if err := os.Remove(u.path); err != nil {
+		log.Println("[ERROR] Could not remove the upload", u.path, err)
+	}
+	broadcastUploadEvent(u)
+}
+
+// apiUpload is an upload as returned by the API and sent in the "file_upload"
+// event
+type apiUpload struct {
+	ID         string `json:"id"`
+	PublicKey  string `json:"publicKey"`
+	Number     uint32 `json:"number"`
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"`
+	Sent       uint64 `json:"sent"`
+	State      string `json:"state"`
+	PausedByUs bool   `json:"paused_by_us"`
+}
+
+// getAPIUpload returns an upload as returned by the API
+// u  the upload
+func getAPIUpload(u *upload) apiUpload {
+	uploadsMtx.Lock()
+	defer uploadsMtx.Unlock()
+
+	return apiUpload{ID: u.id, PublicKey: u.publicKey, Number: u.friend, Name: u.name, Size: u.size, Sent: u.sent, State: u.state, PausedByUs: u.pausedByUs}
+}
+
+// getUploads returns the uploads that are not finished
+func getUploads() []apiUpload {
+	uploadsMtx.Lock()
+	var list []*upload
+	for _, u := range uploads {
+		list = append(list, u)
+	}
+	uploadsMtx.Unlock()
+
+	result := make([]apiUpload, len(list))
+	for i, u := range list {
+		result[i] = getAPIUpload(u)
+	}
+	return result
+}
+
+// getUpload returns an upload that is not finished
+// id  the id of the upload
+func getUpload(id string) (apiUpload, error) {
+	uploadsMtx.Lock()
+	u, ok := uploads[id]
+	uploadsMtx.Unlock()
+	if !ok {
+		return apiUpload{}, errUnknownUpload
+	}
+
+	return getAPIUpload(u), nil
+}
+
+// broadcastUploadEvent sends the "file_upload" event with the state and the
+// progress of an upload to all clients
+// u  the upload
+func broadcastUploadEvent(u *upload) {
+	type jsonEvent struct {
+		Type string `json:"type"`
+		apiUpload
+	}
+
+	data, _ := json.Marshal(jsonEvent{Type: "file_upload", apiUpload: getAPIUpload(u)})
+
+	broadcastToClients(string(data))
+}
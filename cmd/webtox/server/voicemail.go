@@ -0,0 +1,252 @@
+//go:build !toxsim
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+	"github.com/calvindc/dpc-tox/librarywrapper/toxmedia"
+)
+
+// voicemailSession is a call answered by the voicemail. The greeting is
+// played first, then the audio of the caller is recorded until the call ends
+// or the configured maximum duration is reached.
+type voicemailSession struct {
+	friend    uint32
+	publicKey string
+
+	recording bool
+	pcm       bytes.Buffer // 16 bit mono PCM at libtoxav.DefaultSamplingRate
+	limit     *time.Timer
+
+	stop chan struct{} // closed when the session ends
+}
+
+var voicemailMtx sync.Mutex
+
+// Map of calls answered by the voicemail
+var voicemailSessions = make(map[uint32]*voicemailSession)
+
+// voicemailShouldAnswer returns true if an incoming call should be answered
+// by the voicemail: nobody is connected to the GUI or the user is away or busy
+func voicemailShouldAnswer() bool {
+	if !voicemailEnabled() {
+		return false
+	}
+
+	if clientCount() == 0 {
+		return true
+	}
+
+	status, err := tox.SelfGetStatus()
+	return err == nil && (status == libtox.TOX_USERSTATUS_AWAY || status == libtox.TOX_USERSTATUS_BUSY)
+}
+
+// startVoicemail answers the call of a friend, plays the greeting and starts
+// recording
+// friendnumber  the friend who is calling
+func startVoicemail(friendnumber uint32) {
+	publicKey, err := tox.FriendGetPublickey(friendnumber)
+	if err != nil {
+		log.Println("[ERROR] Voicemail: unknown friend", friendnumber)
+		return
+	}
+
+	session := &voicemailSession{
+		friend:    friendnumber,
+		publicKey: hex.EncodeToString(publicKey),
+		stop:      make(chan struct{}),
+	}
+
+	voicemailMtx.Lock()
+	if _, exists := voicemailSessions[friendnumber]; exists {
+		voicemailMtx.Unlock()
+		return
+	}
+	voicemailSessions[friendnumber] = session
+	voicemailMtx.Unlock()
+
+	// only receive audio, the greeting is the only thing we send
+	if _, err = toxav.Answer(friendnumber, getConfig().Calls.AudioBitRate, 0); err != nil {
+		log.Println("[ERROR] Voicemail: could not answer the call:", err)
+		voicemailMtx.Lock()
+		delete(voicemailSessions, friendnumber)
+		voicemailMtx.Unlock()
+		return
+	}
+	log.Println("Voicemail answered the call of friend", friendnumber)
+
+	playGreeting(session)
+
+	voicemailMtx.Lock()
+	defer voicemailMtx.Unlock()
+
+	select {
+	case <-session.stop:
+		// the caller hung up during the greeting
+		return
+	default:
+	}
+
+	session.recording = true
+	session.limit = time.AfterFunc(getConfig().Calls.VoicemailMaxDuration.Duration, func() {
+		voicemailMtx.Lock()
+		current := voicemailSessions[friendnumber] == session
+		voicemailMtx.Unlock()
+		if !current {
+			return
+		}
+
+		if err := toxav.Cancel(friendnumber); err != nil {
+			log.Println("[ERROR] Voicemail: could not hang up:", err)
+		}
+		// hanging up ourselves does not trigger onCallState
+		endVoicemail(friendnumber)
+	})
+}
+
+// playGreeting sends the configured greeting to the caller in 20 ms frames.
+// A missing greeting is skipped.
+// session  the session to play the greeting in
+func playGreeting(session *voicemailSession) {
+	greeting := getConfig().Calls.VoicemailGreeting
+	f, err := os.Open(greeting)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("[ERROR] Voicemail: could not open greeting:", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	wav, err := toxmedia.NewWAVReader(f)
+	if err != nil {
+		log.Println("[ERROR] Voicemail:", greeting+":", err)
+		return
+	}
+	framer, err := wav.Framer(libtoxav.DefaultSamplingRate, wav.Channels)
+	if err != nil {
+		log.Println("[ERROR] Voicemail:", greeting+":", err)
+		return
+	}
+
+	ticker := time.NewTicker(libtoxav.DefaultFrameDuration)
+	defer ticker.Stop()
+
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-session.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := toxav.AudioSendFrame(session.friend, frame); err != nil && err != libtoxav.ErrSendFramePayloadTypeDisabled {
+			log.Println("[ERROR] Voicemail: could not send greeting:", err)
+			return
+		}
+	}
+}
+
+// recordVoicemail appends a received audio frame to the recording of the
+// friend, if the voicemail is recording the friend
+// friendnumber  the friend who sent the frame
+// frame         the received audio frame
+func recordVoicemail(friendnumber uint32, frame *libtoxav.AudioFrame) {
+	voicemailMtx.Lock()
+	defer voicemailMtx.Unlock()
+
+	session, ok := voicemailSessions[friendnumber]
+	if !ok || !session.recording {
+		return
+	}
+
+	frame, err := toCallFormat(frame)
+	if err != nil {
+		return
+	}
+
+	session.pcm.Write(frame.Bytes())
+}
+
+// endVoicemail ends the voicemail session of a friend and stores the
+// recording, if anything was recorded
+// friendnumber  the friend whose call ended
+func endVoicemail(friendnumber uint32) {
+	voicemailMtx.Lock()
+	session, ok := voicemailSessions[friendnumber]
+	if ok {
+		delete(voicemailSessions, friendnumber)
+		close(session.stop)
+		if session.limit != nil {
+			session.limit.Stop()
+		}
+	}
+	voicemailMtx.Unlock()
+
+	if ok {
+		closeVideoFeed(friendnumber)
+	}
+	if !ok || session.pcm.Len() == 0 {
+		return
+	}
+
+	samples := int64(session.pcm.Len() / (2 * libtoxav.DefaultChannels))
+	duration := samples * 1000 / int64(libtoxav.DefaultSamplingRate)
+	audio := toxmedia.EncodeWAV(session.pcm.Bytes(), libtoxav.DefaultSamplingRate, libtoxav.DefaultChannels)
+
+	id, err := storage.StoreVoicemail(session.publicKey, duration, audio)
+	if err != nil {
+		log.Println("[ERROR] Voicemail could not be stored:", err)
+		return
+	}
+	log.Printf("Stored voicemail %d from friend %d (%d ms)\n", id, friendnumber, duration)
+
+	type jsonEvent struct {
+		Type      string `json:"type"`
+		ID        int64  `json:"id"`
+		PublicKey string `json:"publicKey"`
+		Number    uint32 `json:"number"`
+		Time      int64  `json:"time"`
+		Duration  int64  `json:"duration"`
+	}
+
+	e, _ := json.Marshal(jsonEvent{
+		Type:      "voicemail",
+		ID:        id,
+		PublicKey: session.publicKey,
+		Number:    friendnumber,
+		Time:      time.Now().Unix() * 1000,
+		Duration:  duration,
+	})
+
+	broadcastToClients(string(e))
+}
+
+// cancelVoicemails hangs up all calls answered by the voicemail and stores
+// what was recorded so far
+func cancelVoicemails() {
+	voicemailMtx.Lock()
+	var friends []uint32
+	for friendnumber := range voicemailSessions {
+		friends = append(friends, friendnumber)
+	}
+	voicemailMtx.Unlock()
+
+	for _, friendnumber := range friends {
+		toxav.Cancel(friendnumber)
+		endVoicemail(friendnumber)
+	}
+}
@@ -0,0 +1,103 @@
+package libtox
+
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// BufferMode selects how the payload of high-rate callbacks (friend and
+// conference messages, custom packets and received file chunks) is handed to
+// the callback.
+type BufferMode int32
+
+const (
+	// BufferCopy hands every callback a freshly allocated copy of the
+	// payload. This is the default.
+	BufferCopy BufferMode = iota
+
+	// BufferBorrowed hands the callback a slice that points directly into
+	// toxcore's memory. The slice is only valid until the callback returns
+	// and must be copied if it is needed later. No allocation is made.
+	BufferBorrowed
+
+	// BufferPooled hands the callback a buffer taken from a pool. The
+	// callback owns the buffer and should give it back with ReleaseBuffer
+	// once it is done with it (possibly after the callback returned).
+	BufferPooled
+)
+
+// poolBufferSize is large enough for every payload delivered by toxcore: file
+// chunks, messages and custom packets.
+const poolBufferSize = TOX_MAX_CUSTOM_PACKET_SIZE
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := new([poolBufferSize]byte)
+		runtime.SetFinalizer(buffer, forgetBuffer)
+		return buffer
+	},
+}
+
+// pooledOut holds the addresses of the pool buffers handed to callbacks and
+// not released yet, so ReleaseBuffer only takes back buffers of the pool. The
+// addresses do not keep the buffers alive: a buffer that is never released is
+// removed by its finalizer when it is collected.
+var (
+	pooledMtx sync.Mutex
+	pooledOut = make(map[uintptr]bool)
+)
+
+// forgetBuffer removes a collected pool buffer from pooledOut
+func forgetBuffer(buffer *[poolBufferSize]byte) {
+	pooledMtx.Lock()
+	delete(pooledOut, uintptr(unsafe.Pointer(buffer)))
+	pooledMtx.Unlock()
+}
+
+/* SetBufferMode sets how the payload of message, custom packet and file chunk
+ * callbacks is handed over. See BufferMode. */
+func (t *Tox) SetBufferMode(mode BufferMode) {
+	t.bufferMode.Store(int32(mode))
+}
+
+/* GetBufferMode returns the BufferMode set with SetBufferMode. */
+func (t *Tox) GetBufferMode() BufferMode {
+	return BufferMode(t.bufferMode.Load())
+}
+
+// pooledBuffer returns a buffer of the given length from the pool. Lengths
+// larger than poolBufferSize get a regular allocation.
+func pooledBuffer(length int) []byte {
+	if length > poolBufferSize {
+		return make([]byte, length)
+	}
+
+	buffer := bufferPool.Get().(*[poolBufferSize]byte)
+	pooledMtx.Lock()
+	pooledOut[uintptr(unsafe.Pointer(buffer))] = true
+	pooledMtx.Unlock()
+
+	return buffer[:length]
+}
+
+/* ReleaseBuffer returns a buffer handed to a callback in BufferPooled mode to
+ * the pool. The buffer must not be used afterwards. Buffers that did not come
+ * from the pool, or were already released, are ignored. */
+func ReleaseBuffer(buffer []byte) {
+	if cap(buffer) != poolBufferSize {
+		return
+	}
+
+	pooled := (*[poolBufferSize]byte)(buffer[:poolBufferSize])
+	key := uintptr(unsafe.Pointer(pooled))
+
+	pooledMtx.Lock()
+	out := pooledOut[key]
+	delete(pooledOut, key)
+	pooledMtx.Unlock()
+
+	if out {
+		bufferPool.Put(pooled)
+	}
+}
@@ -0,0 +1,149 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"testing"
+)
+
+func TestReleaseBuffer(t *testing.T) {
+	tests := []struct {
+		name    string
+		release func() []byte // releases a buffer and returns it
+		foreign bool          // the buffer must not be handed out by the pool
+	}{
+		{"foreign buffer", func() []byte {
+			buffer := make([]byte, 10, poolBufferSize)
+			ReleaseBuffer(buffer)
+			return buffer
+		}, true},
+		{"released twice", func() []byte {
+			buffer := pooledBuffer(10)
+			ReleaseBuffer(buffer)
+			ReleaseBuffer(buffer)
+			return buffer
+		}, false},
+		{"part of a pooled buffer", func() []byte {
+			buffer := pooledBuffer(10)
+			ReleaseBuffer(buffer[1:])
+			ReleaseBuffer(buffer[:5:5])
+			return buffer
+		}, true},
+		{"larger than the pool buffers", func() []byte {
+			buffer := pooledBuffer(poolBufferSize + 1)
+			ReleaseBuffer(buffer)
+			return buffer
+		}, true},
+		{"nil", func() []byte {
+			ReleaseBuffer(nil)
+			return nil
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := tt.release()
+
+			a, b := pooledBuffer(10), pooledBuffer(10)
+			defer ReleaseBuffer(a)
+			defer ReleaseBuffer(b)
+
+			if &a[:1][0] == &b[:1][0] {
+				t.Fatal("pooledBuffer() returned the same buffer twice")
+			}
+			if tt.foreign && (&a[:1][0] == &buffer[:1][0] || &b[:1][0] == &buffer[:1][0]) {
+				t.Fatal("pooledBuffer() returned a buffer that is not owned by the pool")
+			}
+		})
+	}
+}
+
+func BenchmarkPayload(b *testing.B) {
+	modes := []struct {
+		name string
+		mode BufferMode
+	}{
+		{"Copy", BufferCopy},
+		{"Borrowed", BufferBorrowed},
+		{"Pooled", BufferPooled},
+	}
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"Message", 128},
+		{"Chunk", simChunkSize},
+	}
+
+	for _, m := range modes {
+		for _, s := range sizes {
+			b.Run(m.name+"/"+s.name, func(b *testing.B) {
+				tox := &Tox{}
+				tox.SetBufferMode(m.mode)
+				data := make([]byte, s.size)
+
+				b.SetBytes(int64(s.size))
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					buffer := tox.payload(data)
+					if m.mode == BufferPooled {
+						ReleaseBuffer(buffer)
+					}
+				}
+			})
+		}
+	}
+}
+
+// benchmarkFileRecvChunk measures receiving b.N file chunks of the largest
+// size in the given BufferMode
+func benchmarkFileRecvChunk(b *testing.B, mode BufferMode) {
+	p := newSimPair(b)
+	p.net.SetChunksPerIterate(64)
+	p.b.SetBufferMode(mode)
+
+	chunk := make([]byte, simChunkSize)
+	p.a.CallbackFileChunkRequest(func(tox *Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+		if length > 0 {
+			tox.FileSendChunk(friendnumber, filenumber, position, chunk[:length])
+		}
+	})
+	p.b.CallbackFileRecv(func(tox *Tox, friendnumber uint32, filenumber uint32, kind ToxFileKind, filesize uint64, filename string, length uint32) {
+		tox.FileControl(friendnumber, filenumber, TOX_FILE_CONTROL_RESUME)
+	})
+	var received uint64
+	p.b.CallbackFileRecvChunk(func(tox *Tox, friendnumber uint32, filenumber uint32, position uint64, data []byte, length uint32) {
+		received += uint64(length)
+		if mode == BufferPooled {
+			ReleaseBuffer(data)
+		}
+	})
+
+	size := uint64(b.N) * simChunkSize
+	if _, err := p.a.FileSend(0, TOX_FILE_KIND_DATA, size, nil, "file"); err != nil {
+		b.Fatal(err)
+	}
+	p.net.Settle(10)
+
+	b.SetBytes(simChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for received < size {
+		p.net.IterateAll()
+	}
+}
+
+func BenchmarkFileRecvChunkCopy(b *testing.B) {
+	benchmarkFileRecvChunk(b, BufferCopy)
+}
+
+func BenchmarkFileRecvChunkBorrowed(b *testing.B) {
+	benchmarkFileRecvChunk(b, BufferBorrowed)
+}
+
+func BenchmarkFileRecvChunkPooled(b *testing.B) {
+	benchmarkFileRecvChunk(b, BufferPooled)
+}
@@ -1,8 +1,9 @@
+//go:build !toxsim
+
 package libtox
 
 //#include <tox/tox.h>
 import "C"
-import "errors"
 
 const (
 	TOX_PUBLIC_KEY_SIZE           = C.TOX_PUBLIC_KEY_SIZE           //32
@@ -91,22 +92,6 @@ var (
 )
 
 /* === Errors === */
-// General errors
-var (
-	ErrToxNew   = errors.New("Error initializing Tox")
-	ErrToxInit  = errors.New("Tox not initialized")
-	ErrArgs     = errors.New("Nil arguments or wrong size")
-	ErrFuncFail = errors.New("Function failed")
-	ErrUnknown  = errors.New("An unknown error occoured")
-)
-
-var (
-	ErrNewMalloc        = errors.New("Memory allocation failed")
-	ErrNewPortAlloc     = errors.New("Could not bind to port")
-	ErrNewProxy         = errors.New("Invalid proxy configuration")
-	ErrNewLoadEnc       = errors.New("The savedata is encrypted")
-	ErrNewLoadBadFormat = errors.New("The savedata format is invalid")
-)
 
 type ToxErrNew C.TOX_ERR_NEW
 
@@ -132,16 +117,6 @@ var (
 	TOX_ERR_BOOTSTRAP_BAD_PORT ToxErrBootstrap = C.TOX_ERR_BOOTSTRAP_BAD_PORT
 )
 
-var (
-	ErrFriendAddTooLong      = errors.New("Message too long")
-	ErrFriendAddNoMessage    = errors.New("Empty message")
-	ErrFriendAddOwnKey       = errors.New("Own key")
-	ErrFriendAddAlreadySent  = errors.New("Already sent")
-	ErrFriendAddBadChecksum  = errors.New("Bad checksum in address")
-	ErrFriendAddSetNewNospam = errors.New("Different nospam")
-	ErrFriendAddNoMem        = errors.New("Failed increasing friend list")
-)
-
 type ToxErrFriendAdd C.TOX_ERR_FRIEND_ADD
 
 var (
@@ -254,10 +229,6 @@ var (
 	TOX_ERR_FILE_GET_NOT_FOUND        ToxErrFileGet = C.TOX_ERR_FILE_GET_NOT_FOUND
 )
 
-var (
-	ErrFileSendInvalidFileID = errors.New("The size of the given FileID is invalid.")
-)
-
 type ToxErrFileSend C.TOX_ERR_FILE_SEND
 
 var (
@@ -303,10 +274,6 @@ var (
 	TOX_ERR_GET_PORT_NOT_BOUND ToxErrGetPort = C.TOX_ERR_GET_PORT_NOT_BOUND
 )
 
-var (
-	ErrConferenceNewFailedInitialize = errors.New("conference instance failed to initialize")
-)
-
 // Conference
 
 type ToxErrConferenceNew C.TOX_ERR_CONFERENCE_NEW
@@ -316,11 +283,6 @@ var (
 	TOX_ERR_CONFERENCE_NEW_INIT ToxErrConferenceNew = C.TOX_ERR_CONFERENCE_NEW_INIT //The conference instance failed to initialize.
 )
 
-var (
-	ErrConferenceDeleteFailed             = errors.New("delete conference failed")
-	ErrConferenceDeleteConferenceNotFound = errors.New("the conference number passed did not designate a valid conference.")
-)
-
 type ToxErrConferenceDelete C.TOX_ERR_CONFERENCE_DELETE
 
 var (
@@ -337,12 +299,6 @@ var (
 	TOX_ERR_CONFERENCE_PEER_QUERY_NO_CONNECTION        ToxErrConferencePeerQuery = C.TOX_ERR_CONFERENCE_PEER_QUERY_NO_CONNECTION
 )
 
-var (
-	ErrConferenceInviteConferenceNotFound = errors.New("The conference number passed did not designate a valid conference")
-	ErrConferenceInviteFailSend           = errors.New("The invite packet failed to send")
-	ErrConferenceInviteNoConnection       = errors.New("The client is not connected to the conference")
-)
-
 type ToxErrConferenceInvite C.TOX_ERR_CONFERENCE_INVITE
 
 var (
@@ -0,0 +1,71 @@
+package libtox
+
+import "errors"
+
+/* === Errors === */
+// General errors
+var (
+	ErrToxNew   = errors.New("Error initializing Tox")
+	ErrToxInit  = errors.New("Tox not initialized")
+	ErrArgs     = errors.New("Nil arguments or wrong size")
+	ErrFuncFail = errors.New("Function failed")
+	ErrUnknown  = errors.New("An unknown error occoured")
+)
+
+var (
+	ErrNewMalloc        = errors.New("Memory allocation failed")
+	ErrNewPortAlloc     = errors.New("Could not bind to port")
+	ErrNewProxy         = errors.New("Invalid proxy configuration")
+	ErrNewLoadEnc       = errors.New("The savedata is encrypted")
+	ErrNewLoadBadFormat = errors.New("The savedata format is invalid")
+)
+
+var (
+	ErrFriendAddTooLong      = errors.New("Message too long")
+	ErrFriendAddNoMessage    = errors.New("Empty message")
+	ErrFriendAddOwnKey       = errors.New("Own key")
+	ErrFriendAddAlreadySent  = errors.New("Already sent")
+	ErrFriendAddBadChecksum  = errors.New("Bad checksum in address")
+	ErrFriendAddSetNewNospam = errors.New("Different nospam")
+	ErrFriendAddNoMem        = errors.New("Failed increasing friend list")
+)
+
+var (
+	ErrFriendSendMessageFriendNotFound     = errors.New("The friend number did not designate a valid friend")
+	ErrFriendSendMessageFriendNotConnected = errors.New("This client is currently not connected to the friend")
+	ErrFriendSendMessageSendq              = errors.New("An allocation error occurred while increasing the send queue size")
+	ErrFriendSendMessageTooLong            = errors.New("Message length exceeded TOX_MAX_MESSAGE_LENGTH")
+)
+
+var (
+	ErrFileSendInvalidFileID = errors.New("The size of the given FileID is invalid.")
+)
+
+var (
+	ErrFileSendChunkSendq = errors.New("Packet queue is full")
+)
+
+var (
+	ErrFriendCustomPacketSendq = errors.New("Packet queue is full")
+)
+
+var (
+	ErrConferenceNewFailedInitialize = errors.New("conference instance failed to initialize")
+)
+
+var (
+	ErrConferenceDeleteFailed             = errors.New("delete conference failed")
+	ErrConferenceDeleteConferenceNotFound = errors.New("the conference number passed did not designate a valid conference.")
+)
+
+var (
+	ErrConferenceInviteConferenceNotFound = errors.New("The conference number passed did not designate a valid conference")
+	ErrConferenceInviteFailSend           = errors.New("The invite packet failed to send")
+	ErrConferenceInviteNoConnection       = errors.New("The client is not connected to the conference")
+)
+
+var (
+	ErrPoolClosed    = errors.New("The pool is closed")
+	ErrPoolNameInUse = errors.New("An instance with this name already exists in the pool")
+	ErrPoolNotFound  = errors.New("No instance with this name in the pool")
+)
@@ -0,0 +1,169 @@
+//go:build !toxsim
+
+package libtox
+
+/*
+#include <tox/tox.h>
+#include <stdlib.h>
+
+typedef struct {
+	uint32_t number;
+	uint8_t public_key[TOX_PUBLIC_KEY_SIZE];
+	uint8_t name[TOX_MAX_NAME_LENGTH];
+	size_t name_length;
+	uint8_t status_message[TOX_MAX_STATUS_MESSAGE_LENGTH];
+	size_t status_message_length;
+	TOX_USER_STATUS status;
+	TOX_CONNECTION connection;
+	uint64_t last_online;
+} friend_info;
+
+// get_friend_info fills info with the state of one friend.
+static bool get_friend_info(const Tox *tox, uint32_t number, friend_info *info) {
+	TOX_ERR_FRIEND_QUERY err;
+
+	info->number = number;
+	if (!tox_friend_get_public_key(tox, number, info->public_key, NULL)) {
+		return false;
+	}
+
+	info->name_length = tox_friend_get_name_size(tox, number, &err);
+	if (err != TOX_ERR_FRIEND_QUERY_OK || info->name_length > TOX_MAX_NAME_LENGTH) {
+		return false;
+	}
+	tox_friend_get_name(tox, number, info->name, NULL);
+
+	info->status_message_length = tox_friend_get_status_message_size(tox, number, &err);
+	if (err != TOX_ERR_FRIEND_QUERY_OK || info->status_message_length > TOX_MAX_STATUS_MESSAGE_LENGTH) {
+		return false;
+	}
+	tox_friend_get_status_message(tox, number, info->status_message, NULL);
+
+	info->status = tox_friend_get_status(tox, number, NULL);
+	info->connection = tox_friend_get_connection_status(tox, number, NULL);
+	info->last_online = tox_friend_get_last_online(tox, number, NULL);
+
+	return true;
+}
+
+// get_friend_infos fills infos with the state of all friends and stores the
+// number of friends in count. If there are more than capacity friends,
+// nothing is filled in and the caller has to retry with a larger buffer.
+// Returns false if memory allocation failed.
+static bool get_friend_infos(const Tox *tox, friend_info *infos, size_t capacity, size_t *count) {
+	*count = tox_self_get_friend_list_size(tox);
+	if (*count == 0 || *count > capacity) {
+		return true;
+	}
+
+	uint32_t *numbers = malloc(*count * sizeof(uint32_t));
+	if (numbers == NULL) {
+		return false;
+	}
+	tox_self_get_friend_list(tox, numbers);
+
+	size_t filled = 0;
+	for (size_t i = 0; i < *count; i++) {
+		if (get_friend_info(tox, numbers[i], &infos[filled])) {
+			filled++;
+		}
+	}
+
+	free(numbers);
+	*count = filled;
+	return true;
+}
+*/
+import "C"
+import (
+	"math"
+	"time"
+	"unsafe"
+)
+
+// goFriendInfo converts a C friend_info into a FriendInfo.
+func goFriendInfo(info *C.friend_info) FriendInfo {
+	var lastOnline time.Time
+	if info.last_online != 0 && uint64(info.last_online) != math.MaxUint64 {
+		lastOnline = time.Unix(int64(info.last_online), 0)
+	}
+
+	return FriendInfo{
+		Number:        uint32(info.number),
+		PublicKey:     C.GoBytes(unsafe.Pointer(&info.public_key[0]), TOX_PUBLIC_KEY_SIZE),
+		Name:          string(C.GoBytes(unsafe.Pointer(&info.name[0]), C.int(info.name_length))),
+		StatusMessage: string(C.GoBytes(unsafe.Pointer(&info.status_message[0]), C.int(info.status_message_length))),
+		Status:        ToxUserStatus(info.status),
+		Connection:    ToxConnection(info.connection),
+		LastOnline:    lastOnline,
+	}
+}
+
+/* FriendGetInfo returns the state of the friend with the given friendNumber
+ * with a single call into toxcore. */
+func (t *Tox) FriendGetInfo(friendNumber uint32) (FriendInfo, error) {
+	if t.Toxcore == nil {
+		return FriendInfo{}, ErrToxInit
+	}
+
+	info := (*C.friend_info)(C.malloc(C.sizeof_friend_info))
+	if info == nil {
+		return FriendInfo{}, ErrFuncFail
+	}
+	defer C.free(unsafe.Pointer(info))
+
+	if !bool(C.get_friend_info(t.Toxcore, C.uint32_t(friendNumber), info)) {
+		return FriendInfo{}, ErrFuncFail
+	}
+
+	return goFriendInfo(info), nil
+}
+
+/* SelfGetFriendInfos returns the state of all friends. Unlike calling the
+ * FriendGet* functions for every friend, all friends are read in a single
+ * pass inside toxcore. */
+func (t *Tox) SelfGetFriendInfos() ([]FriendInfo, error) {
+	if t.Toxcore == nil {
+		return nil, ErrToxInit
+	}
+
+	capacity := C.size_t(C.tox_self_get_friend_list_size(t.Toxcore)) + 8
+	for {
+		infos := (*C.friend_info)(C.malloc(capacity * C.sizeof_friend_info))
+		if infos == nil {
+			return nil, ErrFuncFail
+		}
+
+		var count C.size_t
+		if !bool(C.get_friend_infos(t.Toxcore, infos, capacity, &count)) {
+			C.free(unsafe.Pointer(infos))
+			return nil, ErrFuncFail
+		}
+		if count > capacity {
+			// friends were added in the meantime
+			C.free(unsafe.Pointer(infos))
+			capacity = count + 8
+			continue
+		}
+
+		cInfos := unsafe.Slice(infos, int(count))
+		friends := make([]FriendInfo, len(cInfos))
+		for i := range cInfos {
+			friends[i] = goFriendInfo(&cInfos[i])
+		}
+		C.free(unsafe.Pointer(infos))
+
+		return friends, nil
+	}
+}
+
+// rosterAdd puts a newly added friend into the roster cache.
+func (t *Tox) rosterAdd(friendNumber uint32) {
+	if !t.RosterCacheEnabled() {
+		return
+	}
+
+	if info, err := t.FriendGetInfo(friendNumber); err == nil {
+		t.rosterPut(info)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !toxsim
+
 #include <tox/tox.h>
 
 /* Macro defined:
@@ -71,6 +73,15 @@ void hook_callback_conference_connected(Tox*, Tox_Conference_Number, void*);
 //typedef void tox_conference_message_cb(Tox *tox, Tox_Conference_Number conference_number, Tox_Conference_Peer_Number peer_number, Tox_Message_Type type, const uint8_t message[], size_t length, void *user_data);
 void hook_callback_conference_message(Tox*, Tox_Conference_Number, Tox_Conference_Peer_Number, Tox_Message_Type, const uint8_t*, size_t, void*);
 
+//typedef void tox_conference_title_cb(Tox *tox, Tox_Conference_Number conference_number, Tox_Conference_Peer_Number peer_number, const uint8_t title[], size_t length, void *user_data);
+void hook_callback_conference_title(Tox*, Tox_Conference_Number, Tox_Conference_Peer_Number, const uint8_t*, size_t, void*);
+
+//typedef void tox_conference_peer_name_cb(Tox *tox, Tox_Conference_Number conference_number, Tox_Conference_Peer_Number peer_number, const uint8_t name[], size_t length, void *user_data);
+void hook_callback_conference_peer_name(Tox*, Tox_Conference_Number, Tox_Conference_Peer_Number, const uint8_t*, size_t, void*);
+
+//typedef void tox_conference_peer_list_changed_cb(Tox *tox, Tox_Conference_Number conference_number, void *user_data);
+void hook_callback_conference_peer_list_changed(Tox*, Tox_Conference_Number, void*);
+
 CREATE_HOOK(callback_self_connection_status)
 CREATE_HOOK(callback_friend_name)
 CREATE_HOOK(callback_friend_status_message)
@@ -89,4 +100,7 @@ CREATE_HOOK(callback_friend_lossless_packet)
 
 CREATE_HOOK(callback_conference_invite)
 CREATE_HOOK(callback_conference_connected)
-CREATE_HOOK(callback_conference_message)
\ No newline at end of file
+CREATE_HOOK(callback_conference_message)
+CREATE_HOOK(callback_conference_title)
+CREATE_HOOK(callback_conference_peer_name)
+CREATE_HOOK(callback_conference_peer_list_changed)
\ No newline at end of file
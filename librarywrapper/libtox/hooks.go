@@ -1,3 +1,5 @@
+//go:build !toxsim
+
 package libtox
 
 //#include <tox/tox.h>
@@ -5,6 +7,7 @@ import "C"
 import "encoding/hex"
 import "unsafe"
 import (
+	"time"
 	"unicode/utf8"
 )
 
@@ -15,22 +18,39 @@ func hook_callback_self_connection_status(t unsafe.Pointer, status C.TOX_CONNECT
 
 //export hook_callback_friend_name
 func hook_callback_friend_name(t unsafe.Pointer, friendnumber C.uint32_t, name *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendNameChanges((*Tox)(tox), uint32(friendnumber), C.GoBytes(unsafe.Pointer(name), C.int(length)), uint32(length))
+	goName := C.GoBytes(unsafe.Pointer(name), C.int(length))
+	(*Tox)(tox).rosterUpdate(uint32(friendnumber), func(info *FriendInfo) { info.Name = string(goName) })
+	if (*Tox)(tox).onFriendNameChanges != nil {
+		(*Tox)(tox).onFriendNameChanges((*Tox)(tox), uint32(friendnumber), goName, uint32(length))
+	}
 }
 
 //export hook_callback_friend_status_message
 func hook_callback_friend_status_message(t unsafe.Pointer, friendnumber C.uint32_t, message *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendStatusMessageChanges((*Tox)(tox), uint32(friendnumber), C.GoBytes(unsafe.Pointer(message), C.int(length)), uint32(length))
+	goMessage := C.GoBytes(unsafe.Pointer(message), C.int(length))
+	(*Tox)(tox).rosterUpdate(uint32(friendnumber), func(info *FriendInfo) { info.StatusMessage = string(goMessage) })
+	if (*Tox)(tox).onFriendStatusMessageChanges != nil {
+		(*Tox)(tox).onFriendStatusMessageChanges((*Tox)(tox), uint32(friendnumber), goMessage, uint32(length))
+	}
 }
 
 //export hook_callback_friend_status
 func hook_callback_friend_status(t unsafe.Pointer, friendnumber C.uint32_t, status C.TOX_USER_STATUS, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendStatusChanges((*Tox)(tox), uint32(friendnumber), ToxUserStatus(status))
+	(*Tox)(tox).rosterUpdate(uint32(friendnumber), func(info *FriendInfo) { info.Status = ToxUserStatus(status) })
+	if (*Tox)(tox).onFriendStatusChanges != nil {
+		(*Tox)(tox).onFriendStatusChanges((*Tox)(tox), uint32(friendnumber), ToxUserStatus(status))
+	}
 }
 
 //export hook_callback_friend_connection_status
 func hook_callback_friend_connection_status(t unsafe.Pointer, friendnumber C.uint32_t, status C.TOX_CONNECTION, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendConnectionStatusChanges((*Tox)(tox), uint32(friendnumber), ToxConnection(status))
+	(*Tox)(tox).rosterUpdate(uint32(friendnumber), func(info *FriendInfo) {
+		info.Connection = ToxConnection(status)
+		info.LastOnline = time.Unix(time.Now().Unix(), 0)
+	})
+	if (*Tox)(tox).onFriendConnectionStatusChanges != nil {
+		(*Tox)(tox).onFriendConnectionStatusChanges((*Tox)(tox), uint32(friendnumber), ToxConnection(status))
+	}
 }
 
 //export hook_callback_friend_typing
@@ -50,7 +70,7 @@ func hook_callback_friend_request(t unsafe.Pointer, publicKey *C.uint8_t, messag
 
 //export hook_callback_friend_message
 func hook_callback_friend_message(t unsafe.Pointer, friendnumber C.uint32_t, messagetype C.TOX_MESSAGE_TYPE, message *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendMessage((*Tox)(tox), uint32(friendnumber), ToxMessageType(messagetype), C.GoBytes(unsafe.Pointer(message), C.int(length)), uint32(length))
+	(*Tox)(tox).onFriendMessage((*Tox)(tox), uint32(friendnumber), ToxMessageType(messagetype), (*Tox)(tox).payload(message, length), uint32(length))
 }
 
 //export hook_callback_file_recv_control
@@ -78,17 +98,17 @@ func hook_callback_file_recv(t unsafe.Pointer, friendnumber C.uint32_t, filenumb
 
 //export hook_callback_file_recv_chunk
 func hook_callback_file_recv_chunk(t unsafe.Pointer, friendnumber C.uint32_t, filenumber C.uint32_t, position C.uint64_t, data *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFileRecvChunk((*Tox)(tox), uint32(friendnumber), uint32(filenumber), uint64(position), C.GoBytes((unsafe.Pointer)(data), C.int(length)), uint32(length))
+	(*Tox)(tox).onFileRecvChunk((*Tox)(tox), uint32(friendnumber), uint32(filenumber), uint64(position), (*Tox)(tox).payload(data, length), uint32(length))
 }
 
 //export hook_callback_friend_lossy_packet
 func hook_callback_friend_lossy_packet(t unsafe.Pointer, friendnumber C.uint32_t, data *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendLossyPacket((*Tox)(tox), uint32(friendnumber), C.GoBytes((unsafe.Pointer)(data), C.int(length)), uint32(length))
+	(*Tox)(tox).onFriendLossyPacket((*Tox)(tox), uint32(friendnumber), (*Tox)(tox).payload(data, length), uint32(length))
 }
 
 //export hook_callback_friend_lossless_packet
 func hook_callback_friend_lossless_packet(t unsafe.Pointer, friendnumber C.uint32_t, data *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onFriendLosslessPacket((*Tox)(tox), uint32(friendnumber), C.GoBytes((unsafe.Pointer)(data), (C.int)(length)), uint32(length))
+	(*Tox)(tox).onFriendLosslessPacket((*Tox)(tox), uint32(friendnumber), (*Tox)(tox).payload(data, length), uint32(length))
 }
 
 //export hook_callback_conference_invite
@@ -103,5 +123,35 @@ func hook_callback_conference_connected(t unsafe.Pointer, conferencenumber C.uin
 
 //export hook_callback_conference_message
 func hook_callback_conference_message(t unsafe.Pointer, conferencenumber C.uint32_t, peernumber C.uint32_t, messagetype C.Tox_Message_Type, message *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
-	(*Tox)(tox).onConferenceMessage((*Tox)(tox), uint32(conferencenumber), uint32(peernumber), ToxMessageType(messagetype), C.GoBytes(unsafe.Pointer(message), C.int(length)), uint32(length))
+	(*Tox)(tox).onConferenceMessage((*Tox)(tox), uint32(conferencenumber), uint32(peernumber), ToxMessageType(messagetype), (*Tox)(tox).payload(message, length), uint32(length))
+}
+
+//export hook_callback_conference_title
+func hook_callback_conference_title(t unsafe.Pointer, conferencenumber C.uint32_t, peernumber C.uint32_t, title *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
+	(*Tox)(tox).onConferenceTitle((*Tox)(tox), uint32(conferencenumber), uint32(peernumber), C.GoBytes(unsafe.Pointer(title), C.int(length)), uint32(length))
+}
+
+//export hook_callback_conference_peer_name
+func hook_callback_conference_peer_name(t unsafe.Pointer, conferencenumber C.uint32_t, peernumber C.uint32_t, name *C.uint8_t, length C.size_t, tox unsafe.Pointer) {
+	(*Tox)(tox).onConferencePeerName((*Tox)(tox), uint32(conferencenumber), uint32(peernumber), C.GoBytes(unsafe.Pointer(name), C.int(length)), uint32(length))
+}
+
+//export hook_callback_conference_peer_list_changed
+func hook_callback_conference_peer_list_changed(t unsafe.Pointer, conferencenumber C.uint32_t, tox unsafe.Pointer) {
+	(*Tox)(tox).onConferencePeerListChanged((*Tox)(tox), uint32(conferencenumber))
+}
+
+// payload hands the payload of a callback over according to the BufferMode of
+// t.
+func (t *Tox) payload(data *C.uint8_t, length C.size_t) []byte {
+	switch t.GetBufferMode() {
+	case BufferBorrowed:
+		return unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length))
+	case BufferPooled:
+		buffer := pooledBuffer(int(length))
+		copy(buffer, unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length)))
+		return buffer
+	}
+
+	return C.GoBytes(unsafe.Pointer(data), C.int(length))
 }
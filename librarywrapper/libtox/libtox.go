@@ -1,3 +1,5 @@
+//go:build !toxsim
+
 package libtox
 
 //#cgo LDFLAGS: -ltoxcore
@@ -11,6 +13,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -25,6 +28,9 @@ type Tox struct {
 	cOptions *C.struct_Tox_Options
 	Toxcore  *C.Tox
 	mtx      sync.Mutex
+	roster   atomic.Pointer[rosterCache]
+
+	bufferMode atomic.Int32
 
 	// Callbacks
 	onSelfConnectionStatusChanges   OnSelfConnectionStatusChanges
@@ -43,49 +49,12 @@ type Tox struct {
 	onFriendLossyPacket             OnFriendLossyPacket
 	onFriendLosslessPacket          OnFriendLosslessPacket
 
-	onConferenceInvite    OnConferenceInvite
-	onConferenceMessage   OnConferenceMessage
-	onConferenceConnected OnConferenceConnected
-}
-
-// Options tox option params
-type Options struct {
-	/* The type of socket to create.
-	 * If IPv6Enabled is true, both IPv6 and IPv4 connections are allowed.
-	 */
-	IPv6Enabled bool
-
-	/* Enable the use of UDP communication when available.
-	 *
-	 * Setting this to false will force Tox to use TCP only. Communications will
-	 * need to be relayed through a TCP relay node, potentially slowing them down.
-	 * Disabling UDP support is necessary when using anonymous proxies or Tor.
-	 */
-	UDPEnabled bool
-
-	/* The type of the proxy (PROXY_TYPE_NONE, PROXY_TYPE_HTTP or PROXY_TYPE_SOCKS5). */
-	ProxyType ToxProxyType
-
-	/* The IP address or DNS name of the proxy to be used. */
-	ProxyHost string
-
-	/* The port to use to connect to the proxy server. */
-	ProxyPort uint16
-
-	/* The start port of the inclusive port range to attempt to use. */
-	StartPort uint16
-
-	/* The end port of the inclusive port range to attempt to use. */
-	EndPort uint16
-
-	/* The port to use for the TCP server. If 0, the tcp server is disabled. */
-	TcpPort uint16
-
-	/* The type of savedata to load from. */
-	SaveDataType ToxSaveDataType
-
-	/* The savedata. */
-	SaveData []byte
+	onConferenceInvite          OnConferenceInvite
+	onConferenceMessage         OnConferenceMessage
+	onConferenceConnected       OnConferenceConnected
+	onConferenceTitle           OnConferenceTitle
+	onConferencePeerName        OnConferencePeerName
+	onConferencePeerListChanged OnConferencePeerListChanged
 }
 
 //=================
@@ -208,8 +177,11 @@ func (t *Tox) Kill() error {
 		return ErrToxInit
 	}
 
+	t.mtx.Lock()
 	C.tox_options_free(t.cOptions)
 	C.tox_kill(t.Toxcore)
+	t.Toxcore = nil
+	t.mtx.Unlock()
 
 	return nil
 }
@@ -337,13 +309,14 @@ func (t *Tox) IterationInterval() (uint32, error) {
 /* Iterate is the main loop. It needs to be called every IterationInterval()
  * milliseconds. */
 func (t *Tox) Iterate() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.Toxcore == nil {
 		return ErrToxInit
 	}
 
-	t.mtx.Lock()
 	C.tox_iterate(t.Toxcore, unsafe.Pointer(t))
-	t.mtx.Unlock()
 
 	return nil
 }
@@ -557,6 +530,7 @@ func (t *Tox) FriendAdd(address []byte, message string) (uint32, error) {
 
 	switch ToxErrFriendAdd(toxErrFriendAdd) {
 	case TOX_ERR_FRIEND_ADD_OK:
+		t.rosterAdd(uint32(ret))
 		return uint32(ret), nil
 	case TOX_ERR_FRIEND_ADD_NULL:
 		return uint32(ret), ErrArgs
@@ -601,6 +575,7 @@ func (t *Tox) FriendAddNorequest(publickey []byte) (uint32, error) {
 
 	switch ToxErrFriendAdd(toxErrFriendAdd) {
 	case TOX_ERR_FRIEND_ADD_OK:
+		t.rosterAdd(uint32(ret))
 		return uint32(ret), nil
 	case TOX_ERR_FRIEND_ADD_NULL:
 		return uint32(ret), ErrArgs
@@ -636,6 +611,7 @@ func (t *Tox) FriendDelete(friendNumber uint32) error {
 
 	switch ToxErrFriendDelete(toxErrFriendDelete) {
 	case TOX_ERR_FRIEND_DELETE_OK:
+		t.rosterDelete(friendNumber)
 		return nil
 	case TOX_ERR_FRIEND_DELETE_FRIEND_NOT_FOUND:
 		return ErrArgs
@@ -930,11 +906,22 @@ func (t *Tox) FriendSendMessage(friendNumber uint32, messagetype ToxMessageType,
 	var toxFriendSendMessageError C.TOX_ERR_FRIEND_SEND_MESSAGE = C.TOX_ERR_FRIEND_SEND_MESSAGE_OK
 	n := C.tox_friend_send_message(t.Toxcore, (C.uint32_t)(friendNumber), cMessageType, cMessage, (C.size_t)(len(message)), &toxFriendSendMessageError)
 
-	if ToxErrFriendSendMessage(toxFriendSendMessageError) != TOX_ERR_FRIEND_SEND_MESSAGE_OK {
+	switch ToxErrFriendSendMessage(toxFriendSendMessageError) {
+	case TOX_ERR_FRIEND_SEND_MESSAGE_OK:
+		return uint32(n), nil
+	case TOX_ERR_FRIEND_SEND_MESSAGE_NULL:
+		return 0, ErrArgs
+	case TOX_ERR_FRIEND_SEND_MESSAGE_FRIEND_NOT_FOUND:
+		return 0, ErrFriendSendMessageFriendNotFound
+	case TOX_ERR_FRIEND_SEND_MESSAGE_FRIEND_NOT_CONNECTED:
+		return 0, ErrFriendSendMessageFriendNotConnected
+	case TOX_ERR_FRIEND_SEND_MESSAGE_SENDQ:
+		return 0, ErrFriendSendMessageSendq
+	case TOX_ERR_FRIEND_SEND_MESSAGE_TOO_LONG:
+		return 0, ErrFriendSendMessageTooLong
+	default:
 		return 0, ErrFuncFail
 	}
-
-	return uint32(n), nil
 }
 
 /* Hash generates a cryptographic hash of the given data (can be used to cache
@@ -1080,6 +1067,9 @@ func (t *Tox) FileSendChunk(friendNumber uint32, fileNumber uint32, position uin
 	var toxErrFileSendChunk C.TOX_ERR_FILE_SEND_CHUNK
 	success := C.tox_file_send_chunk(t.Toxcore, (C.uint32_t)(friendNumber), (C.uint32_t)(fileNumber), (C.uint64_t)(position), cData, (C.size_t)(len(data)), &toxErrFileSendChunk)
 
+	if ToxErrFileSendChunk(toxErrFileSendChunk) == TOX_ERR_FILE_SEND_CHUNK_SENDQ {
+		return ErrFileSendChunkSendq
+	}
 	if !bool(success) || ToxErrFileSendChunk(toxErrFileSendChunk) != TOX_ERR_FILE_SEND_CHUNK_OK {
 		return ErrFuncFail
 	}
@@ -1110,6 +1100,8 @@ func (t *Tox) FriendSendLossyPacket(friendNumber uint32, data []byte) error {
 		return nil
 	case TOX_ERR_FRIEND_CUSTOM_PACKET_NULL:
 		return ErrArgs
+	case TOX_ERR_FRIEND_CUSTOM_PACKET_SENDQ:
+		return ErrFriendCustomPacketSendq
 	default:
 		return ErrFuncFail
 	}
@@ -1141,6 +1133,8 @@ func (t *Tox) FriendSendLosslessPacket(friendNumber uint32, data []byte) error {
 		return nil
 	case TOX_ERR_FRIEND_CUSTOM_PACKET_NULL:
 		return ErrArgs
+	case TOX_ERR_FRIEND_CUSTOM_PACKET_SENDQ:
+		return ErrFriendCustomPacketSendq
 	default:
 		return ErrFuncFail
 	}
@@ -1404,6 +1398,9 @@ func (t *Tox) ConferenceGetTitle(conferenceNumber uint32) (string, error) {
 	if err != nil {
 		return "", ErrFuncFail
 	}
+	if length == 0 {
+		return "", nil
+	}
 	title := make([]byte, length)
 	var toxErrConferenceTitle C.Tox_Err_Conference_Title
 	success := C.tox_conference_get_title(t.Toxcore, (C.uint32_t)(conferenceNumber), (*C.uint8_t)(&title[0]), &toxErrConferenceTitle)
@@ -1432,8 +1429,8 @@ func (t *Tox) ConferencePeerNumberIsOurs(conferenceNumber, peerNumber uint32) (b
 		return false, ErrToxInit
 	}
 	var toxErrConferencePeerQuery C.Tox_Err_Conference_Peer_Query
-	ret := C.tox_conference_peer_number_is_ours(t.Toxcore, (C.uint32_t)(conferenceNumber), (C.uint32_t)(conferenceNumber), &toxErrConferencePeerQuery)
-	if !ret || ToxErrConferencePeerQuery(toxErrConferencePeerQuery) != TOX_ERR_CONFERENCE_PEER_QUERY_OK {
+	ret := C.tox_conference_peer_number_is_ours(t.Toxcore, (C.uint32_t)(conferenceNumber), (C.uint32_t)(peerNumber), &toxErrConferencePeerQuery)
+	if ToxErrConferencePeerQuery(toxErrConferencePeerQuery) != TOX_ERR_CONFERENCE_PEER_QUERY_OK {
 		return false, ErrFuncFail
 	}
 	return bool(ret), nil
@@ -1580,12 +1577,11 @@ func (t *Tox) ConferenceGetIdentifier(conferenceNumber uint32) (string, error) {
 		return "", ErrToxInit
 	}
 
-	idbuf := [1 + C.TOX_PUBLIC_KEY_SIZE]byte{}
+	idbuf := [TOX_CONFERENCE_ID_SIZE]byte{}
 
-	C.tox_conference_get_id(t.Toxcore, (C.uint32_t)(conferenceNumber), (*C.uint8_t)(&idbuf[0]))
-
-	identifier := strings.ToUpper(hex.EncodeToString(idbuf[:]))
-	identifier = identifier[2:] // 1B(type)+32B(identifier)
+	if !bool(C.tox_conference_get_id(t.Toxcore, (C.uint32_t)(conferenceNumber), (*C.uint8_t)(&idbuf[0]))) {
+		return "", ErrFuncFail
+	}
 
-	return identifier, nil
+	return strings.ToUpper(hex.EncodeToString(idbuf[:])), nil
 }
@@ -0,0 +1,492 @@
+package libtox
+
+import (
+	"container/heap"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pool hosts many Tox instances in one process.
+/*
+ * Instead of one goroutine and ticker per instance, a Pool drives Iterate of
+ * all its instances from a small set of workers. Every instance is iterated
+ * again after its own IterationInterval. The Pool also hands out UDP and TCP
+ * ports from its port ranges, so instances never try to bind the same port.
+ *
+ * Instances are identified by a name chosen by the caller (e.g. the bot
+ * name). They can be added, removed and saved while the Pool is running.
+ */
+type Pool struct {
+	mtx     sync.Mutex
+	options PoolOptions
+
+	instances map[string]*poolInstance
+	queue     poolQueue
+	usedUDP   map[uint16]string
+	usedTCP   map[uint16]string
+
+	wake   chan struct{}
+	work   chan *poolInstance
+	quit   chan struct{}
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// Workers is the number of goroutines calling Iterate. Defaults to the
+	// number of CPUs.
+	Workers int
+
+	// StartPort and EndPort are the UDP port range shared by all instances.
+	// Defaults to 33445-34445 like toxcore.
+	StartPort uint16
+	EndPort   uint16
+
+	// TCPStartPort and TCPEndPort are the port range for instances acting as
+	// TCP relays. If TCPStartPort is 0, instances do not run a TCP relay.
+	TCPStartPort uint16
+	TCPEndPort   uint16
+}
+
+// InstanceHealth is a snapshot of the state of one instance in a Pool.
+type InstanceHealth struct {
+	Name          string
+	Connection    ToxConnection
+	DHTConnected  bool
+	FriendCount   int64
+	OnlineFriends int64
+	UDPPort       uint16
+	TCPPort       uint16
+	LastIterate   time.Time
+}
+
+type poolInstance struct {
+	name string
+	tox  *Tox
+	mtx  sync.Mutex
+
+	udpPort uint16
+	tcpPort uint16
+
+	// scheduling, guarded by Pool.mtx
+	next        time.Time
+	index       int
+	removed     bool
+	lastIterate time.Time
+}
+
+// poolQueue is a min-heap of instances ordered by their next Iterate.
+type poolQueue []*poolInstance
+
+func (q poolQueue) Len() int           { return len(q) }
+func (q poolQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q poolQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *poolQueue) Push(x interface{}) {
+	instance := x.(*poolInstance)
+	instance.index = len(*q)
+	*q = append(*q, instance)
+}
+
+func (q *poolQueue) Pop() interface{} {
+	old := *q
+	instance := old[len(old)-1]
+	old[len(old)-1] = nil
+	instance.index = -1
+	*q = old[:len(old)-1]
+	return instance
+}
+
+/* NewPool creates a Pool and starts its workers. options may be nil. */
+func NewPool(options *PoolOptions) *Pool {
+	p := &Pool{
+		instances: make(map[string]*poolInstance),
+		usedUDP:   make(map[uint16]string),
+		usedTCP:   make(map[uint16]string),
+		wake:      make(chan struct{}, 1),
+		work:      make(chan *poolInstance),
+		quit:      make(chan struct{}),
+	}
+	if options != nil {
+		p.options = *options
+	}
+	if p.options.Workers <= 0 {
+		p.options.Workers = runtime.NumCPU()
+	}
+	if p.options.StartPort == 0 && p.options.EndPort == 0 {
+		p.options.StartPort, p.options.EndPort = 33445, 34445
+	}
+	if p.options.StartPort > p.options.EndPort {
+		p.options.StartPort, p.options.EndPort = p.options.EndPort, p.options.StartPort
+	}
+	if p.options.TCPStartPort > p.options.TCPEndPort {
+		p.options.TCPStartPort, p.options.TCPEndPort = p.options.TCPEndPort, p.options.TCPStartPort
+	}
+
+	p.wg.Add(1 + p.options.Workers)
+	go p.schedule()
+	for i := 0; i < p.options.Workers; i++ {
+		go p.iterate()
+	}
+
+	return p
+}
+
+/* Add creates a new instance with the given name and starts iterating it.
+ * The ports in options are replaced by ports allocated from the ranges of the
+ * Pool. options may be nil. */
+func (p *Pool) Add(name string, options *Options) (*Tox, error) {
+	var opts Options
+	if options == nil {
+		opts = Options{IPv6Enabled: true, UDPEnabled: true}
+	} else {
+		opts = *options
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if _, exists := p.instances[name]; exists {
+		return nil, ErrPoolNameInUse
+	}
+
+	// a port may be taken by another process, so keep trying the next free
+	// one until the ranges are exhausted. New does not tell which port is
+	// taken, so all UDP ports are tried with a TCP port before the next one.
+	triedUDP := make(map[uint16]bool)
+	triedTCP := make(map[uint16]bool)
+	for {
+		var udpPort, tcpPort uint16
+		if p.options.TCPStartPort != 0 {
+			tcpPort = p.freePort(p.usedTCP, triedTCP, p.options.TCPStartPort, p.options.TCPEndPort)
+			if tcpPort == 0 {
+				return nil, ErrNewPortAlloc
+			}
+		}
+		if opts.UDPEnabled {
+			udpPort = p.freePort(p.usedUDP, triedUDP, p.options.StartPort, p.options.EndPort)
+			if udpPort == 0 && tcpPort == 0 {
+				return nil, ErrNewPortAlloc
+			}
+			if udpPort == 0 {
+				triedTCP[tcpPort] = true
+				triedUDP = make(map[uint16]bool)
+				continue
+			}
+		}
+
+		opts.StartPort, opts.EndPort = udpPort, udpPort
+		opts.TcpPort = tcpPort
+
+		t, err := New(&opts)
+		if err == ErrNewPortAlloc && udpPort != 0 {
+			triedUDP[udpPort] = true
+			continue
+		}
+		if err == ErrNewPortAlloc && tcpPort != 0 {
+			triedTCP[tcpPort] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		instance := &poolInstance{name: name, tox: t, udpPort: udpPort, tcpPort: tcpPort, next: time.Now()}
+		if udpPort != 0 {
+			p.usedUDP[udpPort] = name
+		}
+		if tcpPort != 0 {
+			p.usedTCP[tcpPort] = name
+		}
+		p.instances[name] = instance
+		heap.Push(&p.queue, instance)
+		p.notify()
+
+		return t, nil
+	}
+}
+
+// freePort returns the first port in [start, end] that is neither used nor
+// tried, or 0. p.mtx must be held.
+func (p *Pool) freePort(used map[uint16]string, tried map[uint16]bool, start uint16, end uint16) uint16 {
+	for port := uint32(start); port <= uint32(end); port++ {
+		if _, inUse := used[uint16(port)]; !inUse && !tried[uint16(port)] {
+			return uint16(port)
+		}
+	}
+
+	return 0
+}
+
+/* Remove stops iterating the instance with the given name, kills it and frees
+ * its ports. Save it before if it should be restored later. */
+func (p *Pool) Remove(name string) error {
+	p.mtx.Lock()
+	instance, ok := p.instances[name]
+	if !ok {
+		p.mtx.Unlock()
+		return ErrPoolNotFound
+	}
+	p.detach(instance)
+	p.mtx.Unlock()
+
+	// wait for a running Iterate to finish
+	instance.mtx.Lock()
+	defer instance.mtx.Unlock()
+
+	return instance.tox.Kill()
+}
+
+// detach removes instance from the Pool. p.mtx must be held.
+func (p *Pool) detach(instance *poolInstance) {
+	instance.removed = true
+	if instance.index >= 0 {
+		heap.Remove(&p.queue, instance.index)
+	}
+	delete(p.instances, instance.name)
+	if instance.udpPort != 0 {
+		delete(p.usedUDP, instance.udpPort)
+	}
+	if instance.tcpPort != 0 {
+		delete(p.usedTCP, instance.tcpPort)
+	}
+}
+
+/* Get returns the instance with the given name or nil. */
+func (p *Pool) Get(name string) *Tox {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if instance, ok := p.instances[name]; ok {
+		return instance.tox
+	}
+
+	return nil
+}
+
+/* Names returns the sorted names of all instances. */
+func (p *Pool) Names() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	names := make([]string, 0, len(p.instances))
+	for name := range p.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+/* Save returns the savedata of the instance with the given name. */
+func (p *Pool) Save(name string) ([]byte, error) {
+	p.mtx.Lock()
+	instance, ok := p.instances[name]
+	p.mtx.Unlock()
+	if !ok {
+		return nil, ErrPoolNotFound
+	}
+
+	instance.mtx.Lock()
+	defer instance.mtx.Unlock()
+
+	return instance.tox.GetSavedata()
+}
+
+/* SaveAll returns the savedata of all instances by name. Instances that
+ * failed to save are left out and the last error is returned. */
+func (p *Pool) SaveAll() (map[string][]byte, error) {
+	var lastErr error
+
+	saves := make(map[string][]byte)
+	for _, name := range p.Names() {
+		data, err := p.Save(name)
+		if err != nil {
+			if err != ErrPoolNotFound {
+				lastErr = err
+			}
+			continue
+		}
+		saves[name] = data
+	}
+
+	return saves, lastErr
+}
+
+/* Health returns the current health of the instance with the given name. */
+func (p *Pool) Health(name string) (InstanceHealth, error) {
+	p.mtx.Lock()
+	instance, ok := p.instances[name]
+	var health InstanceHealth
+	if ok {
+		health = InstanceHealth{
+			Name:        name,
+			UDPPort:     instance.udpPort,
+			TCPPort:     instance.tcpPort,
+			LastIterate: instance.lastIterate,
+		}
+	}
+	p.mtx.Unlock()
+	if !ok {
+		return health, ErrPoolNotFound
+	}
+
+	instance.mtx.Lock()
+	defer instance.mtx.Unlock()
+
+	t := instance.tox
+	connection, err := t.SelfGetConnectionStatus()
+	if err != nil {
+		return health, err
+	}
+	health.Connection = connection
+	health.DHTConnected = connection != TOX_CONNECTION_NONE
+
+	friends, err := t.SelfGetFriendlist()
+	if err != nil {
+		return health, err
+	}
+	health.FriendCount = int64(len(friends))
+	for _, friendNumber := range friends {
+		status, err := t.FriendGetConnectionStatus(friendNumber)
+		if err == nil && status != TOX_CONNECTION_NONE {
+			health.OnlineFriends++
+		}
+	}
+
+	return health, nil
+}
+
+/* HealthAll returns the health of all instances sorted by name. */
+func (p *Pool) HealthAll() []InstanceHealth {
+	var all []InstanceHealth
+	for _, name := range p.Names() {
+		if health, err := p.Health(name); err == nil {
+			all = append(all, health)
+		}
+	}
+
+	return all
+}
+
+/* Close stops all workers and kills all instances. Save them before if they
+ * should be restored later. */
+func (p *Pool) Close() error {
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return ErrPoolClosed
+	}
+	p.closed = true
+	close(p.quit)
+	p.mtx.Unlock()
+
+	p.wg.Wait()
+
+	p.mtx.Lock()
+	instances := make([]*poolInstance, 0, len(p.instances))
+	for _, instance := range p.instances {
+		instances = append(instances, instance)
+	}
+	for _, instance := range instances {
+		p.detach(instance)
+	}
+	p.mtx.Unlock()
+
+	for _, instance := range instances {
+		instance.tox.Kill()
+	}
+
+	return nil
+}
+
+// notify wakes up the scheduler. p.mtx must be held.
+func (p *Pool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule hands instances to the workers when they are due.
+func (p *Pool) schedule() {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		p.mtx.Lock()
+		var due *poolInstance
+		wait := time.Hour
+		if p.queue.Len() > 0 {
+			wait = time.Until(p.queue[0].next)
+			if wait <= 0 {
+				due = heap.Pop(&p.queue).(*poolInstance)
+			}
+		}
+		p.mtx.Unlock()
+
+		if due != nil {
+			select {
+			case p.work <- due:
+				continue
+			case <-p.quit:
+				close(p.work)
+				return
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-p.wake:
+		case <-p.quit:
+			close(p.work)
+			return
+		}
+	}
+}
+
+// iterate is the loop of one worker.
+func (p *Pool) iterate() {
+	defer p.wg.Done()
+
+	for instance := range p.work {
+		instance.mtx.Lock()
+		instance.tox.Iterate()
+		interval, err := instance.tox.IterationInterval()
+		instance.mtx.Unlock()
+
+		if err != nil || interval == 0 {
+			interval = 25
+		}
+
+		p.mtx.Lock()
+		now := time.Now()
+		instance.lastIterate = now
+		if !instance.removed && !p.closed {
+			instance.next = now.Add(time.Duration(interval) * time.Millisecond)
+			heap.Push(&p.queue, instance)
+			p.notify()
+		}
+		p.mtx.Unlock()
+	}
+}
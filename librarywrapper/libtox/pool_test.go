@@ -0,0 +1,282 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestPool creates a Pool for instances on DefaultNetwork with its own port
+// range and closes it at the end of the test
+func newTestPool(t *testing.T, options PoolOptions) *Pool {
+	t.Helper()
+
+	p := NewPool(&options)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// blockIterate makes the next Iterate of tox block in a callback until release
+// is called. entered is closed when the Iterate blocks.
+func blockIterate(t *testing.T, tox *Tox) (entered <-chan struct{}, release func()) {
+	t.Helper()
+
+	enteredCh, releaseCh := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	tox.CallbackSelfConnectionStatusChanges(func(_ *Tox, status ToxConnection) {
+		once.Do(func() {
+			close(enteredCh)
+			<-releaseCh
+		})
+	})
+	if err := tox.Bootstrap("127.0.0.1", 33445, make([]byte, TOX_PUBLIC_KEY_SIZE)); err != nil {
+		t.Fatal(err)
+	}
+
+	var releaseOnce sync.Once
+	release = func() { releaseOnce.Do(func() { close(releaseCh) }) }
+	t.Cleanup(release)
+	return enteredCh, release
+}
+
+// waitFor fails the test if ch is not closed within a second
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not happen within a second", what)
+	}
+}
+
+func TestPoolIterationInterval(t *testing.T) {
+	p := newTestPool(t, PoolOptions{Workers: 2, StartPort: 40000, EndPort: 40009})
+
+	intervals := map[string]uint32{"fast": 10, "slow": 100}
+	instances := make(map[string]*Tox)
+	for name, interval := range intervals {
+		tox, err := p.Add(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		DefaultNetwork.SetInstanceIterationInterval(tox, interval)
+		instances[name] = tox
+	}
+
+	// the first Iterate may still use the default interval
+	time.Sleep(100 * time.Millisecond)
+	start := make(map[string]uint64)
+	for name, tox := range instances {
+		start[name] = DefaultNetwork.Iterations(tox)
+	}
+	const period = 500 * time.Millisecond
+	time.Sleep(period)
+
+	for name, tox := range instances {
+		iterations := DefaultNetwork.Iterations(tox) - start[name]
+		want := uint64(period / (time.Duration(intervals[name]) * time.Millisecond))
+		// Iterate itself and the scheduler take some time, so there are
+		// rather less than more
+		if iterations < want/3 || iterations > want+2 {
+			t.Errorf("%s instance was iterated %d times in %v, want about %d", name, iterations, period, want)
+		}
+	}
+
+	if health, err := p.Health("fast"); err != nil || time.Since(health.LastIterate) > time.Second {
+		t.Errorf("fast instance was last iterated at %v (%v)", health.LastIterate, err)
+	}
+}
+
+func TestPoolRemoveDuringIterate(t *testing.T) {
+	p := newTestPool(t, PoolOptions{Workers: 4, StartPort: 40010, EndPort: 40049})
+
+	tox, err := p.Add("blocked", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entered, release := blockIterate(t, tox)
+	waitFor(t, entered, "the blocking Iterate")
+
+	removed := make(chan struct{})
+	go func() {
+		if err := p.Remove("blocked"); err != nil {
+			t.Errorf("Remove() error = %v", err)
+		}
+		close(removed)
+	}()
+
+	// Remove waits for the running Iterate, but the instance is gone at once
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-removed:
+		t.Fatal("Remove() returned during the Iterate of the instance")
+	default:
+	}
+	if p.Get("blocked") != nil {
+		t.Error("Get() returned the removed instance")
+	}
+
+	release()
+	waitFor(t, removed, "Remove")
+
+	iterations := DefaultNetwork.Iterations(tox)
+	time.Sleep(100 * time.Millisecond)
+	if after := DefaultNetwork.Iterations(tox); after != iterations {
+		t.Errorf("removed instance was iterated %d more times", after-iterations)
+	}
+	if _, err := tox.IterationInterval(); err != ErrToxInit {
+		t.Errorf("removed instance is not killed, IterationInterval() error = %v", err)
+	}
+
+	// instances come and go while the others are iterated
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				name := fmt.Sprintf("bot-%d-%d", i, j)
+				tox, err := p.Add(name, nil)
+				if err != nil {
+					t.Errorf("Add(%q) error = %v", name, err)
+					return
+				}
+				DefaultNetwork.SetInstanceIterationInterval(tox, 1)
+				time.Sleep(time.Millisecond)
+				p.HealthAll()
+				if err := p.Remove(name); err != nil {
+					t.Errorf("Remove(%q) error = %v", name, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if names := p.Names(); len(names) != 0 {
+		t.Errorf("pool still has the instances %v", names)
+	}
+}
+
+func TestPoolPorts(t *testing.T) {
+	// a port of each range taken outside the pool
+	other, err := New(&Options{UDPEnabled: true, StartPort: 40050, EndPort: 40050, TcpPort: 40060})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Kill()
+
+	p := newTestPool(t, PoolOptions{StartPort: 40050, EndPort: 40053, TCPStartPort: 40060, TCPEndPort: 40063})
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := p.Add(name, nil); err != nil {
+			t.Fatalf("Add(%q) error = %v", name, err)
+		}
+	}
+	if _, err := p.Add("d", nil); err != ErrNewPortAlloc {
+		t.Fatalf("Add() with all ports in use error = %v, want %v", err, ErrNewPortAlloc)
+	}
+	if _, err := p.Add("a", nil); err != ErrPoolNameInUse {
+		t.Fatalf("Add() of a name in use error = %v, want %v", err, ErrPoolNameInUse)
+	}
+
+	checkPorts := func(want int) {
+		t.Helper()
+
+		udp, tcp := make(map[uint16]string), make(map[uint16]string)
+		all := p.HealthAll()
+		if len(all) != want {
+			t.Fatalf("pool has %d instances, want %d", len(all), want)
+		}
+		for _, health := range all {
+			if health.UDPPort < 40051 || health.UDPPort > 40053 {
+				t.Errorf("%s has the UDP port %d, want 40051-40053", health.Name, health.UDPPort)
+			}
+			if health.TCPPort < 40061 || health.TCPPort > 40063 {
+				t.Errorf("%s has the TCP port %d, want 40061-40063", health.Name, health.TCPPort)
+			}
+			if name, used := udp[health.UDPPort]; used {
+				t.Errorf("%s and %s have the UDP port %d", name, health.Name, health.UDPPort)
+			}
+			if name, used := tcp[health.TCPPort]; used {
+				t.Errorf("%s and %s have the TCP port %d", name, health.Name, health.TCPPort)
+			}
+			udp[health.UDPPort], tcp[health.TCPPort] = health.Name, health.Name
+		}
+	}
+	checkPorts(3)
+
+	// the ports of a removed instance are handed out again
+	health, _ := p.Health("b")
+	if err := p.Remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Add("d", nil); err != nil {
+		t.Fatalf("Add() after Remove() error = %v", err)
+	}
+	if d, _ := p.Health("d"); d.UDPPort != health.UDPPort || d.TCPPort != health.TCPPort {
+		t.Errorf("new instance has the ports %d/%d, want %d/%d of the removed one", d.UDPPort, d.TCPPort, health.UDPPort, health.TCPPort)
+	}
+	checkPorts(3)
+}
+
+func TestPoolClose(t *testing.T) {
+	p := NewPool(&PoolOptions{Workers: 2, StartPort: 40070, EndPort: 40079})
+
+	var instances []*Tox
+	for _, name := range []string{"a", "b", "c"} {
+		tox, err := p.Add(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, tox)
+	}
+	entered, release := blockIterate(t, instances[0])
+	waitFor(t, entered, "the blocking Iterate")
+
+	closed := make(chan struct{})
+	go func() {
+		if err := p.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+		close(closed)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a worker was iterating")
+	default:
+	}
+
+	release()
+	waitFor(t, closed, "Close")
+
+	iterations := make([]uint64, len(instances))
+	for i, tox := range instances {
+		iterations[i] = DefaultNetwork.Iterations(tox)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i, tox := range instances {
+		if after := DefaultNetwork.Iterations(tox); after != iterations[i] {
+			t.Errorf("instance %d was iterated after Close()", i)
+		}
+		if _, err := tox.IterationInterval(); err != ErrToxInit {
+			t.Errorf("instance %d is not killed, IterationInterval() error = %v", i, err)
+		}
+	}
+
+	if names := p.Names(); len(names) != 0 {
+		t.Errorf("closed pool has the instances %v", names)
+	}
+	if _, err := p.Add("d", nil); err != ErrPoolClosed {
+		t.Errorf("Add() after Close() error = %v, want %v", err, ErrPoolClosed)
+	}
+	if err := p.Close(); err != ErrPoolClosed {
+		t.Errorf("second Close() error = %v, want %v", err, ErrPoolClosed)
+	}
+}
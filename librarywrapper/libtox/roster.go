@@ -0,0 +1,129 @@
+package libtox
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FriendInfo is a snapshot of the state of a friend.
+type FriendInfo struct {
+	Number        uint32
+	PublicKey     []byte
+	Name          string
+	StatusMessage string
+	Status        ToxUserStatus
+	Connection    ToxConnection
+	// LastOnline is the zero time if the friend was never seen online.
+	LastOnline time.Time
+}
+
+// rosterCache holds the FriendInfo of all friends of an instance. Once
+// enabled, it is kept current by the friend callbacks and by FriendAdd,
+// FriendAddNorequest and FriendDelete.
+type rosterCache struct {
+	mtx     sync.RWMutex
+	friends map[uint32]FriendInfo
+}
+
+/* EnableRosterCache loads the state of all friends into a cache that is kept
+ * current by the friend callbacks. Afterwards Roster and RosterFriend answer
+ * without calling into toxcore. The callbacks set with CallbackFriend* keep
+ * working as before. */
+func (t *Tox) EnableRosterCache() error {
+	infos, err := t.SelfGetFriendInfos()
+	if err != nil {
+		return err
+	}
+
+	r := &rosterCache{friends: make(map[uint32]FriendInfo, len(infos))}
+	for _, info := range infos {
+		r.friends[info.Number] = info
+	}
+
+	t.roster.Store(r)
+	t.registerRosterCallbacks()
+
+	return nil
+}
+
+/* DisableRosterCache drops the roster cache. */
+func (t *Tox) DisableRosterCache() {
+	t.roster.Store(nil)
+}
+
+/* RosterCacheEnabled returns true if the roster cache is enabled. */
+func (t *Tox) RosterCacheEnabled() bool {
+	return t.roster.Load() != nil
+}
+
+/* Roster returns the state of all friends ordered by friend number. It reads
+ * from the roster cache if it is enabled and falls back to
+ * SelfGetFriendInfos otherwise. */
+func (t *Tox) Roster() ([]FriendInfo, error) {
+	r := t.roster.Load()
+	if r == nil {
+		return t.SelfGetFriendInfos()
+	}
+
+	r.mtx.RLock()
+	infos := make([]FriendInfo, 0, len(r.friends))
+	for _, info := range r.friends {
+		infos = append(infos, info)
+	}
+	r.mtx.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Number < infos[j].Number })
+
+	return infos, nil
+}
+
+/* RosterFriend returns the state of the friend with the given friendNumber. It
+ * reads from the roster cache if it is enabled and falls back to
+ * FriendGetInfo otherwise. */
+func (t *Tox) RosterFriend(friendNumber uint32) (FriendInfo, error) {
+	r := t.roster.Load()
+	if r == nil {
+		return t.FriendGetInfo(friendNumber)
+	}
+
+	r.mtx.RLock()
+	info, ok := r.friends[friendNumber]
+	r.mtx.RUnlock()
+	if !ok {
+		return FriendInfo{}, ErrFuncFail
+	}
+
+	return info, nil
+}
+
+// rosterPut stores info in the roster cache, if it is enabled.
+func (t *Tox) rosterPut(info FriendInfo) {
+	if r := t.roster.Load(); r != nil {
+		r.mtx.Lock()
+		r.friends[info.Number] = info
+		r.mtx.Unlock()
+	}
+}
+
+// rosterDelete removes a friend from the roster cache, if it is enabled.
+func (t *Tox) rosterDelete(friendNumber uint32) {
+	if r := t.roster.Load(); r != nil {
+		r.mtx.Lock()
+		delete(r.friends, friendNumber)
+		r.mtx.Unlock()
+	}
+}
+
+// rosterUpdate applies update to a friend in the roster cache, if it is
+// enabled. Friends missing in the cache are left alone.
+func (t *Tox) rosterUpdate(friendNumber uint32, update func(info *FriendInfo)) {
+	if r := t.roster.Load(); r != nil {
+		r.mtx.Lock()
+		if info, ok := r.friends[friendNumber]; ok {
+			update(&info)
+			r.friends[friendNumber] = info
+		}
+		r.mtx.Unlock()
+	}
+}
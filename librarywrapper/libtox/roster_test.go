@@ -0,0 +1,115 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"reflect"
+	"testing"
+)
+
+// cachedFriend returns the entry of friendNumber in the roster cache of t,
+// without falling back to a query.
+func cachedFriend(t *Tox, friendNumber uint32) (FriendInfo, bool) {
+	r := t.roster.Load()
+	if r == nil {
+		return FriendInfo{}, false
+	}
+
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	info, ok := r.friends[friendNumber]
+	return info, ok
+}
+
+func TestRosterCacheHooks(t *testing.T) {
+	p := newSimPair(t)
+	c, err := p.net.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.a.EnableRosterCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache is updated before the callbacks see the change
+	var seen []FriendInfo
+	see := func(friendNumber uint32) {
+		info, _ := cachedFriend(p.a, friendNumber)
+		seen = append(seen, info)
+	}
+	p.a.CallbackFriendNameChanges(func(_ *Tox, friendNumber uint32, name []byte, length uint32) { see(friendNumber) })
+	p.a.CallbackFriendStatusChanges(func(_ *Tox, friendNumber uint32, status ToxUserStatus) { see(friendNumber) })
+	p.a.CallbackFriendConnectionStatusChanges(func(_ *Tox, friendNumber uint32, status ToxConnection) { see(friendNumber) })
+
+	pkC, _ := c.SelfGetPublicKey()
+
+	steps := []struct {
+		name         string
+		change       func()
+		friend       uint32
+		want         func(info FriendInfo) bool
+		wantCallback bool
+	}{
+		{
+			name:         "name",
+			change:       func() { p.b.SelfSetName("bob") },
+			want:         func(info FriendInfo) bool { return info.Name == "bob" },
+			wantCallback: true,
+		},
+		{
+			name:         "status",
+			change:       func() { p.b.SelfSetStatus(TOX_USERSTATUS_AWAY) },
+			want:         func(info FriendInfo) bool { return info.Status == TOX_USERSTATUS_AWAY },
+			wantCallback: true,
+		},
+		{
+			name:         "connection lost",
+			change:       func() { p.net.SetOnline(p.b, false) },
+			want:         func(info FriendInfo) bool { return info.Connection == TOX_CONNECTION_NONE && !info.LastOnline.IsZero() },
+			wantCallback: true,
+		},
+		{
+			name:         "connection back",
+			change:       func() { p.net.SetOnline(p.b, true) },
+			want:         func(info FriendInfo) bool { return info.Connection != TOX_CONNECTION_NONE },
+			wantCallback: true,
+		},
+		{
+			name:   "add",
+			change: func() { p.a.FriendAddNorequest(pkC) },
+			friend: 1,
+			want:   func(info FriendInfo) bool { return reflect.DeepEqual(info.PublicKey, pkC) },
+		},
+	}
+
+	for _, step := range steps {
+		seen = nil
+		step.change()
+		p.net.Settle(10)
+
+		info, ok := cachedFriend(p.a, step.friend)
+		if !ok || !step.want(info) {
+			t.Fatalf("%s: cached friend %d is %+v", step.name, step.friend, info)
+		}
+		if step.wantCallback && (len(seen) == 0 || !step.want(seen[len(seen)-1])) {
+			t.Errorf("%s: callbacks saw %+v", step.name, seen)
+		}
+
+		// the cache matches the state in the instance
+		roster, _ := p.a.Roster()
+		infos, _ := p.a.SelfGetFriendInfos()
+		if !reflect.DeepEqual(roster, infos) {
+			t.Fatalf("%s: Roster() = %+v, want %+v", step.name, roster, infos)
+		}
+	}
+
+	if err := p.a.FriendDelete(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cachedFriend(p.a, 0); ok {
+		t.Error("deleted friend is still cached")
+	}
+	if roster, _ := p.a.Roster(); len(roster) != 1 || roster[0].Number != 1 {
+		t.Errorf("Roster() after FriendDelete = %+v, want friend 1 only", roster)
+	}
+}
@@ -0,0 +1,1239 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tox is Tox instance type.
+/*
+ * This is the simulated backend (build tag `toxsim`). It offers the same API
+ * as the cgo backend, but all instances live on an in-memory Network instead
+ * of the Tox DHT. See Network for time control and fault injection.
+ */
+type Tox struct {
+	net    *Network
+	mtx    sync.Mutex
+	seq    uint64
+	killed bool
+	roster atomic.Pointer[rosterCache]
+
+	bufferMode atomic.Int32
+
+	publicKey []byte
+	secretKey []byte
+	dhtID     []byte
+	nospam    uint32
+
+	name          string
+	statusMessage string
+	status        ToxUserStatus
+
+	udpEnabled   bool
+	udpPort      uint16
+	tcpPort      uint16
+	bootstrapped bool
+	connection   ToxConnection
+
+	interval   uint32 // overrides the interval of the Network if not 0
+	iterations uint64
+
+	friends     map[uint32]*simFriend
+	conferences map[uint32]*simConference
+	queue       []simEvent
+
+	// Callbacks
+	onSelfConnectionStatusChanges   OnSelfConnectionStatusChanges
+	onFriendNameChanges             OnFriendNameChanges
+	onFriendStatusMessageChanges    OnFriendStatusMessageChanges
+	onFriendStatusChanges           OnFriendStatusChanges
+	onFriendConnectionStatusChanges OnFriendConnectionStatusChanges
+	onFriendTypingChanges           OnFriendTypingChanges
+	onFriendReadReceipt             OnFriendReadReceipt
+	onFriendRequest                 OnFriendRequest
+	onFriendMessage                 OnFriendMessage
+	onFileRecvControl               OnFileRecvControl
+	onFileChunkRequest              OnFileChunkRequest
+	onFileRecv                      OnFileRecv
+	onFileRecvChunk                 OnFileRecvChunk
+	onFriendLossyPacket             OnFriendLossyPacket
+	onFriendLosslessPacket          OnFriendLosslessPacket
+
+	onConferenceInvite          OnConferenceInvite
+	onConferenceMessage         OnConferenceMessage
+	onConferenceConnected       OnConferenceConnected
+	onConferenceTitle           OnConferenceTitle
+	onConferencePeerName        OnConferencePeerName
+	onConferencePeerListChanged OnConferencePeerListChanged
+}
+
+// simFriend is the state an instance keeps about one of its friends.
+type simFriend struct {
+	publicKey     []byte
+	name          string
+	statusMessage string
+	status        ToxUserStatus
+	connection    ToxConnection
+	typing        bool
+	lastOnline    time.Time
+	messageID     uint32
+	request       *simRequest
+	files         map[uint32]*simFile
+}
+
+// simRequest is a friend request that has not reached its recipient yet.
+type simRequest struct {
+	nospam  uint32
+	message []byte
+}
+
+// simSave is the savedata format of the simulated backend.
+type simSave struct {
+	SecretKey     []byte
+	Nospam        uint32
+	Name          string
+	StatusMessage string
+	Status        ToxUserStatus
+	Friends       []simSaveFriend
+}
+
+type simSaveFriend struct {
+	Number        uint32
+	PublicKey     []byte
+	Name          string
+	StatusMessage string
+	LastOnline    int64
+}
+
+var simSaveMagic = []byte("toxsim\x00")
+
+/* VersionMajor returns the major version number of the used Tox library */
+func VersionMajor() uint32 {
+	return 0
+}
+
+/* VersionMinor returns the minor version number of the used Tox library */
+func VersionMinor() uint32 {
+	return 2
+}
+
+/* VersionPatch returns the patch number of the used Tox library */
+func VersionPatch() uint32 {
+	return 19
+}
+
+/* VersionIsCompatible returns whether the compiled Tox library version is
+ * compatible with the passed version numbers. */
+func VersionIsCompatible(major uint32, minor uint32, patch uint32) bool {
+	if major != VersionMajor() {
+		return false
+	}
+	if major == 0 {
+		return minor == VersionMinor() && patch <= VersionPatch()
+	}
+
+	return minor < VersionMinor() || (minor == VersionMinor() && patch <= VersionPatch())
+}
+
+/* New creates and initialises a new Tox instance on the DefaultNetwork and
+ * returns the corresponding gotox instance. */
+func New(options *Options) (*Tox, error) {
+	return DefaultNetwork.New(options)
+}
+
+/* New creates and initialises a new Tox instance on the Network n. */
+func (n *Network) New(options *Options) (*Tox, error) {
+	if options == nil {
+		options = &Options{IPv6Enabled: true, UDPEnabled: true}
+	}
+
+	if options.ProxyType != TOX_PROXY_TYPE_NONE && (len(options.ProxyHost) == 0 || options.ProxyPort == 0) {
+		return nil, ErrNewProxy
+	}
+	if len(options.ProxyHost) > 255 {
+		return nil, ErrArgs
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t := &Tox{
+		net:         n,
+		udpEnabled:  options.UDPEnabled,
+		friends:     make(map[uint32]*simFriend),
+		conferences: make(map[uint32]*simConference),
+	}
+
+	switch options.SaveDataType {
+	case TOX_SAVEDATA_TYPE_TOX_SAVE:
+		if err := t.load(options.SaveData); err != nil {
+			return nil, err
+		}
+	case TOX_SAVEDATA_TYPE_SECRET_KEY:
+		if len(options.SaveData) != TOX_SECRET_KEY_SIZE {
+			return nil, ErrNewLoadBadFormat
+		}
+		t.setSecretKey(options.SaveData)
+		t.nospam = n.rand.Uint32()
+	default:
+		secretkey := make([]byte, TOX_SECRET_KEY_SIZE)
+		n.rand.Read(secretkey)
+		t.setSecretKey(secretkey)
+		t.nospam = n.rand.Uint32()
+	}
+
+	t.dhtID = make([]byte, TOX_PUBLIC_KEY_SIZE)
+	n.rand.Read(t.dhtID)
+
+	if t.udpEnabled {
+		start, end := options.StartPort, options.EndPort
+		if start == 0 && end == 0 {
+			start, end = 33445, 34445
+		}
+		port, ok := n.allocPort(n.usedUDP, start, end)
+		if !ok {
+			return nil, ErrNewPortAlloc
+		}
+		t.udpPort = port
+	}
+	if options.TcpPort != 0 {
+		if n.usedTCP[options.TcpPort] {
+			if t.udpPort != 0 {
+				delete(n.usedUDP, t.udpPort)
+			}
+			return nil, ErrNewPortAlloc
+		}
+		n.usedTCP[options.TcpPort] = true
+		t.tcpPort = options.TcpPort
+	}
+
+	n.seq++
+	t.seq = n.seq
+	n.nodes = append(n.nodes, t)
+
+	return t, nil
+}
+
+// setSecretKey sets the key pair of t. The simulated public key is the
+// SHA-256 of the secret key.
+func (t *Tox) setSecretKey(secretkey []byte) {
+	t.secretKey = append([]byte(nil), secretkey...)
+	publickey := sha256.Sum256(secretkey)
+	t.publicKey = publickey[:]
+}
+
+// load restores t from savedata created by GetSavedata.
+func (t *Tox) load(data []byte) error {
+	if !bytes.HasPrefix(data, simSaveMagic) {
+		return ErrNewLoadBadFormat
+	}
+
+	var save simSave
+	if err := json.Unmarshal(data[len(simSaveMagic):], &save); err != nil || len(save.SecretKey) != TOX_SECRET_KEY_SIZE {
+		return ErrNewLoadBadFormat
+	}
+
+	t.setSecretKey(save.SecretKey)
+	t.nospam = save.Nospam
+	t.name = save.Name
+	t.statusMessage = save.StatusMessage
+	t.status = save.Status
+
+	for _, f := range save.Friends {
+		friend := newSimFriend(f.PublicKey)
+		friend.name = f.Name
+		friend.statusMessage = f.StatusMessage
+		if f.LastOnline != 0 {
+			friend.lastOnline = time.Unix(f.LastOnline, 0)
+		}
+		t.friends[f.Number] = friend
+	}
+
+	return nil
+}
+
+func newSimFriend(publickey []byte) *simFriend {
+	return &simFriend{
+		publicKey: append([]byte(nil), publickey...),
+		files:     make(map[uint32]*simFile),
+	}
+}
+
+// lock locks the Network of t and reports whether t is still usable.
+func (t *Tox) lock() bool {
+	if t == nil || t.net == nil {
+		return false
+	}
+
+	t.net.mu.Lock()
+	if t.killed {
+		t.net.mu.Unlock()
+		return false
+	}
+
+	return true
+}
+
+func (t *Tox) unlock() {
+	t.net.mu.Unlock()
+}
+
+// transport returns the connection type t uses for its connections.
+func (t *Tox) transport() ToxConnection {
+	if t.udpEnabled {
+		return TOX_CONNECTION_UDP
+	}
+
+	return TOX_CONNECTION_TCP
+}
+
+// friendNumbers returns the sorted friend numbers of t. n.mu must be held.
+func (t *Tox) friendNumbers() []uint32 {
+	numbers := make([]uint32, 0, len(t.friends))
+	for number := range t.friends {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers
+}
+
+// friendByKey returns the friend of t with the given public key. n.mu must be
+// held.
+func (t *Tox) friendByKey(publickey []byte) (uint32, *simFriend) {
+	for number, f := range t.friends {
+		if bytes.Equal(f.publicKey, publickey) {
+			return number, f
+		}
+	}
+
+	return math.MaxUint32, nil
+}
+
+// connectedFriend returns friend number of t together with its peer if they
+// are currently connected. n.mu must be held.
+func (t *Tox) connectedFriend(number uint32) (*simFriend, *Tox) {
+	f, ok := t.friends[number]
+	if !ok || f.connection == TOX_CONNECTION_NONE {
+		return f, nil
+	}
+
+	return f, t.net.node(f.publicKey)
+}
+
+/* Kill releases all resources associated with the Tox instance and disconnects
+ * from the network.
+ * After calling this function `t *TOX` becomes invalid. Do not use it again! */
+func (t *Tox) Kill() error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	n := t.net
+	for i, node := range n.nodes {
+		if node == t {
+			n.nodes = append(n.nodes[:i], n.nodes[i+1:]...)
+			break
+		}
+	}
+	for _, c := range t.conferences {
+		c.leave(t)
+	}
+	delete(n.usedUDP, t.udpPort)
+	delete(n.usedTCP, t.tcpPort)
+	delete(n.sendq, t)
+	delete(n.offline, t)
+
+	t.killed = true
+	t.queue = nil
+	n.refresh()
+
+	return nil
+}
+
+/* GetSaveDataSize returns the size of the savedata returned by GetSavedata. */
+func (t *Tox) GetSaveDataSize() (uint32, error) {
+	data, err := t.GetSavedata()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(len(data)), nil
+}
+
+/* GetSavedata returns a byte slice of all information associated with the tox
+ * instance. */
+func (t *Tox) GetSavedata() ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	save := simSave{
+		SecretKey:     t.secretKey,
+		Nospam:        t.nospam,
+		Name:          t.name,
+		StatusMessage: t.statusMessage,
+		Status:        t.status,
+	}
+	for _, number := range t.friendNumbers() {
+		f := t.friends[number]
+		var lastOnline int64
+		if !f.lastOnline.IsZero() {
+			lastOnline = f.lastOnline.Unix()
+		}
+		save.Friends = append(save.Friends, simSaveFriend{
+			Number:        number,
+			PublicKey:     f.publicKey,
+			Name:          f.name,
+			StatusMessage: f.statusMessage,
+			LastOnline:    lastOnline,
+		})
+	}
+
+	data, err := json.Marshal(save)
+	if err != nil {
+		return nil, ErrFuncFail
+	}
+
+	return append(append([]byte(nil), simSaveMagic...), data...), nil
+}
+
+/* Bootstrap sends a "get nodes" request to the given bootstrap node with IP,
+ * port, and public key to setup connections.
+ * In the simulation any node is accepted and connects t to its Network. */
+func (t *Tox) Bootstrap(address string, port uint16, publickey []byte) error {
+	if len(publickey) != TOX_PUBLIC_KEY_SIZE {
+		return ErrArgs
+	}
+	if len(address) == 0 || len(address) > TOX_MAX_HOSTNAME_LENGTH || port == 0 {
+		return ErrFuncFail
+	}
+
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	t.bootstrapped = true
+	t.net.refresh()
+
+	return nil
+}
+
+/* AddTCPRelay adds the given node with IP, port, and public key without using
+ * it as a boostrap node. */
+func (t *Tox) AddTCPRelay(address string, port uint16, publickey []byte) error {
+	return t.Bootstrap(address, port, publickey)
+}
+
+/* SelfGetConnectionStatus returns true if Tox is connected to the DHT. */
+func (t *Tox) SelfGetConnectionStatus() (ToxConnection, error) {
+	if !t.lock() {
+		return TOX_CONNECTION_NONE, ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.connection, nil
+}
+
+/* IterationInterval returns the time in milliseconds before Iterate() should be
+ * called again. */
+func (t *Tox) IterationInterval() (uint32, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.interval != 0 {
+		return t.interval, nil
+	}
+	return t.net.interval, nil
+}
+
+/* Iterate is the main loop. It needs to be called every IterationInterval()
+ * milliseconds. In the simulation it delivers all events that are due on the
+ * Network clock and asks file senders for their next chunks. */
+func (t *Tox) Iterate() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if !t.lock() {
+		return ErrToxInit
+	}
+	n := t.net
+	n.refresh()
+	t.iterations++
+
+	due := 0
+	for due < len(t.queue) && !t.queue[due].at.After(n.now) {
+		due++
+	}
+	events := t.queue[:due:due]
+	t.queue = append([]simEvent(nil), t.queue[due:]...)
+	t.unlock()
+
+	for _, event := range events {
+		event.fire()
+	}
+
+	t.requestChunks()
+
+	return nil
+}
+
+/* SelfGetAddress returns the public address to give to others. */
+func (t *Tox) SelfGetAddress() ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	return simAddress(t.publicKey, t.nospam), nil
+}
+
+// simAddress builds a Tox ID from a public key and a nospam, including the
+// toxcore checksum.
+func simAddress(publickey []byte, nospam uint32) []byte {
+	address := make([]byte, TOX_ADDRESS_SIZE)
+	copy(address, publickey)
+	binary.BigEndian.PutUint32(address[TOX_PUBLIC_KEY_SIZE:], nospam)
+
+	var checksum [2]byte
+	for i := 0; i < TOX_PUBLIC_KEY_SIZE+TOX_NOSPAM_SIZE; i++ {
+		checksum[i%2] ^= address[i]
+	}
+	copy(address[TOX_PUBLIC_KEY_SIZE+TOX_NOSPAM_SIZE:], checksum[:])
+
+	return address
+}
+
+/* SelfSetNospam sets the nospam of your ID. */
+func (t *Tox) SelfSetNospam(nospam uint32) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	t.nospam = nospam
+	return nil
+}
+
+/* SelfGetNospam returns the nospam of your ID. */
+func (t *Tox) SelfGetNospam() (uint32, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.nospam, nil
+}
+
+/* SelfGetPublicKey returns the publickey of your profile. */
+func (t *Tox) SelfGetPublicKey() ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	return append([]byte(nil), t.publicKey...), nil
+}
+
+/* SelfGetSecretKey returns the secretkey of your profile. */
+func (t *Tox) SelfGetSecretKey() ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	return append([]byte(nil), t.secretKey...), nil
+}
+
+/* SelfSetName sets your nickname. The maximum name length is MAX_NAME_LENGTH. */
+func (t *Tox) SelfSetName(name string) error {
+	if len(name) > TOX_MAX_NAME_LENGTH {
+		return ErrFuncFail
+	}
+
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.name == name {
+		return nil
+	}
+	t.name = name
+	t.broadcastProfile(func(to *Tox, number uint32, f *simFriend) {
+		f.name = name
+		to.rosterUpdate(number, func(info *FriendInfo) { info.Name = name })
+		if cb := to.onFriendNameChanges; cb != nil {
+			cb(to, number, []byte(name), uint32(len(name)))
+		}
+	})
+	for _, c := range t.conferences {
+		peerNumber, _ := c.peer(t)
+		t.net.postConference(c, t, func(m *Tox, number uint32) {
+			if cb := m.onConferencePeerName; cb != nil {
+				cb(m, number, peerNumber, []byte(name), uint32(len(name)))
+			}
+		})
+	}
+
+	return nil
+}
+
+// broadcastProfile queues apply at every connected friend of t. apply runs
+// with the receiving instance, its friend number for t and its friend record
+// of t. n.mu must be held.
+func (t *Tox) broadcastProfile(apply func(to *Tox, number uint32, f *simFriend)) {
+	n := t.net
+	for _, number := range t.friendNumbers() {
+		_, peer := t.connectedFriend(number)
+		if peer == nil {
+			continue
+		}
+
+		n.post(peer, func() {
+			n.mu.Lock()
+			number, f := peer.friendByKey(t.publicKey)
+			n.mu.Unlock()
+
+			if f != nil {
+				apply(peer, number, f)
+			}
+		})
+	}
+}
+
+/* SelfGetNameSize returns the length of your name. */
+func (t *Tox) SelfGetNameSize() (int64, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	return int64(len(t.name)), nil
+}
+
+/* SelfGetName returns your nickname. */
+func (t *Tox) SelfGetName() (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.name, nil
+}
+
+/* SelfSetStatusMessage sets your status message.
+ * The maximum status length is MAX_STATUS_MESSAGE_LENGTH. */
+func (t *Tox) SelfSetStatusMessage(status string) error {
+	if len(status) > TOX_MAX_STATUS_MESSAGE_LENGTH {
+		return ErrFuncFail
+	}
+
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.statusMessage == status {
+		return nil
+	}
+	t.statusMessage = status
+	t.broadcastProfile(func(to *Tox, number uint32, f *simFriend) {
+		f.statusMessage = status
+		to.rosterUpdate(number, func(info *FriendInfo) { info.StatusMessage = status })
+		if cb := to.onFriendStatusMessageChanges; cb != nil {
+			cb(to, number, []byte(status), uint32(len(status)))
+		}
+	})
+
+	return nil
+}
+
+/* SelfGetStatusMessageSize returns the size of your status message. */
+func (t *Tox) SelfGetStatusMessageSize() (int64, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	return int64(len(t.statusMessage)), nil
+}
+
+/* SelfGetStatusMessage returns your status message. */
+func (t *Tox) SelfGetStatusMessage() (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.statusMessage, nil
+}
+
+/* SelfSetStatus sets your userstatus. */
+func (t *Tox) SelfSetStatus(userstatus ToxUserStatus) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.status == userstatus {
+		return nil
+	}
+	t.status = userstatus
+	t.broadcastProfile(func(to *Tox, number uint32, f *simFriend) {
+		f.status = userstatus
+		to.rosterUpdate(number, func(info *FriendInfo) { info.Status = userstatus })
+		if cb := to.onFriendStatusChanges; cb != nil {
+			cb(to, number, userstatus)
+		}
+	})
+
+	return nil
+}
+
+/* SelfGetStatus returns your status. */
+func (t *Tox) SelfGetStatus() (ToxUserStatus, error) {
+	if !t.lock() {
+		return TOX_USERSTATUS_NONE, ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.status, nil
+}
+
+// addFriend stores a new friend under the lowest free friend number. n.mu
+// must be held.
+func (t *Tox) addFriend(publickey []byte) uint32 {
+	number := uint32(0)
+	for {
+		if _, used := t.friends[number]; !used {
+			break
+		}
+		number++
+	}
+	t.friends[number] = newSimFriend(publickey)
+	t.rosterPut(t.friendInfo(number, t.friends[number]))
+
+	return number
+}
+
+/* FriendAdd adds a friend by sending a friend request containing the given
+ * message.
+ * Returns the friend number on success, or a ToxErrFriendAdd on failure.
+ */
+func (t *Tox) FriendAdd(address []byte, message string) (uint32, error) {
+	if len(address) != TOX_ADDRESS_SIZE || len(message) == 0 {
+		return 0, ErrArgs
+	}
+	if len(message) > TOX_MAX_FRIEND_REQUEST_LENGTH {
+		return math.MaxUint32, ErrFriendAddTooLong
+	}
+
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	publickey := address[:TOX_PUBLIC_KEY_SIZE]
+	nospam := binary.BigEndian.Uint32(address[TOX_PUBLIC_KEY_SIZE:])
+
+	if bytes.Equal(publickey, t.publicKey) {
+		return math.MaxUint32, ErrFriendAddOwnKey
+	}
+	if !bytes.Equal(simAddress(publickey, nospam), address) {
+		return math.MaxUint32, ErrFriendAddBadChecksum
+	}
+
+	if number, f := t.friendByKey(publickey); f != nil {
+		if f.request != nil && f.request.nospam != nospam {
+			f.request.nospam = nospam
+			return number, ErrFriendAddSetNewNospam
+		}
+		return math.MaxUint32, ErrFriendAddAlreadySent
+	}
+
+	number := t.addFriend(publickey)
+	t.friends[number].request = &simRequest{nospam: nospam, message: []byte(message)}
+	t.net.refresh()
+
+	return number, nil
+}
+
+/* FriendAddNorequest adds a friend without sending a friend request.
+ * Returns the friend number on success.
+ */
+func (t *Tox) FriendAddNorequest(publickey []byte) (uint32, error) {
+	if len(publickey) != TOX_PUBLIC_KEY_SIZE {
+		return math.MaxUint32, ErrArgs
+	}
+
+	if !t.lock() {
+		return math.MaxUint32, ErrToxInit
+	}
+	defer t.unlock()
+
+	if bytes.Equal(publickey, t.publicKey) {
+		return math.MaxUint32, ErrFuncFail
+	}
+	if _, f := t.friendByKey(publickey); f != nil {
+		return math.MaxUint32, ErrFuncFail
+	}
+
+	number := t.addFriend(publickey)
+	t.net.refresh()
+
+	return number, nil
+}
+
+/* FriendDelete removes a friend. */
+func (t *Tox) FriendDelete(friendNumber uint32) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	if _, ok := t.friends[friendNumber]; !ok {
+		return ErrArgs
+	}
+
+	delete(t.friends, friendNumber)
+	t.rosterDelete(friendNumber)
+	t.net.refresh()
+
+	return nil
+}
+
+/* FriendByPublicKey returns the friend number associated to a given publickey. */
+func (t *Tox) FriendByPublicKey(publickey []byte) (uint32, error) {
+	if len(publickey) != TOX_PUBLIC_KEY_SIZE {
+		return math.MaxUint32, ErrArgs
+	}
+
+	if !t.lock() {
+		return math.MaxUint32, ErrToxInit
+	}
+	defer t.unlock()
+
+	number, f := t.friendByKey(publickey)
+	if f == nil {
+		return number, ErrFuncFail
+	}
+
+	return number, nil
+}
+
+/* FriendExists returns true if a friend exists with given friendNumber. */
+func (t *Tox) FriendExists(friendNumber uint32) (bool, error) {
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	_, ok := t.friends[friendNumber]
+	return ok, nil
+}
+
+/* SelfGetFriendlistSize returns the number of friends on the friendlist. */
+func (t *Tox) SelfGetFriendlistSize() (int64, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	return int64(len(t.friends)), nil
+}
+
+/* SelfGetFriendlist returns a slice of uint32 containing the friendNumbers. */
+func (t *Tox) SelfGetFriendlist() ([]uint32, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	return t.friendNumbers(), nil
+}
+
+/* FriendGetPublickey returns the publickey associated to that friendNumber. */
+func (t *Tox) FriendGetPublickey(friendNumber uint32) ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return nil, ErrArgs
+	}
+
+	return append([]byte(nil), f.publicKey...), nil
+}
+
+/* FriendGetLastOnline returns the timestamp of the last time the friend with
+ * the given friendNumber was seen online. */
+func (t *Tox) FriendGetLastOnline(friendNumber uint32) (time.Time, error) {
+	if !t.lock() {
+		return time.Time{}, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok || f.lastOnline.IsZero() {
+		return time.Time{}, ErrFuncFail
+	}
+	if f.connection != TOX_CONNECTION_NONE {
+		return time.Unix(t.net.now.Unix(), 0), nil
+	}
+
+	return time.Unix(f.lastOnline.Unix(), 0), nil
+}
+
+/* FriendGetNameSize returns the length of the name of friendNumber. */
+func (t *Tox) FriendGetNameSize(friendNumber uint32) (int64, error) {
+	name, err := t.FriendGetName(friendNumber)
+	return int64(len(name)), err
+}
+
+/* FriendGetName returns the name of friendNumber. */
+func (t *Tox) FriendGetName(friendNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return f.name, nil
+}
+
+/* FriendGetStatusMessageSize returns the size of the status of a friend with
+ * the given friendNumber.
+ */
+func (t *Tox) FriendGetStatusMessageSize(friendNumber uint32) (int64, error) {
+	message, err := t.FriendGetStatusMessage(friendNumber)
+	return int64(len(message)), err
+}
+
+/* FriendGetStatusMessage returns the status message of friend with the given
+ * friendNumber.
+ */
+func (t *Tox) FriendGetStatusMessage(friendNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return f.statusMessage, nil
+}
+
+/* FriendGetStatus returns the status of friendNumber. */
+func (t *Tox) FriendGetStatus(friendNumber uint32) (ToxUserStatus, error) {
+	if !t.lock() {
+		return TOX_USERSTATUS_NONE, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return TOX_USERSTATUS_NONE, ErrFuncFail
+	}
+
+	return f.status, nil
+}
+
+/* FriendGetConnectionStatus returns true if the friend is connected. */
+func (t *Tox) FriendGetConnectionStatus(friendNumber uint32) (ToxConnection, error) {
+	if !t.lock() {
+		return TOX_CONNECTION_NONE, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return TOX_CONNECTION_NONE, ErrFuncFail
+	}
+
+	return f.connection, nil
+}
+
+/* FriendGetTyping returns true if friendNumber is typing. */
+func (t *Tox) FriendGetTyping(friendNumber uint32) (bool, error) {
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return false, ErrFuncFail
+	}
+
+	return f.typing, nil
+}
+
+/* SelfSetTyping sets your typing status to a friend. */
+func (t *Tox) SelfSetTyping(friendNumber uint32, typing bool) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil {
+		return ErrFuncFail
+	}
+	if peer == nil {
+		return nil
+	}
+
+	n := t.net
+	n.post(peer, func() {
+		n.mu.Lock()
+		number, f := peer.friendByKey(t.publicKey)
+		if f != nil {
+			f.typing = typing
+		}
+		n.mu.Unlock()
+
+		if f != nil {
+			if cb := peer.onFriendTypingChanges; cb != nil {
+				cb(peer, number, typing)
+			}
+		}
+	})
+
+	return nil
+}
+
+/* FriendSendMessage sends a message to a friend if he/she is online.
+ * Maximum message length is MAX_MESSAGE_LENGTH.
+ * messagetype is the type of the message (normal, action, ...).
+ * Returns the message ID if successful, an error otherwise.
+ */
+func (t *Tox) FriendSendMessage(friendNumber uint32, messagetype ToxMessageType, message []byte) (uint32, error) {
+	if len(message) == 0 {
+		return 0, ErrArgs
+	}
+	if len(message) > TOX_MAX_MESSAGE_LENGTH {
+		return 0, ErrFriendSendMessageTooLong
+	}
+
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil {
+		return 0, ErrFriendSendMessageFriendNotFound
+	}
+	if peer == nil {
+		return 0, ErrFriendSendMessageFriendNotConnected
+	}
+
+	n := t.net
+	if n.sendqFull(t) {
+		return 0, ErrFriendSendMessageSendq
+	}
+
+	f.messageID++
+	messageID := f.messageID
+	if n.dropped() {
+		return messageID, nil
+	}
+
+	data := append([]byte(nil), message...)
+	n.post(peer, func() {
+		n.mu.Lock()
+		number, f := peer.friendByKey(t.publicKey)
+		delivered := f != nil && n.linked(t, peer)
+		n.mu.Unlock()
+
+		if !delivered {
+			return
+		}
+		if cb := peer.onFriendMessage; cb != nil {
+			cb(peer, number, messagetype, peer.payload(data), uint32(len(data)))
+		}
+
+		n.send(func() {
+			n.post(t, func() {
+				n.mu.Lock()
+				number, f := t.friendByKey(peer.publicKey)
+				n.mu.Unlock()
+
+				if f != nil {
+					if cb := t.onFriendReadReceipt; cb != nil {
+						cb(t, number, messageID)
+					}
+				}
+			})
+		})
+	})
+
+	return messageID, nil
+}
+
+/* Hash generates a cryptographic hash of the given data (can be used to cache
+ * avatars). */
+func (t *Tox) Hash(data []byte) ([]byte, error) {
+	if t == nil || t.net == nil {
+		return nil, ErrToxInit
+	}
+
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+/* FriendSendLossyPacket sends a custom lossy packet to a friend.
+ * The first byte of data must be in the range 200-254. Maximum length of a
+ * custom packet is TOX_MAX_CUSTOM_PACKET_SIZE. */
+func (t *Tox) FriendSendLossyPacket(friendNumber uint32, data []byte) error {
+	if len(data) == 0 {
+		return ErrArgs
+	}
+	if data[0] < 200 || data[0] > 254 {
+		return ErrFuncFail
+	}
+
+	return t.sendCustomPacket(friendNumber, data, func(to *Tox) OnFriendLossyPacket { return to.onFriendLossyPacket })
+}
+
+/* FriendSendLosslessPacket sends a custom lossless packet to a friend.
+ * The first byte of data must be in the range 160-191. Maximum length of a
+ * custom packet is TOX_MAX_CUSTOM_PACKET_SIZE. */
+func (t *Tox) FriendSendLosslessPacket(friendNumber uint32, data []byte) error {
+	if len(data) == 0 {
+		return ErrArgs
+	}
+	if data[0] < 160 || data[0] > 191 {
+		return ErrFuncFail
+	}
+
+	return t.sendCustomPacket(friendNumber, data, func(to *Tox) OnFriendLossyPacket {
+		if to.onFriendLosslessPacket == nil {
+			return nil
+		}
+		return OnFriendLossyPacket(to.onFriendLosslessPacket)
+	})
+}
+
+func (t *Tox) sendCustomPacket(friendNumber uint32, data []byte, handler func(to *Tox) OnFriendLossyPacket) error {
+	if len(data) > TOX_MAX_CUSTOM_PACKET_SIZE {
+		return ErrFuncFail
+	}
+
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return ErrFuncFail
+	}
+
+	n := t.net
+	if n.sendqFull(t) {
+		return ErrFriendCustomPacketSendq
+	}
+	if n.dropped() {
+		return nil
+	}
+
+	packet := append([]byte(nil), data...)
+	n.post(peer, func() {
+		n.mu.Lock()
+		number, f := peer.friendByKey(t.publicKey)
+		delivered := f != nil && n.linked(t, peer)
+		n.mu.Unlock()
+
+		if delivered {
+			if cb := handler(peer); cb != nil {
+				cb(peer, number, peer.payload(packet), uint32(len(packet)))
+			}
+		}
+	})
+
+	return nil
+}
+
+/* SelfGetDhtId returns the temporary DHT public key of this instance. */
+func (t *Tox) SelfGetDhtId() ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	return append([]byte(nil), t.dhtID...), nil
+}
+
+/* SelfGetUDPPort returns the UDP port the Tox instance is bound to. */
+func (t *Tox) SelfGetUDPPort() (uint16, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.udpPort == 0 {
+		return 0, ErrFuncFail
+	}
+
+	return t.udpPort, nil
+}
+
+/* SelfGetTCPPort returns the TCP port the Tox instance is bound to. This is
+ * only relevant if the instance is acting as a TCP relay. */
+func (t *Tox) SelfGetTCPPort() (uint16, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	if t.tcpPort == 0 {
+		return 0, ErrFuncFail
+	}
+
+	return t.tcpPort, nil
+}
+
+// Network returns the Network t lives on.
+func (t *Tox) Network() *Network {
+	return t.net
+}
+
+// payload hands the payload of a callback over according to the BufferMode of
+// t. The simulation always delivers a private copy, so borrowing it is free.
+func (t *Tox) payload(data []byte) []byte {
+	switch t.GetBufferMode() {
+	case BufferBorrowed:
+		return data
+	case BufferPooled:
+		buffer := pooledBuffer(len(data))
+		copy(buffer, data)
+		return buffer
+	}
+
+	return append([]byte(nil), data...)
+}
@@ -0,0 +1,175 @@
+//go:build toxsim
+
+package libtox
+
+/*
+ * Functions to register the callbacks.
+ */
+
+// CallbackSelfConnectionStatusChanges sets the function to be called when self connection status changed.
+func (t *Tox) CallbackSelfConnectionStatusChanges(f OnSelfConnectionStatusChanges) {
+	if t.lock() {
+		t.onSelfConnectionStatusChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendNameChanges sets the function to be called for friend's name changed.
+func (t *Tox) CallbackFriendNameChanges(f OnFriendNameChanges) {
+	if t.lock() {
+		t.onFriendNameChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendStatusMessageChanges sets the function to be called when friend's status message changed.
+func (t *Tox) CallbackFriendStatusMessageChanges(f OnFriendStatusMessageChanges) {
+	if t.lock() {
+		t.onFriendStatusMessageChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendStatusChanges sets the function to be called when friend's status changed.
+func (t *Tox) CallbackFriendStatusChanges(f OnFriendStatusChanges) {
+	if t.lock() {
+		t.onFriendStatusChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendConnectionStatusChanges sets the function to be called when friend's connection status changed.
+func (t *Tox) CallbackFriendConnectionStatusChanges(f OnFriendConnectionStatusChanges) {
+	if t.lock() {
+		t.onFriendConnectionStatusChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendTypingChanges sets the function to be called when friend's typing changed.
+func (t *Tox) CallbackFriendTypingChanges(f OnFriendTypingChanges) {
+	if t.lock() {
+		t.onFriendTypingChanges = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendReadReceipt sets the function to be called when receiving read receipts.
+func (t *Tox) CallbackFriendReadReceipt(f OnFriendReadReceipt) {
+	if t.lock() {
+		t.onFriendReadReceipt = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendRequest sets the function to be called when friend's request receipts.
+func (t *Tox) CallbackFriendRequest(f OnFriendRequest) {
+	if t.lock() {
+		t.onFriendRequest = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendMessage sets the function to be called when receiving a friend message.
+func (t *Tox) CallbackFriendMessage(f OnFriendMessage) {
+	if t.lock() {
+		t.onFriendMessage = f
+		t.unlock()
+	}
+}
+
+// CallbackFileRecvControl sets the callback for file control requests.
+func (t *Tox) CallbackFileRecvControl(f OnFileRecvControl) {
+	if t.lock() {
+		t.onFileRecvControl = f
+		t.unlock()
+	}
+}
+
+// CallbackFileChunkRequest sets the callback to be called when tox is ready to send more file data.
+func (t *Tox) CallbackFileChunkRequest(f OnFileChunkRequest) {
+	if t.lock() {
+		t.onFileChunkRequest = f
+		t.unlock()
+	}
+}
+
+// CallbackFileRecv sets the callback to be called when a file transfer request is received.
+func (t *Tox) CallbackFileRecv(f OnFileRecv) {
+	if t.lock() {
+		t.onFileRecv = f
+		t.unlock()
+	}
+}
+
+// and subsequently when a chunk of file data for an accepted request was received.
+func (t *Tox) CallbackFileRecvChunk(f OnFileRecvChunk) {
+	if t.lock() {
+		t.onFileRecvChunk = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendLossyPacket sets the callback to be called when a lossy packet is received from a friend.
+func (t *Tox) CallbackFriendLossyPacket(f OnFriendLossyPacket) {
+	if t.lock() {
+		t.onFriendLossyPacket = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendLosslessPacket sets the callback to be called when a lossless packet is received from a friend.
+func (t *Tox) CallbackFriendLosslessPacket(f OnFriendLosslessPacket) {
+	if t.lock() {
+		t.onFriendLosslessPacket = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendLosslessPacket sets the callback to be called when the client is invited to join a conference.
+func (t *Tox) CallbackConferenceInvite(f OnConferenceInvite) {
+	if t.lock() {
+		t.onConferenceInvite = f
+		t.unlock()
+	}
+}
+
+// CallbackFriendLosslessPacket sets the callback to be called when the client receives a conference message.
+func (t *Tox) CallbackConferenceMessage(f OnConferenceMessage) {
+	if t.lock() {
+		t.onConferenceMessage = f
+		t.unlock()
+	}
+}
+
+// after joining it with the tox_conference_join function.
+func (t *Tox) CallbackConferenceConnected(f OnConferenceConnected) {
+	if t.lock() {
+		t.onConferenceConnected = f
+		t.unlock()
+	}
+}
+
+// CallbackConferenceTitle sets the callback to be called when a peer changes the conference title.
+func (t *Tox) CallbackConferenceTitle(f OnConferenceTitle) {
+	if t.lock() {
+		t.onConferenceTitle = f
+		t.unlock()
+	}
+}
+
+// CallbackConferencePeerName sets the callback to be called when a peer of a conference changes their name.
+func (t *Tox) CallbackConferencePeerName(f OnConferencePeerName) {
+	if t.lock() {
+		t.onConferencePeerName = f
+		t.unlock()
+	}
+}
+
+// CallbackConferencePeerListChanged sets the callback to be called when a peer joins or leaves a conference.
+func (t *Tox) CallbackConferencePeerListChanged(f OnConferencePeerListChanged) {
+	if t.lock() {
+		t.onConferencePeerListChanged = f
+		t.unlock()
+	}
+}
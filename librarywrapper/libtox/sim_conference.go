@@ -0,0 +1,486 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// simConference is a conference shared by all its members on a Network. The
+// peer number of a member is its index in members.
+type simConference struct {
+	id      [TOX_CONFERENCE_ID_SIZE]byte
+	ctype   ToxConferenceType
+	title   string
+	members []*Tox
+}
+
+// peer returns the peer number of t in c.
+func (c *simConference) peer(t *Tox) (uint32, bool) {
+	for i, m := range c.members {
+		if m == t {
+			return uint32(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// leave removes t from c.
+func (c *simConference) leave(t *Tox) {
+	if i, ok := c.peer(t); ok {
+		c.members = append(c.members[:i], c.members[i+1:]...)
+	}
+}
+
+// cookie returns the invite cookie of c: the conference type followed by the
+// conference id.
+func (c *simConference) cookie() []byte {
+	return append([]byte{byte(c.ctype)}, c.id[:]...)
+}
+
+// conferenceNumber returns the number t uses for c. n.mu must be held.
+func (t *Tox) conferenceNumber(c *simConference) (uint32, bool) {
+	for number, conference := range t.conferences {
+		if conference == c {
+			return number, true
+		}
+	}
+
+	return 0, false
+}
+
+// addConference stores c under the lowest free conference number. n.mu must
+// be held.
+func (t *Tox) addConference(c *simConference) uint32 {
+	number := uint32(0)
+	for {
+		if _, used := t.conferences[number]; !used {
+			break
+		}
+		number++
+	}
+	t.conferences[number] = c
+	c.members = append(c.members, t)
+
+	return number
+}
+
+// postConference queues fire at every member of c but skip, with the number
+// the member uses for c. Members who left c meanwhile are skipped. n.mu must be
+// held.
+func (n *Network) postConference(c *simConference, skip *Tox, fire func(m *Tox, number uint32)) {
+	for _, m := range c.members {
+		m := m
+		if m == skip {
+			continue
+		}
+
+		n.post(m, func() {
+			n.mu.Lock()
+			number, joined := m.conferenceNumber(c)
+			n.mu.Unlock()
+
+			if joined {
+				fire(m, number)
+			}
+		})
+	}
+}
+
+// postPeerListChanged tells every member of c that a peer joined or left.
+// n.mu must be held.
+func (n *Network) postPeerListChanged(c *simConference) {
+	n.postConference(c, nil, func(m *Tox, number uint32) {
+		if cb := m.onConferencePeerListChanged; cb != nil {
+			cb(m, number)
+		}
+	})
+}
+
+// conferencePeer returns the member peerNumber of the conference. n.mu must be
+// held.
+func (t *Tox) conferencePeer(conferenceNumber uint32, peerNumber uint32) (*Tox, bool) {
+	c, ok := t.conferences[conferenceNumber]
+	if !ok || peerNumber >= uint32(len(c.members)) {
+		return nil, false
+	}
+
+	return c.members[peerNumber], true
+}
+
+// ConferenceNew creates and connects to a new text conference.
+func (t *Tox) ConferenceNew() (uint32, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	n := t.net
+	c := &simConference{ctype: TOX_CONFERENCE_TYPE_TEXT}
+	n.rand.Read(c.id[:])
+	n.conferences[c.id] = c
+
+	return t.addConference(c), nil
+}
+
+// ConferenceDelete this function deletes a conference.
+func (t *Tox) ConferenceDelete(conferenceNumber uint32) (bool, error) {
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return false, ErrConferenceDeleteConferenceNotFound
+	}
+
+	c.leave(t)
+	delete(t.conferences, conferenceNumber)
+	if len(c.members) == 0 {
+		delete(t.net.conferences, c.id)
+	}
+	t.net.postPeerListChanged(c)
+
+	return true, nil
+}
+
+// ConferencePeerGetName
+func (t *Tox) ConferencePeerGetName(conferenceNumber, peerNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	peer, ok := t.conferencePeer(conferenceNumber, peerNumber)
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return peer.name, nil
+}
+
+func (t *Tox) ConferencePeerGetNameSize(conferenceNumber, peerNumber uint32) (int64, error) {
+	name, err := t.ConferencePeerGetName(conferenceNumber, peerNumber)
+	return int64(len(name)), err
+}
+
+func (t *Tox) ConferencePeerGetPublicKey(conferenceNumber uint32, peerNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	peer, ok := t.conferencePeer(conferenceNumber, peerNumber)
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return strings.ToUpper(hex.EncodeToString(peer.publicKey)), nil
+}
+
+func (t *Tox) ConferenceInvite(friendNumber uint32, conferenceNumber uint32) (int, error) {
+	if !t.lock() {
+		return -2, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil {
+		return -1, errors.New(fmt.Sprintf("friend not exists: %d", friendNumber))
+	}
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return 0, ErrConferenceInviteConferenceNotFound
+	}
+	if peer == nil {
+		return 0, ErrConferenceInviteFailSend
+	}
+
+	n := t.net
+	ctype := c.ctype
+	cookie := c.cookie()
+	n.post(peer, func() {
+		n.mu.Lock()
+		number, f := peer.friendByKey(t.publicKey)
+		delivered := f != nil && n.linked(t, peer)
+		n.mu.Unlock()
+
+		if delivered {
+			if cb := peer.onConferenceInvite; cb != nil {
+				cb(peer, number, ctype, cookie)
+			}
+		}
+	})
+
+	return 1, nil
+}
+
+func (t *Tox) ConferenceJoin(friendNumber uint32, cookie []byte) (uint32, error) {
+	if len(cookie) != 1+TOX_CONFERENCE_ID_SIZE {
+		return 0, errors.New("Invalid cookie:" + string(cookie))
+	}
+
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	n := t.net
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return 0, errors.New(fmt.Sprintf("join group chat failed: %d", 4))
+	}
+
+	var id [TOX_CONFERENCE_ID_SIZE]byte
+	copy(id[:], cookie[1:])
+	c, ok := n.conferences[id]
+	if !ok || byte(c.ctype) != cookie[0] {
+		return 0, errors.New(fmt.Sprintf("join group chat failed: %d", 2))
+	}
+	if _, joined := t.conferenceNumber(c); joined {
+		return 0, errors.New(fmt.Sprintf("join group chat failed: %d", 5))
+	}
+
+	number := t.addConference(c)
+	n.post(t, func() {
+		if cb := t.onConferenceConnected; cb != nil {
+			cb(t, number)
+		}
+	})
+	n.postPeerListChanged(c)
+
+	return number, nil
+}
+
+func (t *Tox) ConferenceSendMessage(conferenceNumber uint32, messageType ToxMessageType, message []byte) (bool, error) {
+	if len(message) == 0 {
+		return false, ErrArgs
+	}
+
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return false, errors.New(fmt.Sprintf("group send message failed: %d", TOX_ERR_CONFERENCE_SEND_MESSAGE_CONFERENCE_NOT_FOUND))
+	}
+	if len(message) > TOX_MAX_MESSAGE_LENGTH {
+		return false, errors.New(fmt.Sprintf("group send message failed: %d", TOX_ERR_CONFERENCE_SEND_MESSAGE_TOO_LONG))
+	}
+	if !t.net.online(t) {
+		return false, errors.New(fmt.Sprintf("group send message failed: %d", TOX_ERR_CONFERENCE_SEND_MESSAGE_NO_CONNECTION))
+	}
+
+	n := t.net
+	data := append([]byte(nil), message...)
+	for _, m := range c.members {
+		m := m
+		if m != t && n.dropped() {
+			continue
+		}
+
+		n.post(m, func() {
+			n.mu.Lock()
+			number, joined := m.conferenceNumber(c)
+			peerNumber, member := c.peer(t)
+			delivered := joined && member && (m == t || n.linked(t, m))
+			n.mu.Unlock()
+
+			if delivered {
+				if cb := m.onConferenceMessage; cb != nil {
+					cb(m, number, peerNumber, messageType, m.payload(data), uint32(len(data)))
+				}
+			}
+		})
+	}
+
+	return true, nil
+}
+
+func (t *Tox) ConferenceSetTitle(conferenceNumber uint32, title string) (bool, error) {
+	if len(title) > TOX_MAX_NAME_LENGTH {
+		return false, ErrFuncFail
+	}
+
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return false, ErrFuncFail
+	}
+	c.title = title
+
+	peerNumber, _ := c.peer(t)
+	t.net.postConference(c, t, func(m *Tox, number uint32) {
+		if cb := m.onConferenceTitle; cb != nil {
+			cb(m, number, peerNumber, []byte(title), uint32(len(title)))
+		}
+	})
+
+	return true, nil
+}
+
+func (t *Tox) ConferenceGetTitle(conferenceNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return c.title, nil
+}
+
+func (t *Tox) ConferenceGetTitleSize(conferenceNumber uint32) (int64, error) {
+	title, err := t.ConferenceGetTitle(conferenceNumber)
+	return int64(len(title)), err
+}
+
+func (t *Tox) ConferencePeerNumberIsOurs(conferenceNumber, peerNumber uint32) (bool, error) {
+	if !t.lock() {
+		return false, ErrToxInit
+	}
+	defer t.unlock()
+
+	peer, ok := t.conferencePeer(conferenceNumber, peerNumber)
+	if !ok {
+		return false, ErrFuncFail
+	}
+
+	return peer == t, nil
+}
+
+func (t *Tox) ConferencePeerCount(conferenceNumber uint32) (uint32, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return 0, ErrFuncFail
+	}
+
+	return uint32(len(c.members)), nil
+}
+
+// extra combined api
+func (t *Tox) ConferenceGetNames(conferenceNumber uint32) ([]string, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return nil, ErrFuncFail
+	}
+
+	peerNames := make([]string, 0, len(c.members))
+	for _, m := range c.members {
+		peerNames = append(peerNames, m.name)
+	}
+
+	return peerNames, nil
+}
+
+func (t *Tox) ConferenceGetPeerPubkeys(conferenceNumber uint32) ([]string, error) {
+	peers, err := t.ConferenceGetPeers(conferenceNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	peerPubkeys := make([]string, len(peers))
+	for peerNumber, pubkey := range peers {
+		peerPubkeys[peerNumber] = pubkey
+	}
+
+	return peerPubkeys, nil
+}
+
+// return [peerNumber]pubKey
+func (t *Tox) ConferenceGetPeers(conferenceNumber uint32) (map[uint32]string, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return nil, ErrFuncFail
+	}
+
+	peers := make(map[uint32]string, len(c.members))
+	for i, m := range c.members {
+		peers[uint32(i)] = strings.ToUpper(hex.EncodeToString(m.publicKey))
+	}
+
+	return peers, nil
+}
+
+// ConferenceGetChatlistSize
+func (t *Tox) ConferenceGetChatlistSize() (uint32, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	return uint32(len(t.conferences)), nil
+}
+
+func (t *Tox) ConferenceGetChatlist() ([]uint32, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	chatList := make([]uint32, 0, len(t.conferences))
+	for number := range t.conferences {
+		chatList = append(chatList, number)
+	}
+	sort.Slice(chatList, func(i, j int) bool { return chatList[i] < chatList[j] })
+
+	return chatList, nil
+}
+
+func (t *Tox) ConferenceGetType(conferenceNumber uint32) (int, error) {
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return 0, ErrFuncFail
+	}
+
+	return int(c.ctype), nil
+}
+
+func (t *Tox) ConferenceGetIdentifier(conferenceNumber uint32) (string, error) {
+	if !t.lock() {
+		return "", ErrToxInit
+	}
+	defer t.unlock()
+
+	c, ok := t.conferences[conferenceNumber]
+	if !ok {
+		return "", ErrFuncFail
+	}
+
+	return strings.ToUpper(hex.EncodeToString(c.id[:])), nil
+}
@@ -0,0 +1,335 @@
+//go:build toxsim
+
+package libtox
+
+// The values below mirror tox.h, so code built against the simulated backend
+// sees the same constants and enum values as with the native library.
+
+const (
+	TOX_PUBLIC_KEY_SIZE           = 32
+	TOX_SECRET_KEY_SIZE           = 32
+	TOX_CONFERENCE_UID_SIZE       = 32
+	TOX_CONFERENCE_ID_SIZE        = 32
+	TOX_NOSPAM_SIZE               = 4  //(sizeof(uint32_t))
+	TOX_ADDRESS_SIZE              = 38 //(TOX_PUBLIC_KEY_SIZE + TOX_NOSPAM_SIZE + sizeof(uint16_t))
+	TOX_MAX_NAME_LENGTH           = 128
+	TOX_MAX_STATUS_MESSAGE_LENGTH = 1007
+	TOX_MAX_FRIEND_REQUEST_LENGTH = 1016
+	TOX_MAX_MESSAGE_LENGTH        = 1372
+	TOX_MAX_CUSTOM_PACKET_SIZE    = 1373
+	TOX_HASH_LENGTH               = 32
+	TOX_FILE_ID_LENGTH            = 32
+	TOX_MAX_FILENAME_LENGTH       = 255
+	TOX_MAX_HOSTNAME_LENGTH       = 255
+)
+
+type ToxUserStatus uint32
+
+var (
+	TOX_USERSTATUS_NONE ToxUserStatus = 0 //User is online and available.
+	TOX_USERSTATUS_AWAY ToxUserStatus = 1 //User is away. Clients can set this e.g. after a user defined inactivity time.
+	TOX_USERSTATUS_BUSY ToxUserStatus = 2 //User is busy. Signals to other clients that this client does not currently wish to communicate.
+)
+
+type ToxMessageType uint32
+
+var (
+	TOX_MESSAGE_TYPE_NORMAL ToxMessageType = 0
+	TOX_MESSAGE_TYPE_ACTION ToxMessageType = 1
+)
+
+type ToxProxyType uint32
+
+var (
+	TOX_PROXY_TYPE_NONE   ToxProxyType = 0
+	TOX_PROXY_TYPE_HTTP   ToxProxyType = 1
+	TOX_PROXY_TYPE_SOCKS5 ToxProxyType = 2
+)
+
+type ToxSaveDataType uint32
+
+var (
+	TOX_SAVEDATA_TYPE_NONE       ToxSaveDataType = 0
+	TOX_SAVEDATA_TYPE_TOX_SAVE   ToxSaveDataType = 1
+	TOX_SAVEDATA_TYPE_SECRET_KEY ToxSaveDataType = 2
+)
+
+type ToxConferenceType uint32
+
+var (
+	TOX_CONFERENCE_TYPE_TEXT ToxConferenceType = 0
+	TOX_CONFERENCE_TYPE_AV   ToxConferenceType = 1
+)
+
+type ToxErrOptionsNew uint32
+
+var (
+	TOX_ERR_OPTIONS_NEW_OK     ToxErrOptionsNew = 0
+	TOX_ERR_OPTIONS_NEW_MALLOC ToxErrOptionsNew = 1
+)
+
+type ToxConnection uint32
+
+var (
+	TOX_CONNECTION_NONE ToxConnection = 0
+	TOX_CONNECTION_TCP  ToxConnection = 1
+	TOX_CONNECTION_UDP  ToxConnection = 2
+)
+
+type ToxFileKind uint32
+
+var (
+	TOX_FILE_KIND_DATA   ToxFileKind = 0
+	TOX_FILE_KIND_AVATAR ToxFileKind = 1
+)
+
+type ToxFileControl uint32
+
+var (
+	TOX_FILE_CONTROL_RESUME ToxFileControl = 0
+	TOX_FILE_CONTROL_PAUSE  ToxFileControl = 1
+	TOX_FILE_CONTROL_CANCEL ToxFileControl = 2
+)
+
+/* === Errors === */
+
+type ToxErrNew uint32
+
+var (
+	TOX_ERR_NEW_OK              ToxErrNew = 0
+	TOX_ERR_NEW_NULL            ToxErrNew = 1
+	TOX_ERR_NEW_MALLOC          ToxErrNew = 2
+	TOX_ERR_NEW_PORT_ALLOC      ToxErrNew = 3
+	TOX_ERR_NEW_PROXY_BAD_TYPE  ToxErrNew = 4
+	TOX_ERR_NEW_PROXY_BAD_HOST  ToxErrNew = 5
+	TOX_ERR_NEW_PROXY_BAD_PORT  ToxErrNew = 6
+	TOX_ERR_NEW_PROXY_NOT_FOUND ToxErrNew = 7
+	TOX_ERR_NEW_LOAD_ENCRYPTED  ToxErrNew = 8
+	TOX_ERR_NEW_LOAD_BAD_FORMAT ToxErrNew = 9
+)
+
+type ToxErrBootstrap uint32
+
+var (
+	TOX_ERR_BOOTSTRAP_OK       ToxErrBootstrap = 0
+	TOX_ERR_BOOTSTRAP_NULL     ToxErrBootstrap = 1
+	TOX_ERR_BOOTSTRAP_BAD_HOST ToxErrBootstrap = 2
+	TOX_ERR_BOOTSTRAP_BAD_PORT ToxErrBootstrap = 3
+)
+
+type ToxErrFriendAdd uint32
+
+var (
+	TOX_ERR_FRIEND_ADD_OK             ToxErrFriendAdd = 0
+	TOX_ERR_FRIEND_ADD_NULL           ToxErrFriendAdd = 1
+	TOX_ERR_FRIEND_ADD_TOO_LONG       ToxErrFriendAdd = 2
+	TOX_ERR_FRIEND_ADD_NO_MESSAGE     ToxErrFriendAdd = 3
+	TOX_ERR_FRIEND_ADD_OWN_KEY        ToxErrFriendAdd = 4
+	TOX_ERR_FRIEND_ADD_ALREADY_SENT   ToxErrFriendAdd = 5
+	TOX_ERR_FRIEND_ADD_BAD_CHECKSUM   ToxErrFriendAdd = 6
+	TOX_ERR_FRIEND_ADD_SET_NEW_NOSPAM ToxErrFriendAdd = 7
+	TOX_ERR_FRIEND_ADD_MALLOC         ToxErrFriendAdd = 8
+)
+
+type ToxErrFriendByPublicKey uint32
+
+var (
+	TOX_ERR_FRIEND_BY_PUBLIC_KEY_OK        ToxErrFriendByPublicKey = 0
+	TOX_ERR_FRIEND_BY_PUBLIC_KEY_NULL      ToxErrFriendByPublicKey = 1
+	TOX_ERR_FRIEND_BY_PUBLIC_KEY_NOT_FOUND ToxErrFriendByPublicKey = 2
+)
+
+type ToxErrFriendGetPublicKey uint32
+
+var (
+	TOX_ERR_FRIEND_GET_PUBLIC_KEY_OK               ToxErrFriendGetPublicKey = 0
+	TOX_ERR_FRIEND_GET_PUBLIC_KEY_FRIEND_NOT_FOUND ToxErrFriendGetPublicKey = 1
+)
+
+type ToxErrFriendDelete uint32
+
+var (
+	TOX_ERR_FRIEND_DELETE_OK               ToxErrFriendDelete = 0
+	TOX_ERR_FRIEND_DELETE_FRIEND_NOT_FOUND ToxErrFriendDelete = 1
+)
+
+type ToxErrFriendQuery uint32
+
+var (
+	TOX_ERR_FRIEND_QUERY_OK               ToxErrFriendQuery = 0
+	TOX_ERR_FRIEND_QUERY_NULL             ToxErrFriendQuery = 1
+	TOX_ERR_FRIEND_QUERY_FRIEND_NOT_FOUND ToxErrFriendQuery = 2
+)
+
+type ToxErrSetInfo uint32
+
+var (
+	TOX_ERR_SET_INFO_OK       ToxErrSetInfo = 0
+	TOX_ERR_SET_INFO_NULL     ToxErrSetInfo = 1
+	TOX_ERR_SET_INFO_TOO_LONG ToxErrSetInfo = 2
+)
+
+type ToxErrSetTyping uint32
+
+var (
+	TOX_ERR_SET_TYPING_OK               ToxErrSetTyping = 0
+	TOX_ERR_SET_TYPING_FRIEND_NOT_FOUND ToxErrSetTyping = 1
+)
+
+type ToxErrFriendSendMessage uint32
+
+var (
+	TOX_ERR_FRIEND_SEND_MESSAGE_OK                   ToxErrFriendSendMessage = 0
+	TOX_ERR_FRIEND_SEND_MESSAGE_NULL                 ToxErrFriendSendMessage = 1
+	TOX_ERR_FRIEND_SEND_MESSAGE_FRIEND_NOT_FOUND     ToxErrFriendSendMessage = 2
+	TOX_ERR_FRIEND_SEND_MESSAGE_FRIEND_NOT_CONNECTED ToxErrFriendSendMessage = 3
+	TOX_ERR_FRIEND_SEND_MESSAGE_SENDQ                ToxErrFriendSendMessage = 4
+	TOX_ERR_FRIEND_SEND_MESSAGE_TOO_LONG             ToxErrFriendSendMessage = 5
+	TOX_ERR_FRIEND_SEND_MESSAGE_EMPTY                ToxErrFriendSendMessage = 6
+)
+
+type ToxErrFriendGetLastOnline uint32
+
+var (
+	TOX_ERR_FRIEND_GET_LAST_ONLINE_OK               ToxErrFriendGetLastOnline = 0
+	TOX_ERR_FRIEND_GET_LAST_ONLINE_FRIEND_NOT_FOUND ToxErrFriendGetLastOnline = 1
+)
+
+type ToxErrFileControl uint32
+
+var (
+	TOX_ERR_FILE_CONTROL_OK                   ToxErrFileControl = 0
+	TOX_ERR_FILE_CONTROL_FRIEND_NOT_FOUND     ToxErrFileControl = 1
+	TOX_ERR_FILE_CONTROL_FRIEND_NOT_CONNECTED ToxErrFileControl = 2
+	TOX_ERR_FILE_CONTROL_NOT_FOUND            ToxErrFileControl = 3
+	TOX_ERR_FILE_CONTROL_NOT_PAUSED           ToxErrFileControl = 4
+	TOX_ERR_FILE_CONTROL_DENIED               ToxErrFileControl = 5
+	TOX_ERR_FILE_CONTROL_ALREADY_PAUSED       ToxErrFileControl = 6
+	TOX_ERR_FILE_CONTROL_SENDQ                ToxErrFileControl = 7
+)
+
+type ToxErrFileSeek uint32
+
+var (
+	TOX_ERR_FILE_SEEK_OK                                 ToxErrFileSeek = 0
+	TOX_ERR_FILE_SEEK_FRIEND_NOT_FOUND                   ToxErrFileSeek = 1
+	TOX_ERR_FILE_SEEK_FRIEND_NOT_CONNECTEDToxErrFileSeek ToxErrFileSeek = 2
+	TOX_ERR_FILE_SEEK_NOT_FOUND                          ToxErrFileSeek = 3
+	TOX_ERR_FILE_SEEK_DENIED                             ToxErrFileSeek = 4
+	TOX_ERR_FILE_SEEK_INVALID_POSITION                   ToxErrFileSeek = 5
+	TOX_ERR_FILE_SEEK_SENDQ                              ToxErrFileSeek = 6
+)
+
+type ToxErrFileGet uint32
+
+var (
+	TOX_ERR_FILE_GET_OK               ToxErrFileGet = 0
+	TOX_ERR_FILE_GET_NULL             ToxErrFileGet = 1
+	TOX_ERR_FILE_GET_FRIEND_NOT_FOUND ToxErrFileGet = 2
+	TOX_ERR_FILE_GET_NOT_FOUND        ToxErrFileGet = 3
+)
+
+type ToxErrFileSend uint32
+
+var (
+	TOX_ERR_FILE_SEND_OK                   ToxErrFileSend = 0
+	TOX_ERR_FILE_SEND_NULL                 ToxErrFileSend = 1
+	TOX_ERR_FILE_SEND_FRIEND_NOT_FOUND     ToxErrFileSend = 2
+	TOX_ERR_FILE_SEND_FRIEND_NOT_CONNECTED ToxErrFileSend = 3
+	TOX_ERR_FILE_SEND_NAME_TOO_LONG        ToxErrFileSend = 4
+	TOX_ERR_FILE_SEND_TOO_MANY             ToxErrFileSend = 5
+)
+
+type ToxErrFileSendChunk uint32
+
+var (
+	TOX_ERR_FILE_SEND_CHUNK_OK                   ToxErrFileSendChunk = 0
+	TOX_ERR_FILE_SEND_CHUNK_NULL                 ToxErrFileSendChunk = 1
+	TOX_ERR_FILE_SEND_CHUNK_FRIEND_NOT_FOUND     ToxErrFileSendChunk = 2
+	TOX_ERR_FILE_SEND_CHUNK_FRIEND_NOT_CONNECTED ToxErrFileSendChunk = 3
+	TOX_ERR_FILE_SEND_CHUNK_NOT_FOUND            ToxErrFileSendChunk = 4
+	TOX_ERR_FILE_SEND_CHUNK_NOT_TRANSFERRING     ToxErrFileSendChunk = 5
+	TOX_ERR_FILE_SEND_CHUNK_INVALID_LENGTH       ToxErrFileSendChunk = 6
+	TOX_ERR_FILE_SEND_CHUNK_SENDQ                ToxErrFileSendChunk = 7
+	TOX_ERR_FILE_SEND_CHUNK_WRONG_POSITION       ToxErrFileSendChunk = 8
+)
+
+type ToxErrFriendCustomPacket uint32
+
+var (
+	TOX_ERR_FRIEND_CUSTOM_PACKET_OK                   ToxErrFriendCustomPacket = 0
+	TOX_ERR_FRIEND_CUSTOM_PACKET_NULL                 ToxErrFriendCustomPacket = 1
+	TOX_ERR_FRIEND_CUSTOM_PACKET_FRIEND_NOT_FOUND     ToxErrFriendCustomPacket = 2
+	TOX_ERR_FRIEND_CUSTOM_PACKET_FRIEND_NOT_CONNECTED ToxErrFriendCustomPacket = 3
+	TOX_ERR_FRIEND_CUSTOM_PACKET_INVALID              ToxErrFriendCustomPacket = 4
+	TOX_ERR_FRIEND_CUSTOM_PACKET_EMPTY                ToxErrFriendCustomPacket = 5
+	TOX_ERR_FRIEND_CUSTOM_PACKET_TOO_LONG             ToxErrFriendCustomPacket = 6
+	TOX_ERR_FRIEND_CUSTOM_PACKET_SENDQ                ToxErrFriendCustomPacket = 7
+)
+
+type ToxErrGetPort uint32
+
+var (
+	TOX_ERR_GET_PORT_OK        ToxErrGetPort = 0
+	TOX_ERR_GET_PORT_NOT_BOUND ToxErrGetPort = 1
+)
+
+// Conference
+
+type ToxErrConferenceNew uint32
+
+var (
+	TOX_ERR_CONFERENCE_NEW_OK   ToxErrConferenceNew = 0 //The function returned successfully.
+	TOX_ERR_CONFERENCE_NEW_INIT ToxErrConferenceNew = 1 //The conference instance failed to initialize.
+)
+
+type ToxErrConferenceDelete uint32
+
+var (
+	TOX_ERR_CONFERENCE_DELETE_OK                   ToxErrConferenceDelete = 0
+	TOX_ERR_CONFERENCE_DELETE_CONFERENCE_NOT_FOUND ToxErrConferenceDelete = 1 //The conference number passed did not designate a valid conference.
+)
+
+type ToxErrConferencePeerQuery uint32
+
+var (
+	TOX_ERR_CONFERENCE_PEER_QUERY_OK                   ToxErrConferencePeerQuery = 0
+	TOX_ERR_CONFERENCE_PEER_QUERY_CONFERENCE_NOT_FOUND ToxErrConferencePeerQuery = 1
+	TOX_ERR_CONFERENCE_PEER_QUERY_PEER_NOT_FOUND       ToxErrConferencePeerQuery = 2
+	TOX_ERR_CONFERENCE_PEER_QUERY_NO_CONNECTION        ToxErrConferencePeerQuery = 3
+)
+
+type ToxErrConferenceInvite uint32
+
+var (
+	TOX_ERR_CONFERENCE_INVITE_OK                   ToxErrConferenceInvite = 0
+	TOX_ERR_CONFERENCE_INVITE_CONFERENCE_NOT_FOUND ToxErrConferenceInvite = 1
+	TOX_ERR_CONFERENCE_INVITE_FAIL_SEND            ToxErrConferenceInvite = 2
+	TOX_ERR_CONFERENCE_INVITE_NO_CONNECTION        ToxErrConferenceInvite = 3
+)
+
+type ToxErrConferenceSendMessage uint32
+
+var (
+	TOX_ERR_CONFERENCE_SEND_MESSAGE_OK                   ToxErrConferenceSendMessage = 0
+	TOX_ERR_CONFERENCE_SEND_MESSAGE_CONFERENCE_NOT_FOUND ToxErrConferenceSendMessage = 1
+	TOX_ERR_CONFERENCE_SEND_MESSAGE_TOO_LONG             ToxErrConferenceSendMessage = 2
+	TOX_ERR_CONFERENCE_SEND_MESSAGE_NO_CONNECTION        ToxErrConferenceSendMessage = 3
+	TOX_ERR_CONFERENCE_SEND_MESSAGE_FAIL_SEND            ToxErrConferenceSendMessage = 4
+)
+
+type ToxErrConferenceTitle uint32
+
+var (
+	TOX_ERR_CONFERENCE_TITLE_OK                   ToxErrConferenceTitle = 0
+	TOX_ERR_CONFERENCE_TITLE_CONFERENCE_NOT_FOUND ToxErrConferenceTitle = 1
+	TOX_ERR_CONFERENCE_TITLE_INVALID_LENGTH       ToxErrConferenceTitle = 2
+	TOX_ERR_CONFERENCE_TITLE_FAIL_SEND            ToxErrConferenceTitle = 3
+)
+
+type ToxErrConferenceGetType uint32
+
+var (
+	TOX_ERR_CONFERENCE_GET_TYPE_OK                   ToxErrConferenceGetType = 0
+	TOX_ERR_CONFERENCE_GET_TYPE_CONFERENCE_NOT_FOUND ToxErrConferenceGetType = 1
+)
@@ -0,0 +1,383 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"math"
+	"sort"
+)
+
+// simChunkSize is the largest chunk toxcore asks a file sender for.
+const simChunkSize = 1371
+
+// simStreamSize is the size of a file of unknown size, a stream. A chunk
+// shorter than simChunkSize ends it.
+const simStreamSize = math.MaxUint64
+
+// simFile is one side of a file transfer. Outgoing transfers use the file
+// numbers 0..255, incoming transfers use (sender file number + 1) << 16 like
+// toxcore does.
+type simFile struct {
+	outgoing bool
+	kind     ToxFileKind
+	size     uint64
+	id       []byte
+	name     string
+
+	accepted   bool
+	pausedUs   bool
+	pausedThem bool
+	done       bool
+
+	// sent is the position of the next chunk to send (outgoing) or to
+	// receive (incoming), requested the position up to which chunks have
+	// been requested from the sender.
+	sent      uint64
+	requested uint64
+}
+
+// peerFileNumber converts the file number of one side of a transfer into the
+// file number used by the other side.
+func peerFileNumber(fileNumber uint32, outgoing bool) uint32 {
+	if outgoing {
+		return (fileNumber + 1) << 16
+	}
+
+	return (fileNumber >> 16) - 1
+}
+
+// canRequest reports whether the sender of file should be asked for more
+// chunks. n.mu must be held.
+func (n *Network) canRequest(f *simFriend, file *simFile) bool {
+	return file.outgoing && file.accepted && !file.pausedUs && !file.pausedThem && !file.done &&
+		f.connection != TOX_CONNECTION_NONE && file.requested < file.size
+}
+
+// requestChunks asks t for the next chunks of its outgoing transfers and
+// reports finished transfers with a zero length chunk request.
+func (t *Tox) requestChunks() {
+	type chunkRequest struct {
+		friendNumber uint32
+		fileNumber   uint32
+		position     uint64
+		length       uint64
+	}
+
+	if !t.lock() {
+		return
+	}
+	n := t.net
+
+	var requests []chunkRequest
+	for _, friendNumber := range t.friendNumbers() {
+		f := t.friends[friendNumber]
+		for _, fileNumber := range sortedFileNumbers(f) {
+			file := f.files[fileNumber]
+			if !file.outgoing {
+				continue
+			}
+
+			if file.done {
+				requests = append(requests, chunkRequest{friendNumber, fileNumber, file.sent, 0})
+				delete(f.files, fileNumber)
+				continue
+			}
+
+			for i := 0; i < n.chunksPerIterate && n.canRequest(f, file); i++ {
+				length := file.size - file.requested
+				if length > simChunkSize {
+					length = simChunkSize
+				}
+				requests = append(requests, chunkRequest{friendNumber, fileNumber, file.requested, length})
+				file.requested += length
+			}
+		}
+	}
+	t.unlock()
+
+	if cb := t.onFileChunkRequest; cb != nil {
+		for _, r := range requests {
+			cb(t, r.friendNumber, r.fileNumber, r.position, r.length)
+		}
+	}
+}
+
+func sortedFileNumbers(f *simFriend) []uint32 {
+	numbers := make([]uint32, 0, len(f.files))
+	for number := range f.files {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers
+}
+
+// peerFile runs apply on the record of the other side of a transfer of t once
+// the event reaches peer. The callback returned by apply, if any, is called
+// without holding n.mu.
+func (t *Tox) peerFile(peer *Tox, fileNumber uint32, outgoing bool, apply func(friendNumber uint32, f *simFriend, number uint32, file *simFile) func()) {
+	n := t.net
+	number := peerFileNumber(fileNumber, outgoing)
+
+	n.post(peer, func() {
+		n.mu.Lock()
+		friendNumber, f := peer.friendByKey(t.publicKey)
+		var fire func()
+		if f != nil && n.linked(t, peer) {
+			if file, ok := f.files[number]; ok {
+				fire = apply(friendNumber, f, number, file)
+			}
+		}
+		n.mu.Unlock()
+
+		if fire != nil {
+			fire()
+		}
+	})
+}
+
+/* FileControl sends a FileControl to a friend with the given friendNumber. */
+func (t *Tox) FileControl(friendNumber uint32, fileNumber uint32, fileControl ToxFileControl) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return ErrFuncFail
+	}
+	file, ok := f.files[fileNumber]
+	if !ok {
+		return ErrFuncFail
+	}
+
+	switch fileControl {
+	case TOX_FILE_CONTROL_RESUME:
+		switch {
+		case !file.accepted && file.outgoing:
+			return ErrFuncFail
+		case !file.accepted:
+			file.accepted = true
+		case file.pausedUs:
+			file.pausedUs = false
+		default:
+			return ErrFuncFail
+		}
+	case TOX_FILE_CONTROL_PAUSE:
+		if !file.accepted || file.pausedUs {
+			return ErrFuncFail
+		}
+		file.pausedUs = true
+	case TOX_FILE_CONTROL_CANCEL:
+		delete(f.files, fileNumber)
+	default:
+		return ErrArgs
+	}
+
+	t.peerFile(peer, fileNumber, file.outgoing, func(friendNumber uint32, f *simFriend, number uint32, file *simFile) func() {
+		switch fileControl {
+		case TOX_FILE_CONTROL_RESUME:
+			if !file.accepted {
+				file.accepted = true
+				// an empty file is complete as soon as it is accepted
+				file.done = file.outgoing && file.size == 0
+			} else {
+				file.pausedThem = false
+			}
+		case TOX_FILE_CONTROL_PAUSE:
+			file.pausedThem = true
+		case TOX_FILE_CONTROL_CANCEL:
+			delete(f.files, number)
+		}
+
+		return func() {
+			if cb := peer.onFileRecvControl; cb != nil {
+				cb(peer, friendNumber, number, fileControl)
+			}
+		}
+	})
+
+	return nil
+}
+
+/* FileSeek sends a file seek control command to a friend for a given file
+ * transfer. */
+func (t *Tox) FileSeek(friendNumber uint32, fileNumber uint32, position uint64) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return ErrFuncFail
+	}
+	file, ok := f.files[fileNumber]
+	if !ok || file.outgoing || file.accepted || position >= file.size {
+		return ErrFuncFail
+	}
+
+	file.sent = position
+	t.peerFile(peer, fileNumber, false, func(friendNumber uint32, f *simFriend, number uint32, file *simFile) func() {
+		if !file.accepted {
+			file.sent = position
+			file.requested = position
+		}
+		return nil
+	})
+
+	return nil
+}
+
+/* FileGetFileId returns the file id associated to the file transfer. */
+func (t *Tox) FileGetFileId(friendNumber uint32, fileNumber uint32) ([]byte, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return nil, ErrFuncFail
+	}
+	file, ok := f.files[fileNumber]
+	if !ok {
+		return nil, ErrFuncFail
+	}
+
+	return append([]byte(nil), file.id...), nil
+}
+
+/* FileSend sends a file transmission request. */
+func (t *Tox) FileSend(friendNumber uint32, fileKind ToxFileKind, fileLength uint64, fileID []byte, fileName string) (uint32, error) {
+	if fileID != nil && len(fileID) != TOX_FILE_ID_LENGTH {
+		return 0, ErrFileSendInvalidFileID
+	}
+	if len(fileName) == 0 {
+		return 0, ErrArgs
+	}
+	if len(fileName) > TOX_MAX_FILENAME_LENGTH {
+		return 0, ErrFuncFail
+	}
+
+	if !t.lock() {
+		return 0, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return 0, ErrFuncFail
+	}
+
+	fileNumber := uint32(0)
+	for ; fileNumber < 256; fileNumber++ {
+		if _, used := f.files[fileNumber]; !used {
+			break
+		}
+	}
+	if fileNumber == 256 {
+		return 0, ErrFuncFail
+	}
+
+	n := t.net
+	id := append([]byte(nil), fileID...)
+	if fileID == nil {
+		id = make([]byte, TOX_FILE_ID_LENGTH)
+		n.rand.Read(id)
+	}
+
+	f.files[fileNumber] = &simFile{
+		outgoing: true,
+		kind:     fileKind,
+		size:     fileLength,
+		id:       id,
+		name:     fileName,
+	}
+
+	n.post(peer, func() {
+		n.mu.Lock()
+		number, f := peer.friendByKey(t.publicKey)
+		delivered := f != nil && n.linked(t, peer)
+		recvNumber := peerFileNumber(fileNumber, true)
+		if delivered {
+			f.files[recvNumber] = &simFile{
+				kind: fileKind,
+				size: fileLength,
+				id:   append([]byte(nil), id...),
+				name: fileName,
+			}
+		}
+		n.mu.Unlock()
+
+		if delivered {
+			if cb := peer.onFileRecv; cb != nil {
+				cb(peer, number, recvNumber, fileKind, fileLength, fileName, uint32(len(fileName)))
+			}
+		}
+	})
+
+	return fileNumber, nil
+}
+
+/* FileSendChunk sends a chunk of file data to a friend. */
+func (t *Tox) FileSendChunk(friendNumber uint32, fileNumber uint32, position uint64, data []byte) error {
+	if !t.lock() {
+		return ErrToxInit
+	}
+	defer t.unlock()
+
+	f, peer := t.connectedFriend(friendNumber)
+	if f == nil || peer == nil {
+		return ErrFuncFail
+	}
+	file, ok := f.files[fileNumber]
+	if !ok || !file.outgoing || !file.accepted || file.done || file.pausedUs || file.pausedThem {
+		return ErrFuncFail
+	}
+
+	length := uint64(len(data))
+	if position != file.sent || length > simChunkSize || position+length > file.size {
+		return ErrFuncFail
+	}
+	stream := file.size == simStreamSize
+	if length != simChunkSize && position+length != file.size && !stream {
+		return ErrFuncFail
+	}
+
+	n := t.net
+	if n.sendqFull(t) {
+		// the sender will be asked again for everything not sent yet
+		file.requested = file.sent
+		return ErrFileSendChunkSendq
+	}
+
+	file.sent += length
+	file.done = file.sent == file.size || stream && length < simChunkSize
+
+	chunk := append([]byte(nil), data...)
+	done := file.done
+	t.peerFile(peer, fileNumber, true, func(friendNumber uint32, f *simFriend, number uint32, file *simFile) func() {
+		if position != file.sent {
+			return nil
+		}
+		file.sent += length
+		if done {
+			delete(f.files, number)
+		}
+
+		return func() {
+			if cb := peer.onFileRecvChunk; cb != nil {
+				if length > 0 {
+					cb(peer, friendNumber, number, position, peer.payload(chunk), uint32(len(chunk)))
+				}
+				if done {
+					cb(peer, friendNumber, number, position+length, nil, 0)
+				}
+			}
+		}
+	})
+
+	return nil
+}
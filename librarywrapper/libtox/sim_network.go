@@ -0,0 +1,469 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Network is the in-memory stand-in for the Tox DHT used by the simulated
+// backend (build tag `toxsim`).
+/*
+ * Every Tox instance created by the simulated backend lives on a Network.
+ * Instances on the same Network can find each other by public key, exchange
+ * friend requests, messages, read receipts, custom packets, file transfers
+ * and conference traffic without cgo or a real network.
+ *
+ * Time is fully controlled by the caller: the clock only moves when Advance or
+ * Step is called, and events are delivered to an instance when its Iterate is
+ * called and the event's delivery time has been reached. Faults (dropped
+ * packets, severed links, instances going offline and full send queues) can be
+ * injected at any time.
+ */
+type Network struct {
+	mu   sync.Mutex
+	now  time.Time
+	seq  uint64
+	rand *rand.Rand
+
+	nodes       []*Tox
+	conferences map[[TOX_CONFERENCE_ID_SIZE]byte]*simConference
+	usedUDP     map[uint16]bool
+	usedTCP     map[uint16]bool
+
+	latency          time.Duration
+	interval         uint32
+	chunksPerIterate int
+
+	// fault injection
+	dropRate float64
+	dropNext int
+	sendq    map[*Tox]int
+	cut      map[[2]*Tox]bool
+	offline  map[*Tox]bool
+}
+
+// simEvent is something that happens to a Tox instance during its Iterate.
+type simEvent struct {
+	at   time.Time
+	seq  uint64
+	fire func()
+}
+
+// DefaultNetwork is the Network used by New.
+var DefaultNetwork = NewNetwork(1)
+
+// NewNetwork creates an empty Network. All keys, nospams and random drops are
+// derived from seed, so a test that performs the same steps on a Network with
+// the same seed sees the same results.
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		now:              time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		rand:             rand.New(rand.NewSource(seed)),
+		conferences:      make(map[[TOX_CONFERENCE_ID_SIZE]byte]*simConference),
+		usedUDP:          make(map[uint16]bool),
+		usedTCP:          make(map[uint16]bool),
+		interval:         50,
+		chunksPerIterate: 16,
+		sendq:            make(map[*Tox]int),
+		cut:              make(map[[2]*Tox]bool),
+		offline:          make(map[*Tox]bool),
+	}
+}
+
+// Now returns the current time of the simulated clock.
+func (n *Network) Now() time.Time {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.now
+}
+
+// Advance moves the simulated clock forward by d. Events become due, but are
+// only delivered on the next Iterate of the receiving instance.
+func (n *Network) Advance(d time.Duration) {
+	n.mu.Lock()
+	n.now = n.now.Add(d)
+	n.mu.Unlock()
+}
+
+// SetLatency sets the delay between sending a packet and it becoming due at
+// the receiver. The default is 0, so packets arrive on the next Iterate.
+func (n *Network) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	n.latency = d
+	n.mu.Unlock()
+}
+
+// SetIterationInterval sets the value returned by IterationInterval (in
+// milliseconds) of all instances on the Network. The default is 50.
+func (n *Network) SetIterationInterval(ms uint32) {
+	n.mu.Lock()
+	n.interval = ms
+	n.mu.Unlock()
+}
+
+// SetInstanceIterationInterval sets the value returned by IterationInterval
+// (in milliseconds) of t alone. 0 restores the interval of the Network.
+func (n *Network) SetInstanceIterationInterval(t *Tox, ms uint32) {
+	n.mu.Lock()
+	t.interval = ms
+	n.mu.Unlock()
+}
+
+// Iterations returns how often Iterate of t was called.
+func (n *Network) Iterations(t *Tox) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return t.iterations
+}
+
+// SetChunksPerIterate sets how many file chunks a sender is asked for per
+// Iterate and transfer. The default is 16.
+func (n *Network) SetChunksPerIterate(chunks int) {
+	n.mu.Lock()
+	if chunks > 0 {
+		n.chunksPerIterate = chunks
+	}
+	n.mu.Unlock()
+}
+
+// SetDropRate sets the probability (0..1) that a message, custom packet or
+// conference message is lost on the way. Lost messages never produce a read
+// receipt.
+func (n *Network) SetDropRate(p float64) {
+	n.mu.Lock()
+	n.dropRate = p
+	n.mu.Unlock()
+}
+
+// DropNext drops the next count messages, custom packets or conference
+// messages sent by any instance.
+func (n *Network) DropNext(count int) {
+	n.mu.Lock()
+	n.dropNext += count
+	n.mu.Unlock()
+}
+
+// FailSendq makes the next count sends (messages, custom packets and file
+// chunks) of t fail as if its send queue was full.
+func (n *Network) FailSendq(t *Tox, count int) {
+	n.mu.Lock()
+	n.sendq[t] += count
+	n.mu.Unlock()
+}
+
+// Disconnect severs the link between a and b. Both see each other go offline
+// and packets in flight between them are lost.
+func (n *Network) Disconnect(a *Tox, b *Tox) {
+	n.mu.Lock()
+	n.cut[linkKey(a, b)] = true
+	n.refresh()
+	n.mu.Unlock()
+}
+
+// Reconnect restores a link severed with Disconnect.
+func (n *Network) Reconnect(a *Tox, b *Tox) {
+	n.mu.Lock()
+	delete(n.cut, linkKey(a, b))
+	n.refresh()
+	n.mu.Unlock()
+}
+
+// SetOnline takes t off the DHT (online == false) or brings it back. An
+// offline instance loses its DHT connection and all friend connections.
+func (n *Network) SetOnline(t *Tox, online bool) {
+	n.mu.Lock()
+	if online {
+		delete(n.offline, t)
+	} else {
+		n.offline[t] = true
+	}
+	n.refresh()
+	n.mu.Unlock()
+}
+
+// Instances returns the live instances on the Network in creation order.
+func (n *Network) Instances() []*Tox {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]*Tox(nil), n.nodes...)
+}
+
+// IterateAll calls Iterate once on every live instance in creation order.
+func (n *Network) IterateAll() {
+	for _, t := range n.Instances() {
+		t.Iterate()
+	}
+}
+
+// Step advances the clock by d and then iterates all instances once.
+func (n *Network) Step(d time.Duration) {
+	n.Advance(d)
+	n.IterateAll()
+}
+
+// Settle iterates all instances until no events are due and no file transfer
+// has work left, or until maxRounds rounds have passed. It returns the number
+// of rounds that were needed. The clock is not advanced.
+func (n *Network) Settle(maxRounds int) int {
+	for round := 1; round <= maxRounds; round++ {
+		n.IterateAll()
+		if !n.busy() {
+			return round
+		}
+	}
+
+	return maxRounds
+}
+
+// busy reports whether any instance has due events or file chunks to request.
+func (n *Network) busy() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, t := range n.nodes {
+		if len(t.queue) > 0 && !t.queue[0].at.After(n.now) {
+			return true
+		}
+		for _, f := range t.friends {
+			for _, file := range f.files {
+				if file.outgoing && (file.done || n.canRequest(f, file)) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// post queues fire for t after the configured latency. n.mu must be held.
+func (n *Network) post(t *Tox, fire func()) {
+	n.postAt(t, n.now.Add(n.latency), fire)
+}
+
+// postNow queues fire for t without latency. n.mu must be held.
+func (n *Network) postNow(t *Tox, fire func()) {
+	n.postAt(t, n.now, fire)
+}
+
+func (n *Network) postAt(t *Tox, at time.Time, fire func()) {
+	n.seq++
+	t.queue = append(t.queue, simEvent{at: at, seq: n.seq, fire: fire})
+	sort.SliceStable(t.queue, func(i, j int) bool {
+		if t.queue[i].at.Equal(t.queue[j].at) {
+			return t.queue[i].seq < t.queue[j].seq
+		}
+		return t.queue[i].at.Before(t.queue[j].at)
+	})
+}
+
+// send runs fn with n.mu held. It is used by event handlers that need to
+// post follow-up events (e.g. read receipts).
+func (n *Network) send(fn func()) {
+	n.mu.Lock()
+	fn()
+	n.mu.Unlock()
+}
+
+// dropped decides whether a lossy-by-fault packet is lost. n.mu must be held.
+func (n *Network) dropped() bool {
+	if n.dropNext > 0 {
+		n.dropNext--
+		return true
+	}
+
+	return n.dropRate > 0 && n.rand.Float64() < n.dropRate
+}
+
+// sendqFull consumes one injected SENDQ failure of t. n.mu must be held.
+func (n *Network) sendqFull(t *Tox) bool {
+	if n.sendq[t] > 0 {
+		n.sendq[t]--
+		return true
+	}
+
+	return false
+}
+
+// online reports whether t is connected to the DHT. n.mu must be held.
+func (n *Network) online(t *Tox) bool {
+	return t != nil && !t.killed && t.bootstrapped && !n.offline[t]
+}
+
+// linked reports whether a and b can currently talk to each other. n.mu must
+// be held.
+func (n *Network) linked(a *Tox, b *Tox) bool {
+	return n.online(a) && n.online(b) && !n.cut[linkKey(a, b)]
+}
+
+// node returns the live instance with the given public key. n.mu must be held.
+func (n *Network) node(publickey []byte) *Tox {
+	for i := len(n.nodes) - 1; i >= 0; i-- {
+		if string(n.nodes[i].publicKey) == string(publickey) {
+			return n.nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// refresh recomputes the DHT and friend connection states of all instances,
+// queues the resulting callbacks and delivers pending friend requests. n.mu
+// must be held.
+func (n *Network) refresh() {
+	for _, t := range n.nodes {
+		t := t
+
+		self := TOX_CONNECTION_NONE
+		if n.online(t) {
+			self = t.transport()
+		}
+		if self != t.connection {
+			t.connection = self
+			n.postNow(t, func() {
+				if cb := t.onSelfConnectionStatusChanges; cb != nil {
+					cb(t, self)
+				}
+			})
+		}
+
+		for _, number := range t.friendNumbers() {
+			f := t.friends[number]
+			peer := n.node(f.publicKey)
+
+			if f.request != nil && peer != nil && n.linked(t, peer) {
+				n.deliverFriendRequest(t, peer, f.request)
+				f.request = nil
+			}
+
+			status := TOX_CONNECTION_NONE
+			if peer != nil && n.linked(t, peer) {
+				if _, back := peer.friendByKey(t.publicKey); back != nil {
+					status = t.transport()
+					if peer.transport() == TOX_CONNECTION_TCP {
+						status = TOX_CONNECTION_TCP
+					}
+				}
+			}
+			if status == f.connection {
+				continue
+			}
+
+			wasOnline := f.connection != TOX_CONNECTION_NONE
+			f.connection = status
+			f.lastOnline = n.now
+			if status == TOX_CONNECTION_NONE {
+				// toxcore drops all file transfers of a friend that went
+				// offline without calling any callback
+				f.files = make(map[uint32]*simFile)
+				f.typing = false
+			}
+
+			number := number
+			now := time.Unix(n.now.Unix(), 0)
+			n.postNow(t, func() {
+				t.rosterUpdate(number, func(info *FriendInfo) {
+					info.Connection = status
+					info.LastOnline = now
+				})
+				if cb := t.onFriendConnectionStatusChanges; cb != nil {
+					cb(t, number, status)
+				}
+			})
+
+			if !wasOnline && status != TOX_CONNECTION_NONE {
+				// a friend coming online tells us its current profile
+				n.syncProfile(t, number, f, peer)
+			}
+		}
+	}
+}
+
+// syncProfile copies the profile of peer into friend record f of t and
+// queues the callbacks for the values that changed. n.mu must be held.
+func (n *Network) syncProfile(t *Tox, number uint32, f *simFriend, peer *Tox) {
+	if f.name != peer.name {
+		name := peer.name
+		f.name = name
+		n.postNow(t, func() {
+			t.rosterUpdate(number, func(info *FriendInfo) { info.Name = name })
+			if cb := t.onFriendNameChanges; cb != nil {
+				cb(t, number, []byte(name), uint32(len(name)))
+			}
+		})
+	}
+	if f.statusMessage != peer.statusMessage {
+		message := peer.statusMessage
+		f.statusMessage = message
+		n.postNow(t, func() {
+			t.rosterUpdate(number, func(info *FriendInfo) { info.StatusMessage = message })
+			if cb := t.onFriendStatusMessageChanges; cb != nil {
+				cb(t, number, []byte(message), uint32(len(message)))
+			}
+		})
+	}
+	if f.status != peer.status {
+		status := peer.status
+		f.status = status
+		n.postNow(t, func() {
+			t.rosterUpdate(number, func(info *FriendInfo) { info.Status = status })
+			if cb := t.onFriendStatusChanges; cb != nil {
+				cb(t, number, status)
+			}
+		})
+	}
+}
+
+// deliverFriendRequest queues the friend request of from at to. The request
+// is silently discarded when the nospam does not match anymore or when to
+// already has from as a friend, just like toxcore does. n.mu must be held.
+func (n *Network) deliverFriendRequest(from *Tox, to *Tox, request *simRequest) {
+	publickey := append([]byte(nil), from.publicKey...)
+	message := append([]byte(nil), request.message...)
+	nospam := request.nospam
+
+	n.post(to, func() {
+		n.mu.Lock()
+		_, known := to.friendByKey(publickey)
+		valid := known == nil && to.nospam == nospam
+		n.mu.Unlock()
+
+		if valid {
+			if cb := to.onFriendRequest; cb != nil {
+				cb(to, publickey, message, uint32(len(message)))
+			}
+		}
+	})
+}
+
+// allocPort returns the first free port in [start, end] of the given set.
+// n.mu must be held.
+func (n *Network) allocPort(used map[uint16]bool, start uint16, end uint16) (uint16, bool) {
+	if start > end {
+		start, end = end, start
+	}
+
+	for port := uint32(start); port <= uint32(end); port++ {
+		if !used[uint16(port)] {
+			used[uint16(port)] = true
+			return uint16(port), true
+		}
+	}
+
+	return 0, false
+}
+
+func linkKey(a *Tox, b *Tox) [2]*Tox {
+	if a.seq > b.seq {
+		a, b = b, a
+	}
+
+	return [2]*Tox{a, b}
+}
@@ -0,0 +1,59 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"time"
+)
+
+// friendInfo returns the FriendInfo of friend number of t. n.mu must be held.
+func (t *Tox) friendInfo(number uint32, f *simFriend) FriendInfo {
+	info := FriendInfo{
+		Number:        number,
+		PublicKey:     append([]byte(nil), f.publicKey...),
+		Name:          f.name,
+		StatusMessage: f.statusMessage,
+		Status:        f.status,
+		Connection:    f.connection,
+	}
+	if !f.lastOnline.IsZero() {
+		info.LastOnline = time.Unix(f.lastOnline.Unix(), 0)
+	}
+
+	return info
+}
+
+/* FriendGetInfo returns the state of the friend with the given friendNumber. */
+func (t *Tox) FriendGetInfo(friendNumber uint32) (FriendInfo, error) {
+	if !t.lock() {
+		return FriendInfo{}, ErrToxInit
+	}
+	defer t.unlock()
+
+	f, ok := t.friends[friendNumber]
+	if !ok {
+		return FriendInfo{}, ErrFuncFail
+	}
+
+	return t.friendInfo(friendNumber, f), nil
+}
+
+/* SelfGetFriendInfos returns the state of all friends. */
+func (t *Tox) SelfGetFriendInfos() ([]FriendInfo, error) {
+	if !t.lock() {
+		return nil, ErrToxInit
+	}
+	defer t.unlock()
+
+	numbers := t.friendNumbers()
+	infos := make([]FriendInfo, len(numbers))
+	for i, number := range numbers {
+		infos[i] = t.friendInfo(number, t.friends[number])
+	}
+
+	return infos, nil
+}
+
+// registerRosterCallbacks is a no-op: the simulated backend always updates
+// the roster cache before calling the friend callbacks.
+func (t *Tox) registerRosterCallbacks() {}
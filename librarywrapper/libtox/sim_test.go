@@ -0,0 +1,392 @@
+//go:build toxsim
+
+package libtox
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// simPair is two instances on their own Network that are friends with each
+// other.
+type simPair struct {
+	net  *Network
+	a, b *Tox
+}
+
+// newSimPair creates two bootstrapped instances that are friends with each
+// other and waits until they are connected. Both use friend number 0 for the
+// other one.
+func newSimPair(t testing.TB) *simPair {
+	t.Helper()
+
+	n := NewNetwork(1)
+	a, err := n.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tox := range []*Tox{a, b} {
+		if err := tox.Bootstrap("127.0.0.1", 33445, make([]byte, TOX_PUBLIC_KEY_SIZE)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkA, _ := a.SelfGetPublicKey()
+	pkB, _ := b.SelfGetPublicKey()
+	if _, err := a.FriendAddNorequest(pkB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.FriendAddNorequest(pkA); err != nil {
+		t.Fatal(err)
+	}
+	n.Settle(10)
+
+	if status, _ := a.FriendGetConnectionStatus(0); status == TOX_CONNECTION_NONE {
+		t.Fatal("friends are not connected")
+	}
+
+	return &simPair{net: n, a: a, b: b}
+}
+
+func TestSimMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		fault        func(p *simPair)
+		message      string
+		wantErr      error
+		wantReceived bool
+	}{
+		{"delivered", nil, "hello", nil, true},
+		{"dropped", func(p *simPair) { p.net.DropNext(1) }, "hello", nil, false},
+		{"all dropped", func(p *simPair) { p.net.SetDropRate(1) }, "hello", nil, false},
+		{"send queue full", func(p *simPair) { p.net.FailSendq(p.a, 1) }, "hello", ErrFriendSendMessageSendq, false},
+		{"friend offline", func(p *simPair) { p.net.SetOnline(p.b, false) }, "hello", ErrFriendSendMessageFriendNotConnected, false},
+		{"link severed", func(p *simPair) { p.net.Disconnect(p.a, p.b) }, "hello", ErrFriendSendMessageFriendNotConnected, false},
+		{"too long", nil, strings.Repeat("x", TOX_MAX_MESSAGE_LENGTH+1), ErrFriendSendMessageTooLong, false},
+		{"empty", nil, "", ErrArgs, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSimPair(t)
+
+			var received []string
+			var receipts []uint32
+			p.b.CallbackFriendMessage(func(_ *Tox, friendnumber uint32, messagetype ToxMessageType, message []byte, length uint32) {
+				received = append(received, string(message))
+			})
+			p.a.CallbackFriendReadReceipt(func(_ *Tox, friendnumber uint32, messageid uint32) {
+				receipts = append(receipts, messageid)
+			})
+
+			if tt.fault != nil {
+				tt.fault(p)
+				p.net.Settle(10)
+			}
+
+			id, err := p.a.FriendSendMessage(0, TOX_MESSAGE_TYPE_NORMAL, []byte(tt.message))
+			if err != tt.wantErr {
+				t.Fatalf("FriendSendMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			p.net.Settle(10)
+
+			if !tt.wantReceived {
+				if len(received) != 0 || len(receipts) != 0 {
+					t.Fatalf("got messages %q and receipts %v, want none", received, receipts)
+				}
+				return
+			}
+			if len(received) != 1 || received[0] != tt.message {
+				t.Fatalf("received %q, want [%q]", received, tt.message)
+			}
+			if len(receipts) != 1 || receipts[0] != id {
+				t.Fatalf("receipts %v, want [%d]", receipts, id)
+			}
+		})
+	}
+}
+
+func TestSimMessageIDs(t *testing.T) {
+	p := newSimPair(t)
+
+	var receipts []uint32
+	p.a.CallbackFriendReadReceipt(func(_ *Tox, friendnumber uint32, messageid uint32) {
+		receipts = append(receipts, messageid)
+	})
+
+	var ids []uint32
+	for i := 0; i < 3; i++ {
+		id, err := p.a.FriendSendMessage(0, TOX_MESSAGE_TYPE_NORMAL, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	p.net.Settle(10)
+
+	if ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Fatalf("message IDs %v are not unique", ids)
+	}
+	if len(receipts) != len(ids) {
+		t.Fatalf("receipts %v, want %v", receipts, ids)
+	}
+	for i := range ids {
+		if receipts[i] != ids[i] {
+			t.Fatalf("receipts %v, want %v", receipts, ids)
+		}
+	}
+}
+
+func TestSimSetOnline(t *testing.T) {
+	p := newSimPair(t)
+
+	var statuses []ToxConnection
+	p.a.CallbackFriendConnectionStatusChanges(func(_ *Tox, friendnumber uint32, status ToxConnection) {
+		statuses = append(statuses, status)
+	})
+
+	steps := []struct {
+		online bool
+		want   ToxConnection
+	}{
+		{false, TOX_CONNECTION_NONE},
+		{true, TOX_CONNECTION_UDP},
+		{false, TOX_CONNECTION_NONE},
+	}
+
+	for i, step := range steps {
+		p.net.SetOnline(p.b, step.online)
+		p.net.Settle(10)
+
+		if len(statuses) != i+1 || statuses[i] != step.want {
+			t.Fatalf("step %d: connection callbacks %v, want %v last", i, statuses, step.want)
+		}
+		if status, _ := p.a.FriendGetConnectionStatus(0); status != step.want {
+			t.Fatalf("step %d: FriendGetConnectionStatus() = %v, want %v", i, status, step.want)
+		}
+	}
+}
+
+// simTransfer sends data from a to b and collects what b receives.
+type simTransfer struct {
+	data     []byte
+	received []byte
+	done     bool
+
+	// seek is the position b continues a transfer from, see FileSeek
+	seek uint64
+}
+
+// start registers the callbacks of the transfer on both sides
+func (tr *simTransfer) start(t *testing.T, p *simPair) {
+	p.a.CallbackFileChunkRequest(func(tox *Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+		if length == 0 {
+			return
+		}
+		// a refused chunk is requested again
+		tox.FileSendChunk(friendnumber, filenumber, position, tr.data[position:position+length])
+	})
+	p.b.CallbackFileRecv(func(tox *Tox, friendnumber uint32, filenumber uint32, kind ToxFileKind, filesize uint64, filename string, length uint32) {
+		if tr.seek > 0 {
+			if err := tox.FileSeek(friendnumber, filenumber, tr.seek); err != nil {
+				t.Errorf("FileSeek() error = %v", err)
+			}
+		}
+		if err := tox.FileControl(friendnumber, filenumber, TOX_FILE_CONTROL_RESUME); err != nil {
+			t.Errorf("FileControl() error = %v", err)
+		}
+	})
+	p.b.CallbackFileRecvChunk(func(tox *Tox, friendnumber uint32, filenumber uint32, position uint64, data []byte, length uint32) {
+		if length == 0 {
+			tr.done = true
+			return
+		}
+		if position != uint64(len(tr.received)) {
+			t.Errorf("chunk at %d, want %d", position, len(tr.received))
+			return
+		}
+		tr.received = append(tr.received, data...)
+	})
+}
+
+func TestSimFileTransfer(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		fault func(p *simPair)
+	}{
+		{"one byte", 1, nil},
+		{"one chunk", simChunkSize, nil},
+		{"several chunks", 3*simChunkSize + 17, nil},
+		{"send queue full", 3*simChunkSize + 17, func(p *simPair) { p.net.FailSendq(p.a, 2) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSimPair(t)
+
+			tr := &simTransfer{data: bytes.Repeat([]byte("0123456789"), tt.size/10+1)[:tt.size]}
+			tr.start(t, p)
+
+			if _, err := p.a.FileSend(0, TOX_FILE_KIND_DATA, uint64(tt.size), nil, "file"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.fault != nil {
+				tt.fault(p)
+			}
+			p.net.Settle(100)
+
+			if !tr.done {
+				t.Fatal("transfer did not complete")
+			}
+			if !bytes.Equal(tr.received, tr.data) {
+				t.Fatalf("received %d bytes, want %d", len(tr.received), len(tr.data))
+			}
+		})
+	}
+}
+
+func TestSimFileResume(t *testing.T) {
+	p := newSimPair(t)
+	p.net.SetChunksPerIterate(1)
+
+	tr := &simTransfer{data: bytes.Repeat([]byte("abcdefghij"), 1000)}
+	tr.start(t, p)
+
+	fileID := bytes.Repeat([]byte{7}, TOX_FILE_ID_LENGTH)
+	if _, err := p.a.FileSend(0, TOX_FILE_KIND_DATA, uint64(len(tr.data)), fileID, "file"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		p.net.IterateAll()
+	}
+
+	// going offline drops the transfer on both sides
+	p.net.SetOnline(p.b, false)
+	p.net.Settle(10)
+	if tr.done || len(tr.received) == 0 || len(tr.received) == len(tr.data) {
+		t.Fatalf("received %d of %d bytes before going offline", len(tr.received), len(tr.data))
+	}
+	p.net.SetOnline(p.b, true)
+	p.net.Settle(10)
+
+	// the receiver continues where it stopped
+	tr.seek = uint64(len(tr.received))
+	if _, err := p.a.FileSend(0, TOX_FILE_KIND_DATA, uint64(len(tr.data)), fileID, "file"); err != nil {
+		t.Fatal(err)
+	}
+	p.net.Settle(100)
+
+	if !tr.done {
+		t.Fatal("transfer did not complete")
+	}
+	if !bytes.Equal(tr.received, tr.data) {
+		t.Fatalf("received %d bytes, want %d", len(tr.received), len(tr.data))
+	}
+}
+
+func TestSimFileStream(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"short last chunk", 2*simChunkSize + 100},
+		{"empty last chunk", 2 * simChunkSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSimPair(t)
+
+			tr := &simTransfer{data: bytes.Repeat([]byte("0123456789"), tt.size/10+1)[:tt.size]}
+			tr.start(t, p)
+			p.a.CallbackFileChunkRequest(func(tox *Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+				if length == 0 || position > uint64(len(tr.data)) {
+					// chunks are requested ahead of the end of the stream
+					return
+				}
+				// a shorter chunk, empty if needed, ends the stream
+				end := position + length
+				if end > uint64(len(tr.data)) {
+					end = uint64(len(tr.data))
+				}
+				if err := tox.FileSendChunk(friendnumber, filenumber, position, tr.data[position:end]); err != nil {
+					t.Errorf("FileSendChunk() error = %v", err)
+				}
+			})
+
+			if _, err := p.a.FileSend(0, TOX_FILE_KIND_DATA, simStreamSize, nil, "stream"); err != nil {
+				t.Fatal(err)
+			}
+			p.net.Settle(100)
+
+			if !tr.done {
+				t.Fatal("stream did not end")
+			}
+			if !bytes.Equal(tr.received, tr.data) {
+				t.Fatalf("received %d bytes, want %d", len(tr.received), len(tr.data))
+			}
+		})
+	}
+}
+
+func TestSimConferenceEvents(t *testing.T) {
+	p := newSimPair(t)
+
+	var events []string
+	for _, tox := range []*Tox{p.a, p.b} {
+		who := "a"
+		if tox == p.b {
+			who = "b"
+		}
+		tox.CallbackConferencePeerListChanged(func(_ *Tox, conferencenumber uint32) {
+			events = append(events, who+" peers")
+		})
+		tox.CallbackConferencePeerName(func(_ *Tox, conferencenumber uint32, peernumber uint32, name []byte, length uint32) {
+			events = append(events, who+" name "+string(name))
+		})
+		tox.CallbackConferenceTitle(func(_ *Tox, conferencenumber uint32, peernumber uint32, title []byte, length uint32) {
+			events = append(events, who+" title "+string(title))
+		})
+	}
+	p.b.CallbackConferenceInvite(func(tox *Tox, friendnumber uint32, conferencetype ToxConferenceType, cookie []byte) {
+		if _, err := tox.ConferenceJoin(friendnumber, cookie); err != nil {
+			t.Errorf("ConferenceJoin() error = %v", err)
+		}
+	})
+
+	conference, err := p.a.ConferenceNew()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+		want []string
+	}{
+		{"join", func() error { _, err := p.a.ConferenceInvite(0, conference); return err }, []string{"a peers", "b peers"}},
+		{"name", func() error { return p.b.SelfSetName("Bob") }, []string{"a name Bob"}},
+		{"title", func() error { _, err := p.a.ConferenceSetTitle(conference, "Room"); return err }, []string{"b title Room"}},
+		{"leave", func() error { _, err := p.b.ConferenceDelete(0); return err }, []string{"a peers"}},
+	}
+
+	for _, step := range steps {
+		events = nil
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		p.net.Settle(10)
+
+		sort.Strings(events)
+		if strings.Join(events, ", ") != strings.Join(step.want, ", ") {
+			t.Fatalf("%s: events %q, want %q", step.name, events, step.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package libtoxav
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Defaults for audio sent with toxav: 20 ms of 48 kHz mono audio per frame.
+const (
+	DefaultSamplingRate  = 48000
+	DefaultChannels      = 1
+	DefaultFrameDuration = 20 * time.Millisecond
+)
+
+// SamplingRates are the sampling rates Opus accepts.
+var SamplingRates = []uint32{8000, 12000, 16000, 24000, 48000}
+
+// frameDurations are the frame lengths Opus accepts, in units of 0.5 ms.
+var frameDurations = []int{5, 10, 20, 40, 80, 120}
+
+// AudioFrame is a frame of 16 bit PCM audio. For stereo the samples are
+// interleaved: left, right, left, right, ...
+type AudioFrame struct {
+	PCM          []int16 // SampleCount * Channels samples
+	SampleCount  int     // samples per channel
+	Channels     int
+	SamplingRate uint32
+}
+
+// NewAudioFrame returns a silent frame holding duration of audio.
+func NewAudioFrame(samplingRate uint32, channels int, duration time.Duration) (*AudioFrame, error) {
+	sampleCount := int(time.Duration(samplingRate) * duration / time.Second)
+	frame := &AudioFrame{
+		PCM:          make([]int16, sampleCount*channels),
+		SampleCount:  sampleCount,
+		Channels:     channels,
+		SamplingRate: samplingRate,
+	}
+	if err := frame.Validate(); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// ValidSampleCount reports whether sampleCount samples per channel at
+// samplingRate are 2.5, 5, 10, 20, 40 or 60 ms of audio.
+func ValidSampleCount(samplingRate uint32, sampleCount int) bool {
+	for _, d := range frameDurations {
+		if sampleCount*2000 == int(samplingRate)*d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks f against the formats toxav accepts.
+func (f *AudioFrame) Validate() error {
+	if f.Channels != 1 && f.Channels != 2 {
+		return ErrAudioFrameChannels
+	}
+
+	valid := false
+	for _, rate := range SamplingRates {
+		valid = valid || rate == f.SamplingRate
+	}
+	if !valid {
+		return ErrAudioFrameSamplingRate
+	}
+
+	if !ValidSampleCount(f.SamplingRate, f.SampleCount) {
+		return ErrAudioFrameSampleCount
+	}
+	if len(f.PCM) != f.SampleCount*f.Channels {
+		return ErrAudioFramePCM
+	}
+
+	return nil
+}
+
+// Duration returns the length of the audio in f.
+func (f *AudioFrame) Duration() time.Duration {
+	if f.SamplingRate == 0 {
+		return 0
+	}
+
+	return time.Duration(f.SampleCount) * time.Second / time.Duration(f.SamplingRate)
+}
+
+// Clone returns a copy of f that does not share its PCM.
+func (f *AudioFrame) Clone() *AudioFrame {
+	c := *f
+	c.PCM = append([]int16(nil), f.PCM...)
+
+	return &c
+}
+
+// Bytes returns the PCM of f as little endian bytes, the layout of WAV files.
+func (f *AudioFrame) Bytes() []byte {
+	b := make([]byte, 2*len(f.PCM))
+	for i, s := range f.PCM {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+	}
+
+	return b
+}
@@ -0,0 +1,79 @@
+package libtoxav
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAudioFrameValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame AudioFrame
+		want  error
+	}{
+		{"20 ms mono", AudioFrame{PCM: make([]int16, 960), SampleCount: 960, Channels: 1, SamplingRate: 48000}, nil},
+		{"60 ms stereo", AudioFrame{PCM: make([]int16, 2*480), SampleCount: 480, Channels: 2, SamplingRate: 8000}, nil},
+		{"2.5 ms", AudioFrame{PCM: make([]int16, 120), SampleCount: 120, Channels: 1, SamplingRate: 48000}, nil},
+		{"no channels", AudioFrame{SampleCount: 960, SamplingRate: 48000}, ErrAudioFrameChannels},
+		{"three channels", AudioFrame{PCM: make([]int16, 3*960), SampleCount: 960, Channels: 3, SamplingRate: 48000}, ErrAudioFrameChannels},
+		{"44.1 kHz", AudioFrame{PCM: make([]int16, 882), SampleCount: 882, Channels: 1, SamplingRate: 44100}, ErrAudioFrameSamplingRate},
+		{"no sampling rate", AudioFrame{PCM: make([]int16, 960), SampleCount: 960, Channels: 1}, ErrAudioFrameSamplingRate},
+		{"30 ms", AudioFrame{PCM: make([]int16, 1440), SampleCount: 1440, Channels: 1, SamplingRate: 48000}, ErrAudioFrameSampleCount},
+		{"no samples", AudioFrame{SampleCount: 0, Channels: 1, SamplingRate: 48000}, ErrAudioFrameSampleCount},
+		{"PCM too short", AudioFrame{PCM: make([]int16, 959), SampleCount: 960, Channels: 1, SamplingRate: 48000}, ErrAudioFramePCM},
+		{"mono PCM in a stereo frame", AudioFrame{PCM: make([]int16, 960), SampleCount: 960, Channels: 2, SamplingRate: 48000}, ErrAudioFramePCM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.frame.Validate(); err != tt.want {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAudioFrame(t *testing.T) {
+	tests := []struct {
+		rate     uint32
+		channels int
+		duration time.Duration
+		wantPCM  int
+		wantErr  error
+	}{
+		{48000, 1, 20 * time.Millisecond, 960, nil},
+		{16000, 2, 10 * time.Millisecond, 320, nil},
+		{24000, 1, 60 * time.Millisecond, 1440, nil},
+		{48000, 1, 30 * time.Millisecond, 0, ErrAudioFrameSampleCount},
+		{22050, 1, 20 * time.Millisecond, 0, ErrAudioFrameSamplingRate},
+	}
+
+	for _, tt := range tests {
+		frame, err := NewAudioFrame(tt.rate, tt.channels, tt.duration)
+		if err != tt.wantErr {
+			t.Errorf("NewAudioFrame(%d, %d, %v) error = %v, want %v", tt.rate, tt.channels, tt.duration, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(frame.PCM) != tt.wantPCM || frame.Duration() != tt.duration {
+			t.Errorf("NewAudioFrame(%d, %d, %v) has %d samples of %v, want %d", tt.rate, tt.channels, tt.duration, len(frame.PCM), frame.Duration(), tt.wantPCM)
+		}
+	}
+}
+
+func TestAudioFrameBytes(t *testing.T) {
+	frame := &AudioFrame{PCM: []int16{1, -1, 0x1234, -32768}, SampleCount: 2, Channels: 2, SamplingRate: 48000}
+	want := []byte{0x01, 0x00, 0xff, 0xff, 0x34, 0x12, 0x00, 0x80}
+
+	if got := frame.Bytes(); string(got) != string(want) {
+		t.Errorf("Bytes() = % x, want % x", got, want)
+	}
+
+	clone := frame.Clone()
+	clone.PCM[0] = 7
+	if frame.PCM[0] != 1 {
+		t.Error("Clone() shares the PCM")
+	}
+}
@@ -0,0 +1,218 @@
+package libtoxav
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// resampler converts interleaved PCM to another channel count and sampling
+// rate with linear interpolation. It keeps state between calls, so a stream
+// can be fed in pieces of any size.
+type resampler struct {
+	inChannels int
+	channels   int
+
+	// last is the previous input sample (in the output channel layout), pos
+	// the position of the next output sample after last in input samples.
+	last []int16
+	pos  float64
+	step float64
+}
+
+func newResampler(inRate uint32, inChannels int, rate uint32, channels int) *resampler {
+	return &resampler{
+		inChannels: inChannels,
+		channels:   channels,
+		step:       float64(inRate) / float64(rate),
+	}
+}
+
+// push appends the converted pcm to out. A trailing partial sample is
+// dropped.
+func (r *resampler) push(out []int16, pcm []int16) []int16 {
+	for i := 0; i+r.inChannels <= len(pcm); i += r.inChannels {
+		out = r.resample(out, r.convert(pcm[i:i+r.inChannels]))
+	}
+
+	return out
+}
+
+// flush appends the last input sample still held back to out.
+func (r *resampler) flush(out []int16) []int16 {
+	if r.last != nil && r.pos < 1 {
+		out = append(out, r.last...)
+	}
+	r.last = nil
+	r.pos = 0
+
+	return out
+}
+
+// convert maps one input sample to the output channels.
+func (r *resampler) convert(sample []int16) []int16 {
+	left := sample[0]
+	right := left
+	if r.inChannels == 2 {
+		right = sample[1]
+	}
+
+	if r.channels == 1 {
+		return []int16{int16((int32(left) + int32(right)) / 2)}
+	}
+
+	return []int16{left, right}
+}
+
+// resample feeds one input sample to the linear interpolation.
+func (r *resampler) resample(out []int16, cur []int16) []int16 {
+	if r.last == nil {
+		r.last = cur
+		return out
+	}
+
+	for ; r.pos < 1; r.pos += r.step {
+		for c := range cur {
+			s := float64(r.last[c]) + (float64(cur[c])-float64(r.last[c]))*r.pos
+			out = append(out, int16(s))
+		}
+	}
+	r.pos--
+	r.last = cur
+
+	return out
+}
+
+// AudioConverter converts a stream of PCM that arrives in pieces, e.g. frame
+// by frame, to another sampling rate and channel count. Unlike a new
+// AudioFramer per piece it keeps the interpolation state between the pieces,
+// so the converted stream does not click at their boundaries.
+type AudioConverter struct {
+	resampler *resampler
+
+	InRate     uint32
+	InChannels int
+}
+
+// NewAudioConverter creates an AudioConverter from PCM with inChannels
+// channels at inRate to PCM with the given sampling rate and channels.
+func NewAudioConverter(inRate uint32, inChannels int, rate uint32, channels int) (*AudioConverter, error) {
+	if inChannels < 1 || inChannels > 2 || channels < 1 || channels > 2 {
+		return nil, ErrAudioFrameChannels
+	}
+	if inRate == 0 || rate == 0 {
+		return nil, ErrAudioFrameSamplingRate
+	}
+
+	return &AudioConverter{
+		resampler:  newResampler(inRate, inChannels, rate, channels),
+		InRate:     inRate,
+		InChannels: inChannels,
+	}, nil
+}
+
+// Convert returns the converted interleaved pcm. The last input sample is
+// held back for the interpolation until the next call or Flush.
+func (c *AudioConverter) Convert(pcm []int16) []int16 {
+	return c.resampler.push(nil, pcm)
+}
+
+// Flush returns the sample held back at the end of the stream.
+func (c *AudioConverter) Flush() []int16 {
+	return c.resampler.flush(nil)
+}
+
+// AudioFramer cuts a stream of little endian 16 bit PCM into frames toxav
+// accepts. The input may use any sampling rate and channel count; it is
+// converted to the output format with linear interpolation.
+type AudioFramer struct {
+	r         io.Reader
+	resampler *resampler
+
+	rate        uint32
+	channels    int
+	sampleCount int
+
+	raw     []byte
+	samples []int16
+	pending []int16 // converted samples not returned yet, interleaved
+
+	eof bool
+}
+
+// NewAudioFramer creates an AudioFramer reading PCM with inChannels channels at
+// inRate from r and returning frames of frameDuration with the given sampling
+// rate and channels. A zero frameDuration means DefaultFrameDuration.
+func NewAudioFramer(r io.Reader, inRate uint32, inChannels int, rate uint32, channels int, frameDuration time.Duration) (*AudioFramer, error) {
+	if inChannels < 1 || inChannels > 2 {
+		return nil, ErrAudioFrameChannels
+	}
+	if inRate == 0 {
+		return nil, ErrAudioFrameSamplingRate
+	}
+	if frameDuration == 0 {
+		frameDuration = DefaultFrameDuration
+	}
+
+	// validates the output format
+	frame, err := NewAudioFrame(rate, channels, frameDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AudioFramer{
+		r:           r,
+		resampler:   newResampler(inRate, inChannels, rate, channels),
+		rate:        rate,
+		channels:    channels,
+		sampleCount: frame.SampleCount,
+		raw:         make([]byte, 4096*2*inChannels),
+	}, nil
+}
+
+// ReadFrame returns the next frame. The last frame of the stream is padded
+// with silence. At the end of the stream it returns io.EOF.
+func (f *AudioFramer) ReadFrame() (*AudioFrame, error) {
+	size := f.sampleCount * f.channels
+	for len(f.pending) < size && !f.eof {
+		if err := f.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if len(f.pending) == 0 {
+		return nil, io.EOF
+	}
+
+	frame := &AudioFrame{
+		PCM:          make([]int16, size),
+		SampleCount:  f.sampleCount,
+		Channels:     f.channels,
+		SamplingRate: f.rate,
+	}
+	n := copy(frame.PCM, f.pending)
+	f.pending = f.pending[:copy(f.pending, f.pending[n:])]
+
+	return frame, nil
+}
+
+// fill reads the next chunk of input and appends it, converted, to pending.
+func (f *AudioFramer) fill() error {
+	n, err := io.ReadFull(f.r, f.raw)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		f.eof = true
+	} else if err != nil {
+		return err
+	}
+
+	f.samples = f.samples[:0]
+	for i := 0; i+2 <= n; i += 2 {
+		f.samples = append(f.samples, int16(binary.LittleEndian.Uint16(f.raw[i:])))
+	}
+	f.pending = f.resampler.push(f.pending, f.samples)
+
+	if f.eof {
+		f.pending = f.resampler.flush(f.pending)
+	}
+
+	return nil
+}
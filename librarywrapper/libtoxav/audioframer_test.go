@@ -0,0 +1,185 @@
+package libtoxav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// ramp returns n samples per channel, sample i of channel c being f(i, c)
+func ramp(n int, channels int, f func(i int, c int) int16) []int16 {
+	pcm := make([]int16, 0, n*channels)
+	for i := 0; i < n; i++ {
+		for c := 0; c < channels; c++ {
+			pcm = append(pcm, f(i, c))
+		}
+	}
+	return pcm
+}
+
+// pcmReader returns pcm as little endian bytes
+func pcmReader(pcm []int16) io.Reader {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, pcm)
+	return &b
+}
+
+func TestAudioFramer(t *testing.T) {
+	tests := []struct {
+		name       string
+		inRate     uint32
+		inChannels int
+		rate       uint32
+		channels   int
+		input      []int16
+		want       []int16 // the converted stream without the padding
+		wantFrames int
+	}{
+		{
+			name:   "same format",
+			inRate: 16000, inChannels: 1, rate: 16000, channels: 1,
+			input:      ramp(200, 1, func(i, c int) int16 { return int16(i) }),
+			want:       ramp(200, 1, func(i, c int) int16 { return int16(i) }),
+			wantFrames: 2,
+		},
+		{
+			name:   "upsample 8 kHz to 16 kHz",
+			inRate: 8000, inChannels: 1, rate: 16000, channels: 1,
+			input: ramp(100, 1, func(i, c int) int16 { return int16(10 * i) }),
+			// the last input sample has no successor to interpolate with
+			want:       ramp(199, 1, func(i, c int) int16 { return int16(5 * i) }),
+			wantFrames: 2,
+		},
+		{
+			name:   "downsample 48 kHz to 16 kHz",
+			inRate: 48000, inChannels: 1, rate: 16000, channels: 1,
+			input:      ramp(480, 1, func(i, c int) int16 { return int16(i) }),
+			want:       ramp(160, 1, func(i, c int) int16 { return int16(3 * i) }),
+			wantFrames: 1,
+		},
+		{
+			name:   "stereo to mono",
+			inRate: 16000, inChannels: 2, rate: 16000, channels: 1,
+			input:      ramp(160, 2, func(i, c int) int16 { return int16(i + 2*c*i) }),
+			want:       ramp(160, 1, func(i, c int) int16 { return int16(2 * i) }),
+			wantFrames: 1,
+		},
+		{
+			name:   "mono to stereo",
+			inRate: 16000, inChannels: 1, rate: 16000, channels: 2,
+			input:      ramp(160, 1, func(i, c int) int16 { return int16(i) }),
+			want:       ramp(160, 2, func(i, c int) int16 { return int16(i) }),
+			wantFrames: 1,
+		},
+		{
+			// more than one read of the input
+			name:   "long stream",
+			inRate: 8000, inChannels: 1, rate: 16000, channels: 1,
+			input:      ramp(5000, 1, func(i, c int) int16 { return int16(2 * i) }),
+			want:       ramp(9999, 1, func(i, c int) int16 { return int16(i) }),
+			wantFrames: 63,
+		},
+		{
+			name:   "empty stream",
+			inRate: 48000, inChannels: 1, rate: 48000, channels: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			framer, err := NewAudioFramer(pcmReader(tt.input), tt.inRate, tt.inChannels, tt.rate, tt.channels, 10*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int16
+			frames := 0
+			for {
+				frame, err := framer.ReadFrame()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := frame.Validate(); err != nil {
+					t.Fatalf("frame %d is invalid: %v", frames, err)
+				}
+				if frame.Duration() != 10*time.Millisecond || frame.SamplingRate != tt.rate || frame.Channels != tt.channels {
+					t.Fatalf("frame %d has %v at %d Hz with %d channels", frames, frame.Duration(), frame.SamplingRate, frame.Channels)
+				}
+				got = append(got, frame.PCM...)
+				frames++
+			}
+
+			if frames != tt.wantFrames {
+				t.Fatalf("got %d frames, want %d", frames, tt.wantFrames)
+			}
+			for i, want := range tt.want {
+				if got[i] != want {
+					t.Fatalf("sample %d = %d, want %d", i, got[i], want)
+				}
+			}
+			// the last frame is padded with silence
+			for i := len(tt.want); i < len(got); i++ {
+				if got[i] != 0 {
+					t.Fatalf("padding sample %d = %d, want 0", i, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewAudioFramerFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		inRate        uint32
+		inChannels    int
+		rate          uint32
+		channels      int
+		frameDuration time.Duration
+		want          error
+	}{
+		{"any input rate", 44100, 2, 48000, 1, 0, nil},
+		{"no input channels", 48000, 0, 48000, 1, 0, ErrAudioFrameChannels},
+		{"no input rate", 0, 1, 48000, 1, 0, ErrAudioFrameSamplingRate},
+		{"output rate", 48000, 1, 44100, 1, 0, ErrAudioFrameSamplingRate},
+		{"frame duration", 48000, 1, 48000, 1, 30 * time.Millisecond, ErrAudioFrameSampleCount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAudioFramer(pcmReader(nil), tt.inRate, tt.inChannels, tt.rate, tt.channels, tt.frameDuration); err != tt.want {
+				t.Errorf("NewAudioFramer() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioConverterPieces(t *testing.T) {
+	input := ramp(1000, 2, func(i, c int) int16 { return int16(7*i - 3*c*i) })
+
+	whole, _ := NewAudioConverter(44100, 2, 48000, 1)
+	want := append(whole.Convert(input), whole.Flush()...)
+
+	// pieces of whole stereo samples
+	for _, size := range []int{1, 2, 3, 160, 999} {
+		pieces, _ := NewAudioConverter(44100, 2, 48000, 1)
+		var got []int16
+		for i := 0; i < len(input); i += 2 * size {
+			got = append(got, pieces.Convert(input[i:min(i+2*size, len(input))])...)
+		}
+		got = append(got, pieces.Flush()...)
+
+		if len(got) != len(want) {
+			t.Fatalf("pieces of %d samples: %d samples, want %d", size, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("pieces of %d samples: sample %d = %d, want %d", size, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -1,16 +1,90 @@
+//go:build !toxsim
+
 package libtoxav
 
-//#include <tox/toxav.h>
-//#include "hooks-macro.c"
+/*
+#include <tox/toxav.h>
+#include "hooks-macro.c"
+*/
 import "C"
-import "unsafe"
 
-type OnCall func(toxav *ToxAV, friendnumber uint32, audioenabled bool, videoenabled bool, userdata unsafe.Pointer)
+/*
+ * Functions to register the callbacks.
+ */
+
+// CallbackCall sets the function to be called when a friend calls.
+func (tav *ToxAV) CallbackCall(f OnCall) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
 
-func (tav *ToxAV) CallbackCall(f OnCall, userData unsafe.Pointer) {
 	if tav.toxav != nil {
 		tav.onCall = f
-		tav.onCallUserData = userData
-		C.set_callback_call(tav.toxav, userData)
+		C.set_callback_call(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackCallState sets the function to be called when the call state of a friend changes.
+func (tav *ToxAV) CallbackCallState(f OnCallState) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onCallState = f
+		C.set_callback_call_state(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackAudioBitRate sets the function to be called when the audio bit rate should be lowered.
+func (tav *ToxAV) CallbackAudioBitRate(f OnAudioBitRate) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onAudioBitRate = f
+		C.set_callback_audio_bit_rate(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackVideoBitRate sets the function to be called when the video bit rate should be lowered.
+func (tav *ToxAV) CallbackVideoBitRate(f OnVideoBitRate) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onVideoBitRate = f
+		C.set_callback_video_bit_rate(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackAudioReceiveFrame sets the function to be called when an audio frame is received.
+func (tav *ToxAV) CallbackAudioReceiveFrame(f OnAudioReceiveFrame) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onAudioReceiveFrame = f
+		C.set_callback_audio_receive_frame(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackVideoReceiveFrame sets the function to be called when a video frame is received.
+func (tav *ToxAV) CallbackVideoReceiveFrame(f OnVideoReceiveFrame) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onVideoReceiveFrame = f
+		C.set_callback_video_receive_frame(tav.toxav, C.uintptr_t(tav.handle))
+	}
+}
+
+// CallbackGroupAudio sets the function to be called when audio is received in an AV conference.
+// It applies to all conferences created, joined or enabled with this ToxAV.
+func (tav *ToxAV) CallbackGroupAudio(f OnGroupAudio) {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav != nil {
+		tav.onGroupAudio = f
 	}
 }
@@ -0,0 +1,32 @@
+//go:build !toxsim
+
+package libtoxav
+
+import "image"
+
+// OnCall This event is triggered when a call is received from a friend.
+type OnCall func(toxav *ToxAV, friendnumber uint32, audioenabled bool, videoenabled bool)
+
+// OnCallState This event is triggered when a friend's call state changes.
+// A state with the ERROR or FINISHED flag set is the last state of the call.
+type OnCallState func(toxav *ToxAV, friendnumber uint32, state ToxavFriendCallState)
+
+// OnAudioBitRate This event is triggered when the network becomes too saturated for the current
+// audio bit rate, the client should lower it to the suggested value.
+type OnAudioBitRate func(toxav *ToxAV, friendnumber uint32, audiobitrate uint32)
+
+// OnVideoBitRate This event is triggered when the network becomes too saturated for the current
+// video bit rate, the client should lower it to the suggested value.
+type OnVideoBitRate func(toxav *ToxAV, friendnumber uint32, videobitrate uint32)
+
+// OnAudioReceiveFrame This event is triggered when an audio frame is received from a friend.
+// The frame is a copy and may be kept by the callback.
+type OnAudioReceiveFrame func(toxav *ToxAV, friendnumber uint32, frame *AudioFrame)
+
+// OnVideoReceiveFrame This event is triggered when a video frame is received from a friend.
+// The frame is a 4:2:0 copy of the received planes and may be kept by the callback.
+type OnVideoReceiveFrame func(toxav *ToxAV, friendnumber uint32, frame *image.YCbCr)
+
+// OnGroupAudio This event is triggered when audio is received from a peer of an AV conference.
+// The frame is a copy and may be kept by the callback, e.g. to pass it to an AudioMixer.
+type OnGroupAudio func(toxav *ToxAV, groupnumber uint32, peernumber uint32, frame *AudioFrame)
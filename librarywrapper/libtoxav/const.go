@@ -1,62 +1,157 @@
+//go:build !toxsim
+
 package libtoxav
 
+//#include <tox/toxav.h>
 import "C"
-import "errors"
+import "strings"
+
+type ToxavErrNew C.TOXAV_ERR_NEW
 
-// General errors
 var (
-	ErrToxNew   = errors.New("Error initializing Tox")
-	ErrToxInit  = errors.New("Tox not initialized")
-	ErrArgs     = errors.New("Nil arguments or wrong size")
-	ErrFuncFail = errors.New("Function failed")
-	ErrUnknown  = errors.New("An unknown error occoured")
+	TOXAV_ERR_NEW_OK       ToxavErrNew = C.TOXAV_ERR_NEW_OK
+	TOXAV_ERR_NEW_NULL     ToxavErrNew = C.TOXAV_ERR_NEW_NULL
+	TOXAV_ERR_NEW_MALLOC   ToxavErrNew = C.TOXAV_ERR_NEW_MALLOC
+	TOXAV_ERR_NEW_MULTIPLE ToxavErrNew = C.TOXAV_ERR_NEW_MULTIPLE
 )
 
+type ToxavErrCall C.TOXAV_ERR_CALL
+
 var (
-	ErrNewMalloc        = errors.New("Memory allocation failed")
-	ErrNewPortAlloc     = errors.New("Could not bind to port")
-	ErrNewProxy         = errors.New("Invalid proxy configuration")
-	ErrNewLoadEnc       = errors.New("The savedata is encrypted")
-	ErrNewLoadBadFormat = errors.New("The savedata format is invalid")
+	TOXAV_ERR_CALL_OK                     ToxavErrCall = C.TOXAV_ERR_CALL_OK
+	TOXAV_ERR_CALL_MALLOC                 ToxavErrCall = C.TOXAV_ERR_CALL_MALLOC
+	TOXAV_ERR_CALL_SYNC                   ToxavErrCall = C.TOXAV_ERR_CALL_SYNC
+	TOXAV_ERR_CALL_FRIEND_NOT_FOUND       ToxavErrCall = C.TOXAV_ERR_CALL_FRIEND_NOT_FOUND
+	TOXAV_ERR_CALL_FRIEND_NOT_CONNECTED   ToxavErrCall = C.TOXAV_ERR_CALL_FRIEND_NOT_CONNECTED
+	TOXAV_ERR_CALL_FRIEND_ALREADY_IN_CALL ToxavErrCall = C.TOXAV_ERR_CALL_FRIEND_ALREADY_IN_CALL
+	TOXAV_ERR_CALL_INVALID_BIT_RATE       ToxavErrCall = C.TOXAV_ERR_CALL_INVALID_BIT_RATE
 )
 
-// ==== toxAV error ====
+type ToxavErrAnswer C.TOXAV_ERR_ANSWER
+
 var (
-	ErrToxAVNew  = errors.New("Error initializing ToxAV")
-	ErrToxAVInit = errors.New("ToxAV not initialized")
+	TOXAV_ERR_ANSWER_OK                   ToxavErrAnswer = C.TOXAV_ERR_ANSWER_OK
+	TOXAV_ERR_ANSWER_SYNC                 ToxavErrAnswer = C.TOXAV_ERR_ANSWER_SYNC
+	TOXAV_ERR_ANSWER_CODEC_INITIALIZATION ToxavErrAnswer = C.TOXAV_ERR_ANSWER_CODEC_INITIALIZATION
+	TOXAV_ERR_ANSWER_FRIEND_NOT_FOUND     ToxavErrAnswer = C.TOXAV_ERR_ANSWER_FRIEND_NOT_FOUND
+	TOXAV_ERR_ANSWER_FRIEND_NOT_CALLING   ToxavErrAnswer = C.TOXAV_ERR_ANSWER_FRIEND_NOT_CALLING
+	TOXAV_ERR_ANSWER_INVALID_BIT_RATE     ToxavErrAnswer = C.TOXAV_ERR_ANSWER_INVALID_BIT_RATE
 )
+
+type ToxavCallControl C.TOXAV_CALL_CONTROL
+
 var (
-	ErrNewMultiple = errors.New("Not allow to create a second session")
+	TOXAV_CALL_CONTROL_RESUME       ToxavCallControl = C.TOXAV_CALL_CONTROL_RESUME       //Resume a previously paused call.
+	TOXAV_CALL_CONTROL_PAUSE        ToxavCallControl = C.TOXAV_CALL_CONTROL_PAUSE        //Put a call on hold.
+	TOXAV_CALL_CONTROL_CANCEL       ToxavCallControl = C.TOXAV_CALL_CONTROL_CANCEL       //Reject a call if it was not answered, yet. Cancel a call after it was answered.
+	TOXAV_CALL_CONTROL_MUTE_AUDIO   ToxavCallControl = C.TOXAV_CALL_CONTROL_MUTE_AUDIO   //Request that the friend stops sending audio frames.
+	TOXAV_CALL_CONTROL_UNMUTE_AUDIO ToxavCallControl = C.TOXAV_CALL_CONTROL_UNMUTE_AUDIO //Calling this control will notify client to start sending audio again.
+	TOXAV_CALL_CONTROL_HIDE_VIDEO   ToxavCallControl = C.TOXAV_CALL_CONTROL_HIDE_VIDEO   //Request that the friend stops sending video frames.
+	TOXAV_CALL_CONTROL_SHOW_VIDEO   ToxavCallControl = C.TOXAV_CALL_CONTROL_SHOW_VIDEO   //Calling this control will notify client to start sending video again.
 )
 
-type ToxavErrNew C.TOXAV_ERR_NEW
+type ToxavErrCallControl C.TOXAV_ERR_CALL_CONTROL
 
 var (
-	TOXAV_ERR_NEW_OK       = C.TOXAV_ERR_NEW_OK
-	TOXAV_ERR_NEW_NULL     = C.TOXAV_ERR_NEW_NULL
-	TOXAV_ERR_NEW_MALLOC   = C.TOXAV_ERR_NEW_MALLOC
-	TOXAV_ERR_NEW_MULTIPLE = C.TOXAV_ERR_NEW_MULTIPLE
+	TOXAV_ERR_CALL_CONTROL_OK                 ToxavErrCallControl = C.TOXAV_ERR_CALL_CONTROL_OK
+	TOXAV_ERR_CALL_CONTROL_SYNC               ToxavErrCallControl = C.TOXAV_ERR_CALL_CONTROL_SYNC
+	TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_FOUND   ToxavErrCallControl = C.TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_FOUND
+	TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_IN_CALL ToxavErrCallControl = C.TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_IN_CALL
+	TOXAV_ERR_CALL_CONTROL_INVALID_TRANSITION ToxavErrCallControl = C.TOXAV_ERR_CALL_CONTROL_INVALID_TRANSITION
 )
 
-type ToxavErrCall C.TOXAV_ERR_CALL
+type ToxavErrBitRateSet C.TOXAV_ERR_BIT_RATE_SET
 
 var (
-	TOXAV_ERR_CALL_OK                     = C.TOXAV_ERR_CALL_OK
-	TOXAV_ERR_CALL_MALLOC                 = C.TOXAV_ERR_CALL_MALLOC
-	TOXAV_ERR_CALL_SYNC                   = C.TOXAV_ERR_CALL_SYNC
-	TOXAV_ERR_CALL_FRIEND_NOT_FOUND       = C.TOXAV_ERR_CALL_FRIEND_NOT_FOUND
-	TOXAV_ERR_CALL_FRIEND_NOT_CONNECTED   = C.TOXAV_ERR_CALL_FRIEND_NOT_CONNECTED
-	TOXAV_ERR_CALL_FRIEND_ALREADY_IN_CALL = C.TOXAV_ERR_CALL_FRIEND_ALREADY_IN_CALL
-	TOXAV_ERR_CALL_INVALID_BIT_RATE       = C.TOXAV_ERR_CALL_INVALID_BIT_RATE
+	TOXAV_ERR_BIT_RATE_SET_OK                 ToxavErrBitRateSet = C.TOXAV_ERR_BIT_RATE_SET_OK
+	TOXAV_ERR_BIT_RATE_SET_SYNC               ToxavErrBitRateSet = C.TOXAV_ERR_BIT_RATE_SET_SYNC
+	TOXAV_ERR_BIT_RATE_SET_INVALID_BIT_RATE   ToxavErrBitRateSet = C.TOXAV_ERR_BIT_RATE_SET_INVALID_BIT_RATE
+	TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_FOUND   ToxavErrBitRateSet = C.TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_FOUND
+	TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_IN_CALL ToxavErrBitRateSet = C.TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_IN_CALL
 )
 
-type ToxavErrAnswer C.TOXAV_ERR_ANSWER
+type ToxavErrSendFrame C.TOXAV_ERR_SEND_FRAME
 
 var (
-	TOXAV_ERR_ANSWER_OK                   = C.TOXAV_ERR_ANSWER_OK
-	TOXAV_ERR_ANSWER_SYNC                 = C.TOXAV_ERR_ANSWER_SYNC
-	TOXAV_ERR_ANSWER_CODEC_INITIALIZATION = C.TOXAV_ERR_ANSWER_CODEC_INITIALIZATION
-	TOXAV_ERR_ANSWER_FRIEND_NOT_FOUND     = C.TOXAV_ERR_ANSWER_FRIEND_NOT_FOUND
-	TOXAV_ERR_ANSWER_FRIEND_NOT_CALLING   = C.TOXAV_ERR_ANSWER_FRIEND_NOT_CALLING
-	TOXAV_ERR_ANSWER_INVALID_BIT_RATE     = C.TOXAV_ERR_ANSWER_INVALID_BIT_RATE
+	TOXAV_ERR_SEND_FRAME_OK                    ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_OK
+	TOXAV_ERR_SEND_FRAME_NULL                  ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_NULL
+	TOXAV_ERR_SEND_FRAME_FRIEND_NOT_FOUND      ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_FRIEND_NOT_FOUND
+	TOXAV_ERR_SEND_FRAME_FRIEND_NOT_IN_CALL    ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_FRIEND_NOT_IN_CALL
+	TOXAV_ERR_SEND_FRAME_SYNC                  ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_SYNC
+	TOXAV_ERR_SEND_FRAME_INVALID               ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_INVALID
+	TOXAV_ERR_SEND_FRAME_PAYLOAD_TYPE_DISABLED ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_PAYLOAD_TYPE_DISABLED
+	TOXAV_ERR_SEND_FRAME_RTP_FAILED            ToxavErrSendFrame = C.TOXAV_ERR_SEND_FRAME_RTP_FAILED
 )
+
+// ToxavFriendCallState is the bit mask reported by the call state callback.
+type ToxavFriendCallState uint32
+
+var (
+	TOXAV_FRIEND_CALL_STATE_NONE        ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_NONE        //The empty bit mask. None of the bits specified below are set.
+	TOXAV_FRIEND_CALL_STATE_ERROR       ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_ERROR       //Set by the AV core if an error occurred on the remote end or if friend timed out. This is the final state after which no more state transitions can occur for the call.
+	TOXAV_FRIEND_CALL_STATE_FINISHED    ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_FINISHED    //Set by the AV core if the remote end finished the call. This is the final state after which no more state transitions can occur for the call.
+	TOXAV_FRIEND_CALL_STATE_SENDING_A   ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_SENDING_A   //The flag that marks that friend is sending audio.
+	TOXAV_FRIEND_CALL_STATE_SENDING_V   ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_SENDING_V   //The flag that marks that friend is sending video.
+	TOXAV_FRIEND_CALL_STATE_ACCEPTING_A ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_ACCEPTING_A //The flag that marks that friend is receiving audio.
+	TOXAV_FRIEND_CALL_STATE_ACCEPTING_V ToxavFriendCallState = C.TOXAV_FRIEND_CALL_STATE_ACCEPTING_V //The flag that marks that friend is receiving video.
+)
+
+// CallState is a decoded ToxavFriendCallState.
+type CallState struct {
+	Error          bool // the call ended with an error or the friend timed out
+	Finished       bool // the friend finished the call
+	SendingAudio   bool // the friend is sending audio
+	SendingVideo   bool // the friend is sending video
+	AcceptingAudio bool // the friend is receiving audio
+	AcceptingVideo bool // the friend is receiving video
+}
+
+// Has reports whether all bits of flag are set in s.
+func (s ToxavFriendCallState) Has(flag ToxavFriendCallState) bool {
+	return s&flag == flag
+}
+
+// Decode splits s into its flags.
+func (s ToxavFriendCallState) Decode() CallState {
+	return CallState{
+		Error:          s.Has(TOXAV_FRIEND_CALL_STATE_ERROR),
+		Finished:       s.Has(TOXAV_FRIEND_CALL_STATE_FINISHED),
+		SendingAudio:   s.Has(TOXAV_FRIEND_CALL_STATE_SENDING_A),
+		SendingVideo:   s.Has(TOXAV_FRIEND_CALL_STATE_SENDING_V),
+		AcceptingAudio: s.Has(TOXAV_FRIEND_CALL_STATE_ACCEPTING_A),
+		AcceptingVideo: s.Has(TOXAV_FRIEND_CALL_STATE_ACCEPTING_V),
+	}
+}
+
+// Ended reports whether s is a final state: no more state changes are
+// reported for the call.
+func (s ToxavFriendCallState) Ended() bool {
+	return s&(TOXAV_FRIEND_CALL_STATE_ERROR|TOXAV_FRIEND_CALL_STATE_FINISHED) != 0
+}
+
+// String returns the names of the flags set in s, e.g. "sending_a|accepting_a".
+func (s ToxavFriendCallState) String() string {
+	names := []struct {
+		flag ToxavFriendCallState
+		name string
+	}{
+		{TOXAV_FRIEND_CALL_STATE_ERROR, "error"},
+		{TOXAV_FRIEND_CALL_STATE_FINISHED, "finished"},
+		{TOXAV_FRIEND_CALL_STATE_SENDING_A, "sending_a"},
+		{TOXAV_FRIEND_CALL_STATE_SENDING_V, "sending_v"},
+		{TOXAV_FRIEND_CALL_STATE_ACCEPTING_A, "accepting_a"},
+		{TOXAV_FRIEND_CALL_STATE_ACCEPTING_V, "accepting_v"},
+	}
+
+	var set []string
+	for _, n := range names {
+		if s.Has(n.flag) {
+			set = append(set, n.name)
+		}
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+
+	return strings.Join(set, "|")
+}
@@ -0,0 +1,97 @@
+package libtoxav
+
+import "errors"
+
+/* === Errors === */
+// General errors
+var (
+	ErrToxNew   = errors.New("Error initializing Tox")
+	ErrToxInit  = errors.New("Tox not initialized")
+	ErrArgs     = errors.New("Nil arguments or wrong size")
+	ErrFuncFail = errors.New("Function failed")
+	ErrUnknown  = errors.New("An unknown error occoured")
+)
+
+var (
+	ErrNewMalloc        = errors.New("Memory allocation failed")
+	ErrNewPortAlloc     = errors.New("Could not bind to port")
+	ErrNewProxy         = errors.New("Invalid proxy configuration")
+	ErrNewLoadEnc       = errors.New("The savedata is encrypted")
+	ErrNewLoadBadFormat = errors.New("The savedata format is invalid")
+)
+
+// ==== toxAV error ====
+var (
+	ErrToxAVNew  = errors.New("Error initializing ToxAV")
+	ErrToxAVInit = errors.New("ToxAV not initialized")
+)
+
+var (
+	ErrNewMultiple = errors.New("Not allow to create a second session")
+)
+
+var (
+	ErrCallMalloc              = errors.New("A resource allocation error occurred while trying to create the structures required for the call")
+	ErrCallSync                = errors.New("Synchronization error occurred")
+	ErrCallFriendNotFound      = errors.New("The friend number did not designate a valid friend")
+	ErrCallFriendNotConnected  = errors.New("The friend was valid, but not currently connected")
+	ErrCallFriendAlreadyInCall = errors.New("Attempted to call a friend while already in an audio or video call with them")
+	ErrCallInvalidBitRate      = errors.New("Audio or video bit rate is invalid")
+)
+
+var (
+	ErrAnswerSync                = errors.New("Synchronization error occurred")
+	ErrAnswerCodecInitialization = errors.New("Failed to initialize codecs for call session")
+	ErrAnswerFriendNotFound      = errors.New("The friend number did not designate a valid friend")
+	ErrAnswerFriendNotCalling    = errors.New("The friend was valid, but they are not currently trying to initiate a call")
+	ErrAnswerInvalidBitRate      = errors.New("Audio or video bit rate is invalid")
+)
+
+var (
+	ErrCallControlSync              = errors.New("Synchronization error occurred")
+	ErrCallControlFriendNotFound    = errors.New("The friend number did not designate a valid friend")
+	ErrCallControlFriendNotInCall   = errors.New("This client is currently not in a call with the friend")
+	ErrCallControlInvalidTransition = errors.New("Happens if user tried to pause an already paused call or if trying to resume a call that is not paused")
+)
+
+var (
+	ErrBitRateSetSync            = errors.New("Synchronization error occurred")
+	ErrBitRateSetInvalidBitRate  = errors.New("The bit rate passed was not one of the supported values")
+	ErrBitRateSetFriendNotFound  = errors.New("The friend number did not designate a valid friend")
+	ErrBitRateSetFriendNotInCall = errors.New("This client is currently not in a call with the friend")
+)
+
+var (
+	ErrSendFrameNull                = errors.New("Frame data was nil")
+	ErrSendFrameFriendNotFound      = errors.New("The friend number did not designate a valid friend")
+	ErrSendFrameFriendNotInCall     = errors.New("This client is currently not in a call with the friend")
+	ErrSendFrameSync                = errors.New("Synchronization error occurred")
+	ErrSendFrameInvalid             = errors.New("One of the frame parameters was invalid")
+	ErrSendFramePayloadTypeDisabled = errors.New("Either friend turned off receiving or we turned off sending for the payload")
+	ErrSendFrameRtpFailed           = errors.New("Failed to push frame through RTP interface")
+)
+
+var (
+	ErrAudioFrameChannels     = errors.New("Audio channels must be 1 or 2")
+	ErrAudioFrameSamplingRate = errors.New("Audio sampling rate must be 8000, 12000, 16000, 24000 or 48000")
+	ErrAudioFrameSampleCount  = errors.New("Audio sample count must be 2.5, 5, 10, 20, 40 or 60 ms of audio")
+	ErrAudioFramePCM          = errors.New("Audio PCM length does not match sample count and channels")
+)
+
+var (
+	ErrVideoFrameSize           = errors.New("Video width and height must be between 1 and 65535")
+	ErrVideoFrameSubsampleRatio = errors.New("Video frames must use 4:2:0 chroma subsampling")
+)
+
+var (
+	ErrAVGroupchatAdd     = errors.New("Could not create the AV groupchat")
+	ErrAVGroupchatJoin    = errors.New("Could not join the AV groupchat")
+	ErrGroupSendAudio     = errors.New("Could not send audio to the groupchat")
+	ErrGroupchatEnableAV  = errors.New("Could not enable AV in the groupchat")
+	ErrGroupchatDisableAV = errors.New("Could not disable AV in the groupchat")
+)
+
+var (
+	ErrRunnerStarted = errors.New("Runner already started")
+	ErrRunnerStopped = errors.New("Runner stopped")
+)
@@ -0,0 +1,26 @@
+//go:build !toxsim
+
+#include <stdint.h>
+#include <tox/toxav.h>
+
+/* AV groupchats use the old group call API: the audio callback and its user
+ * data are passed when the group is created, joined or enabled. The user data
+ * is the cgo.Handle of the ToxAV.
+ */
+
+//Tag: Header for the exported GO function in /libtoxav/hooks.go
+
+//typedef void toxav_audio_data_cb(void *tox, uint32_t groupnumber, uint32_t peernumber, const int16_t pcm[], uint32_t samples, uint8_t channels, uint32_t sample_rate, void *userdata);
+void hook_group_audio(void*, uint32_t, uint32_t, const int16_t*, uint32_t, uint8_t, uint32_t, void*);
+
+int32_t add_av_groupchat(Tox *tox, uintptr_t handle) {
+  return toxav_add_av_groupchat(tox, hook_group_audio, (void*)handle);
+}
+
+int32_t join_av_groupchat(Tox *tox, uint32_t friendnumber, const uint8_t *data, uint16_t length, uintptr_t handle) {
+  return toxav_join_av_groupchat(tox, friendnumber, data, length, hook_group_audio, (void*)handle);
+}
+
+int32_t groupchat_enable_av(Tox *tox, uint32_t groupnumber, uintptr_t handle) {
+  return toxav_groupchat_enable_av(tox, groupnumber, hook_group_audio, (void*)handle);
+}
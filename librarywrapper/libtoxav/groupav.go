@@ -0,0 +1,172 @@
+//go:build !toxsim
+
+package libtoxav
+
+/*
+#include <stdint.h>
+#include <tox/toxav.h>
+
+int32_t add_av_groupchat(Tox *tox, uintptr_t handle);
+int32_t join_av_groupchat(Tox *tox, uint32_t friendnumber, const uint8_t *data, uint16_t length, uintptr_t handle);
+int32_t groupchat_enable_av(Tox *tox, uint32_t groupnumber, uintptr_t handle);
+*/
+import "C"
+import "unsafe"
+
+// toxcore returns the Tox of tav as the C type of this package.
+func (tav *ToxAV) toxcore() *C.Tox {
+	if tav.tox == nil {
+		return nil
+	}
+
+	return (*C.Tox)(unsafe.Pointer(tav.tox.Toxcore))
+}
+
+// setGroup records whether the audio callback of a conference is registered.
+func (tav *ToxAV) setGroup(groupNumber uint32, enabled bool) {
+	tav.groupsMtx.Lock()
+	defer tav.groupsMtx.Unlock()
+
+	if enabled {
+		tav.groups[groupNumber] = true
+	} else {
+		delete(tav.groups, groupNumber)
+	}
+}
+
+// disableGroups disables A/V in all conferences using the handle of tav.
+func (tav *ToxAV) disableGroups() {
+	tav.groupsMtx.Lock()
+	defer tav.groupsMtx.Unlock()
+
+	for groupNumber := range tav.groups {
+		if tav.toxcore() == nil {
+			break
+		}
+		C.toxav_groupchat_disable_av(tav.toxcore(), C.uint32_t(groupNumber))
+		delete(tav.groups, groupNumber)
+	}
+}
+
+/**
+ * AddAVGroupchat creates a new AV conference. Audio received in it is passed
+ * to the function set with CallbackGroupAudio.
+ */
+func (tav *ToxAV) AddAVGroupchat() (uint32, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return 0, ErrToxAVInit
+	}
+
+	ret := C.add_av_groupchat(tav.toxcore(), C.uintptr_t(tav.handle))
+	if ret < 0 {
+		return 0, ErrAVGroupchatAdd
+	}
+	tav.setGroup(uint32(ret), true)
+
+	return uint32(ret), nil
+}
+
+/**
+ * JoinAVGroupchat joins an AV conference the friend invited us to. cookie is
+ * the cookie passed to libtox's OnConferenceInvite for TOX_CONFERENCE_TYPE_AV.
+ */
+func (tav *ToxAV) JoinAVGroupchat(friendNumber uint32, cookie []byte) (uint32, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return 0, ErrToxAVInit
+	}
+	if len(cookie) == 0 || len(cookie) > 0xffff {
+		return 0, ErrArgs
+	}
+
+	ret := C.join_av_groupchat(tav.toxcore(), C.uint32_t(friendNumber), (*C.uint8_t)(unsafe.Pointer(&cookie[0])), C.uint16_t(len(cookie)), C.uintptr_t(tav.handle))
+	if ret < 0 {
+		return 0, ErrAVGroupchatJoin
+	}
+	tav.setGroup(uint32(ret), true)
+
+	return uint32(ret), nil
+}
+
+/**
+ * GroupSendAudio sends an audio frame to an AV conference. The recommended
+ * format is 20 ms of 48 kHz mono audio.
+ */
+func (tav *ToxAV) GroupSendAudio(groupNumber uint32, frame *AudioFrame) error {
+	if frame == nil {
+		return ErrSendFrameNull
+	}
+	if err := frame.Validate(); err != nil {
+		return err
+	}
+
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return ErrToxAVInit
+	}
+
+	ret := C.toxav_group_send_audio(tav.toxcore(), C.uint32_t(groupNumber), (*C.int16_t)(unsafe.Pointer(&frame.PCM[0])),
+		C.uint32_t(frame.SampleCount), C.uint8_t(frame.Channels), C.uint32_t(frame.SamplingRate))
+	if ret != 0 {
+		return ErrGroupSendAudio
+	}
+
+	return nil
+}
+
+/**
+ * GroupchatEnableAV enables A/V in a conference. Conferences created or
+ * joined with this package start enabled, conferences loaded from savedata
+ * start disabled.
+ */
+func (tav *ToxAV) GroupchatEnableAV(groupNumber uint32) error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return ErrToxAVInit
+	}
+
+	if C.groupchat_enable_av(tav.toxcore(), C.uint32_t(groupNumber), C.uintptr_t(tav.handle)) != 0 {
+		return ErrGroupchatEnableAV
+	}
+	tav.setGroup(groupNumber, true)
+
+	return nil
+}
+
+/* GroupchatDisableAV disables A/V in a conference. */
+func (tav *ToxAV) GroupchatDisableAV(groupNumber uint32) error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return ErrToxAVInit
+	}
+
+	if C.toxav_groupchat_disable_av(tav.toxcore(), C.uint32_t(groupNumber)) != 0 {
+		return ErrGroupchatDisableAV
+	}
+	tav.setGroup(groupNumber, false)
+
+	return nil
+}
+
+/* GroupchatAVEnabled returns whether A/V is enabled in a conference. */
+func (tav *ToxAV) GroupchatAVEnabled(groupNumber uint32) (bool, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil || tav.toxcore() == nil {
+		return false, ErrToxAVInit
+	}
+
+	return bool(C.toxav_groupchat_av_enabled(tav.toxcore(), C.uint32_t(groupNumber))), nil
+}
@@ -1,11 +1,15 @@
+//go:build !toxsim
+
+#include <stdint.h>
 #include <tox/toxav.h>
 
 /* Macro defined:
- * Creates the C function to directly register a given callback from toxav.h
+ * Creates the C function to directly register a given callback from toxav.h.
+ * The user data is the cgo.Handle of the ToxAV, so no Go pointer is passed to C.
  */
 #define CREATE_HOOK(x) \
-static void set_##x(ToxAV *toxav, void *t, void *user_data) { \
-toxav_##x(toxav, hook_##x, t, user_data); \
+static void set_##x(ToxAV *toxav, uintptr_t handle) { \
+  toxav_##x(toxav, hook_##x, (void*)handle); \
 }
 
 //Tag: Headers for the exported GO functions in /libtoxav/hooks.go
@@ -13,5 +17,25 @@ toxav_##x(toxav, hook_##x, t, user_data); \
 //typedef void toxav_call_cb(ToxAV *av, uint32_t friend_number, bool audio_enabled, bool video_enabled, void *user_data);
 void hook_callback_call(ToxAV*, uint32_t, bool, bool, void*);
 
+//typedef void toxav_call_state_cb(ToxAV *av, uint32_t friend_number, uint32_t state, void *user_data);
+void hook_callback_call_state(ToxAV*, uint32_t, uint32_t, void*);
+
+//typedef void toxav_audio_bit_rate_cb(ToxAV *av, uint32_t friend_number, uint32_t audio_bit_rate, void *user_data);
+void hook_callback_audio_bit_rate(ToxAV*, uint32_t, uint32_t, void*);
+
+//typedef void toxav_video_bit_rate_cb(ToxAV *av, uint32_t friend_number, uint32_t video_bit_rate, void *user_data);
+void hook_callback_video_bit_rate(ToxAV*, uint32_t, uint32_t, void*);
+
+//typedef void toxav_audio_receive_frame_cb(ToxAV *av, uint32_t friend_number, const int16_t pcm[], size_t sample_count, uint8_t channels, uint32_t sampling_rate, void *user_data);
+void hook_callback_audio_receive_frame(ToxAV*, uint32_t, const int16_t*, size_t, uint8_t, uint32_t, void*);
+
+//typedef void toxav_video_receive_frame_cb(ToxAV *av, uint32_t friend_number, uint16_t width, uint16_t height, const uint8_t y[], const uint8_t u[], const uint8_t v[], int32_t ystride, int32_t ustride, int32_t vstride, void *user_data);
+void hook_callback_video_receive_frame(ToxAV*, uint32_t, uint16_t, uint16_t, const uint8_t*, const uint8_t*, const uint8_t*, int32_t, int32_t, int32_t, void*);
+
 //toxav callback functions
-CREATE_HOOK(callback_call)
\ No newline at end of file
+CREATE_HOOK(callback_call)
+CREATE_HOOK(callback_call_state)
+CREATE_HOOK(callback_audio_bit_rate)
+CREATE_HOOK(callback_video_bit_rate)
+CREATE_HOOK(callback_audio_receive_frame)
+CREATE_HOOK(callback_video_receive_frame)
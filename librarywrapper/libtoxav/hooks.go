@@ -1,10 +1,112 @@
+//go:build !toxsim
+
 package libtoxav
 
 //#include <tox/toxav.h>
 import "C"
-import "unsafe"
+import (
+	"image"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// toxavOf returns the ToxAV registered with the cgo.Handle passed as user data.
+func toxavOf(userdata unsafe.Pointer) *ToxAV {
+	return cgo.Handle(uintptr(userdata)).Value().(*ToxAV)
+}
 
 //export hook_callback_call
-func hook_callback_call(t unsafe.Pointer, friendnumber C.uint32_t, audioenabled C._Bool, videoenabled C._Bool, toxav unsafe.Pointer, userdata unsafe.Pointer) {
-	(*ToxAV)(toxav).onCall((*ToxAV)(toxav), uint32(friendnumber), bool(audioenabled), bool(videoenabled), userdata)
+func hook_callback_call(av unsafe.Pointer, friendnumber C.uint32_t, audioenabled C._Bool, videoenabled C._Bool, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onCall != nil {
+		tav.onCall(tav, uint32(friendnumber), bool(audioenabled), bool(videoenabled))
+	}
+}
+
+//export hook_callback_call_state
+func hook_callback_call_state(av unsafe.Pointer, friendnumber C.uint32_t, state C.uint32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onCallState != nil {
+		tav.onCallState(tav, uint32(friendnumber), ToxavFriendCallState(state))
+	}
+}
+
+//export hook_callback_audio_bit_rate
+func hook_callback_audio_bit_rate(av unsafe.Pointer, friendnumber C.uint32_t, audiobitrate C.uint32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onAudioBitRate != nil {
+		tav.onAudioBitRate(tav, uint32(friendnumber), uint32(audiobitrate))
+	}
+}
+
+//export hook_callback_video_bit_rate
+func hook_callback_video_bit_rate(av unsafe.Pointer, friendnumber C.uint32_t, videobitrate C.uint32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onVideoBitRate != nil {
+		tav.onVideoBitRate(tav, uint32(friendnumber), uint32(videobitrate))
+	}
+}
+
+//export hook_callback_audio_receive_frame
+func hook_callback_audio_receive_frame(av unsafe.Pointer, friendnumber C.uint32_t, pcm *C.int16_t, samplecount C.size_t, channels C.uint8_t, samplingrate C.uint32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onAudioReceiveFrame == nil {
+		return
+	}
+
+	frame := &AudioFrame{
+		SampleCount:  int(samplecount),
+		Channels:     int(channels),
+		SamplingRate: uint32(samplingrate),
+	}
+	if n := frame.SampleCount * frame.Channels; n > 0 && pcm != nil {
+		frame.PCM = append([]int16(nil), unsafe.Slice((*int16)(unsafe.Pointer(pcm)), n)...)
+	}
+	tav.onAudioReceiveFrame(tav, uint32(friendnumber), frame)
+}
+
+//export hook_callback_video_receive_frame
+func hook_callback_video_receive_frame(av unsafe.Pointer, friendnumber C.uint32_t, width C.uint16_t, height C.uint16_t, y *C.uint8_t, u *C.uint8_t, v *C.uint8_t, ystride C.int32_t, ustride C.int32_t, vstride C.int32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onVideoReceiveFrame == nil {
+		return
+	}
+
+	w, h := int(width), int(height)
+	frame := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	copyPlane(frame.Y, frame.YStride, unsafe.Pointer(y), int(ystride), w, h)
+	copyPlane(frame.Cb, frame.CStride, unsafe.Pointer(u), int(ustride), w/2, h/2)
+	copyPlane(frame.Cr, frame.CStride, unsafe.Pointer(v), int(vstride), w/2, h/2)
+	tav.onVideoReceiveFrame(tav, uint32(friendnumber), frame)
+}
+
+// copyPlane copies a plane of rows*width bytes from toxav into dst. Rows of
+// the source are stride bytes apart; a negative stride is a bottom-up plane.
+func copyPlane(dst []byte, dstStride int, src unsafe.Pointer, stride int, width, rows int) {
+	if src == nil || width == 0 {
+		return
+	}
+
+	for row := 0; row < rows; row++ {
+		line := unsafe.Slice((*byte)(unsafe.Add(src, row*stride)), width)
+		copy(dst[row*dstStride:], line)
+	}
+}
+
+//export hook_group_audio
+func hook_group_audio(tox unsafe.Pointer, groupnumber C.uint32_t, peernumber C.uint32_t, pcm *C.int16_t, samples C.uint32_t, channels C.uint8_t, samplerate C.uint32_t, userdata unsafe.Pointer) {
+	tav := toxavOf(userdata)
+	if tav.onGroupAudio == nil {
+		return
+	}
+
+	frame := &AudioFrame{
+		SampleCount:  int(samples),
+		Channels:     int(channels),
+		SamplingRate: uint32(samplerate),
+	}
+	if n := frame.SampleCount * frame.Channels; n > 0 && pcm != nil {
+		frame.PCM = append([]int16(nil), unsafe.Slice((*int16)(unsafe.Pointer(pcm)), n)...)
+	}
+	tav.onGroupAudio(tav, uint32(groupnumber), uint32(peernumber), frame)
 }
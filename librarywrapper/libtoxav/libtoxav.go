@@ -1,33 +1,44 @@
+//go:build !toxsim
+
 package libtoxav
 
 //#cgo LDFLAGS: -ltoxcore
 //#include <tox/toxav.h>
-//#include <vpx/vpx_image.h>
 import "C"
 
 import (
-	"errors"
-	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+	"image"
+	"runtime/cgo"
 	"sync"
 	"unsafe"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
 )
 
 type ToxAV struct {
-	tox   libtox.Tox
+	tox   *libtox.Tox
 	toxav *C.ToxAV
-	mtx   sync.Mutex
+	// mtx is held for reading by every function using toxav and for writing
+	// by Kill and while callbacks are set, so the audio, video and core loops
+	// can iterate concurrently and toxav is never used after it was freed.
+	// ToxAV must not be called from its own callbacks.
+	mtx sync.RWMutex
+	// handle is passed to toxav as callback user data instead of a Go pointer.
+	handle cgo.Handle
 
-	// session datas
-	out_image  []byte
-	out_width  uint16
-	out_hegith uint16
-	in_image   *C.vpx_image_t
-	in_width   uint16
-	in_height  uint16
+	// groups are the AV conferences whose audio callback carries handle.
+	groupsMtx sync.Mutex
+	groups    map[uint32]bool
 
 	// Callbacks
 	onCall         OnCall
-	onCallUserData unsafe.Pointer
+	onCallState    OnCallState
+	onAudioBitRate OnAudioBitRate
+	onVideoBitRate OnVideoBitRate
+
+	onAudioReceiveFrame OnAudioReceiveFrame
+	onVideoReceiveFrame OnVideoReceiveFrame
+	onGroupAudio        OnGroupAudio
 }
 
 /**
@@ -35,9 +46,13 @@ type ToxAV struct {
  * ToxAV *toxav_new(Tox *tox, Toxav_Err_New *error);
  */
 func NewToxAV(tox *libtox.Tox) (*ToxAV, error) {
-	var cToxAV *C.ToxAV
+	if tox == nil || tox.Toxcore == nil {
+		return nil, ErrToxInit
+	}
+
 	var toxAVErrNew C.TOXAV_ERR_NEW
-	cToxAV = C.toxav_new(tox.Toxcore, &toxAVErrNew)
+	// the C.Tox types of libtox and libtoxav are distinct Go types
+	cToxAV := C.toxav_new((*C.Tox)(unsafe.Pointer(tox.Toxcore)), &toxAVErrNew)
 	if cToxAV == nil || ToxavErrNew(toxAVErrNew) != TOXAV_ERR_NEW_OK {
 		switch ToxavErrNew(toxAVErrNew) {
 		case TOXAV_ERR_NEW_NULL:
@@ -52,7 +67,9 @@ func NewToxAV(tox *libtox.Tox) (*ToxAV, error) {
 		}
 		return nil, ErrUnknown
 	}
-	tav := &ToxAV{tox: tox.Toxcore, toxav: cToxAV}
+
+	tav := &ToxAV{tox: tox, toxav: cToxAV, groups: make(map[uint32]bool)}
+	tav.handle = cgo.NewHandle(tav)
 
 	return tav, nil
 }
@@ -63,15 +80,28 @@ func NewToxAV(tox *libtox.Tox) (*ToxAV, error) {
  * notifying peers. After calling this function, no other functions may be
  * called and the av pointer becomes invalid.
  */
-func (tav *ToxAV) Kill() {
+func (tav *ToxAV) Kill() error {
+	tav.mtx.Lock()
+	defer tav.mtx.Unlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	// the conferences would keep calling back with the deleted handle
+	tav.disableGroups()
 	C.toxav_kill(tav.toxav)
+	tav.toxav = nil
+	tav.handle.Delete()
+
+	return nil
 }
 
 /**
  * Returns the Tox instance the A/V object was created for.
  */
 func (tav *ToxAV) GetTox() *libtox.Tox {
-	return tav.toxav
+	return tav.tox
 }
 
 /**
@@ -80,8 +110,15 @@ func (tav *ToxAV) GetTox() *libtox.Tox {
  * be. If no call is active at the moment, this function returns 200.
  * This function MUST be called from the same thread as toxav_iterate.
  */
-func (tav *ToxAV) IterationInterval() uint32 {
-	return uint32(C.toxav_iteration_interval(tav.toxav))
+func (tav *ToxAV) IterationInterval() (uint32, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return 0, ErrToxAVInit
+	}
+
+	return uint32(C.toxav_iteration_interval(tav.toxav)), nil
 }
 
 /**
@@ -89,8 +126,17 @@ func (tav *ToxAV) IterationInterval() uint32 {
  * `toxav_iteration_interval()` milliseconds. It is best called in the separate
  * thread from tox_iterate.
  */
-func (tav *ToxAV) Iterate() {
+func (tav *ToxAV) Iterate() error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
 	C.toxav_iterate(tav.toxav)
+
+	return nil
 }
 
 /**
@@ -99,28 +145,342 @@ func (tav *ToxAV) Iterate() {
  * should be. If no call is active at the moment, this function returns 200.
  * This function MUST be called from the same thread as toxav_audio_iterate.
  */
-func (tav *ToxAV) AudioIterationInterval() uint32 {
-	return uint32(C.toxav_audio_iteration_interval(tav.toxav))
+func (tav *ToxAV) AudioIterationInterval() (uint32, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return 0, ErrToxAVInit
+	}
+
+	return uint32(C.toxav_audio_iteration_interval(tav.toxav)), nil
 }
 
-func (tav *ToxAV) AudioIterate() {
+/**
+ * Main loop for the audio part of the session. Only use this together with
+ * VideoIterate and not with Iterate.
+ */
+func (tav *ToxAV) AudioIterate() error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
 	C.toxav_audio_iterate(tav.toxav)
+
+	return nil
 }
 
+/**
+ * Returns the interval in milliseconds when the next toxav_video_iterate call
+ * should be. If no call is active at the moment, this function returns 200.
+ * This function MUST be called from the same thread as toxav_video_iterate.
+ */
+func (tav *ToxAV) VideoIterationInterval() (uint32, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return 0, ErrToxAVInit
+	}
+
+	return uint32(C.toxav_video_iteration_interval(tav.toxav)), nil
+}
+
+/**
+ * Main loop for the video part of the session. Only use this together with
+ * AudioIterate and not with Iterate.
+ */
+func (tav *ToxAV) VideoIterate() error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	C.toxav_video_iterate(tav.toxav)
+
+	return nil
+}
+
+/**
+ * Call a friend. This will start ringing the friend.
+ * It is the client's responsibility to stop ringing after a certain timeout,
+ * if such behaviour is desired. A bit rate of 0 disables audio or video
+ * sending for the call.
+ */
 func (tav *ToxAV) Call(friendNumber uint32, audioBitRate uint32, videoBitRate uint32) (bool, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return false, ErrToxAVInit
+	}
+
 	var toxavErrCall C.TOXAV_ERR_CALL
 	ret := C.toxav_call(tav.toxav, (C.uint32_t)(friendNumber), (C.uint32_t)(audioBitRate), (C.uint32_t)(videoBitRate), &toxavErrCall)
-	if ToxavErrCall(toxavErrCall) != TOXAV_ERR_CALL_OK {
-		return bool(ret), errors.New(string(toxavErrCall)) //todo: write to error discription
+	switch ToxavErrCall(toxavErrCall) {
+	case TOXAV_ERR_CALL_OK:
+		return bool(ret), nil
+	case TOXAV_ERR_CALL_MALLOC:
+		return false, ErrCallMalloc
+	case TOXAV_ERR_CALL_SYNC:
+		return false, ErrCallSync
+	case TOXAV_ERR_CALL_FRIEND_NOT_FOUND:
+		return false, ErrCallFriendNotFound
+	case TOXAV_ERR_CALL_FRIEND_NOT_CONNECTED:
+		return false, ErrCallFriendNotConnected
+	case TOXAV_ERR_CALL_FRIEND_ALREADY_IN_CALL:
+		return false, ErrCallFriendAlreadyInCall
+	case TOXAV_ERR_CALL_INVALID_BIT_RATE:
+		return false, ErrCallInvalidBitRate
 	}
-	return bool(ret), nil
+
+	return false, ErrUnknown
 }
 
+/**
+ * Accept an incoming call. If answering fails for any reason, the call will
+ * still be pending and it is possible to try and answer it later. A bit rate
+ * of 0 disables audio or video sending for the call.
+ */
 func (tav *ToxAV) Answer(friendNumber uint32, audioBitRate uint32, videoBitRate uint32) (bool, error) {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return false, ErrToxAVInit
+	}
+
 	var toxavErrAnswer C.TOXAV_ERR_ANSWER
 	ret := C.toxav_answer(tav.toxav, C.uint32_t(friendNumber), C.uint32_t(audioBitRate), C.uint32_t(videoBitRate), &toxavErrAnswer)
-	if ToxavErrAnswer(toxavErrAnswer) != TOXAV_ERR_ANSWER_OK {
-		return bool(ret), errors.New(string(toxavErrAnswer))
+	switch ToxavErrAnswer(toxavErrAnswer) {
+	case TOXAV_ERR_ANSWER_OK:
+		return bool(ret), nil
+	case TOXAV_ERR_ANSWER_SYNC:
+		return false, ErrAnswerSync
+	case TOXAV_ERR_ANSWER_CODEC_INITIALIZATION:
+		return false, ErrAnswerCodecInitialization
+	case TOXAV_ERR_ANSWER_FRIEND_NOT_FOUND:
+		return false, ErrAnswerFriendNotFound
+	case TOXAV_ERR_ANSWER_FRIEND_NOT_CALLING:
+		return false, ErrAnswerFriendNotCalling
+	case TOXAV_ERR_ANSWER_INVALID_BIT_RATE:
+		return false, ErrAnswerInvalidBitRate
+	}
+
+	return false, ErrUnknown
+}
+
+/**
+ * Sends a call control command to a friend: pause or resume the call, mute or
+ * unmute audio, hide or show video, or cancel (reject) the call.
+ */
+func (tav *ToxAV) CallControl(friendNumber uint32, control ToxavCallControl) error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	var toxavErrCallControl C.TOXAV_ERR_CALL_CONTROL
+	C.toxav_call_control(tav.toxav, C.uint32_t(friendNumber), C.TOXAV_CALL_CONTROL(control), &toxavErrCallControl)
+	switch ToxavErrCallControl(toxavErrCallControl) {
+	case TOXAV_ERR_CALL_CONTROL_OK:
+		return nil
+	case TOXAV_ERR_CALL_CONTROL_SYNC:
+		return ErrCallControlSync
+	case TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_FOUND:
+		return ErrCallControlFriendNotFound
+	case TOXAV_ERR_CALL_CONTROL_FRIEND_NOT_IN_CALL:
+		return ErrCallControlFriendNotInCall
+	case TOXAV_ERR_CALL_CONTROL_INVALID_TRANSITION:
+		return ErrCallControlInvalidTransition
+	}
+
+	return ErrUnknown
+}
+
+/* Pause puts the call with a friend on hold. */
+func (tav *ToxAV) Pause(friendNumber uint32) error {
+	return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_PAUSE)
+}
+
+/* Resume resumes a previously paused call. */
+func (tav *ToxAV) Resume(friendNumber uint32) error {
+	return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_RESUME)
+}
+
+/* Cancel rejects a call that was not answered yet or hangs up an answered one. */
+func (tav *ToxAV) Cancel(friendNumber uint32) error {
+	return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_CANCEL)
+}
+
+/* MuteAudio asks the friend to stop (mute true) or start sending audio again. */
+func (tav *ToxAV) MuteAudio(friendNumber uint32, mute bool) error {
+	if mute {
+		return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_MUTE_AUDIO)
+	}
+	return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_UNMUTE_AUDIO)
+}
+
+/* HideVideo asks the friend to stop (hide true) or start sending video again. */
+func (tav *ToxAV) HideVideo(friendNumber uint32, hide bool) error {
+	if hide {
+		return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_HIDE_VIDEO)
+	}
+	return tav.CallControl(friendNumber, TOXAV_CALL_CONTROL_SHOW_VIDEO)
+}
+
+/**
+ * Set the bit rate to be used in subsequent audio frames. A bit rate of 0
+ * disables sending audio.
+ */
+func (tav *ToxAV) AudioSetBitRate(friendNumber uint32, bitRate uint32) error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	var toxavErrBitRateSet C.TOXAV_ERR_BIT_RATE_SET
+	C.toxav_audio_set_bit_rate(tav.toxav, C.uint32_t(friendNumber), C.uint32_t(bitRate), &toxavErrBitRateSet)
+
+	return bitRateSetError(toxavErrBitRateSet)
+}
+
+/**
+ * Set the bit rate to be used in subsequent video frames. A bit rate of 0
+ * disables sending video.
+ */
+func (tav *ToxAV) VideoSetBitRate(friendNumber uint32, bitRate uint32) error {
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	var toxavErrBitRateSet C.TOXAV_ERR_BIT_RATE_SET
+	C.toxav_video_set_bit_rate(tav.toxav, C.uint32_t(friendNumber), C.uint32_t(bitRate), &toxavErrBitRateSet)
+
+	return bitRateSetError(toxavErrBitRateSet)
+}
+
+func bitRateSetError(code C.TOXAV_ERR_BIT_RATE_SET) error {
+	switch ToxavErrBitRateSet(code) {
+	case TOXAV_ERR_BIT_RATE_SET_OK:
+		return nil
+	case TOXAV_ERR_BIT_RATE_SET_SYNC:
+		return ErrBitRateSetSync
+	case TOXAV_ERR_BIT_RATE_SET_INVALID_BIT_RATE:
+		return ErrBitRateSetInvalidBitRate
+	case TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_FOUND:
+		return ErrBitRateSetFriendNotFound
+	case TOXAV_ERR_BIT_RATE_SET_FRIEND_NOT_IN_CALL:
+		return ErrBitRateSetFriendNotInCall
+	}
+
+	return ErrUnknown
+}
+
+/**
+ * Send an audio frame to a friend. The frame is validated against the formats
+ * Opus accepts before it is handed to toxav.
+ */
+func (tav *ToxAV) AudioSendFrame(friendNumber uint32, frame *AudioFrame) error {
+	if frame == nil {
+		return ErrSendFrameNull
+	}
+	if err := frame.Validate(); err != nil {
+		return err
+	}
+
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	var toxavErrSendFrame C.TOXAV_ERR_SEND_FRAME
+	C.toxav_audio_send_frame(tav.toxav, C.uint32_t(friendNumber), (*C.int16_t)(unsafe.Pointer(&frame.PCM[0])),
+		C.size_t(frame.SampleCount), C.uint8_t(frame.Channels), C.uint32_t(frame.SamplingRate), &toxavErrSendFrame)
+
+	return sendFrameError(toxavErrSendFrame)
+}
+
+func sendFrameError(code C.TOXAV_ERR_SEND_FRAME) error {
+	switch ToxavErrSendFrame(code) {
+	case TOXAV_ERR_SEND_FRAME_OK:
+		return nil
+	case TOXAV_ERR_SEND_FRAME_NULL:
+		return ErrSendFrameNull
+	case TOXAV_ERR_SEND_FRAME_FRIEND_NOT_FOUND:
+		return ErrSendFrameFriendNotFound
+	case TOXAV_ERR_SEND_FRAME_FRIEND_NOT_IN_CALL:
+		return ErrSendFrameFriendNotInCall
+	case TOXAV_ERR_SEND_FRAME_SYNC:
+		return ErrSendFrameSync
+	case TOXAV_ERR_SEND_FRAME_INVALID:
+		return ErrSendFrameInvalid
+	case TOXAV_ERR_SEND_FRAME_PAYLOAD_TYPE_DISABLED:
+		return ErrSendFramePayloadTypeDisabled
+	case TOXAV_ERR_SEND_FRAME_RTP_FAILED:
+		return ErrSendFrameRtpFailed
+	}
+
+	return ErrUnknown
+}
+
+/**
+ * Send a video frame to a friend. The frame must use 4:2:0 chroma
+ * subsampling; use ToYCbCr or VideoSendImage for other images. Odd widths and
+ * heights lose their last chroma column or row.
+ */
+func (tav *ToxAV) VideoSendFrame(friendNumber uint32, frame *image.YCbCr) error {
+	if frame == nil {
+		return ErrSendFrameNull
+	}
+	if err := validVideoFrame(frame); err != nil {
+		return err
+	}
+
+	tav.mtx.RLock()
+	defer tav.mtx.RUnlock()
+
+	if tav.toxav == nil {
+		return ErrToxAVInit
+	}
+
+	y, u, v := packedPlanes(frame)
+	var toxavErrSendFrame C.TOXAV_ERR_SEND_FRAME
+	C.toxav_video_send_frame(tav.toxav, C.uint32_t(friendNumber), C.uint16_t(frame.Rect.Dx()), C.uint16_t(frame.Rect.Dy()),
+		(*C.uint8_t)(unsafe.Pointer(&y[0])), (*C.uint8_t)(unsafe.Pointer(planePtr(u))), (*C.uint8_t)(unsafe.Pointer(planePtr(v))),
+		&toxavErrSendFrame)
+
+	return sendFrameError(toxavErrSendFrame)
+}
+
+/* VideoSendImage converts img with ToYCbCr and sends it to a friend. */
+func (tav *ToxAV) VideoSendImage(friendNumber uint32, img image.Image) error {
+	if img == nil {
+		return ErrSendFrameNull
+	}
+
+	return tav.VideoSendFrame(friendNumber, ToYCbCr(img))
+}
+
+// planePtr returns a pointer to the first byte of a chroma plane, which is
+// empty for frames one pixel wide or high.
+func planePtr(plane []byte) *byte {
+	if len(plane) == 0 {
+		return new(byte)
 	}
-	return bool(ret), nil
+	return &plane[0]
 }
@@ -0,0 +1,150 @@
+package libtoxav
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMixerDelay is how much audio an AudioMixer buffers per peer.
+const DefaultMixerDelay = 200 * time.Millisecond
+
+// mixerPeer is the queued audio of one peer, already in the output format.
+type mixerPeer struct {
+	resampler    *resampler
+	channels     int
+	samplingRate uint32
+	queue        []int16
+}
+
+// AudioMixer mixes the audio of the peers of a conference into one PCM
+// stream. Received frames are added with Write; Mix is called once per frame
+// duration, e.g. from a time.Ticker, and returns the sum of the queued audio
+// of all peers. Peers that sent nothing are silent in the mix.
+type AudioMixer struct {
+	mtx         sync.Mutex
+	rate        uint32
+	channels    int
+	sampleCount int
+	maxQueue    int
+	peers       map[uint32]*mixerPeer
+}
+
+// NewAudioMixer creates a mixer producing frames of frameDuration with the
+// given sampling rate and channels. maxDelay is the amount of audio buffered
+// per peer; older audio is dropped. Zero values mean DefaultFrameDuration and
+// DefaultMixerDelay.
+func NewAudioMixer(rate uint32, channels int, frameDuration time.Duration, maxDelay time.Duration) (*AudioMixer, error) {
+	if frameDuration == 0 {
+		frameDuration = DefaultFrameDuration
+	}
+	if maxDelay == 0 {
+		maxDelay = DefaultMixerDelay
+	}
+
+	frame, err := NewAudioFrame(rate, channels, frameDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	maxQueue := int(time.Duration(rate)*maxDelay/time.Second) * channels
+	if maxQueue < len(frame.PCM) {
+		maxQueue = len(frame.PCM)
+	}
+
+	return &AudioMixer{
+		rate:        rate,
+		channels:    channels,
+		sampleCount: frame.SampleCount,
+		maxQueue:    maxQueue,
+		peers:       make(map[uint32]*mixerPeer),
+	}, nil
+}
+
+// Write queues a frame received from peer.
+func (m *AudioMixer) Write(peer uint32, frame *AudioFrame) {
+	if frame == nil || frame.Channels < 1 || frame.Channels > 2 || frame.SamplingRate == 0 {
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p, ok := m.peers[peer]
+	if !ok || p.channels != frame.Channels || p.samplingRate != frame.SamplingRate {
+		if !ok {
+			p = &mixerPeer{}
+			m.peers[peer] = p
+		}
+		p.channels = frame.Channels
+		p.samplingRate = frame.SamplingRate
+		p.resampler = newResampler(frame.SamplingRate, frame.Channels, m.rate, m.channels)
+	}
+
+	p.queue = p.resampler.push(p.queue, frame.PCM)
+	if over := len(p.queue) - m.maxQueue; over > 0 {
+		// drop whole samples so the channels stay aligned
+		over += (m.channels - over%m.channels) % m.channels
+		p.queue = p.queue[:copy(p.queue, p.queue[over:])]
+	}
+}
+
+// RemovePeer drops the queued audio of peer, e.g. when it left the conference.
+func (m *AudioMixer) RemovePeer(peer uint32) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	delete(m.peers, peer)
+}
+
+// Peers returns the peers that have sent audio, sorted.
+func (m *AudioMixer) Peers() []uint32 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	peers := make([]uint32, 0, len(m.peers))
+	for peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+
+	return peers
+}
+
+// Mix removes one frame of audio from the queue of every peer and returns
+// their sum, clipped to the int16 range.
+func (m *AudioMixer) Mix() *AudioFrame {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	size := m.sampleCount * m.channels
+	sum := make([]int32, size)
+	for _, p := range m.peers {
+		n := len(p.queue)
+		if n > size {
+			n = size
+		}
+		for i := 0; i < n; i++ {
+			sum[i] += int32(p.queue[i])
+		}
+		p.queue = p.queue[:copy(p.queue, p.queue[n:])]
+	}
+
+	frame := &AudioFrame{
+		PCM:          make([]int16, size),
+		SampleCount:  m.sampleCount,
+		Channels:     m.channels,
+		SamplingRate: m.rate,
+	}
+	for i, s := range sum {
+		if s > math.MaxInt16 {
+			s = math.MaxInt16
+		} else if s < math.MinInt16 {
+			s = math.MinInt16
+		}
+		frame.PCM[i] = int16(s)
+	}
+
+	return frame
+}
@@ -0,0 +1,114 @@
+package libtoxav
+
+import (
+	"testing"
+	"time"
+)
+
+// constantFrame returns 10 ms of audio with every sample set to value
+func constantFrame(rate uint32, channels int, value int16) *AudioFrame {
+	frame, _ := NewAudioFrame(rate, channels, 10*time.Millisecond)
+	for i := range frame.PCM {
+		frame.PCM[i] = value
+	}
+	return frame
+}
+
+func TestAudioMixerMix(t *testing.T) {
+	type write struct {
+		peer     uint32
+		rate     uint32
+		channels int
+		value    int16
+	}
+
+	tests := []struct {
+		name   string
+		writes []write
+		want   int16
+	}{
+		{"no peers", nil, 0},
+		{"one peer", []write{{1, 48000, 1, 1000}}, 1000},
+		{"two peers", []write{{1, 48000, 1, 1000}, {2, 48000, 1, -3000}}, -2000},
+		{"clipped high", []write{{1, 48000, 1, 30000}, {2, 48000, 1, 10000}}, 32767},
+		{"clipped low", []write{{1, 48000, 1, -30000}, {2, 48000, 1, -10000}}, -32768},
+		{"stereo peer", []write{{1, 48000, 2, 1000}}, 1000},
+		{"resampled peer", []write{{1, 16000, 2, 1000}, {2, 48000, 1, 500}}, 1500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mixer, err := NewAudioMixer(48000, 1, 10*time.Millisecond, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// two frames per peer, as the last sample of a write is held
+			// back for the interpolation
+			for i := 0; i < 2; i++ {
+				for _, w := range tt.writes {
+					mixer.Write(w.peer, constantFrame(w.rate, w.channels, w.value))
+				}
+			}
+
+			frame := mixer.Mix()
+			if err := frame.Validate(); err != nil || frame.SampleCount != 480 || frame.Channels != 1 || frame.SamplingRate != 48000 {
+				t.Fatalf("Mix() returned %d samples with %d channels at %d Hz (%v)", frame.SampleCount, frame.Channels, frame.SamplingRate, err)
+			}
+			for i, s := range frame.PCM {
+				if s != tt.want {
+					t.Fatalf("sample %d = %d, want %d", i, s, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAudioMixerQueue(t *testing.T) {
+	// the queue holds two frames
+	mixer, err := NewAudioMixer(48000, 1, 10*time.Millisecond, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for value := int16(1); value <= 5; value++ {
+		mixer.Write(7, constantFrame(48000, 1, value))
+	}
+	mixer.Write(7, nil)
+	mixer.Write(7, &AudioFrame{PCM: make([]int16, 10), Channels: 3, SamplingRate: 48000})
+
+	if peers := mixer.Peers(); len(peers) != 1 || peers[0] != 7 {
+		t.Fatalf("Peers() = %v, want [7]", peers)
+	}
+
+	// all but the newest two frames were dropped, and the last sample of the
+	// fifth frame is held back
+	tests := []struct {
+		first, rest int16
+	}{
+		{3, 4},
+		{4, 5},
+		{0, 0},
+	}
+	for i, tt := range tests {
+		pcm := mixer.Mix().PCM
+		if pcm[0] != tt.first {
+			t.Errorf("mix %d: first sample = %d, want %d", i, pcm[0], tt.first)
+		}
+		for j, s := range pcm[1:] {
+			if s != tt.rest {
+				t.Fatalf("mix %d: sample %d = %d, want %d", i, j+1, s, tt.rest)
+			}
+		}
+	}
+
+	mixer.Write(7, constantFrame(48000, 1, 100))
+	mixer.Write(9, constantFrame(48000, 1, 100))
+	mixer.RemovePeer(7)
+	if peers := mixer.Peers(); len(peers) != 1 || peers[0] != 9 {
+		t.Fatalf("Peers() after RemovePeer = %v, want [9]", peers)
+	}
+	if s := mixer.Mix().PCM[0]; s != 100 {
+		t.Errorf("mix without the removed peer starts with %d, want 100", s)
+	}
+}
@@ -0,0 +1,249 @@
+package libtoxav
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// The names of the loops of a Runner.
+const (
+	LoopCore  = "core"
+	LoopAV    = "av"
+	LoopAudio = "audio"
+	LoopVideo = "video"
+)
+
+// RunnerOptions configures a Runner. The zero value runs the core Tox loop
+// and separate audio and video loops, each locked to its own OS thread.
+type RunnerOptions struct {
+	// NoCoreLoop leaves iterating the Tox instance to the caller, e.g. when
+	// it belongs to a libtox.Pool.
+	NoCoreLoop bool
+
+	// SingleAVLoop runs one loop calling Iterate instead of separate loops
+	// calling AudioIterate and VideoIterate.
+	SingleAVLoop bool
+
+	// NoLockOSThread does not lock the loop goroutines to their OS threads.
+	// toxav expects each interval function to be called from the same thread
+	// as its iterate function, so only set it if you know better.
+	NoLockOSThread bool
+}
+
+// LoopMetrics describes the timing of one loop of a Runner. Jitter is how
+// much later than requested by the interval function an iteration started,
+// lag how long the iteration itself took.
+type LoopMetrics struct {
+	Name        string
+	Iterations  uint64
+	Interval    time.Duration // last interval requested by toxcore
+	Jitter      time.Duration // mean
+	MaxJitter   time.Duration
+	Lag         time.Duration // mean
+	MaxLag      time.Duration
+	Overruns    uint64 // iterations that took longer than the interval
+	LastIterate time.Time
+}
+
+// runnerLoop is one iterate loop of a Runner.
+type runnerLoop struct {
+	name     string
+	interval func() (uint32, error)
+	iterate  func() error
+
+	stop chan struct{}
+	done chan struct{}
+
+	mtx       sync.Mutex
+	metrics   LoopMetrics
+	jitterSum time.Duration
+	lagSum    time.Duration
+}
+
+// Runner iterates a ToxAV and its Tox instance in dedicated goroutines, each
+// waiting for the interval its iterate function asks for.
+type Runner struct {
+	kill    func() error // kills the ToxAV and the Tox instance, see Close
+	options RunnerOptions
+
+	mtx     sync.Mutex
+	started bool
+	stopped bool
+	core    *runnerLoop
+	av      []*runnerLoop
+}
+
+func newRunnerLoop(name string, interval func() (uint32, error), iterate func() error) *runnerLoop {
+	return &runnerLoop{
+		name:     name,
+		interval: interval,
+		iterate:  iterate,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		metrics:  LoopMetrics{Name: name},
+	}
+}
+
+// Start starts the loops: the core loop first, so the Tox instance is
+// connected when calls come in, then the A/V loops.
+func (r *Runner) Start() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.stopped {
+		return ErrRunnerStopped
+	}
+	if r.started {
+		return ErrRunnerStarted
+	}
+	r.started = true
+
+	for _, l := range r.loops() {
+		go l.run(!r.options.NoLockOSThread)
+	}
+
+	return nil
+}
+
+// Stop stops the A/V loops and then the core loop, waiting for each to finish
+// its current iteration. A stopped Runner cannot be started again.
+func (r *Runner) Stop() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	if !r.started {
+		return
+	}
+
+	for _, l := range r.av {
+		close(l.stop)
+	}
+	for _, l := range r.av {
+		<-l.done
+	}
+
+	if r.core != nil {
+		close(r.core.stop)
+		<-r.core.done
+	}
+}
+
+// Close stops the Runner, then kills the ToxAV and, unless NoCoreLoop is
+// set, the Tox instance.
+func (r *Runner) Close() error {
+	r.Stop()
+
+	if r.kill == nil {
+		return nil
+	}
+	return r.kill()
+}
+
+// Metrics returns the timing of all loops, the core loop first.
+func (r *Runner) Metrics() []LoopMetrics {
+	var metrics []LoopMetrics
+	for _, l := range r.loops() {
+		metrics = append(metrics, l.snapshot())
+	}
+
+	return metrics
+}
+
+func (r *Runner) loops() []*runnerLoop {
+	var loops []*runnerLoop
+	if r.core != nil {
+		loops = append(loops, r.core)
+	}
+
+	return append(loops, r.av...)
+}
+
+// run iterates until stop is closed.
+func (l *runnerLoop) run(lockOSThread bool) {
+	defer close(l.done)
+
+	if lockOSThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var due time.Time
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		if err := l.iterate(); err != nil {
+			// the instance was killed
+			return
+		}
+		end := time.Now()
+
+		ms, err := l.interval()
+		if err != nil {
+			return
+		}
+		interval := time.Duration(ms) * time.Millisecond
+		l.record(start, end, due, interval)
+
+		due = start.Add(interval)
+		timer.Reset(due.Sub(end))
+	}
+}
+
+// record adds an iteration that ran from start to end to the metrics.
+func (l *runnerLoop) record(start, end, due time.Time, interval time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	m := &l.metrics
+	m.Iterations++
+	m.Interval = interval
+	m.LastIterate = start
+
+	if !due.IsZero() {
+		jitter := start.Sub(due)
+		if jitter < 0 {
+			jitter = 0
+		}
+		l.jitterSum += jitter
+		if jitter > m.MaxJitter {
+			m.MaxJitter = jitter
+		}
+	}
+
+	lag := end.Sub(start)
+	l.lagSum += lag
+	if lag > m.MaxLag {
+		m.MaxLag = lag
+	}
+	if lag > interval {
+		m.Overruns++
+	}
+}
+
+func (l *runnerLoop) snapshot() LoopMetrics {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	m := l.metrics
+	if m.Iterations > 1 {
+		m.Jitter = l.jitterSum / time.Duration(m.Iterations-1)
+	}
+	if m.Iterations > 0 {
+		m.Lag = l.lagSum / time.Duration(m.Iterations)
+	}
+
+	return m
+}
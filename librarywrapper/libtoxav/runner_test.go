@@ -0,0 +1,199 @@
+package libtoxav
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLoop returns a loop asking for 1 ms intervals that calls iterate
+func fakeLoop(name string, iterate func() error) *runnerLoop {
+	return newRunnerLoop(name, func() (uint32, error) { return 1, nil }, iterate)
+}
+
+func TestRunnerStopOrder(t *testing.T) {
+	var coreIterations atomic.Int32
+	core := fakeLoop(LoopCore, func() error {
+		coreIterations.Add(1)
+		return nil
+	})
+
+	r := &Runner{core: core, options: RunnerOptions{NoLockOSThread: true}}
+	var avIterations atomic.Int32
+	for _, name := range []string{LoopAudio, LoopVideo} {
+		r.av = append(r.av, fakeLoop(name, func() error {
+			avIterations.Add(1)
+			// an iteration in progress when Stop is called
+			time.Sleep(5 * time.Millisecond)
+			select {
+			case <-core.done:
+				t.Error("the core loop stopped before an A/V loop")
+			default:
+			}
+			return nil
+		}))
+	}
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for coreIterations.Load() < 3 || avIterations.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	r.Stop()
+
+	for _, l := range r.loops() {
+		select {
+		case <-l.done:
+		default:
+			t.Errorf("the %s loop is still running", l.name)
+		}
+	}
+}
+
+func TestRunnerStartStop(t *testing.T) {
+	killed := errors.New("killed")
+	var kills int
+	r := &Runner{
+		core:    fakeLoop(LoopCore, func() error { return nil }),
+		options: RunnerOptions{NoLockOSThread: true},
+		kill: func() error {
+			kills++
+			return killed
+		},
+	}
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(); err != ErrRunnerStarted {
+		t.Errorf("second Start() = %v, want %v", err, ErrRunnerStarted)
+	}
+	r.Stop()
+	r.Stop()
+	if err := r.Start(); err != ErrRunnerStopped {
+		t.Errorf("Start() after Stop() = %v, want %v", err, ErrRunnerStopped)
+	}
+	if err := r.Close(); err != killed || kills != 1 {
+		t.Errorf("Close() = %v after %d kills, want %v after 1", err, kills, killed)
+	}
+
+	// a Runner that never started stops without waiting for its loops
+	idle := &Runner{core: fakeLoop(LoopCore, func() error { return nil })}
+	if err := idle.Close(); err != nil {
+		t.Errorf("Close() of an idle Runner = %v", err)
+	}
+	if err := idle.Start(); err != ErrRunnerStopped {
+		t.Errorf("Start() after Close() = %v, want %v", err, ErrRunnerStopped)
+	}
+}
+
+func TestRunnerLoopEnds(t *testing.T) {
+	// the loop ends by itself once the instance is killed
+	r := &Runner{
+		av:      []*runnerLoop{fakeLoop(LoopAV, func() error { return ErrToxAVInit })},
+		options: RunnerOptions{NoLockOSThread: true},
+	}
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-r.av[0].done
+	r.Stop()
+
+	if m := r.Metrics(); len(m) != 1 || m[0].Iterations != 0 {
+		t.Errorf("Metrics() = %+v, want no iterations of the av loop", m)
+	}
+}
+
+func TestRunnerMetricsOrder(t *testing.T) {
+	iterate := func() error { return nil }
+	tests := []struct {
+		name string
+		r    *Runner
+		want []string
+	}{
+		{"separate loops", &Runner{core: fakeLoop(LoopCore, iterate), av: []*runnerLoop{fakeLoop(LoopAudio, iterate), fakeLoop(LoopVideo, iterate)}}, []string{LoopCore, LoopAudio, LoopVideo}},
+		{"single loop", &Runner{core: fakeLoop(LoopCore, iterate), av: []*runnerLoop{fakeLoop(LoopAV, iterate)}}, []string{LoopCore, LoopAV}},
+		{"no core loop", &Runner{av: []*runnerLoop{fakeLoop(LoopAV, iterate)}}, []string{LoopAV}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := tt.r.Metrics()
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("Metrics() has %d loops, want %d", len(metrics), len(tt.want))
+			}
+			for i, m := range metrics {
+				if m.Name != tt.want[i] {
+					t.Errorf("loop %d is %s, want %s", i, m.Name, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoopMetrics(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+
+	type iteration struct {
+		start, end time.Time
+		interval   time.Duration
+	}
+
+	tests := []struct {
+		name       string
+		iterations []iteration
+		want       LoopMetrics
+	}{
+		{
+			name: "no iterations",
+			want: LoopMetrics{Name: LoopCore},
+		},
+		{
+			name:       "one iteration",
+			iterations: []iteration{{ms(0), ms(5), 20 * time.Millisecond}},
+			want:       LoopMetrics{Name: LoopCore, Iterations: 1, Interval: 20 * time.Millisecond, Lag: 5 * time.Millisecond, MaxLag: 5 * time.Millisecond, LastIterate: ms(0)},
+		},
+		{
+			// the first iteration has no due time, so the jitter is the
+			// mean over the others; starting early is no jitter
+			name: "late, overrun and early",
+			iterations: []iteration{
+				{ms(0), ms(5), 20 * time.Millisecond},
+				{ms(22), ms(47), 20 * time.Millisecond},
+				{ms(40), ms(41), 10 * time.Millisecond},
+			},
+			want: LoopMetrics{
+				Name:        LoopCore,
+				Iterations:  3,
+				Interval:    10 * time.Millisecond,
+				Jitter:      time.Millisecond,
+				MaxJitter:   2 * time.Millisecond,
+				Lag:         31 * time.Millisecond / 3,
+				MaxLag:      25 * time.Millisecond,
+				Overruns:    1,
+				LastIterate: ms(40),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := fakeLoop(LoopCore, nil)
+
+			// due as computed by run
+			var due time.Time
+			for _, it := range tt.iterations {
+				l.record(it.start, it.end, due, it.interval)
+				due = it.start.Add(it.interval)
+			}
+
+			if got := l.snapshot(); got != tt.want {
+				t.Errorf("snapshot() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
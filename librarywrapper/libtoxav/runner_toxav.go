@@ -0,0 +1,34 @@
+//go:build !toxsim
+
+package libtoxav
+
+// NewRunner creates a Runner for tav. options may be nil.
+func NewRunner(tav *ToxAV, options *RunnerOptions) *Runner {
+	r := &Runner{}
+	if options != nil {
+		r.options = *options
+	}
+	r.kill = func() error {
+		if err := tav.Kill(); err != nil {
+			return err
+		}
+		if !r.options.NoCoreLoop {
+			return tav.GetTox().Kill()
+		}
+		return nil
+	}
+
+	if !r.options.NoCoreLoop {
+		tox := tav.GetTox()
+		r.core = newRunnerLoop(LoopCore, tox.IterationInterval, tox.Iterate)
+	}
+	if r.options.SingleAVLoop {
+		r.av = append(r.av, newRunnerLoop(LoopAV, tav.IterationInterval, tav.Iterate))
+	} else {
+		r.av = append(r.av,
+			newRunnerLoop(LoopAudio, tav.AudioIterationInterval, tav.AudioIterate),
+			newRunnerLoop(LoopVideo, tav.VideoIterationInterval, tav.VideoIterate))
+	}
+
+	return r
+}
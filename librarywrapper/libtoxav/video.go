@@ -0,0 +1,137 @@
+package libtoxav
+
+import (
+	"image"
+	"image/color"
+	"time"
+)
+
+// NewVideoFrame returns a black frame in the layout toxav uses: 4:2:0 YCbCr.
+func NewVideoFrame(width, height int) *image.YCbCr {
+	frame := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for i := range frame.Cb {
+		frame.Cb[i] = 128
+		frame.Cr[i] = 128
+	}
+
+	return frame
+}
+
+// validVideoFrame checks that frame can be sent with toxav.
+func validVideoFrame(frame *image.YCbCr) error {
+	if frame.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		return ErrVideoFrameSubsampleRatio
+	}
+	w, h := frame.Rect.Dx(), frame.Rect.Dy()
+	if w < 1 || h < 1 || w > 65535 || h > 65535 {
+		return ErrVideoFrameSize
+	}
+
+	return nil
+}
+
+// packedPlanes returns the Y, U and V planes of frame without padding, the
+// layout toxav_video_send_frame expects. Planes of a frame created with
+// NewVideoFrame or image.NewYCbCr with even sizes are returned without
+// copying.
+func packedPlanes(frame *image.YCbCr) (y, u, v []byte) {
+	w, h := frame.Rect.Dx(), frame.Rect.Dy()
+	cw, ch := w/2, h/2
+
+	if frame.Rect.Min == (image.Point{}) && frame.YStride == w && frame.CStride == cw &&
+		len(frame.Y) >= w*h && len(frame.Cb) >= cw*ch && len(frame.Cr) >= cw*ch {
+		return frame.Y[:w*h], frame.Cb[:cw*ch], frame.Cr[:cw*ch]
+	}
+
+	y = make([]byte, w*h)
+	for row := 0; row < h; row++ {
+		offset := frame.YOffset(frame.Rect.Min.X, frame.Rect.Min.Y+row)
+		copy(y[row*w:(row+1)*w], frame.Y[offset:offset+w])
+	}
+
+	u = make([]byte, cw*ch)
+	v = make([]byte, cw*ch)
+	for row := 0; row < ch; row++ {
+		offset := frame.COffset(frame.Rect.Min.X, frame.Rect.Min.Y+2*row)
+		copy(u[row*cw:(row+1)*cw], frame.Cb[offset:offset+cw])
+		copy(v[row*cw:(row+1)*cw], frame.Cr[offset:offset+cw])
+	}
+
+	return y, u, v
+}
+
+// ToYCbCr converts img to a 4:2:0 YCbCr frame. Frames that already have this
+// layout are returned unchanged. The chroma of each 2x2 block is the average
+// of its pixels.
+func ToYCbCr(img image.Image) *image.YCbCr {
+	if frame, ok := img.(*image.YCbCr); ok && frame.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		return frame
+	}
+
+	b := img.Bounds()
+	frame := image.NewYCbCr(image.Rect(0, 0, b.Dx(), b.Dy()), image.YCbCrSubsampleRatio420)
+	for y := 0; y < b.Dy(); y += 2 {
+		for x := 0; x < b.Dx(); x += 2 {
+			var cb, cr, n int
+			for dy := 0; dy < 2 && y+dy < b.Dy(); dy++ {
+				for dx := 0; dx < 2 && x+dx < b.Dx(); dx++ {
+					r, g, bl, _ := img.At(b.Min.X+x+dx, b.Min.Y+y+dy).RGBA()
+					yy, u, v := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+					frame.Y[frame.YOffset(x+dx, y+dy)] = yy
+					cb += int(u)
+					cr += int(v)
+					n++
+				}
+			}
+			offset := frame.COffset(x, y)
+			frame.Cb[offset] = uint8(cb / n)
+			frame.Cr[offset] = uint8(cr / n)
+		}
+	}
+
+	return frame
+}
+
+// FramePacer spaces out the frames of a video sender to a fixed frame rate.
+type FramePacer struct {
+	interval time.Duration
+	next     time.Time
+
+	// Now and Sleep default to time.Now and time.Sleep.
+	Now   func() time.Time
+	Sleep func(d time.Duration)
+}
+
+// NewFramePacer creates a FramePacer for fps frames per second.
+func NewFramePacer(fps float64) *FramePacer {
+	return &FramePacer{
+		interval: time.Duration(float64(time.Second) / fps),
+		Now:      time.Now,
+		Sleep:    time.Sleep,
+	}
+}
+
+// Interval returns the time between two frames.
+func (p *FramePacer) Interval() time.Duration {
+	return p.interval
+}
+
+// Wait blocks until the next frame is due. If the sender fell behind by more
+// than a frame, the schedule restarts from now and Wait returns the number of
+// frames that were skipped.
+func (p *FramePacer) Wait() (skipped int) {
+	now := p.Now()
+	if p.next.IsZero() {
+		p.next = now
+	}
+
+	if late := now.Sub(p.next); late >= p.interval {
+		skipped = int(late / p.interval)
+		p.next = now
+	} else if late < 0 {
+		p.Sleep(-late)
+	}
+	p.next = p.next.Add(p.interval)
+
+	return skipped
+}
@@ -0,0 +1,171 @@
+package libtoxav
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// patternFrame returns a w x h frame whose bytes are all different
+func patternFrame(w, h int) *image.YCbCr {
+	frame := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	for i := range frame.Y {
+		frame.Y[i] = byte(i)
+	}
+	for i := range frame.Cb {
+		frame.Cb[i] = byte(100 + i)
+		frame.Cr[i] = byte(200 + i)
+	}
+
+	return frame
+}
+
+func TestPackedPlanes(t *testing.T) {
+	padded := patternFrame(6, 4)
+	padded.Rect = image.Rect(0, 0, 4, 4)
+
+	tests := []struct {
+		name     string
+		frame    *image.YCbCr
+		wantCopy bool
+	}{
+		{"packed", patternFrame(4, 2), false},
+		{"NewVideoFrame", NewVideoFrame(640, 480), false},
+		{"padded rows", padded, true},
+		{"sub image", patternFrame(8, 6).SubImage(image.Rect(2, 2, 6, 6)).(*image.YCbCr), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			y, u, v := packedPlanes(tt.frame)
+
+			w, h := tt.frame.Rect.Dx(), tt.frame.Rect.Dy()
+			if len(y) != w*h || len(u) != w*h/4 || len(v) != w*h/4 {
+				t.Fatalf("planes have %d, %d and %d bytes, want %d, %d and %d", len(y), len(u), len(v), w*h, w*h/4, w*h/4)
+			}
+			if copied := &y[0] != &tt.frame.Y[0]; copied != tt.wantCopy {
+				t.Errorf("copied = %v, want %v", copied, tt.wantCopy)
+			}
+
+			min := tt.frame.Rect.Min
+			for row := 0; row < h; row++ {
+				for col := 0; col < w; col++ {
+					if want := tt.frame.YCbCrAt(min.X+col, min.Y+row).Y; y[row*w+col] != want {
+						t.Fatalf("y(%d, %d) = %d, want %d", col, row, y[row*w+col], want)
+					}
+				}
+			}
+			for row := 0; row < h/2; row++ {
+				for col := 0; col < w/2; col++ {
+					want := tt.frame.YCbCrAt(min.X+2*col, min.Y+2*row)
+					if u[row*w/2+col] != want.Cb || v[row*w/2+col] != want.Cr {
+						t.Fatalf("uv(%d, %d) = %d, %d, want %d, %d", col, row, u[row*w/2+col], v[row*w/2+col], want.Cb, want.Cr)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidVideoFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *image.YCbCr
+		want  error
+	}{
+		{"4:2:0", NewVideoFrame(320, 240), nil},
+		{"odd size", NewVideoFrame(3, 3), nil},
+		{"4:4:4", image.NewYCbCr(image.Rect(0, 0, 320, 240), image.YCbCrSubsampleRatio444), ErrVideoFrameSubsampleRatio},
+		{"empty", NewVideoFrame(0, 0), ErrVideoFrameSize},
+		{"too wide", &image.YCbCr{Rect: image.Rect(0, 0, 65536, 2), SubsampleRatio: image.YCbCrSubsampleRatio420}, ErrVideoFrameSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validVideoFrame(tt.frame); err != tt.want {
+				t.Errorf("validVideoFrame() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestToYCbCr(t *testing.T) {
+	frame := NewVideoFrame(4, 4)
+	if ToYCbCr(frame) != frame {
+		t.Error("ToYCbCr() converted a 4:2:0 frame")
+	}
+
+	tests := []struct {
+		name string
+		rect image.Rectangle
+		c    color.RGBA
+	}{
+		{"red", image.Rect(0, 0, 4, 2), color.RGBA{255, 0, 0, 255}},
+		{"odd size", image.Rect(0, 0, 3, 5), color.RGBA{10, 200, 30, 255}},
+		{"offset bounds", image.Rect(5, 7, 9, 9), color.RGBA{0, 0, 255, 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(tt.rect)
+			for y := tt.rect.Min.Y; y < tt.rect.Max.Y; y++ {
+				for x := tt.rect.Min.X; x < tt.rect.Max.X; x++ {
+					img.Set(x, y, tt.c)
+				}
+			}
+
+			got := ToYCbCr(img)
+			if got.SubsampleRatio != image.YCbCrSubsampleRatio420 || got.Rect != image.Rect(0, 0, tt.rect.Dx(), tt.rect.Dy()) {
+				t.Fatalf("ToYCbCr() is %v with bounds %v", got.SubsampleRatio, got.Rect)
+			}
+			wy, wcb, wcr := color.RGBToYCbCr(tt.c.R, tt.c.G, tt.c.B)
+			for y := 0; y < tt.rect.Dy(); y++ {
+				for x := 0; x < tt.rect.Dx(); x++ {
+					if c := got.YCbCrAt(x, y); c != (color.YCbCr{Y: wy, Cb: wcb, Cr: wcr}) {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, color.YCbCr{Y: wy, Cb: wcb, Cr: wcr})
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFramePacer(t *testing.T) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+
+	pacer := NewFramePacer(10)
+	pacer.Now = func() time.Time { return now }
+	pacer.Sleep = func(d time.Duration) {
+		slept = d
+		now = now.Add(d)
+	}
+
+	if pacer.Interval() != 100*time.Millisecond {
+		t.Fatalf("Interval() = %v, want 100ms", pacer.Interval())
+	}
+
+	steps := []struct {
+		name        string
+		work        time.Duration // time spent on the frame before Wait
+		wantSleep   time.Duration
+		wantSkipped int
+	}{
+		{"first frame", 0, 0, 0},
+		{"on time", 30 * time.Millisecond, 70 * time.Millisecond, 0},
+		{"exactly due", 100 * time.Millisecond, 0, 0},
+		{"late by less than a frame", 150 * time.Millisecond, 0, 0},
+		{"catching up", 20 * time.Millisecond, 30 * time.Millisecond, 0},
+		{"late by 2.5 frames", 350 * time.Millisecond, 0, 2},
+		{"rescheduled from now", 10 * time.Millisecond, 90 * time.Millisecond, 0},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.work)
+		slept = 0
+		if skipped := pacer.Wait(); skipped != step.wantSkipped || slept != step.wantSleep {
+			t.Errorf("%s: Wait() slept %v and skipped %d, want %v and %d", step.name, slept, skipped, step.wantSleep, step.wantSkipped)
+		}
+	}
+}
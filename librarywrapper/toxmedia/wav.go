@@ -0,0 +1,239 @@
+// Package toxmedia reads and writes the media files used with libtoxav: 16 bit
+// PCM WAV files for audio and YUV4MPEG2 (Y4M) files for raw 4:2:0 video.
+package toxmedia
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+var (
+	ErrWAVFormat   = errors.New("Not a RIFF WAVE file")
+	ErrWAVEncoding = errors.New("Only 16 bit PCM WAV files with 1 or 2 channels are supported")
+)
+
+// wavHeaderSize is the size of the header written by WAVWriter.
+const wavHeaderSize = 44
+
+// WAVReader reads the PCM data of a WAV file.
+type WAVReader struct {
+	SampleRate uint32
+	Channels   int
+	// DataSize is the size of the PCM data according to the header.
+	DataSize uint32
+
+	data io.Reader
+}
+
+// NewWAVReader parses the header of a WAV file and returns a reader positioned
+// at the start of its PCM data.
+func NewWAVReader(r io.Reader) (*WAVReader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, ErrWAVFormat
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrWAVFormat
+	}
+
+	w := &WAVReader{}
+	haveFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, ErrWAVFormat
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, ErrWAVFormat
+			}
+			format := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, ErrWAVFormat
+			}
+			audioFormat := binary.LittleEndian.Uint16(format[0:2])
+			w.Channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			w.SampleRate = binary.LittleEndian.Uint32(format[4:8])
+			bitsPerSample := binary.LittleEndian.Uint16(format[14:16])
+			// 0xfffe is WAVE_FORMAT_EXTENSIBLE, used by some tools for plain PCM
+			if (audioFormat != 1 && audioFormat != 0xfffe) || bitsPerSample != 16 || w.Channels < 1 || w.Channels > 2 {
+				return nil, ErrWAVEncoding
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, ErrWAVFormat
+			}
+			w.DataSize = size
+			w.data = io.LimitReader(r, int64(size))
+			return w, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return nil, ErrWAVFormat
+			}
+		}
+	}
+}
+
+// Read reads PCM data: little endian int16 samples, interleaved for stereo.
+func (w *WAVReader) Read(p []byte) (int, error) {
+	return w.data.Read(p)
+}
+
+// Framer returns an AudioFramer cutting the PCM data into frames of the given
+// format.
+func (w *WAVReader) Framer(rate uint32, channels int) (*libtoxav.AudioFramer, error) {
+	return libtoxav.NewAudioFramer(w, w.SampleRate, w.Channels, rate, channels, libtoxav.DefaultFrameDuration)
+}
+
+// WAVWriter writes 16 bit PCM audio as a WAV file. The sizes in the header are
+// written by Close, so the underlying writer must be able to seek.
+type WAVWriter struct {
+	w          io.WriteSeeker
+	sampleRate uint32
+	channels   int
+	dataSize   uint32
+	err        error
+
+	// converter converts frames of another format, it is kept between frames
+	// so the interpolation continues across them
+	converter *libtoxav.AudioConverter
+}
+
+// NewWAVWriter writes the header of a WAV file with the given format to w.
+func NewWAVWriter(w io.WriteSeeker, sampleRate uint32, channels int) (*WAVWriter, error) {
+	if channels < 1 || channels > 2 {
+		return nil, ErrWAVEncoding
+	}
+
+	ww := &WAVWriter{w: w, sampleRate: sampleRate, channels: channels}
+	if _, err := w.Write(wavHeader(sampleRate, channels, 0)); err != nil {
+		return nil, err
+	}
+
+	return ww, nil
+}
+
+// Write writes raw PCM data.
+func (w *WAVWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.w.Write(p)
+	w.dataSize += uint32(n)
+	w.err = err
+
+	return n, err
+}
+
+// WriteFrame writes the PCM of frame. Frames of another format are converted
+// to the format of the file.
+func (w *WAVWriter) WriteFrame(frame *libtoxav.AudioFrame) error {
+	if frame.Channels == w.channels && frame.SamplingRate == w.sampleRate {
+		if err := w.flushConverter(); err != nil {
+			return err
+		}
+		_, err := w.Write(frame.Bytes())
+		return err
+	}
+
+	if w.converter == nil || w.converter.InRate != frame.SamplingRate || w.converter.InChannels != frame.Channels {
+		if err := w.flushConverter(); err != nil {
+			return err
+		}
+		converter, err := libtoxav.NewAudioConverter(frame.SamplingRate, frame.Channels, w.sampleRate, w.channels)
+		if err != nil {
+			return err
+		}
+		w.converter = converter
+	}
+
+	_, err := w.Write(pcmBytes(w.converter.Convert(frame.PCM)))
+	return err
+}
+
+// flushConverter writes the sample held back by the converter of the frames
+// of the previous format and drops the converter.
+func (w *WAVWriter) flushConverter() error {
+	if w.converter == nil {
+		return nil
+	}
+
+	pcm := w.converter.Flush()
+	w.converter = nil
+	_, err := w.Write(pcmBytes(pcm))
+
+	return err
+}
+
+// pcmBytes returns interleaved samples as little endian 16 bit PCM.
+func pcmBytes(pcm []int16) []byte {
+	b := make([]byte, 2*len(pcm))
+	for i, s := range pcm {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+	}
+
+	return b
+}
+
+// Duration returns the length of the audio written so far.
+func (w *WAVWriter) Duration() time.Duration {
+	samples := time.Duration(w.dataSize / uint32(2*w.channels))
+	return samples * time.Second / time.Duration(w.sampleRate)
+}
+
+// Close writes the final sizes to the header. It does not close the
+// underlying writer.
+func (w *WAVWriter) Close() error {
+	if err := w.flushConverter(); err != nil {
+		return err
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(wavHeader(w.sampleRate, w.channels, w.dataSize)); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+
+	return err
+}
+
+// EncodeWAV returns a complete WAV file holding pcm.
+func EncodeWAV(pcm []byte, sampleRate uint32, channels int) []byte {
+	return append(wavHeader(sampleRate, channels, uint32(len(pcm))), pcm...)
+}
+
+// wavHeader returns the header of a 16 bit PCM WAV file with dataSize bytes of
+// PCM data.
+func wavHeader(sampleRate uint32, channels int, dataSize uint32) []byte {
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], 36+dataSize)
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], 1)
+	binary.LittleEndian.PutUint16(h[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:28], sampleRate)
+	binary.LittleEndian.PutUint32(h[28:32], sampleRate*uint32(2*channels))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(2*channels))
+	binary.LittleEndian.PutUint16(h[34:36], 16)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], dataSize)
+
+	return h
+}
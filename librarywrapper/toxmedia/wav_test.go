@@ -0,0 +1,101 @@
+//go:build toxsim
+
+package toxmedia
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+// sineFrames returns count frames of 20 ms of a 440 Hz sine
+func sineFrames(t *testing.T, rate uint32, channels int, count int) []*libtoxav.AudioFrame {
+	var frames []*libtoxav.AudioFrame
+	sample := 0
+	for i := 0; i < count; i++ {
+		frame, err := libtoxav.NewAudioFrame(rate, channels, libtoxav.DefaultFrameDuration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for s := 0; s < frame.SampleCount; s++ {
+			v := int16(10000 * math.Sin(2*math.Pi*440*float64(sample)/float64(rate)))
+			for c := 0; c < channels; c++ {
+				frame.PCM[s*channels+c] = v
+			}
+			sample++
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+func TestWAVWriterConvertsFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		inRate     uint32
+		inChannels int
+		rate       uint32
+		channels   int
+	}{
+		{"same format", 48000, 1, 48000, 1},
+		{"upsample", 24000, 1, 48000, 1},
+		{"downsample", 48000, 2, 16000, 1},
+		{"mono to stereo", 12000, 1, 48000, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := sineFrames(t, tt.inRate, tt.inChannels, 10)
+
+			f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			w, err := NewWAVWriter(f, tt.rate, tt.channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, frame := range frames {
+				if err := w.WriteFrame(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// converting the whole stream at once gives the same PCM
+			var stream []int16
+			for _, frame := range frames {
+				stream = append(stream, frame.PCM...)
+			}
+			converter, err := libtoxav.NewAudioConverter(tt.inRate, tt.inChannels, tt.rate, tt.channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := pcmBytes(append(converter.Convert(stream), converter.Flush()...))
+
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewWAVReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("wrote %d bytes of PCM that differ from the %d bytes of the converted stream", len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package toxmedia
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtoxav"
+)
+
+var (
+	ErrY4MFormat     = errors.New("Not a YUV4MPEG2 file")
+	ErrY4MColorSpace = errors.New("Only 4:2:0 YUV4MPEG2 files are supported")
+	ErrY4MFrameSize  = errors.New("Frame size differs from the Y4M header")
+)
+
+const (
+	y4mMagic = "YUV4MPEG2"
+	y4mFrame = "FRAME"
+)
+
+// Y4MReader reads 4:2:0 frames from a YUV4MPEG2 file.
+type Y4MReader struct {
+	Width  int
+	Height int
+	// FrameRate is the frame rate of the header, 25 if it has none.
+	FrameRate float64
+
+	r *bufio.Reader
+}
+
+// NewY4MReader parses the stream header of a Y4M file.
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return nil, ErrY4MFormat
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return nil, ErrY4MFormat
+	}
+
+	y := &Y4MReader{FrameRate: 25, r: br}
+	for _, field := range fields[1:] {
+		value := field[1:]
+		switch field[0] {
+		case 'W':
+			y.Width, err = strconv.Atoi(value)
+		case 'H':
+			y.Height, err = strconv.Atoi(value)
+		case 'F':
+			var num, den int
+			if _, err = fmt.Sscanf(value, "%d:%d", &num, &den); err == nil && num > 0 && den > 0 {
+				y.FrameRate = float64(num) / float64(den)
+			}
+		case 'C':
+			if !strings.HasPrefix(value, "420") {
+				return nil, ErrY4MColorSpace
+			}
+		}
+		if err != nil {
+			return nil, ErrY4MFormat
+		}
+	}
+	if y.Width <= 0 || y.Height <= 0 {
+		return nil, ErrY4MFormat
+	}
+
+	return y, nil
+}
+
+// ReadFrame returns the next frame, or io.EOF at the end of the file.
+func (y *Y4MReader) ReadFrame() (*image.YCbCr, error) {
+	header, err := y.r.ReadString('\n')
+	if err == io.EOF && header == "" {
+		return nil, io.EOF
+	}
+	if err != nil || !strings.HasPrefix(header, y4mFrame) {
+		return nil, ErrY4MFormat
+	}
+
+	frame := image.NewYCbCr(image.Rect(0, 0, y.Width, y.Height), image.YCbCrSubsampleRatio420)
+	for _, plane := range [][]byte{frame.Y, frame.Cb, frame.Cr} {
+		if _, err := io.ReadFull(y.r, plane); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+
+	return frame, nil
+}
+
+// Y4MWriter writes 4:2:0 frames as a YUV4MPEG2 file. The header is written
+// with the first frame; all frames must have its size.
+type Y4MWriter struct {
+	w         *bufio.Writer
+	frameRate int
+	width     int
+	height    int
+	frames    int
+}
+
+// NewY4MWriter creates a Y4MWriter with the given frame rate in the header.
+func NewY4MWriter(w io.Writer, frameRate int) *Y4MWriter {
+	if frameRate <= 0 {
+		frameRate = 25
+	}
+
+	return &Y4MWriter{w: bufio.NewWriter(w), frameRate: frameRate}
+}
+
+// WriteFrame writes frame. Frames that are not 4:2:0 are converted.
+func (y *Y4MWriter) WriteFrame(img image.Image) error {
+	frame := libtoxav.ToYCbCr(img)
+	w, h := frame.Rect.Dx(), frame.Rect.Dy()
+
+	if y.frames == 0 {
+		y.width, y.height = w, h
+		if _, err := fmt.Fprintf(y.w, "%s W%d H%d F%d:1 Ip A1:1 C420jpeg\n", y4mMagic, w, h, y.frameRate); err != nil {
+			return err
+		}
+	} else if w != y.width || h != y.height {
+		return ErrY4MFrameSize
+	}
+
+	if _, err := y.w.WriteString(y4mFrame + "\n"); err != nil {
+		return err
+	}
+	for row := 0; row < h; row++ {
+		offset := frame.YOffset(frame.Rect.Min.X, frame.Rect.Min.Y+row)
+		if _, err := y.w.Write(frame.Y[offset : offset+w]); err != nil {
+			return err
+		}
+	}
+	cw, ch := (w+1)/2, (h+1)/2
+	for _, plane := range [][]byte{frame.Cb, frame.Cr} {
+		for row := 0; row < ch; row++ {
+			offset := frame.COffset(frame.Rect.Min.X, frame.Rect.Min.Y+2*row)
+			if _, err := y.w.Write(plane[offset : offset+cw]); err != nil {
+				return err
+			}
+		}
+	}
+	y.frames++
+
+	return nil
+}
+
+// Frames returns the number of frames written.
+func (y *Y4MWriter) Frames() int {
+	return y.frames
+}
+
+// Flush writes buffered data to the underlying writer.
+func (y *Y4MWriter) Flush() error {
+	return y.w.Flush()
+}
@@ -0,0 +1,121 @@
+// Package toxrecord records the callbacks of a libtox instance to an NDJSON
+// file and replays recorded sessions into the same handler functions without
+// a network.
+//
+// Recording:
+//
+//	rec := toxrecord.NewRecorder(file, &toxrecord.Options{RedactPayload: true})
+//	rec.Attach(tox, toxrecord.Handlers{FriendMessage: onFriendMessage, ...})
+//	defer rec.Close()
+//
+// Replaying at ten times the recorded speed:
+//
+//	replayer := toxrecord.NewReplayer(file, 10)
+//	err := replayer.Replay(tox, toxrecord.Handlers{FriendMessage: onFriendMessage, ...})
+package toxrecord
+
+import (
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+// The event types, named after the toxcore callbacks.
+const (
+	EventSelfConnectionStatus   = "self_connection_status"
+	EventFriendName             = "friend_name"
+	EventFriendStatusMessage    = "friend_status_message"
+	EventFriendStatus           = "friend_status"
+	EventFriendConnectionStatus = "friend_connection_status"
+	EventFriendTyping           = "friend_typing"
+	EventFriendReadReceipt      = "friend_read_receipt"
+	EventFriendRequest          = "friend_request"
+	EventFriendMessage          = "friend_message"
+	EventFileRecvControl        = "file_recv_control"
+	EventFileChunkRequest       = "file_chunk_request"
+	EventFileRecv               = "file_recv"
+	EventFileRecvChunk          = "file_recv_chunk"
+	EventFriendLossyPacket      = "friend_lossy_packet"
+	EventFriendLosslessPacket   = "friend_lossless_packet"
+	EventConferenceInvite       = "conference_invite"
+	EventConferenceConnected    = "conference_connected"
+	EventConferenceMessage      = "conference_message"
+	EventConferenceTitle        = "conference_title"
+	EventConferencePeerName     = "conference_peer_name"
+	EventConferencePeerList     = "conference_peer_list_changed"
+)
+
+// Event is one recorded callback. It is written as one JSON object per line.
+// Only the fields used by the callback of the given Type are set.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	Friend     uint32 `json:"friend,omitempty"`
+	File       uint32 `json:"file,omitempty"`
+	Conference uint32 `json:"conference,omitempty"`
+	Peer       uint32 `json:"peer,omitempty"`
+
+	Connection     libtox.ToxConnection     `json:"connection,omitempty"`
+	Status         libtox.ToxUserStatus     `json:"status,omitempty"`
+	Typing         bool                     `json:"typing,omitempty"`
+	MessageID      uint32                   `json:"message_id,omitempty"`
+	MessageType    libtox.ToxMessageType    `json:"message_type,omitempty"`
+	Control        libtox.ToxFileControl    `json:"control,omitempty"`
+	Kind           libtox.ToxFileKind       `json:"kind,omitempty"`
+	ConferenceType libtox.ToxConferenceType `json:"conference_type,omitempty"`
+
+	Position uint64 `json:"position,omitempty"`
+	FileSize uint64 `json:"file_size,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+
+	// PublicKey is the hex encoded public key of a friend request, empty if
+	// it was redacted.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Data is the payload of the callback (message, name, packet, chunk,
+	// cookie) and Length the length argument of the callback. If Redacted is
+	// set, Data was left out when recording and is replayed as Length zero
+	// bytes.
+	Data     []byte `json:"data,omitempty"`
+	Length   uint64 `json:"length,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// payload returns the data to hand to a handler when replaying e.
+func (e *Event) payload() []byte {
+	if e.Redacted {
+		return make([]byte, e.Length)
+	}
+	if e.Data == nil {
+		return []byte{}
+	}
+
+	return e.Data
+}
+
+// Handlers are the callback functions of a libtox instance. Nil handlers are
+// skipped, but their callbacks are still recorded.
+type Handlers struct {
+	SelfConnectionStatus   libtox.OnSelfConnectionStatusChanges
+	FriendName             libtox.OnFriendNameChanges
+	FriendStatusMessage    libtox.OnFriendStatusMessageChanges
+	FriendStatus           libtox.OnFriendStatusChanges
+	FriendConnectionStatus libtox.OnFriendConnectionStatusChanges
+	FriendTyping           libtox.OnFriendTypingChanges
+	FriendReadReceipt      libtox.OnFriendReadReceipt
+	FriendRequest          libtox.OnFriendRequest
+	FriendMessage          libtox.OnFriendMessage
+	FileRecvControl        libtox.OnFileRecvControl
+	FileChunkRequest       libtox.OnFileChunkRequest
+	FileRecv               libtox.OnFileRecv
+	FileRecvChunk          libtox.OnFileRecvChunk
+	FriendLossyPacket      libtox.OnFriendLossyPacket
+	FriendLosslessPacket   libtox.OnFriendLosslessPacket
+	ConferenceInvite       libtox.OnConferenceInvite
+	ConferenceConnected    libtox.OnConferenceConnected
+	ConferenceMessage      libtox.OnConferenceMessage
+	ConferenceTitle        libtox.OnConferenceTitle
+	ConferencePeerName     libtox.OnConferencePeerName
+	ConferencePeerList     libtox.OnConferencePeerListChanged
+}
@@ -0,0 +1,232 @@
+//go:build toxsim
+
+package toxrecord
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+var (
+	testRequest  = "hello, this is a"
+	testMessages = []string{"first secret message", "second secret message"}
+	testFile     = bytes.Repeat([]byte("secret file content "), 150)
+)
+
+// logHandlers returns handlers that append every call to log. If accept is
+// set they also accept the friend request and the file, as the recorded
+// instance has to.
+func logHandlers(log *[]string, accept bool) Handlers {
+	add := func(format string, args ...interface{}) {
+		*log = append(*log, fmt.Sprintf(format, args...))
+	}
+
+	return Handlers{
+		SelfConnectionStatus: func(t *libtox.Tox, status libtox.ToxConnection) {
+			add("self_connection_status %d", status)
+		},
+		FriendRequest: func(t *libtox.Tox, publickey []byte, message []byte, length uint32) {
+			add("friend_request %x %q", publickey, message)
+			if accept {
+				t.FriendAddNorequest(publickey)
+			}
+		},
+		FriendConnectionStatus: func(t *libtox.Tox, friendnumber uint32, status libtox.ToxConnection) {
+			add("friend_connection_status %d %d", friendnumber, status)
+		},
+		FriendMessage: func(t *libtox.Tox, friendnumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
+			add("friend_message %d %d %q", friendnumber, messagetype, message)
+		},
+		FileRecv: func(t *libtox.Tox, friendnumber uint32, filenumber uint32, kind libtox.ToxFileKind, filesize uint64, filename string, length uint32) {
+			add("file_recv %d %d %d %q", friendnumber, filenumber, filesize, filename)
+			if accept {
+				t.FileControl(friendnumber, filenumber, libtox.TOX_FILE_CONTROL_RESUME)
+			}
+		},
+		FileRecvChunk: func(t *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, data []byte, length uint32) {
+			add("file_recv_chunk %d %d %d %d %x", friendnumber, filenumber, position, length, data)
+		},
+	}
+}
+
+// recordSession records the callbacks of b while a sends it a friend request,
+// two messages and a file on a simulated network. It returns the recording,
+// the calls of the handlers and the public key of a.
+func recordSession(t *testing.T, options Options) ([]byte, []string, []byte) {
+	t.Helper()
+
+	n := libtox.NewNetwork(1)
+	a, err := n.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Kill()
+	defer b.Kill()
+
+	var recording bytes.Buffer
+	var live []string
+	options.Clock = n.Now
+	rec := NewRecorder(&recording, &options)
+	rec.Attach(b, logHandlers(&live, true))
+
+	a.CallbackFileChunkRequest(func(tox *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+		if length == 0 || position > uint64(len(testFile)) {
+			return
+		}
+		end := min(position+length, uint64(len(testFile)))
+		tox.FileSendChunk(friendnumber, filenumber, position, testFile[position:end])
+	})
+
+	for _, tox := range []*libtox.Tox{a, b} {
+		if err := tox.Bootstrap("127.0.0.1", 33445, make([]byte, libtox.TOX_PUBLIC_KEY_SIZE)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	address, _ := b.SelfGetAddress()
+	if _, err := a.FriendAdd(address, testRequest); err != nil {
+		t.Fatal(err)
+	}
+	n.Settle(10)
+
+	n.Step(time.Second)
+	if _, err := a.FriendSendMessage(0, libtox.TOX_MESSAGE_TYPE_NORMAL, []byte(testMessages[0])); err != nil {
+		t.Fatal(err)
+	}
+	n.Settle(10)
+
+	n.Step(2 * time.Second)
+	if _, err := a.FriendSendMessage(0, libtox.TOX_MESSAGE_TYPE_ACTION, []byte(testMessages[1])); err != nil {
+		t.Fatal(err)
+	}
+	n.Settle(10)
+
+	n.Step(time.Second)
+	if _, err := a.FileSend(0, libtox.TOX_FILE_KIND_DATA, uint64(len(testFile)), nil, "secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	n.Settle(100)
+
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := a.SelfGetPublicKey()
+	return recording.Bytes(), live, publicKey
+}
+
+// eventTypes returns the first word of every logged call
+func eventTypes(log []string) []string {
+	types := make([]string, len(log))
+	for i, call := range log {
+		types[i], _, _ = strings.Cut(call, " ")
+	}
+	return types
+}
+
+func TestRecordReplay(t *testing.T) {
+	recording, live, _ := recordSession(t, Options{})
+
+	wantTypes := []string{EventSelfConnectionStatus, EventFriendRequest, EventFriendConnectionStatus, EventFriendMessage, EventFriendMessage, EventFileRecv}
+	if types := eventTypes(live); len(types) < len(wantTypes)+1 || strings.Join(types[:len(wantTypes)], " ") != strings.Join(wantTypes, " ") {
+		t.Fatalf("recorded session is %v, want %v and the chunks", types, wantTypes)
+	}
+
+	var replayed []string
+	if err := NewReplayer(bytes.NewReader(recording), 0).Replay(nil, logHandlers(&replayed, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replayed) != len(live) {
+		t.Fatalf("replayed %d calls, want the %d recorded ones", len(replayed), len(live))
+	}
+	for i := range live {
+		if replayed[i] != live[i] {
+			t.Errorf("call %d is %s, want %s", i, replayed[i], live[i])
+		}
+	}
+}
+
+func TestRecordRedact(t *testing.T) {
+	recording, live, publicKey := recordSession(t, Options{RedactPayload: true, RedactProfile: true})
+
+	secrets := append([]string{testRequest, "secret.txt", hex.EncodeToString(publicKey), base64.StdEncoding.EncodeToString(publicKey), "secret file content"}, testMessages...)
+	for _, secret := range secrets {
+		if bytes.Contains(recording, []byte(secret)) {
+			t.Errorf("redacted recording contains %q", secret)
+		}
+	}
+	if bytes.Contains(recording, []byte(base64.StdEncoding.EncodeToString(testFile[:30]))) {
+		t.Error("redacted recording contains the file")
+	}
+
+	// the redacted calls are replayed in the same order and with the same
+	// lengths
+	var replayed []string
+	if err := NewReplayer(bytes.NewReader(recording), 0).Replay(nil, logHandlers(&replayed, false)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(eventTypes(replayed), " "), strings.Join(eventTypes(live), " "); got != want {
+		t.Fatalf("replayed %s, want %s", got, want)
+	}
+	for i, call := range replayed {
+		switch eventTypes(replayed[i : i+1])[0] {
+		case EventFriendRequest:
+			if want := fmt.Sprintf("friend_request %x %q", make([]byte, libtox.TOX_PUBLIC_KEY_SIZE), make([]byte, len(testRequest))); call != want {
+				t.Errorf("replayed %s, want %s", call, want)
+			}
+		case EventFileRecv:
+			if !strings.HasSuffix(call, `"`+redactedFileName+`"`) {
+				t.Errorf("replayed %s, want the file name %q", call, redactedFileName)
+			}
+		}
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	recording, _, _ := recordSession(t, Options{})
+
+	// the session takes 4 seconds of simulated time
+	const span = 4 * time.Second
+
+	tests := []struct {
+		speed float64
+		want  time.Duration
+	}{
+		{1, span},
+		{10, span / 10},
+		{0.5, 2 * span},
+		{0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.speed), func(t *testing.T) {
+			var slept time.Duration
+			var sleeps int
+			replayer := NewReplayer(bytes.NewReader(recording), tt.speed)
+			replayer.Sleep = func(d time.Duration) {
+				slept += d
+				sleeps++
+			}
+			if err := replayer.Replay(nil, Handlers{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if slept != tt.want {
+				t.Errorf("slept %v, want %v", slept, tt.want)
+			}
+			if tt.speed > 0 && sleeps != 3 {
+				t.Errorf("slept %d times, want once before each step of the clock", sleeps)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package toxrecord
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+// Options configures a Recorder.
+type Options struct {
+	// RedactPayload leaves out the content of messages, friend requests,
+	// custom packets and file chunks. Only their length is recorded.
+	RedactPayload bool
+
+	// RedactProfile leaves out the names of friends and conference peers,
+	// status messages, conference titles, file names and the public keys of
+	// friend requests.
+	RedactProfile bool
+
+	// Clock returns the timestamp of an event. Defaults to time.Now; use the
+	// clock of a simulated network to record simulated time.
+	Clock func() time.Time
+
+	// Filter is called for every event before it is written. It may modify
+	// the event, e.g. for custom redaction. If it returns false the event is
+	// not written.
+	Filter func(e *Event) bool
+}
+
+// Recorder writes the callbacks of a libtox instance as NDJSON.
+type Recorder struct {
+	mtx     sync.Mutex
+	options Options
+	w       *bufio.Writer
+	enc     *json.Encoder
+	err     error
+}
+
+// NewRecorder creates a Recorder writing to w. options may be nil.
+func NewRecorder(w io.Writer, options *Options) *Recorder {
+	r := &Recorder{w: bufio.NewWriter(w)}
+	if options != nil {
+		r.options = *options
+	}
+	if r.options.Clock == nil {
+		r.options.Clock = time.Now
+	}
+	r.enc = json.NewEncoder(r.w)
+
+	return r
+}
+
+// Err returns the first error that occurred while writing.
+func (r *Recorder) Err() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.err
+}
+
+// Flush writes buffered events to the underlying writer.
+func (r *Recorder) Flush() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+
+	return r.err
+}
+
+// Close flushes the Recorder. It does not close the underlying writer.
+func (r *Recorder) Close() error {
+	return r.Flush()
+}
+
+// record writes e. Errors are kept and reported by Err, Flush and Close, so
+// a failing recording never disturbs the callbacks.
+func (r *Recorder) record(e *Event) {
+	e.Time = r.options.Clock()
+	if r.options.Filter != nil && !r.options.Filter(e) {
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(e)
+	}
+}
+
+// payload sets the payload of e, redacted if requested.
+func (r *Recorder) payload(e *Event, data []byte, length uint64, redact bool) {
+	e.Length = length
+	if redact {
+		e.Redacted = true
+		return
+	}
+
+	e.Data = append([]byte(nil), data...)
+}
+
+// Attach registers callbacks on t that record every event and then call the
+// matching handler in h. It replaces the callbacks currently set on t.
+func (r *Recorder) Attach(t *libtox.Tox, h Handlers) {
+	t.CallbackSelfConnectionStatusChanges(func(t *libtox.Tox, status libtox.ToxConnection) {
+		r.record(&Event{Type: EventSelfConnectionStatus, Connection: status})
+		if h.SelfConnectionStatus != nil {
+			h.SelfConnectionStatus(t, status)
+		}
+	})
+
+	t.CallbackFriendNameChanges(func(t *libtox.Tox, friendnumber uint32, name []byte, length uint32) {
+		e := &Event{Type: EventFriendName, Friend: friendnumber}
+		r.payload(e, name, uint64(length), r.options.RedactProfile)
+		r.record(e)
+		if h.FriendName != nil {
+			h.FriendName(t, friendnumber, name, length)
+		}
+	})
+
+	t.CallbackFriendStatusMessageChanges(func(t *libtox.Tox, friendnumber uint32, message []byte, length uint32) {
+		e := &Event{Type: EventFriendStatusMessage, Friend: friendnumber}
+		r.payload(e, message, uint64(length), r.options.RedactProfile)
+		r.record(e)
+		if h.FriendStatusMessage != nil {
+			h.FriendStatusMessage(t, friendnumber, message, length)
+		}
+	})
+
+	t.CallbackFriendStatusChanges(func(t *libtox.Tox, friendnumber uint32, userstatus libtox.ToxUserStatus) {
+		r.record(&Event{Type: EventFriendStatus, Friend: friendnumber, Status: userstatus})
+		if h.FriendStatus != nil {
+			h.FriendStatus(t, friendnumber, userstatus)
+		}
+	})
+
+	t.CallbackFriendConnectionStatusChanges(func(t *libtox.Tox, friendnumber uint32, status libtox.ToxConnection) {
+		r.record(&Event{Type: EventFriendConnectionStatus, Friend: friendnumber, Connection: status})
+		if h.FriendConnectionStatus != nil {
+			h.FriendConnectionStatus(t, friendnumber, status)
+		}
+	})
+
+	t.CallbackFriendTypingChanges(func(t *libtox.Tox, friendnumber uint32, istyping bool) {
+		r.record(&Event{Type: EventFriendTyping, Friend: friendnumber, Typing: istyping})
+		if h.FriendTyping != nil {
+			h.FriendTyping(t, friendnumber, istyping)
+		}
+	})
+
+	t.CallbackFriendReadReceipt(func(t *libtox.Tox, friendnumber uint32, messageid uint32) {
+		r.record(&Event{Type: EventFriendReadReceipt, Friend: friendnumber, MessageID: messageid})
+		if h.FriendReadReceipt != nil {
+			h.FriendReadReceipt(t, friendnumber, messageid)
+		}
+	})
+
+	t.CallbackFriendRequest(func(t *libtox.Tox, publickey []byte, message []byte, length uint32) {
+		e := &Event{Type: EventFriendRequest}
+		if !r.options.RedactProfile {
+			e.PublicKey = hex.EncodeToString(publickey)
+		}
+		r.payload(e, message, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.FriendRequest != nil {
+			h.FriendRequest(t, publickey, message, length)
+		}
+	})
+
+	t.CallbackFriendMessage(func(t *libtox.Tox, friendnumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
+		e := &Event{Type: EventFriendMessage, Friend: friendnumber, MessageType: messagetype}
+		r.payload(e, message, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.FriendMessage != nil {
+			h.FriendMessage(t, friendnumber, messagetype, message, length)
+		}
+	})
+
+	t.CallbackFileRecvControl(func(t *libtox.Tox, friendnumber uint32, filenumber uint32, fileControl libtox.ToxFileControl) {
+		r.record(&Event{Type: EventFileRecvControl, Friend: friendnumber, File: filenumber, Control: fileControl})
+		if h.FileRecvControl != nil {
+			h.FileRecvControl(t, friendnumber, filenumber, fileControl)
+		}
+	})
+
+	t.CallbackFileChunkRequest(func(t *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, length uint64) {
+		r.record(&Event{Type: EventFileChunkRequest, Friend: friendnumber, File: filenumber, Position: position, Length: length})
+		if h.FileChunkRequest != nil {
+			h.FileChunkRequest(t, friendnumber, filenumber, position, length)
+		}
+	})
+
+	t.CallbackFileRecv(func(t *libtox.Tox, friendnumber uint32, filenumber uint32, kind libtox.ToxFileKind, filesize uint64, filename string, length uint32) {
+		e := &Event{Type: EventFileRecv, Friend: friendnumber, File: filenumber, Kind: kind, FileSize: filesize, Length: uint64(length)}
+		if r.options.RedactProfile {
+			e.Redacted = true
+		} else {
+			e.FileName = filename
+		}
+		r.record(e)
+		if h.FileRecv != nil {
+			h.FileRecv(t, friendnumber, filenumber, kind, filesize, filename, length)
+		}
+	})
+
+	t.CallbackFileRecvChunk(func(t *libtox.Tox, friendnumber uint32, filenumber uint32, position uint64, data []byte, length uint32) {
+		e := &Event{Type: EventFileRecvChunk, Friend: friendnumber, File: filenumber, Position: position}
+		r.payload(e, data, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.FileRecvChunk != nil {
+			h.FileRecvChunk(t, friendnumber, filenumber, position, data, length)
+		}
+	})
+
+	t.CallbackFriendLossyPacket(func(t *libtox.Tox, friendnumber uint32, data []byte, length uint32) {
+		e := &Event{Type: EventFriendLossyPacket, Friend: friendnumber}
+		r.payload(e, data, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.FriendLossyPacket != nil {
+			h.FriendLossyPacket(t, friendnumber, data, length)
+		}
+	})
+
+	t.CallbackFriendLosslessPacket(func(t *libtox.Tox, friendnumber uint32, data []byte, length uint32) {
+		e := &Event{Type: EventFriendLosslessPacket, Friend: friendnumber}
+		r.payload(e, data, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.FriendLosslessPacket != nil {
+			h.FriendLosslessPacket(t, friendnumber, data, length)
+		}
+	})
+
+	t.CallbackConferenceInvite(func(t *libtox.Tox, friendnumber uint32, conferencetype libtox.ToxConferenceType, cookie []byte) {
+		e := &Event{Type: EventConferenceInvite, Friend: friendnumber, ConferenceType: conferencetype}
+		r.payload(e, cookie, uint64(len(cookie)), false)
+		r.record(e)
+		if h.ConferenceInvite != nil {
+			h.ConferenceInvite(t, friendnumber, conferencetype, cookie)
+		}
+	})
+
+	t.CallbackConferenceConnected(func(t *libtox.Tox, conferencenumber uint32) {
+		r.record(&Event{Type: EventConferenceConnected, Conference: conferencenumber})
+		if h.ConferenceConnected != nil {
+			h.ConferenceConnected(t, conferencenumber)
+		}
+	})
+
+	t.CallbackConferenceMessage(func(t *libtox.Tox, conferencenumber uint32, peernumber uint32, messagetype libtox.ToxMessageType, message []byte, length uint32) {
+		e := &Event{Type: EventConferenceMessage, Conference: conferencenumber, Peer: peernumber, MessageType: messagetype}
+		r.payload(e, message, uint64(length), r.options.RedactPayload)
+		r.record(e)
+		if h.ConferenceMessage != nil {
+			h.ConferenceMessage(t, conferencenumber, peernumber, messagetype, message, length)
+		}
+	})
+
+	t.CallbackConferenceTitle(func(t *libtox.Tox, conferencenumber uint32, peernumber uint32, title []byte, length uint32) {
+		e := &Event{Type: EventConferenceTitle, Conference: conferencenumber, Peer: peernumber}
+		r.payload(e, title, uint64(length), r.options.RedactProfile)
+		r.record(e)
+		if h.ConferenceTitle != nil {
+			h.ConferenceTitle(t, conferencenumber, peernumber, title, length)
+		}
+	})
+
+	t.CallbackConferencePeerName(func(t *libtox.Tox, conferencenumber uint32, peernumber uint32, name []byte, length uint32) {
+		e := &Event{Type: EventConferencePeerName, Conference: conferencenumber, Peer: peernumber}
+		r.payload(e, name, uint64(length), r.options.RedactProfile)
+		r.record(e)
+		if h.ConferencePeerName != nil {
+			h.ConferencePeerName(t, conferencenumber, peernumber, name, length)
+		}
+	})
+
+	t.CallbackConferencePeerListChanged(func(t *libtox.Tox, conferencenumber uint32) {
+		r.record(&Event{Type: EventConferencePeerList, Conference: conferencenumber})
+		if h.ConferencePeerList != nil {
+			h.ConferencePeerList(t, conferencenumber)
+		}
+	})
+}
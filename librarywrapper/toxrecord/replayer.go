@@ -0,0 +1,189 @@
+package toxrecord
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/calvindc/dpc-tox/librarywrapper/libtox"
+)
+
+// redactedFileName replaces file names that were redacted when recording.
+const redactedFileName = "redacted"
+
+// Replayer feeds a recorded session back into handler functions.
+type Replayer struct {
+	scanner *bufio.Scanner
+	line    int
+
+	// Speed scales the delays between events: 1 replays in real time, 10
+	// ten times faster. With 0 events are replayed without any delay.
+	Speed float64
+
+	// Sleep waits between events. Defaults to time.Sleep; set it to advance
+	// a simulated clock instead.
+	Sleep func(d time.Duration)
+}
+
+// NewReplayer creates a Replayer reading NDJSON from r.
+func NewReplayer(r io.Reader, speed float64) *Replayer {
+	scanner := bufio.NewScanner(r)
+	// a base64 encoded file chunk plus the other fields fits easily
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	return &Replayer{scanner: scanner, Speed: speed, Sleep: time.Sleep}
+}
+
+// Next returns the next recorded event, or io.EOF at the end of the session.
+func (p *Replayer) Next() (*Event, error) {
+	for p.scanner.Scan() {
+		p.line++
+		if len(p.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal(p.scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+		return &e, nil
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// Replay calls the handlers in h for every recorded event, passing t as the
+// Tox instance. t may be nil if the handlers do not use it. Events of unknown
+// type are skipped.
+func (p *Replayer) Replay(t *libtox.Tox, h Handlers) error {
+	var last time.Time
+	for {
+		e, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if p.Speed > 0 && !last.IsZero() && e.Time.After(last) {
+			p.Sleep(time.Duration(float64(e.Time.Sub(last)) / p.Speed))
+		}
+		last = e.Time
+
+		if err := Dispatch(t, h, e); err != nil {
+			return fmt.Errorf("line %d: %v", p.line, err)
+		}
+	}
+}
+
+// Dispatch calls the handler in h matching the type of e.
+func Dispatch(t *libtox.Tox, h Handlers, e *Event) error {
+	switch e.Type {
+	case EventSelfConnectionStatus:
+		if h.SelfConnectionStatus != nil {
+			h.SelfConnectionStatus(t, e.Connection)
+		}
+	case EventFriendName:
+		if h.FriendName != nil {
+			h.FriendName(t, e.Friend, e.payload(), uint32(e.Length))
+		}
+	case EventFriendStatusMessage:
+		if h.FriendStatusMessage != nil {
+			h.FriendStatusMessage(t, e.Friend, e.payload(), uint32(e.Length))
+		}
+	case EventFriendStatus:
+		if h.FriendStatus != nil {
+			h.FriendStatus(t, e.Friend, e.Status)
+		}
+	case EventFriendConnectionStatus:
+		if h.FriendConnectionStatus != nil {
+			h.FriendConnectionStatus(t, e.Friend, e.Connection)
+		}
+	case EventFriendTyping:
+		if h.FriendTyping != nil {
+			h.FriendTyping(t, e.Friend, e.Typing)
+		}
+	case EventFriendReadReceipt:
+		if h.FriendReadReceipt != nil {
+			h.FriendReadReceipt(t, e.Friend, e.MessageID)
+		}
+	case EventFriendRequest:
+		// a redacted public key is replayed as zero bytes
+		publickey := make([]byte, libtox.TOX_PUBLIC_KEY_SIZE)
+		if e.PublicKey != "" {
+			var err error
+			publickey, err = hex.DecodeString(e.PublicKey)
+			if err != nil || len(publickey) != libtox.TOX_PUBLIC_KEY_SIZE {
+				return fmt.Errorf("invalid public key %q", e.PublicKey)
+			}
+		}
+		if h.FriendRequest != nil {
+			h.FriendRequest(t, publickey, e.payload(), uint32(e.Length))
+		}
+	case EventFriendMessage:
+		if h.FriendMessage != nil {
+			h.FriendMessage(t, e.Friend, e.MessageType, e.payload(), uint32(e.Length))
+		}
+	case EventFileRecvControl:
+		if h.FileRecvControl != nil {
+			h.FileRecvControl(t, e.Friend, e.File, e.Control)
+		}
+	case EventFileChunkRequest:
+		if h.FileChunkRequest != nil {
+			h.FileChunkRequest(t, e.Friend, e.File, e.Position, e.Length)
+		}
+	case EventFileRecv:
+		if h.FileRecv != nil {
+			filename := e.FileName
+			if e.Redacted {
+				filename = redactedFileName
+			}
+			h.FileRecv(t, e.Friend, e.File, e.Kind, e.FileSize, filename, uint32(len(filename)))
+		}
+	case EventFileRecvChunk:
+		if h.FileRecvChunk != nil {
+			h.FileRecvChunk(t, e.Friend, e.File, e.Position, e.payload(), uint32(e.Length))
+		}
+	case EventFriendLossyPacket:
+		if h.FriendLossyPacket != nil {
+			h.FriendLossyPacket(t, e.Friend, e.payload(), uint32(e.Length))
+		}
+	case EventFriendLosslessPacket:
+		if h.FriendLosslessPacket != nil {
+			h.FriendLosslessPacket(t, e.Friend, e.payload(), uint32(e.Length))
+		}
+	case EventConferenceInvite:
+		if h.ConferenceInvite != nil {
+			h.ConferenceInvite(t, e.Friend, e.ConferenceType, e.payload())
+		}
+	case EventConferenceConnected:
+		if h.ConferenceConnected != nil {
+			h.ConferenceConnected(t, e.Conference)
+		}
+	case EventConferenceMessage:
+		if h.ConferenceMessage != nil {
+			h.ConferenceMessage(t, e.Conference, e.Peer, e.MessageType, e.payload(), uint32(e.Length))
+		}
+	case EventConferenceTitle:
+		if h.ConferenceTitle != nil {
+			h.ConferenceTitle(t, e.Conference, e.Peer, e.payload(), uint32(e.Length))
+		}
+	case EventConferencePeerName:
+		if h.ConferencePeerName != nil {
+			h.ConferencePeerName(t, e.Conference, e.Peer, e.payload(), uint32(e.Length))
+		}
+	case EventConferencePeerList:
+		if h.ConferencePeerList != nil {
+			h.ConferencePeerList(t, e.Conference)
+		}
+	}
+
+	return nil
+}